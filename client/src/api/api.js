@@ -18,35 +18,174 @@ api.interceptors.request.use(
   (error) => Promise.reject(error)
 );
 
+const clearSession = () => {
+  localStorage.removeItem('token');
+  localStorage.removeItem('refresh_token');
+  localStorage.removeItem('user');
+};
+
+// Concurrent 401s share a single refresh so the rotated token isn't reused.
+let refreshPromise = null;
+
+const refreshAccessToken = () => {
+  if (!refreshPromise) {
+    const refreshToken = localStorage.getItem('refresh_token');
+    refreshPromise = axios
+      .post('/api/auth/refresh', { refresh_token: refreshToken })
+      .then((response) => {
+        localStorage.setItem('token', response.data.token);
+        localStorage.setItem('refresh_token', response.data.refresh_token);
+        return response.data.token;
+      })
+      .finally(() => {
+        refreshPromise = null;
+      });
+  }
+  return refreshPromise;
+};
+
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
-    if (error.response?.status === 401) {
-      localStorage.removeItem('token');
-      localStorage.removeItem('user');
+  async (error) => {
+    const original = error.config;
+    if (
+      error.response?.status === 401 &&
+      original &&
+      !original._retry &&
+      !original.url?.startsWith('/auth/') &&
+      localStorage.getItem('refresh_token')
+    ) {
+      original._retry = true;
+      try {
+        const token = await refreshAccessToken();
+        original.headers.Authorization = `Bearer ${token}`;
+        return api(original);
+      } catch {
+        // fall through to the logout below
+      }
+    }
+    if (error.response?.status === 401 && !original?.url?.startsWith('/auth/login')) {
+      clearSession();
       window.location.href = '/login';
     }
     return Promise.reject(error);
   }
 );
 
+// Writes that take the record's version send it as If-Match, so the server
+// refuses them with 412 if someone else changed the record in the meantime.
+const ifMatch = (version) => (version ? { 'If-Match': `"${version}"` } : {});
+
+// List endpoints return a page at a time with the total in X-Total-Count.
+// getAllPages follows the pages for screens that need every row.
+const PAGE_SIZE = 500;
+
+const getAllPages = async (url, params = {}) => {
+  const first = await api.get(url, { params: { ...params, limit: PAGE_SIZE } });
+  const total = Number(first.headers['x-total-count'] ?? first.data.length);
+  let data = first.data;
+  while (data.length < total) {
+    const page = await api.get(url, { params: { ...params, limit: PAGE_SIZE, offset: data.length } });
+    if (page.data.length === 0) break;
+    data = data.concat(page.data);
+  }
+  return { ...first, data };
+};
+
+export const authAPI = {
+  logout: (all = false) => api.post(`/auth/logout${all ? '?all=true' : ''}`),
+};
+
 export const employeeAPI = {
-  getAll: () => api.get('/employees'),
+  getAll: (params) => getAllPages('/employees', params),
+  list: (params) => api.get('/employees', { params }),
+  export: (params) => api.get('/employees/export', { params, responseType: 'blob' }),
   getById: (id) => api.get(`/employees/${id}`),
   create: (data) => api.post('/employees', data),
-  update: (id, data) => api.put(`/employees/${id}`, data),
+  update: (id, data, version) => api.put(`/employees/${id}`, data, { headers: ifMatch(version) }),
+  patch: (id, changes, version) =>
+    api.patch(`/employees/${id}`, changes, {
+      headers: { 'Content-Type': 'application/merge-patch+json', ...ifMatch(version) },
+    }),
+  import: (file, dryRun = false) => {
+    const form = new FormData();
+    form.append('file', file);
+    return api.post('/employees/import', form, {
+      params: { dry_run: dryRun },
+      headers: { 'Content-Type': 'multipart/form-data' },
+    });
+  },
+  lifecycle: (id) => api.get(`/employees/${id}/lifecycle`),
+  transition: (id, action, data) => api.post(`/employees/${id}/lifecycle/${action}`, data),
+  history: (id, params) => api.get(`/employees/${id}/history`, { params }),
+  changeJob: (id, data) => api.post(`/employees/${id}/history`, data),
+  cancelJobChange: (id, recordId) => api.delete(`/employees/${id}/history/${recordId}`),
+};
+
+export const documentAPI = {
+  list: (employeeId, params) => api.get(`/employees/${employeeId}/documents`, { params }),
+  upload: (employeeId, file, docType, expiryDate) => {
+    const form = new FormData();
+    form.append('file', file);
+    form.append('doc_type', docType);
+    if (expiryDate) form.append('expiry_date', expiryDate);
+    return api.post(`/employees/${employeeId}/documents`, form, {
+      headers: { 'Content-Type': 'multipart/form-data' },
+    });
+  },
+  download: (id) => api.get(`/documents/${id}/download`, { responseType: 'blob' }),
+  delete: (id) => api.delete(`/documents/${id}`),
+  expiring: (params) => api.get('/documents/expiring', { params }),
+};
+
+export const customFieldAPI = {
+  getAll: () => api.get('/custom-fields'),
+  create: (data) => api.post('/custom-fields', data),
+  update: (id, data) => api.put(`/custom-fields/${id}`, data),
+  delete: (id) => api.delete(`/custom-fields/${id}`),
+};
+
+export const workLocationAPI = {
+  getAll: () => api.get('/work-locations'),
+  create: (data) => api.post('/work-locations', data),
+  update: (id, data) => api.put(`/work-locations/${id}`, data),
+  delete: (id) => api.delete(`/work-locations/${id}`),
 };
 
 export const attendanceAPI = {
-  getAll: () => api.get('/attendance'),
+  getAll: (params) => getAllPages('/attendance', params),
+  list: (params) => api.get('/attendance', { params }),
+  export: (params) => api.get('/attendance/export', { params, responseType: 'blob' }),
   clockIn: (data) => api.post('/attendance/clockin', data),
   clockOut: (data) => api.post('/attendance/clockout', data),
+  schedule: (params) => api.get('/attendance/schedule', { params }),
+  summary: (params) => api.get('/attendance/summary', { params }),
+};
+
+export const shiftAPI = {
+  getAll: () => api.get('/shifts'),
+  create: (data) => api.post('/shifts', data),
+  update: (id, data) => api.put(`/shifts/${id}`, data),
+  delete: (id) => api.delete(`/shifts/${id}`),
+  getAssignments: (employeeId) => api.get(`/employees/${employeeId}/shifts`),
+  assign: (employeeId, data) => api.post(`/employees/${employeeId}/shifts`, data),
+  deleteAssignment: (id) => api.delete(`/shift-assignments/${id}`),
+};
+
+export const overtimeAPI = {
+  getPolicy: () => api.get('/overtime-policy'),
+  updatePolicy: (data) => api.put('/overtime-policy', data),
+  getHolidays: (params) => api.get('/holidays', { params }),
+  createHoliday: (data) => api.post('/holidays', data),
+  deleteHoliday: (id) => api.delete(`/holidays/${id}`),
 };
 
 export const leaveAPI = {
-  getAll: () => api.get('/leave'),
+  getAll: (params) => getAllPages('/leave', params),
+  list: (params) => api.get('/leave', { params }),
+  export: (params) => api.get('/leave/export', { params, responseType: 'blob' }),
   create: (data) => api.post('/leave', data),
-  updateStatus: (id, status) => api.put(`/leave/${id}`, { status }),
+  updateStatus: (id, status, version) => api.put(`/leave/${id}`, { status }, { headers: ifMatch(version) }),
 };
 
 export const salaryAPI = {
@@ -60,14 +199,17 @@ export const chatAPI = {
 
 export const feedbackAPI = {
   create: (data) => api.post('/feedback', data),
-  getAll: () => api.get('/feedback'),
+  getAll: (params) => getAllPages('/feedback', params),
+  list: (params) => api.get('/feedback', { params }),
 };
 
 export const settingsAPI = {
   getAll: () => api.get('/settings'),
   get: (key) => api.get(`/settings/${key}`),
-  upsert: (data) => api.post('/settings', data),
+  upsert: (data, version) => api.post('/settings', data, { headers: ifMatch(version) }),
   delete: (key) => api.delete(`/settings/${key}`),
 };
 
+export { clearSession };
+
 export default api;
@@ -15,6 +15,7 @@ import {
   LogoutOutlined,
   SettingOutlined
 } from '@ant-design/icons';
+import { authAPI, clearSession } from '../api/api';
 
 const { Sider, Content } = AntLayout;
 const { Title, Text } = Typography;
@@ -185,9 +186,13 @@ const Layout = () => {
                     key: 'logout',
                     label: 'Logout',
                     icon: <LogoutOutlined />,
-                    onClick: () => {
-                      localStorage.removeItem('token');
-                      localStorage.removeItem('user');
+                    onClick: async () => {
+                      try {
+                        await authAPI.logout();
+                      } catch (error) {
+                        console.error('Logout error:', error);
+                      }
+                      clearSession();
                       navigate('/login');
                     }
                   }
@@ -44,7 +44,7 @@ const Employees = () => {
       };
       
       if (editingEmployee) {
-        await employeeAPI.update(editingEmployee.id, formattedData);
+        await employeeAPI.update(editingEmployee.id, formattedData, editingEmployee.version);
         message.success('Employee updated successfully');
       } else {
         await employeeAPI.create(formattedData);
@@ -57,6 +57,10 @@ const Employees = () => {
       fetchEmployees();
     } catch (error) {
       console.error('Error saving employee:', error);
+      if (error.response?.status === 412) {
+        message.error('Someone else changed this employee. Reload and try again.');
+        return;
+      }
       message.error(editingEmployee ? 'Failed to update employee' : 'Failed to add employee');
     }
   };
@@ -371,12 +375,23 @@ const Employees = () => {
                       </Select>
                     </Form.Item>
 
-                    <Form.Item label="Employment Status" name="employment_status">
-                      <Select placeholder="Select employment status" allowClear>
-                        <Select.Option value="active">Active</Select.Option>
+                    <Form.Item
+                      label="Employment Status"
+                      name="employment_status"
+                      extra={editingEmployee ? 'Changed through hire, leave, termination and rehire actions' : undefined}
+                    >
+                      <Select placeholder="Select employment status" allowClear disabled={!!editingEmployee}>
+                        <Select.Option value="pending">Pending start</Select.Option>
                         <Select.Option value="probation">Probation</Select.Option>
-                        <Select.Option value="resigned">Resigned</Select.Option>
-                        <Select.Option value="terminated">Terminated</Select.Option>
+                        <Select.Option value="active">Active</Select.Option>
+                        {editingEmployee && (
+                          <>
+                            <Select.Option value="on_leave">On leave</Select.Option>
+                            <Select.Option value="terminated">Terminated</Select.Option>
+                            <Select.Option value="resigned">Resigned</Select.Option>
+                            <Select.Option value="inactive">Inactive</Select.Option>
+                          </>
+                        )}
                       </Select>
                     </Form.Item>
 
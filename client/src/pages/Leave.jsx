@@ -53,13 +53,18 @@ const Leave = () => {
     }
   };
 
-  const handleStatusChange = async (leaveId, newStatus) => {
+  const handleStatusChange = async (leave, newStatus) => {
     try {
-      await leaveAPI.updateStatus(leaveId, newStatus);
+      await leaveAPI.updateStatus(leave.id, newStatus, leave.version);
       message.success('Leave status updated successfully');
       fetchData();
     } catch (error) {
       console.error('Error updating leave status:', error);
+      if (error.response?.status === 412) {
+        message.error('This request was changed by someone else');
+        fetchData();
+        return;
+      }
       message.error('Failed to update leave status');
     }
   };
@@ -107,7 +112,7 @@ const Leave = () => {
       render: (status, record) => (
         <Select
           value={status}
-          onChange={(newStatus) => handleStatusChange(record.id, newStatus)}
+          onChange={(newStatus) => handleStatusChange(record, newStatus)}
           style={{ width: 120 }}
           size="small"
         >
@@ -1,6 +1,6 @@
-import { useState } from 'react';
-import { useNavigate } from 'react-router-dom';
-import { Form, Input, Button, Card, Typography, message } from 'antd';
+import { useEffect, useRef, useState } from 'react';
+import { useNavigate, useSearchParams } from 'react-router-dom';
+import { Form, Input, Button, Card, Typography, Modal, message } from 'antd';
 import { UserOutlined, LockOutlined } from '@ant-design/icons';
 import api from '../api/api';
 
@@ -8,8 +8,70 @@ const { Title, Paragraph } = Typography;
 
 const Login = () => {
   const [loading, setLoading] = useState(false);
+  const [currentPassword, setCurrentPassword] = useState(null);
+  const [changing, setChanging] = useState(false);
+  const [challenge, setChallenge] = useState(null);
+  const [verifying, setVerifying] = useState(false);
+  const [ssoLoginURL, setSSOLoginURL] = useState(null);
+  const [searchParams, setSearchParams] = useSearchParams();
+  const ssoHandled = useRef(false);
   const navigate = useNavigate();
 
+  const completeLogin = (data, password) => {
+    localStorage.setItem('token', data.token);
+    localStorage.setItem('refresh_token', data.refresh_token);
+    localStorage.setItem('user', JSON.stringify(data.user));
+
+    if (data.user.must_change_password) {
+      setCurrentPassword(password);
+      return;
+    }
+
+    message.success('Login successful!');
+    navigate('/');
+  };
+
+  useEffect(() => {
+    api.get('/auth/oidc')
+      .then((response) => {
+        if (response.data.enabled) {
+          setSSOLoginURL(response.data.login_url);
+        }
+      })
+      .catch(() => {});
+  }, []);
+
+  // The identity provider sends the browser back here with a one-time code
+  useEffect(() => {
+    const code = searchParams.get('sso_code');
+    const error = searchParams.get('sso_error');
+    if ((!code && !error) || ssoHandled.current) {
+      return;
+    }
+    ssoHandled.current = true;
+    setSearchParams({}, { replace: true });
+
+    if (error) {
+      message.error(error);
+      return;
+    }
+
+    setLoading(true);
+    api.post('/auth/oidc/exchange', { code })
+      .then((response) => {
+        if (response.data.two_factor_required) {
+          setChallenge({ token: response.data.challenge_token, password: null });
+          return;
+        }
+        completeLogin(response.data, null);
+      })
+      .catch((err) => {
+        console.error('SSO login error:', err);
+        message.error(err.response?.data?.error || 'Single sign-on failed.');
+      })
+      .finally(() => setLoading(false));
+  }, [searchParams]);
+
   const onFinish = async (values) => {
     setLoading(true);
     try {
@@ -18,11 +80,12 @@ const Login = () => {
         password: values.password,
       });
 
-      localStorage.setItem('token', response.data.token);
-      localStorage.setItem('user', JSON.stringify(response.data.user));
-      
-      message.success('Login successful!');
-      navigate('/');
+      if (response.data.two_factor_required) {
+        setChallenge({ token: response.data.challenge_token, password: values.password });
+        return;
+      }
+
+      completeLogin(response.data, values.password);
     } catch (error) {
       console.error('Login error:', error);
       message.error(error.response?.data?.error || 'Login failed. Please check your credentials.');
@@ -31,6 +94,49 @@ const Login = () => {
     }
   };
 
+  const onVerifyCode = async (values) => {
+    setVerifying(true);
+    try {
+      const response = await api.post('/auth/2fa/verify', {
+        challenge_token: challenge.token,
+        code: values.code,
+      });
+
+      const { password } = challenge;
+      setChallenge(null);
+      completeLogin(response.data, password);
+    } catch (error) {
+      console.error('Verification error:', error);
+      message.error(error.response?.data?.error || 'Verification failed.');
+    } finally {
+      setVerifying(false);
+    }
+  };
+
+  const onChangePassword = async (values) => {
+    setChanging(true);
+    try {
+      const response = await api.post('/auth/password/change', {
+        current_password: currentPassword,
+        new_password: values.new_password,
+      });
+
+      localStorage.setItem('token', response.data.token);
+      localStorage.setItem('refresh_token', response.data.refresh_token);
+      const user = JSON.parse(localStorage.getItem('user') || '{}');
+      localStorage.setItem('user', JSON.stringify({ ...user, must_change_password: false }));
+
+      setCurrentPassword(null);
+      message.success('Password changed successfully!');
+      navigate('/');
+    } catch (error) {
+      console.error('Change password error:', error);
+      message.error(error.response?.data?.error || 'Failed to change password.');
+    } finally {
+      setChanging(false);
+    }
+  };
+
   return (
     <div className="min-h-screen flex items-center justify-center bg-gradient-to-br from-blue-50 to-indigo-100 p-4">
       <Card className="w-full max-w-md shadow-lg">
@@ -96,12 +202,91 @@ const Login = () => {
           </Form.Item>
         </Form>
 
+        {ssoLoginURL && (
+          <Button
+            className="w-full"
+            size="large"
+            disabled={loading}
+            onClick={() => { window.location.href = ssoLoginURL; }}
+          >
+            Sign in with company account
+          </Button>
+        )}
+
         <div className="text-center mt-4 text-gray-600">
           <Paragraph className="text-sm">
             Test accounts: alice, bob, carol, etc. | Password: password
           </Paragraph>
         </div>
       </Card>
+
+      <Modal
+        title="Two-factor authentication"
+        open={challenge !== null}
+        footer={null}
+        onCancel={() => setChallenge(null)}
+        destroyOnClose
+      >
+        <Paragraph className="text-gray-600">
+          Enter the 6-digit code from your authenticator app, or one of your recovery codes.
+        </Paragraph>
+        <Form name="verify-code" onFinish={onVerifyCode} layout="vertical">
+          <Form.Item
+            name="code"
+            rules={[{ required: true, message: 'Please enter your code' }]}
+          >
+            <Input autoComplete="one-time-code" autoFocus />
+          </Form.Item>
+          <Button type="primary" htmlType="submit" className="w-full" loading={verifying}>
+            Verify
+          </Button>
+        </Form>
+      </Modal>
+
+      <Modal
+        title="Choose a new password"
+        open={currentPassword !== null}
+        footer={null}
+        closable={false}
+        maskClosable={false}
+      >
+        <Paragraph className="text-gray-600">
+          Your account is using a temporary password. Please choose a new one to continue.
+        </Paragraph>
+        <Form name="change-password" onFinish={onChangePassword} layout="vertical">
+          <Form.Item
+            name="new_password"
+            label="New password"
+            rules={[
+              { required: true, message: 'Please enter a new password' },
+              { min: 6, message: 'Password must be at least 6 characters' }
+            ]}
+          >
+            <Input.Password autoComplete="new-password" />
+          </Form.Item>
+          <Form.Item
+            name="confirm_password"
+            label="Confirm new password"
+            dependencies={['new_password']}
+            rules={[
+              { required: true, message: 'Please confirm your new password' },
+              ({ getFieldValue }) => ({
+                validator(_, value) {
+                  if (!value || getFieldValue('new_password') === value) {
+                    return Promise.resolve();
+                  }
+                  return Promise.reject(new Error('Passwords do not match'));
+                },
+              }),
+            ]}
+          >
+            <Input.Password autoComplete="new-password" />
+          </Form.Item>
+          <Button type="primary" htmlType="submit" className="w-full" loading={changing}>
+            Change Password
+          </Button>
+        </Form>
+      </Modal>
     </div>
   );
 };
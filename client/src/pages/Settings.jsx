@@ -13,6 +13,7 @@ const Settings = () => {
   const [feedbackLoading, setFeedbackLoading] = useState(false);
   const [modalVisible, setModalVisible] = useState(false);
   const [editingKey, setEditingKey] = useState(null);
+  const [editingVersion, setEditingVersion] = useState(null);
   const [form] = Form.useForm();
 
   useEffect(() => {
@@ -47,12 +48,14 @@ const Settings = () => {
   const handleAdd = () => {
     form.resetFields();
     setEditingKey(null);
+    setEditingVersion(null);
     setModalVisible(true);
   };
 
   const handleEdit = (record) => {
     form.setFieldsValue(record);
     setEditingKey(record.key);
+    setEditingVersion(record.version);
     setModalVisible(true);
   };
 
@@ -68,12 +71,16 @@ const Settings = () => {
 
   const handleSubmit = async (values) => {
     try {
-      await settingsAPI.upsert(values);
+      await settingsAPI.upsert(values, editingVersion);
       message.success(`Setting ${editingKey ? 'updated' : 'created'} successfully`);
       setModalVisible(false);
       form.resetFields();
       fetchSettings();
     } catch (error) {
+      if (error.response?.status === 412) {
+        message.error('This setting was changed by someone else. Reload and try again.');
+        return;
+      }
       message.error(`Failed to ${editingKey ? 'update' : 'create'} setting`);
     }
   };
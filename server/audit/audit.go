@@ -0,0 +1,419 @@
+// Package audit records who changed what. Register installs GORM callbacks
+// that write an AuditEvent for every create, update and delete, attributed to
+// the Actor carried in the statement's context.
+package audit
+
+import (
+        "bytes"
+        "context"
+        "encoding/json"
+        "fmt"
+        "reflect"
+        "strings"
+
+        "hcm-backend/fieldcrypt"
+        "hcm-backend/models"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+        "gorm.io/gorm/clause"
+)
+
+const (
+        SourceREST   = "rest"
+        SourceAPIKey = "api_key"
+        SourceSystem = "system"
+
+        ActionCreate = "create"
+        ActionUpdate = "update"
+        ActionDelete = "delete"
+)
+
+// Actor identifies where a change came from.
+type Actor struct {
+        UserID    *uint
+        RequestID string
+        IPAddress string
+        // Source is "rest" for API requests made by a user, "api_key:<id>"
+        // for requests made with an API key, "chat:<tool>" for chatbot tools
+        // and "system" for anything without an actor, such as seeding.
+        Source string
+}
+
+type actorKey struct{}
+
+// Tables whose rows are credentials or are themselves logs. Recording them
+// would only leak secrets or audit the audit trail.
+var ignoredTables = map[string]bool{
+        "audit_events":      true,
+        "sessions":          true,
+        "user_tokens":       true,
+        "recovery_codes":    true,
+        "login_throttles":   true,
+        "security_events":   true,
+        "oidc_login_states": true,
+}
+
+// Columns whose values are never copied into the audit trail. Encrypted
+// fields and *_hash columns are redacted as well.
+var redactedColumns = map[string]bool{
+        "password":    true,
+        "totp_secret": true,
+}
+
+// Bookkeeping columns that change on every write and carry no information.
+var skippedColumns = map[string]bool{
+        "created_at":     true,
+        "updated_at":     true,
+        "totp_last_step": true,
+        "last_used_at":   true,
+        "last_used_ip":   true,
+        "version":        true,
+}
+
+const redacted = "[REDACTED]"
+
+const beforeKey = "audit:before"
+
+func WithActor(ctx context.Context, actor Actor) context.Context {
+        return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func ActorFromContext(ctx context.Context) (Actor, bool) {
+        if ctx == nil {
+                return Actor{}, false
+        }
+        actor, ok := ctx.Value(actorKey{}).(Actor)
+        return actor, ok
+}
+
+// WithSource returns ctx with the actor's source replaced, e.g. to attribute
+// a write to the chatbot tool that made it.
+func WithSource(ctx context.Context, source string) context.Context {
+        actor, _ := ActorFromContext(ctx)
+        actor.Source = source
+        return WithActor(ctx, actor)
+}
+
+// Context returns the request's context carrying the authenticated user,
+// request ID and client IP. Pass it to database.DB.WithContext for writes.
+func Context(c *gin.Context) context.Context {
+        actor := Actor{
+                RequestID: c.GetString("requestID"),
+                IPAddress: c.ClientIP(),
+                Source:    SourceREST,
+        }
+        if uid, exists := c.Get("userID"); exists {
+                id := uid.(uint)
+                actor.UserID = &id
+        }
+        if keyID, exists := c.Get("apiKeyID"); exists {
+                actor.Source = fmt.Sprintf("%s:%d", SourceAPIKey, keyID)
+        }
+        return WithActor(c.Request.Context(), actor)
+}
+
+// Register installs the audit callbacks on db. Events are written inside the
+// same transaction as the change, so a failed audit write rolls it back.
+func Register(db *gorm.DB) error {
+        callback := db.Callback()
+
+        if err := callback.Create().After("gorm:create").Before("gorm:commit_or_rollback_transaction").
+                Register("audit:after_create", afterCreate); err != nil {
+                return err
+        }
+        if err := callback.Update().Before("gorm:update").Register("audit:before_update", snapshot); err != nil {
+                return err
+        }
+        if err := callback.Update().After("gorm:update").Before("gorm:commit_or_rollback_transaction").
+                Register("audit:after_update", afterUpdate); err != nil {
+                return err
+        }
+        if err := callback.Delete().Before("gorm:delete").Register("audit:before_delete", snapshot); err != nil {
+                return err
+        }
+        return callback.Delete().After("gorm:delete").Before("gorm:commit_or_rollback_transaction").
+                Register("audit:after_delete", afterDelete)
+}
+
+func audited(db *gorm.DB) bool {
+        stmt := db.Statement
+        return db.Error == nil && stmt.Schema != nil && stmt.Schema.PrioritizedPrimaryField != nil &&
+                !ignoredTables[stmt.Schema.Table]
+}
+
+// eachRow calls fn for every model struct in the statement's value.
+func eachRow(db *gorm.DB, fn func(reflect.Value)) {
+        rv := db.Statement.ReflectValue
+        modelType := db.Statement.Schema.ModelType
+
+        switch rv.Kind() {
+        case reflect.Slice, reflect.Array:
+                for i := 0; i < rv.Len(); i++ {
+                        if elem := reflect.Indirect(rv.Index(i)); elem.IsValid() && elem.Type() == modelType {
+                                fn(elem)
+                        }
+                }
+        case reflect.Struct:
+                if rv.Type() == modelType {
+                        fn(rv)
+                }
+        }
+}
+
+func primaryKeyIn(db *gorm.DB, ids []interface{}) clause.Expression {
+        return clause.IN{
+                Column: clause.Column{Table: clause.CurrentTable, Name: db.Statement.Schema.PrioritizedPrimaryField.DBName},
+                Values: ids,
+        }
+}
+
+// rowQuery starts a query for the statement's table that runs on the same
+// connection (and so the same transaction) as the statement itself. Rows are
+// read by table rather than model so serialized columns come back as stored.
+func rowQuery(db *gorm.DB, unscoped bool) *gorm.DB {
+        query := db.Session(&gorm.Session{NewDB: true}).Table(db.Statement.Schema.Table)
+        if unscoped {
+                return query
+        }
+        for _, field := range db.Statement.Schema.Fields {
+                if field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) {
+                        query = query.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: nil})
+                }
+        }
+        return query
+}
+
+// snapshot loads the rows an update or delete is about to touch, using the
+// statement's own conditions plus the primary key of the model it was given.
+func snapshot(db *gorm.DB) {
+        if !audited(db) {
+                return
+        }
+        stmt := db.Statement
+
+        query := rowQuery(db, stmt.Unscoped)
+
+        hasConditions := false
+        if c, ok := stmt.Clauses["WHERE"]; ok {
+                if where, ok := c.Expression.(clause.Where); ok && len(where.Exprs) > 0 {
+                        query = query.Clauses(where)
+                        hasConditions = true
+                }
+        }
+
+        var ids []interface{}
+        eachRow(db, func(rv reflect.Value) {
+                if id, isZero := stmt.Schema.PrioritizedPrimaryField.ValueOf(stmt.Context, rv); !isZero {
+                        ids = append(ids, id)
+                }
+        })
+        if len(ids) > 0 {
+                query = query.Clauses(clause.Where{Exprs: []clause.Expression{primaryKeyIn(db, ids)}})
+                hasConditions = true
+        }
+
+        // GORM refuses unconditional updates and deletes, so there is
+        // nothing to record.
+        if !hasConditions {
+                return
+        }
+
+        var rows []map[string]interface{}
+        if err := query.Find(&rows).Error; err != nil {
+                db.AddError(fmt.Errorf("audit: %w", err))
+                return
+        }
+        db.InstanceSet(beforeKey, rows)
+}
+
+func afterCreate(db *gorm.DB) {
+        // Rows skipped by ON CONFLICT DO NOTHING, e.g. when GORM saves an
+        // existing association, were not created.
+        if !audited(db) || db.RowsAffected == 0 {
+                return
+        }
+        stmt := db.Statement
+
+        var events []models.AuditEvent
+        eachRow(db, func(rv reflect.Value) {
+                changes := map[string]models.AuditChange{}
+                for _, field := range stmt.Schema.Fields {
+                        if field.DBName == "" || skippedColumns[field.DBName] {
+                                continue
+                        }
+                        value, isZero := field.ValueOf(stmt.Context, rv)
+                        if isZero {
+                                continue
+                        }
+                        // ValueOf wraps serialized fields; record the Go value
+                        if field.Serializer != nil {
+                                value = field.ReflectValueOf(stmt.Context, rv).Interface()
+                        }
+                        changes[field.DBName] = models.AuditChange{New: redact(db, field.DBName, value)}
+                }
+
+                id, _ := stmt.Schema.PrioritizedPrimaryField.ValueOf(stmt.Context, rv)
+                events = append(events, newEvent(db, ActionCreate, id, changes))
+        })
+
+        write(db, events)
+}
+
+func afterUpdate(db *gorm.DB) {
+        if !audited(db) || db.RowsAffected == 0 {
+                return
+        }
+        before := snapshotRows(db)
+        if len(before) == 0 {
+                return
+        }
+        pk := db.Statement.Schema.PrioritizedPrimaryField.DBName
+
+        ids := make([]interface{}, 0, len(before))
+        for _, row := range before {
+                ids = append(ids, row[pk])
+        }
+
+        // Unscoped, in case the update itself soft-deleted the row
+        var after []map[string]interface{}
+        if err := rowQuery(db, true).Clauses(clause.Where{Exprs: []clause.Expression{primaryKeyIn(db, ids)}}).
+                Find(&after).Error; err != nil {
+                db.AddError(fmt.Errorf("audit: %w", err))
+                return
+        }
+
+        afterByID := make(map[string]map[string]interface{}, len(after))
+        for _, row := range after {
+                afterByID[fmt.Sprint(row[pk])] = row
+        }
+
+        var events []models.AuditEvent
+        for _, old := range before {
+                current, ok := afterByID[fmt.Sprint(old[pk])]
+                if !ok {
+                        continue
+                }
+                // Rows the conditions matched but the update left alone
+                // produce no diff and are not recorded.
+                if changes := diff(db, old, current); len(changes) > 0 {
+                        events = append(events, newEvent(db, ActionUpdate, old[pk], changes))
+                }
+        }
+
+        write(db, events)
+}
+
+func afterDelete(db *gorm.DB) {
+        if !audited(db) || db.RowsAffected == 0 {
+                return
+        }
+        pk := db.Statement.Schema.PrioritizedPrimaryField.DBName
+
+        var events []models.AuditEvent
+        for _, row := range snapshotRows(db) {
+                changes := map[string]models.AuditChange{}
+                for column, value := range row {
+                        if value != nil && !skippedColumns[column] {
+                                changes[column] = models.AuditChange{Old: redact(db, column, value)}
+                        }
+                }
+                events = append(events, newEvent(db, ActionDelete, row[pk], changes))
+        }
+
+        write(db, events)
+}
+
+func snapshotRows(db *gorm.DB) []map[string]interface{} {
+        value, ok := db.InstanceGet(beforeKey)
+        if !ok {
+                return nil
+        }
+        rows, _ := value.([]map[string]interface{})
+        return rows
+}
+
+// diff returns the columns whose values differ between two loaded rows.
+func diff(db *gorm.DB, old, current map[string]interface{}) map[string]models.AuditChange {
+        changes := map[string]models.AuditChange{}
+        for column, newValue := range current {
+                if skippedColumns[column] {
+                        continue
+                }
+                oldValue := old[column]
+                if equal(deserialize(db, column, oldValue), deserialize(db, column, newValue)) {
+                        continue
+                }
+                changes[column] = models.AuditChange{
+                        Old: redact(db, column, oldValue),
+                        New: redact(db, column, newValue),
+                }
+        }
+        return changes
+}
+
+// deserialize converts a raw column value back into the field's Go value for
+// fields with a GORM serializer. Encrypted values are re-sealed with a fresh
+// nonce on every save, so only their plaintext can tell whether they changed.
+func deserialize(db *gorm.DB, column string, value interface{}) interface{} {
+        field := db.Statement.Schema.LookUpField(column)
+        if field == nil || field.Serializer == nil || value == nil {
+                return value
+        }
+        dst := reflect.New(db.Statement.Schema.ModelType).Elem()
+        if err := field.Serializer.Scan(db.Statement.Context, field, dst, value); err != nil {
+                return value
+        }
+        return field.ReflectValueOf(db.Statement.Context, dst).Interface()
+}
+
+// equal compares values by their JSON form, which is also how they are
+// stored, so e.g. the same instant in two time zones counts as unchanged.
+func equal(a, b interface{}) bool {
+        aj, errA := json.Marshal(a)
+        bj, errB := json.Marshal(b)
+        if errA != nil || errB != nil {
+                return reflect.DeepEqual(a, b)
+        }
+        return bytes.Equal(aj, bj)
+}
+
+func redact(db *gorm.DB, column string, value interface{}) interface{} {
+        if value == nil {
+                return nil
+        }
+        if redactedColumns[column] || strings.HasSuffix(column, "_hash") {
+                return redacted
+        }
+        if field := db.Statement.Schema.LookUpField(column); field != nil && field.TagSettings["SERIALIZER"] == fieldcrypt.SerializerName {
+                return redacted
+        }
+        return value
+}
+
+func newEvent(db *gorm.DB, action string, id interface{}, changes map[string]models.AuditChange) models.AuditEvent {
+        actor, _ := ActorFromContext(db.Statement.Context)
+        if actor.Source == "" {
+                actor.Source = SourceSystem
+        }
+
+        return models.AuditEvent{
+                ActorUserID: actor.UserID,
+                Action:      action,
+                Entity:      db.Statement.Schema.Table,
+                EntityID:    fmt.Sprint(id),
+                Changes:     changes,
+                RequestID:   actor.RequestID,
+                IPAddress:   actor.IPAddress,
+                Source:      actor.Source,
+        }
+}
+
+func write(db *gorm.DB, events []models.AuditEvent) {
+        if len(events) == 0 {
+                return
+        }
+        if err := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Create(&events).Error; err != nil {
+                db.AddError(fmt.Errorf("audit: %w", err))
+        }
+}
@@ -0,0 +1,67 @@
+// Package blobstore keeps the contents of uploaded files, such as employee
+// documents, outside the database.
+package blobstore
+
+import (
+        "context"
+        "errors"
+        "fmt"
+        "io"
+        "log"
+        "net/http"
+        "os"
+        "time"
+)
+
+// ErrNotFound is returned by Get for a key that holds nothing.
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore holds blobs under keys the caller chooses. Keys are slash
+// separated paths of letters, digits, dots, dashes and underscores.
+type BlobStore interface {
+        // Put stores size bytes read from body under key, replacing
+        // anything already there.
+        Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+        // Get opens the blob under key. The caller closes it.
+        Get(ctx context.Context, key string) (io.ReadCloser, error)
+        // Delete removes the blob under key. Deleting a missing blob is not
+        // an error.
+        Delete(ctx context.Context, key string) error
+}
+
+// Default is the store handlers use. Init configures it from the
+// environment.
+var Default BlobStore = &Local{Dir: "uploads"}
+
+// Init selects the store from DOCUMENT_STORE ("local" or "s3").
+func Init() error {
+        switch store := os.Getenv("DOCUMENT_STORE"); store {
+        case "s3":
+                s3 := &S3{
+                        Endpoint:        os.Getenv("S3_ENDPOINT"),
+                        Region:          os.Getenv("S3_REGION"),
+                        Bucket:          os.Getenv("S3_BUCKET"),
+                        AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+                        SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+                        Client:          &http.Client{Timeout: 5 * time.Minute},
+                }
+                if s3.Endpoint == "" || s3.Bucket == "" || s3.AccessKeyID == "" || s3.SecretAccessKey == "" {
+                        return fmt.Errorf("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required for the s3 store")
+                }
+                if s3.Region == "" {
+                        s3.Region = "us-east-1"
+                }
+                Default = s3
+                log.Println("Documents stored in S3 bucket", s3.Bucket)
+        case "", "local":
+                dir := os.Getenv("DOCUMENT_DIR")
+                if dir == "" {
+                        dir = "uploads"
+                }
+                Default = &Local{Dir: dir}
+                log.Println("Documents stored in", dir)
+        default:
+                return fmt.Errorf("unknown DOCUMENT_STORE %q", store)
+        }
+        return nil
+}
@@ -0,0 +1,250 @@
+// Package fakes3 is an in-memory stand-in for an S3-compatible service, for
+// testing the s3 document store without MinIO or AWS. It serves path-style
+// PUT, GET and DELETE of objects and checks every request's Signature
+// Version 4 independently of the client that made it.
+package fakes3
+
+import (
+        "bytes"
+        "crypto/hmac"
+        "crypto/sha256"
+        "encoding/hex"
+        "encoding/xml"
+        "io"
+        "net/http"
+        "net/url"
+        "sort"
+        "strings"
+        "sync"
+        "time"
+)
+
+// maxClockSkew is how far a request's X-Amz-Date may be from now, as on S3.
+const maxClockSkew = 15 * time.Minute
+
+type object struct {
+        data        []byte
+        contentType string
+}
+
+// Server holds the objects of the buckets it was created with. Requests must
+// be signed with its credentials for its region.
+type Server struct {
+        Region          string
+        AccessKeyID     string
+        SecretAccessKey string
+
+        mu      sync.Mutex
+        buckets map[string]map[string]object
+}
+
+// New returns a server with the given, empty, buckets.
+func New(region, accessKeyID, secretAccessKey string, buckets ...string) *Server {
+        s := &Server{
+                Region:          region,
+                AccessKeyID:     accessKeyID,
+                SecretAccessKey: secretAccessKey,
+                buckets:         map[string]map[string]object{},
+        }
+        for _, bucket := range buckets {
+                s.buckets[bucket] = map[string]object{}
+        }
+        return s
+}
+
+// Object returns the stored content of key in bucket, for checking what a
+// client wrote.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+        s.mu.Lock()
+        defer s.mu.Unlock()
+        obj, ok := s.buckets[bucket][key]
+        return obj.data, ok
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+        body, err := io.ReadAll(r.Body)
+        if err != nil {
+                writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+                return
+        }
+        if code, message := s.verify(r, body); code != "" {
+                writeError(w, http.StatusForbidden, code, message)
+                return
+        }
+
+        bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+        s.mu.Lock()
+        defer s.mu.Unlock()
+        objects, ok := s.buckets[bucket]
+        if !ok {
+                writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+                return
+        }
+        if key == "" {
+                writeError(w, http.StatusBadRequest, "InvalidRequest", "Only object requests are supported")
+                return
+        }
+
+        switch r.Method {
+        case http.MethodPut:
+                if r.ContentLength >= 0 && int64(len(body)) != r.ContentLength {
+                        writeError(w, http.StatusBadRequest, "IncompleteBody", "The body is shorter than Content-Length")
+                        return
+                }
+                objects[key] = object{data: body, contentType: r.Header.Get("Content-Type")}
+                w.WriteHeader(http.StatusOK)
+        case http.MethodGet:
+                obj, ok := objects[key]
+                if !ok {
+                        writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+                        return
+                }
+                if obj.contentType != "" {
+                        w.Header().Set("Content-Type", obj.contentType)
+                }
+                w.WriteHeader(http.StatusOK)
+                w.Write(obj.data)
+        case http.MethodDelete:
+                delete(objects, key)
+                w.WriteHeader(http.StatusNoContent)
+        default:
+                writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The method is not allowed")
+        }
+}
+
+// verify checks r's Signature Version 4 Authorization header, returning an
+// S3 error code and message if it doesn't hold.
+func (s *Server) verify(r *http.Request, body []byte) (string, string) {
+        auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+        if !ok {
+                return "AccessDenied", "Requests must be signed with AWS4-HMAC-SHA256"
+        }
+        fields := map[string]string{}
+        for _, part := range strings.Split(auth, ",") {
+                name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+                fields[name] = value
+        }
+        credential := strings.Split(fields["Credential"], "/")
+        if len(credential) != 5 || credential[4] != "aws4_request" || credential[3] != "s3" {
+                return "AuthorizationHeaderMalformed", "The Credential is malformed"
+        }
+        if credential[0] != s.AccessKeyID {
+                return "InvalidAccessKeyId", "The access key ID does not exist"
+        }
+        if credential[2] != s.Region {
+                return "AuthorizationHeaderMalformed", "The region is wrong; expecting " + s.Region
+        }
+
+        amzDate := r.Header.Get("X-Amz-Date")
+        date, err := time.Parse("20060102T150405Z", amzDate)
+        if err != nil || credential[1] != date.Format("20060102") {
+                return "AccessDenied", "X-Amz-Date is missing or doesn't match the credential"
+        }
+        if skew := time.Since(date); skew > maxClockSkew || skew < -maxClockSkew {
+                return "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large"
+        }
+
+        payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+        if payloadHash != "UNSIGNED-PAYLOAD" {
+                sum := sha256.Sum256(body)
+                if payloadHash != hex.EncodeToString(sum[:]) {
+                        return "XAmzContentSHA256Mismatch", "The body doesn't match X-Amz-Content-Sha256"
+                }
+        }
+
+        signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+        if !sort.StringsAreSorted(signedHeaders) || !contains(signedHeaders, "host") {
+                return "AccessDenied", "SignedHeaders must be sorted and include host"
+        }
+        var canonicalHeaders strings.Builder
+        for _, name := range signedHeaders {
+                value := r.Header.Get(name)
+                if name == "host" {
+                        value = r.Host
+                }
+                canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+        }
+        canonicalRequest := strings.Join([]string{
+                r.Method,
+                canonicalPath(r.URL.Path),
+                canonicalQuery(r.URL.Query()),
+                canonicalHeaders.String(),
+                fields["SignedHeaders"],
+                payloadHash,
+        }, "\n")
+
+        scope := strings.Join(credential[1:], "/")
+        requestHash := sha256.Sum256([]byte(canonicalRequest))
+        stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+        key := []byte("AWS4" + s.SecretAccessKey)
+        for _, part := range credential[1:] {
+                key = mac(key, part)
+        }
+        expected := hex.EncodeToString(mac(key, stringToSign))
+        if !hmac.Equal([]byte(expected), []byte(fields["Signature"])) {
+                return "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided"
+        }
+        return "", ""
+}
+
+// canonicalPath encodes each segment of the decoded path, so a client that
+// escapes a key differently from the spec fails to match.
+func canonicalPath(path string) string {
+        segments := strings.Split(path, "/")
+        for i, segment := range segments {
+                segments[i] = escape(segment)
+        }
+        return strings.Join(segments, "/")
+}
+
+func canonicalQuery(query url.Values) string {
+        var parts []string
+        for name, values := range query {
+                for _, value := range values {
+                        parts = append(parts, escape(name)+"="+escape(value))
+                }
+        }
+        sort.Strings(parts)
+        return strings.Join(parts, "&")
+}
+
+// escape is URI encoding as Signature Version 4 defines it.
+func escape(value string) string {
+        var out strings.Builder
+        for _, b := range []byte(value) {
+                if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || strings.IndexByte("-._~", b) >= 0 {
+                        out.WriteByte(b)
+                } else {
+                        out.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{b})))
+                }
+        }
+        return out.String()
+}
+
+func mac(key []byte, data string) []byte {
+        h := hmac.New(sha256.New, key)
+        h.Write([]byte(data))
+        return h.Sum(nil)
+}
+
+func contains(values []string, value string) bool {
+        for _, v := range values {
+                if v == value {
+                        return true
+                }
+        }
+        return false
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+        var body bytes.Buffer
+        body.WriteString(xml.Header)
+        xml.NewEncoder(&body).Encode(struct {
+                XMLName xml.Name `xml:"Error"`
+                Code    string
+                Message string
+        }{Code: code, Message: message})
+        w.Header().Set("Content-Type", "application/xml")
+        w.WriteHeader(status)
+        w.Write(body.Bytes())
+}
@@ -0,0 +1,76 @@
+package blobstore
+
+import (
+        "context"
+        "errors"
+        "fmt"
+        "io"
+        "os"
+        "path/filepath"
+        "strings"
+)
+
+// Local keeps blobs as files under Dir, one per key.
+type Local struct {
+        Dir string
+}
+
+func (l *Local) path(key string) (string, error) {
+        clean := filepath.Clean("/" + key)
+        if key == "" || clean != "/"+key || strings.Contains(key, "\\") {
+                return "", fmt.Errorf("invalid blob key %q", key)
+        }
+        return filepath.Join(l.Dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes to a temporary file and renames it into place, so a failed
+// upload never leaves a partial blob behind.
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+        path, err := l.path(key)
+        if err != nil {
+                return err
+        }
+        if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+                return err
+        }
+        f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+        if err != nil {
+                return err
+        }
+        defer os.Remove(f.Name())
+
+        written, err := io.Copy(f, body)
+        if closeErr := f.Close(); err == nil {
+                err = closeErr
+        }
+        if err != nil {
+                return err
+        }
+        if written != size {
+                return fmt.Errorf("blob %q: wrote %d bytes, expected %d", key, written, size)
+        }
+        return os.Rename(f.Name(), path)
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+        path, err := l.path(key)
+        if err != nil {
+                return nil, err
+        }
+        f, err := os.Open(path)
+        if errors.Is(err, os.ErrNotExist) {
+                return nil, ErrNotFound
+        }
+        return f, err
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+        path, err := l.path(key)
+        if err != nil {
+                return err
+        }
+        if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+                return err
+        }
+        return nil
+}
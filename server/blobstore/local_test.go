@@ -0,0 +1,91 @@
+package blobstore
+
+import (
+        "context"
+        "errors"
+        "io"
+        "os"
+        "path/filepath"
+        "strings"
+        "testing"
+)
+
+func TestLocalRoundTrip(t *testing.T) {
+        store := &Local{Dir: t.TempDir()}
+        ctx := context.Background()
+        key := "employees/7/contract.pdf"
+
+        if err := store.Put(ctx, key, strings.NewReader("signed"), 6, "application/pdf"); err != nil {
+                t.Fatalf("Put: %v", err)
+        }
+        body, err := store.Get(ctx, key)
+        if err != nil {
+                t.Fatalf("Get: %v", err)
+        }
+        data, err := io.ReadAll(body)
+        body.Close()
+        if err != nil || string(data) != "signed" {
+                t.Fatalf("Get returned %q, %v; want %q", data, err, "signed")
+        }
+
+        if err := store.Delete(ctx, key); err != nil {
+                t.Fatalf("Delete: %v", err)
+        }
+        if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+                t.Fatalf("Get after Delete: got %v, want ErrNotFound", err)
+        }
+        if err := store.Delete(ctx, key); err != nil {
+                t.Fatalf("Delete of a missing blob: %v", err)
+        }
+}
+
+func TestLocalShortUpload(t *testing.T) {
+        dir := t.TempDir()
+        store := &Local{Dir: dir}
+        ctx := context.Background()
+
+        if err := store.Put(ctx, "a/b.txt", strings.NewReader("short"), 100, ""); err == nil {
+                t.Fatal("Put of fewer bytes than size: want an error")
+        }
+        if _, err := store.Get(ctx, "a/b.txt"); !errors.Is(err, ErrNotFound) {
+                t.Errorf("Get after a failed Put: got %v, want ErrNotFound", err)
+        }
+        entries, _ := os.ReadDir(filepath.Join(dir, "a"))
+        if len(entries) != 0 {
+                t.Errorf("a failed Put left %d files behind", len(entries))
+        }
+}
+
+func TestLocalRejectsTraversal(t *testing.T) {
+        parent := t.TempDir()
+        store := &Local{Dir: filepath.Join(parent, "blobs")}
+        ctx := context.Background()
+
+        keys := []string{
+                "",
+                "../escaped.txt",
+                "employees/../../escaped.txt",
+                "/etc/passwd",
+                "./a.txt",
+                "a//b.txt",
+                "a/",
+                `a\..\..\escaped.txt`,
+        }
+        for _, key := range keys {
+                if _, err := store.path(key); err == nil {
+                        t.Errorf("path(%q): want an error", key)
+                }
+                if err := store.Put(ctx, key, strings.NewReader("x"), 1, ""); err == nil {
+                        t.Errorf("Put(%q): want an error", key)
+                }
+                if _, err := store.Get(ctx, key); err == nil || errors.Is(err, ErrNotFound) {
+                        t.Errorf("Get(%q): got %v, want an invalid key error", key, err)
+                }
+                if err := store.Delete(ctx, key); err == nil {
+                        t.Errorf("Delete(%q): want an error", key)
+                }
+        }
+        if _, err := os.Stat(filepath.Join(parent, "escaped.txt")); !errors.Is(err, os.ErrNotExist) {
+                t.Errorf("a blob was written outside Dir")
+        }
+}
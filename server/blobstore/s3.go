@@ -0,0 +1,212 @@
+package blobstore
+
+import (
+        "context"
+        "crypto/hmac"
+        "crypto/sha256"
+        "encoding/hex"
+        "encoding/xml"
+        "fmt"
+        "io"
+        "net/http"
+        "net/url"
+        "sort"
+        "strings"
+        "time"
+)
+
+// unsignedPayload stands in for the body's hash in a signature, so uploads
+// can be streamed without reading them twice.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// emptyPayload is the SHA-256 of an empty body.
+const emptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3 keeps blobs as objects in a bucket of Amazon S3 or a compatible service
+// such as MinIO. Objects are addressed path-style, as Endpoint/Bucket/key,
+// which every S3-compatible service accepts.
+type S3 struct {
+        // Endpoint is the service's base URL, such as
+        // https://s3.eu-west-1.amazonaws.com or http://localhost:9000.
+        Endpoint        string
+        Region          string
+        Bucket          string
+        AccessKeyID     string
+        SecretAccessKey string
+        Client          *http.Client
+}
+
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+        req, err := s.request(ctx, http.MethodPut, key, body)
+        if err != nil {
+                return err
+        }
+        req.ContentLength = size
+        if contentType != "" {
+                req.Header.Set("Content-Type", contentType)
+        }
+        resp, err := s.do(req, unsignedPayload)
+        if err != nil {
+                return err
+        }
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+                return s3Error(req, resp)
+        }
+        return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+        req, err := s.request(ctx, http.MethodGet, key, nil)
+        if err != nil {
+                return nil, err
+        }
+        resp, err := s.do(req, emptyPayload)
+        if err != nil {
+                return nil, err
+        }
+        switch resp.StatusCode {
+        case http.StatusOK:
+                return resp.Body, nil
+        case http.StatusNotFound:
+                resp.Body.Close()
+                return nil, ErrNotFound
+        }
+        defer resp.Body.Close()
+        return nil, s3Error(req, resp)
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+        req, err := s.request(ctx, http.MethodDelete, key, nil)
+        if err != nil {
+                return err
+        }
+        resp, err := s.do(req, emptyPayload)
+        if err != nil {
+                return err
+        }
+        defer resp.Body.Close()
+        switch resp.StatusCode {
+        case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+                return nil
+        }
+        return s3Error(req, resp)
+}
+
+func (s *S3) request(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+        if key == "" || strings.HasPrefix(key, "/") {
+                return nil, fmt.Errorf("invalid blob key %q", key)
+        }
+        target := strings.TrimRight(s.Endpoint, "/") + "/" + s.Bucket + "/" + escapePath(key)
+        return http.NewRequestWithContext(ctx, method, target, body)
+}
+
+func (s *S3) do(req *http.Request, payloadHash string) (*http.Response, error) {
+        s.sign(req, payloadHash, time.Now())
+        client := s.Client
+        if client == nil {
+                client = http.DefaultClient
+        }
+        return client.Do(req)
+}
+
+// sign adds AWS Signature Version 4 headers to req. The host, range,
+// content type and x-amz-* headers are signed.
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+        now = now.UTC()
+        amzDate := now.Format("20060102T150405Z")
+        day := now.Format("20060102")
+        req.Header.Set("X-Amz-Date", amzDate)
+        req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+        headers := map[string]string{"host": req.URL.Host}
+        for name, values := range req.Header {
+                lower := strings.ToLower(name)
+                if lower == "content-type" || lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+                        headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+                }
+        }
+        names := make([]string, 0, len(headers))
+        for name := range headers {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+        var canonicalHeaders strings.Builder
+        for _, name := range names {
+                canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+        }
+        signedHeaders := strings.Join(names, ";")
+
+        canonicalRequest := strings.Join([]string{
+                req.Method,
+                req.URL.EscapedPath(),
+                canonicalQuery(req.URL.Query()),
+                canonicalHeaders.String(),
+                signedHeaders,
+                payloadHash,
+        }, "\n")
+
+        scope := day + "/" + s.Region + "/s3/aws4_request"
+        stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256(canonicalRequest)
+
+        key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), day)
+        key = hmacSHA256(key, s.Region)
+        key = hmacSHA256(key, "s3")
+        key = hmacSHA256(key, "aws4_request")
+        signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+        req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKeyID+"/"+scope+
+                ", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// escapePath percent-encodes each segment of a key as S3 expects: everything
+// but unreserved characters.
+func escapePath(key string) string {
+        segments := strings.Split(key, "/")
+        for i, segment := range segments {
+                segments[i] = strings.ReplaceAll(url.QueryEscape(segment), "+", "%20")
+        }
+        return strings.Join(segments, "/")
+}
+
+func canonicalQuery(query url.Values) string {
+        keys := make([]string, 0, len(query))
+        for key := range query {
+                keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        var parts []string
+        for _, key := range keys {
+                values := append([]string{}, query[key]...)
+                sort.Strings(values)
+                for _, value := range values {
+                        parts = append(parts, escapePath(key)+"="+escapePath(value))
+                }
+        }
+        return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+        mac := hmac.New(sha256.New, key)
+        mac.Write([]byte(data))
+        return mac.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+        sum := sha256.Sum256([]byte(data))
+        return hex.EncodeToString(sum[:])
+}
+
+// s3Error describes a failed request, with the service's error code when the
+// body has one.
+func s3Error(req *http.Request, resp *http.Response) error {
+        var body struct {
+                Code    string
+                Message string
+        }
+        data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+        if xml.Unmarshal(data, &body) == nil && body.Code != "" {
+                return fmt.Errorf("s3: %s %s: %s: %s", req.Method, req.URL.Path, body.Code, body.Message)
+        }
+        return fmt.Errorf("s3: %s %s: %s", req.Method, req.URL.Path, resp.Status)
+}
@@ -0,0 +1,37 @@
+// Command mockidp is a throwaway OpenID Connect provider for trying single
+// sign-on locally. Its sign-in page lets you type any email and groups, so
+// every provisioning and role mapping case can be exercised by hand. Never
+// expose it: it authenticates anyone as anyone.
+//
+//	go run ./cmd/mockidp [-addr :9000] [-client-id hcm-local]
+//
+// and start the server with
+//
+//	OIDC_ISSUER=http://localhost:9000
+//	OIDC_CLIENT_ID=hcm-local
+//	OIDC_REDIRECT_URL=http://localhost:8080/api/auth/oidc/callback
+//	OIDC_ROLE_MAPPING=hr=hr_admin,payroll=payroll_admin,managers=manager
+package main
+
+import (
+        "flag"
+        "log"
+        "net/http"
+
+        "hcm-backend/oidc/fakeidp"
+)
+
+func main() {
+        addr := flag.String("addr", ":9000", "listen address")
+        issuer := flag.String("issuer", "http://localhost:9000", "issuer URL the server is configured with")
+        clientID := flag.String("client-id", "hcm-local", "the only client ID accepted")
+        flag.Parse()
+
+        server, err := fakeidp.New(*issuer, *clientID)
+        if err != nil {
+                log.Fatal(err)
+        }
+
+        log.Printf("Mock IdP listening on %s as issuer %s", *addr, *issuer)
+        log.Fatal(http.ListenAndServe(*addr, server))
+}
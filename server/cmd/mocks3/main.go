@@ -0,0 +1,35 @@
+// Command mocks3 is a throwaway in-memory S3 service for trying the s3
+// document store locally without MinIO or AWS. Objects are lost when it
+// stops. Never expose it.
+//
+//	go run ./cmd/mocks3 [-addr :9100] [-bucket hcm-documents]
+//
+// and start the server with
+//
+//	DOCUMENT_STORE=s3
+//	S3_ENDPOINT=http://localhost:9100
+//	S3_BUCKET=hcm-documents
+//	S3_ACCESS_KEY_ID=mock
+//	S3_SECRET_ACCESS_KEY=mock-secret
+package main
+
+import (
+        "flag"
+        "log"
+        "net/http"
+
+        "hcm-backend/blobstore/fakes3"
+)
+
+func main() {
+        addr := flag.String("addr", ":9100", "listen address")
+        region := flag.String("region", "us-east-1", "region requests must be signed for")
+        bucket := flag.String("bucket", "hcm-documents", "the only bucket")
+        accessKeyID := flag.String("access-key-id", "mock", "access key ID requests must be signed with")
+        secretAccessKey := flag.String("secret-access-key", "mock-secret", "secret access key requests must be signed with")
+        flag.Parse()
+
+        server := fakes3.New(*region, *accessKeyID, *secretAccessKey, *bucket)
+        log.Printf("Mock S3 listening on %s with bucket %s", *addr, *bucket)
+        log.Fatal(http.ListenAndServe(*addr, server))
+}
@@ -0,0 +1,173 @@
+// Command reencrypt rewrites encrypted employee fields and users' two-factor
+// secrets under the active key and refreshes the employees' blind indexes. Run it after adding a new key to
+// FIELD_ENCRYPTION_KEYS and making it active; once it reports nothing left to
+// do, the old key can be removed. It also encrypts plaintext left over from
+// before the fields were encrypted.
+//
+//      go run ./cmd/reencrypt [-dry-run]
+package main
+
+import (
+        "flag"
+        "log"
+
+        "hcm-backend/database"
+        "hcm-backend/fieldcrypt"
+        "hcm-backend/models"
+
+        "github.com/joho/godotenv"
+        "gorm.io/gorm"
+)
+
+const batchSize = 100
+
+// Columns as stored, so their key IDs can be inspected without decrypting.
+type storedEmployee struct {
+        ID             uint
+        NationalID  string
+        TaxID       string
+        BankAccount string
+        DateOfBirth *string
+}
+
+// storedUser is a user's two-factor secret as stored.
+type storedUser struct {
+        ID         uint
+        TOTPSecret string
+}
+
+func (e storedEmployee) needsReencrypt() bool {
+        dateOfBirth := ""
+        if e.DateOfBirth != nil {
+                dateOfBirth = *e.DateOfBirth
+        }
+        for _, value := range []string{e.NationalID, e.TaxID, e.BankAccount, dateOfBirth} {
+                if fieldcrypt.NeedsReencrypt(value) {
+                        return true
+                }
+        }
+        return false
+}
+
+func main() {
+        dryRun := flag.Bool("dry-run", false, "report how many rows need rewriting without changing them")
+        flag.Parse()
+
+        if err := godotenv.Load(); err != nil {
+                log.Println("No .env file found, using environment variables")
+        }
+        if err := fieldcrypt.Init(); err != nil {
+                log.Fatal("Failed to load field encryption keys:", err)
+        }
+
+        database.Connect()
+        database.Migrate()
+
+        var pending []uint
+        var stored []storedEmployee
+        result := database.DB.Table("employees").
+                Select("id, national_id, tax_id, bank_account, date_of_birth").
+                FindInBatches(&stored, batchSize, func(tx *gorm.DB, batch int) error {
+                        for _, e := range stored {
+                                if e.needsReencrypt() {
+                                        pending = append(pending, e.ID)
+                                }
+                        }
+                        return nil
+                })
+        if result.Error != nil {
+                log.Fatal("Failed to scan employees:", result.Error)
+        }
+
+        // Blind indexes can be stale without any ciphertext needing work, for
+        // example after FIELD_HASH_KEY changed, so check every row's hashes.
+        var employees []models.Employee
+        result = database.DB.Unscoped().FindInBatches(&employees, batchSize, func(tx *gorm.DB, batch int) error {
+                for _, e := range employees {
+                        if !hashMatches(e.NationalIDHash, e.NationalID) || !hashMatches(e.TaxIDHash, e.TaxID) {
+                                pending = append(pending, e.ID)
+                        }
+                }
+                return nil
+        })
+        if result.Error != nil {
+                log.Fatal("Failed to read employees:", result.Error)
+        }
+        pending = unique(pending)
+
+        var pendingUsers []uint
+        var users []storedUser
+        result = database.DB.Table("users").Select("id, totp_secret").
+                FindInBatches(&users, batchSize, func(tx *gorm.DB, batch int) error {
+                        for _, u := range users {
+                                if fieldcrypt.NeedsReencrypt(u.TOTPSecret) {
+                                        pendingUsers = append(pendingUsers, u.ID)
+                                }
+                        }
+                        return nil
+                })
+        if result.Error != nil {
+                log.Fatal("Failed to scan users:", result.Error)
+        }
+
+        log.Printf("%d employee(s) and %d user(s) need re-encrypting under key %q", len(pending), len(pendingUsers), fieldcrypt.ActiveKeyID())
+        if *dryRun || len(pending)+len(pendingUsers) == 0 {
+                return
+        }
+
+        rewrittenUsers := 0
+        for _, id := range pendingUsers {
+                var user models.User
+                if err := database.DB.Unscoped().First(&user, id).Error; err != nil {
+                        log.Printf("Skipping user %d: %v", id, err)
+                        continue
+                }
+                if err := database.DB.Unscoped().Model(&user).Select("totp_secret").Updates(&user).Error; err != nil {
+                        log.Fatalf("Failed to re-encrypt user %d: %v", id, err)
+                }
+                rewrittenUsers++
+        }
+        if len(pendingUsers) > 0 {
+                log.Printf("Re-encrypted %d user(s)", rewrittenUsers)
+        }
+
+        rewritten := 0
+        for _, id := range pending {
+                var employee models.Employee
+                if err := database.DB.Unscoped().First(&employee, id).Error; err != nil {
+                        log.Printf("Skipping employee %d: %v", id, err)
+                        continue
+                }
+
+                // Saving re-seals every encrypted field with the active key
+                // and BeforeSave recomputes the blind indexes.
+                if err := database.DB.Unscoped().Model(&employee).
+                        Select("national_id", "national_id_hash", "tax_id", "tax_id_hash", "bank_account", "date_of_birth").
+                        Updates(&employee).Error; err != nil {
+                        log.Fatalf("Failed to re-encrypt employee %d: %v", id, err)
+                }
+                rewritten++
+        }
+
+        log.Printf("Re-encrypted %d employee(s)", rewritten)
+}
+
+func hashMatches(stored *string, value string) bool {
+        expected := fieldcrypt.BlindIndex(value)
+        if stored == nil {
+                return expected == ""
+        }
+        return *stored == expected
+}
+
+func unique(ids []uint) []uint {
+        seen := make(map[uint]bool, len(ids))
+        var out []uint
+        for _, id := range ids {
+                if !seen[id] {
+                        seen[id] = true
+                        out = append(out, id)
+                }
+        }
+        return out
+}
@@ -6,7 +6,9 @@ import (
         "strings"
         "time"
 
+        "hcm-backend/audit"
         "hcm-backend/models"
+        "hcm-backend/timezone"
 
         "golang.org/x/crypto/bcrypt"
         "gorm.io/driver/postgres"
@@ -27,6 +29,10 @@ func Connect() {
                 log.Fatal("Failed to connect to database:", err)
         }
 
+        if err := audit.Register(DB); err != nil {
+                log.Fatal("Failed to register audit callbacks:", err)
+        }
+
         log.Println("Database connected successfully")
 }
 
@@ -42,13 +48,175 @@ func Migrate() {
                 &models.PayrollExport{},
                 &models.ChatFeedback{},
                 &models.ChatbotSettings{},
+                &models.Session{},
+                &models.UserToken{},
+                &models.RecoveryCode{},
+                &models.RolePolicy{},
+                &models.LoginThrottle{},
+                &models.SecurityEvent{},
+                &models.Invitation{},
+                &models.AuditEvent{},
+                &models.APIKey{},
+                &models.OIDCLoginState{},
+                &models.EmploymentEvent{},
+                &models.JobRecord{},
+                &models.CustomField{},
+                &models.Shift{},
+                &models.ShiftAssignment{},
+                &models.WorkLocation{},
+                &models.OvertimePolicy{},
+                &models.Holiday{},
         )
         if err != nil {
                 log.Fatal("Failed to migrate database:", err)
         }
+        createSearchIndexes()
+        createCustomFieldIndex()
+        backfillJobRecords()
+        backfillAttendanceDays()
+        normalizeLeaveStatuses()
+        flagSeedPasswords()
         log.Println("Database migrated successfully")
 }
 
+// createSearchIndexes adds the trigram index behind employee search. It needs
+// the pg_trgm extension; without it search still works, just unindexed.
+func createSearchIndexes() {
+        if err := DB.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+                log.Println("pg_trgm is unavailable, employee search will not be indexed:", err)
+                return
+        }
+        err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_employees_search ON employees USING gin ((" +
+                models.EmployeeSearchDocument + ") gin_trgm_ops)").Error
+        if err != nil {
+                log.Println("Failed to create the employee search index:", err)
+        }
+}
+
+// createCustomFieldIndex indexes custom field values for the containment
+// queries the employee list filters on them with.
+func createCustomFieldIndex() {
+        err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_employees_custom_fields ON employees USING gin (custom_fields jsonb_path_ops)").Error
+        if err != nil {
+                log.Println("Failed to create the custom field index:", err)
+        }
+}
+
+// backfillJobRecords gives every employee without job history a first
+// record from their hire date, so as-of queries cover people hired before
+// history was kept.
+func backfillJobRecords() {
+        var employees []models.Employee
+        err := DB.Select("id", "job_title", "department_id", "manager_id", "job_level", "base_salary", "hire_date", "created_at").
+                Where("NOT EXISTS (SELECT 1 FROM job_records WHERE job_records.employee_id = employees.id)").
+                Find(&employees).Error
+        if err != nil {
+                log.Println("Failed to backfill job history:", err)
+                return
+        }
+
+        now := time.Now()
+        for _, e := range employees {
+                start := e.HireDate
+                if start.Year() <= 1 || start.After(now) {
+                        start = e.CreatedAt
+                }
+                record := models.JobRecordFor(e, time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC))
+                record.AppliedAt = &now
+                if err := DB.Create(&record).Error; err != nil {
+                        log.Println("Failed to backfill job history:", err)
+                        return
+                }
+        }
+        if len(employees) > 0 {
+                log.Printf("Started job history for %d employees", len(employees))
+        }
+}
+
+// backfillAttendanceDays moves attendance recorded before timezones were
+// kept onto the form dates are now stored in: the day, as midnight UTC, the
+// database put it on. They are taken to be in the default zone.
+func backfillAttendanceDays() {
+        result := DB.Model(&models.Attendance{}).Where("timezone IS NULL OR timezone = ''").
+                UpdateColumns(map[string]interface{}{
+                        "date":     gorm.Expr("CAST(DATE(date) AS timestamp) AT TIME ZONE 'UTC'"),
+                        "timezone": timezone.Default().String(),
+                })
+        if result.Error != nil {
+                log.Println("Failed to backfill attendance days:", result.Error)
+        } else if result.RowsAffected > 0 {
+                log.Printf("Set the day and timezone of %d attendance records", result.RowsAffected)
+        }
+}
+
+// seedPassword is the password SeedData gives every account it creates.
+const seedPassword = "password"
+
+// seedEmployees are the demo employees SeedData creates, each with a user
+// account named after their first name.
+var seedEmployees = []struct {
+        Name       string
+        Email      string
+        Department uint
+        JobTitle   string
+        HireDate   time.Time
+        Role       string
+}{
+        {"Alice Johnson", "alice.johnson@company.com", 1, "Senior Software Engineer", time.Date(2020, 1, 15, 0, 0, 0, 0, time.UTC), models.RoleSystemAdmin},
+        {"Bob Smith", "bob.smith@company.com", 1, "Frontend Developer", time.Date(2021, 3, 20, 0, 0, 0, 0, time.UTC), models.RoleEmployee},
+        {"Carol White", "carol.white@company.com", 2, "HR Manager", time.Date(2019, 6, 10, 0, 0, 0, 0, time.UTC), models.RoleHRAdmin},
+        {"David Brown", "david.brown@company.com", 2, "Recruiter", time.Date(2022, 2, 5, 0, 0, 0, 0, time.UTC), models.RoleEmployee},
+        {"Emma Davis", "emma.davis@company.com", 3, "Sales Director", time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC), models.RoleManager},
+        {"Frank Wilson", "frank.wilson@company.com", 3, "Account Executive", time.Date(2021, 11, 15, 0, 0, 0, 0, time.UTC), models.RoleEmployee},
+        {"Grace Lee", "grace.lee@company.com", 1, "DevOps Engineer", time.Date(2020, 7, 22, 0, 0, 0, 0, time.UTC), models.RoleEmployee},
+        {"Henry Martinez", "henry.martinez@company.com", 3, "Sales Representative", time.Date(2023, 1, 10, 0, 0, 0, 0, time.UTC), models.RoleEmployee},
+        {"Iris Taylor", "iris.taylor@company.com", 2, "HR Coordinator", time.Date(2022, 8, 30, 0, 0, 0, 0, time.UTC), models.RolePayrollAdmin},
+        {"Jack Anderson", "jack.anderson@company.com", 1, "Backend Developer", time.Date(2021, 5, 18, 0, 0, 0, 0, time.UTC), models.RoleEmployee},
+}
+
+// normalizeLeaveStatuses lowercases leave statuses the chat assistant used to
+// write capitalized, so status filters and offboarding match them.
+func normalizeLeaveStatuses() {
+        result := DB.Model(&models.LeaveRequest{}).Where("status <> LOWER(status)").
+                UpdateColumn("status", gorm.Expr("LOWER(status)"))
+        if result.Error != nil {
+                log.Println("Failed to normalize leave statuses:", result.Error)
+        } else if result.RowsAffected > 0 {
+                log.Printf("Lowercased the status of %d leave requests", result.RowsAffected)
+        }
+}
+
+// flagSeedPasswords forces a password change on seeded accounts that are
+// still on the shared seed password, for databases seeded before new
+// accounts had to change it. Only the seeded emails are checked, as bcrypt
+// is too slow to try against every user on each start.
+func flagSeedPasswords() {
+        emails := make([]string, len(seedEmployees))
+        for i, data := range seedEmployees {
+                emails[i] = data.Email
+        }
+        var users []models.User
+        if err := DB.Select("id", "password").Where("email IN ? AND must_change_password = ?", emails, false).Find(&users).Error; err != nil {
+                log.Println("Failed to check seeded passwords:", err)
+                return
+        }
+
+        var flagged []uint
+        for _, user := range users {
+                if user.Password != "" && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(seedPassword)) == nil {
+                        flagged = append(flagged, user.ID)
+                }
+        }
+        if len(flagged) == 0 {
+                return
+        }
+        if err := DB.Model(&models.User{}).Where("id IN ?", flagged).Update("must_change_password", true).Error; err != nil {
+                log.Println("Failed to flag seeded passwords:", err)
+                return
+        }
+        log.Printf("%d seeded accounts still use the default password and must change it", len(flagged))
+}
+
 func SeedData() {
         var count int64
         DB.Model(&models.Department{}).Count(&count)
@@ -57,8 +225,8 @@ func SeedData() {
                 return
         }
 
-        // Hash the default password "password" for all users
-        hashedPassword, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+        // Hash the default password for all users
+        hashedPassword, err := bcrypt.GenerateFromPassword([]byte(seedPassword), bcrypt.DefaultCost)
         if err != nil {
                 log.Fatal("Failed to hash password:", err)
         }
@@ -74,26 +242,7 @@ func SeedData() {
         }
 
         // Create users and employees with linked accounts
-        employeeData := []struct {
-                Name       string
-                Email      string
-                Department uint
-                JobTitle   string
-                HireDate   time.Time
-        }{
-                {"Alice Johnson", "alice.johnson@company.com", 1, "Senior Software Engineer", time.Date(2020, 1, 15, 0, 0, 0, 0, time.UTC)},
-                {"Bob Smith", "bob.smith@company.com", 1, "Frontend Developer", time.Date(2021, 3, 20, 0, 0, 0, 0, time.UTC)},
-                {"Carol White", "carol.white@company.com", 2, "HR Manager", time.Date(2019, 6, 10, 0, 0, 0, 0, time.UTC)},
-                {"David Brown", "david.brown@company.com", 2, "Recruiter", time.Date(2022, 2, 5, 0, 0, 0, 0, time.UTC)},
-                {"Emma Davis", "emma.davis@company.com", 3, "Sales Director", time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC)},
-                {"Frank Wilson", "frank.wilson@company.com", 3, "Account Executive", time.Date(2021, 11, 15, 0, 0, 0, 0, time.UTC)},
-                {"Grace Lee", "grace.lee@company.com", 1, "DevOps Engineer", time.Date(2020, 7, 22, 0, 0, 0, 0, time.UTC)},
-                {"Henry Martinez", "henry.martinez@company.com", 3, "Sales Representative", time.Date(2023, 1, 10, 0, 0, 0, 0, time.UTC)},
-                {"Iris Taylor", "iris.taylor@company.com", 2, "HR Coordinator", time.Date(2022, 8, 30, 0, 0, 0, 0, time.UTC)},
-                {"Jack Anderson", "jack.anderson@company.com", 1, "Backend Developer", time.Date(2021, 5, 18, 0, 0, 0, 0, time.UTC)},
-        }
-
-        for _, data := range employeeData {
+        for _, data := range seedEmployees {
                 // Generate username from first name (lowercase)
                 firstName := strings.Split(data.Name, " ")[0]
                 username := strings.ToLower(firstName)
@@ -103,6 +252,9 @@ func SeedData() {
                         Username: username,
                         Email:    data.Email,
                         Password: string(hashedPassword),
+                        Role:     data.Role,
+                        // Everyone shares the default password, so force a change on first login
+                        MustChangePassword: true,
                 }
                 DB.Create(&user)
 
@@ -130,6 +282,29 @@ func SeedData() {
                 DB.Create(&salaries[i])
         }
 
+        backfillJobRecords()
+
         log.Println("Database seeded with 10 employees (with user accounts) and 3 departments")
         log.Println("All user accounts have username = first name (lowercase) and password = 'password'")
+        log.Println("Roles: alice = system_admin, carol = hr_admin, iris = payroll_admin, emma = manager, others = employee")
+}
+
+// BootstrapAdmin promotes the user named by ADMIN_USERNAME to system admin so
+// databases created before roles existed still have someone who can assign them.
+func BootstrapAdmin() {
+        username := os.Getenv("ADMIN_USERNAME")
+        if username == "" {
+                return
+        }
+
+        result := DB.Model(&models.User{}).Where("username = ?", username).Update("role", models.RoleSystemAdmin)
+        if result.Error != nil {
+                log.Println("Failed to bootstrap admin user:", result.Error)
+                return
+        }
+        if result.RowsAffected == 0 {
+                log.Printf("ADMIN_USERNAME %q does not match any user", username)
+                return
+        }
+        log.Printf("User %q granted system_admin role", username)
 }
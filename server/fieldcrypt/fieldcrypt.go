@@ -0,0 +1,215 @@
+// Package fieldcrypt encrypts individual database columns at rest.
+//
+// Each value is sealed with its own random data key, and the data key is
+// sealed with a versioned master key from configuration (envelope
+// encryption). The stored form records the master key's ID, so master keys
+// can be rotated: old ones stay configured for reading while everything is
+// re-encrypted under the new one with cmd/reencrypt.
+//
+// Encrypted columns can't be compared in SQL, so fields that must stay
+// searchable or unique also store a BlindIndex: a keyed, deterministic hash.
+package fieldcrypt
+
+import (
+        "crypto/aes"
+        "crypto/cipher"
+        "crypto/hmac"
+        "crypto/rand"
+        "crypto/sha256"
+        "encoding/base64"
+        "encoding/hex"
+        "errors"
+        "fmt"
+        "os"
+        "strings"
+)
+
+const prefix = "enc:"
+
+var (
+        keys        map[string][]byte
+        activeKeyID string
+        hashKey     []byte
+)
+
+var errNotConfigured = errors.New("field encryption keys are not configured")
+
+// Init loads keys from the environment:
+//
+//      FIELD_ENCRYPTION_KEYS       comma separated id:base64 pairs of 32 byte keys
+//      FIELD_ENCRYPTION_ACTIVE_KEY ID used for new writes (default: first listed)
+//      FIELD_HASH_KEY              base64 key for blind indexes; never rotate it
+//                                  without re-running cmd/reencrypt
+func Init() error {
+        raw := os.Getenv("FIELD_ENCRYPTION_KEYS")
+        if raw == "" {
+                return fmt.Errorf("FIELD_ENCRYPTION_KEYS environment variable is required")
+        }
+
+        loaded := map[string][]byte{}
+        var first string
+        for _, entry := range strings.Split(raw, ",") {
+                id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+                if !ok || id == "" {
+                        return fmt.Errorf("FIELD_ENCRYPTION_KEYS entries must look like id:base64key")
+                }
+                key, err := base64.StdEncoding.DecodeString(encoded)
+                if err != nil || len(key) != 32 {
+                        return fmt.Errorf("key %q must be 32 bytes, base64 encoded", id)
+                }
+                if _, dup := loaded[id]; dup {
+                        return fmt.Errorf("key %q is listed twice", id)
+                }
+                loaded[id] = key
+                if first == "" {
+                        first = id
+                }
+        }
+
+        active := os.Getenv("FIELD_ENCRYPTION_ACTIVE_KEY")
+        if active == "" {
+                active = first
+        }
+        if _, ok := loaded[active]; !ok {
+                return fmt.Errorf("active key %q is not in FIELD_ENCRYPTION_KEYS", active)
+        }
+
+        h, err := base64.StdEncoding.DecodeString(os.Getenv("FIELD_HASH_KEY"))
+        if err != nil || len(h) < 32 {
+                return fmt.Errorf("FIELD_HASH_KEY must be at least 32 bytes, base64 encoded")
+        }
+
+        keys, activeKeyID, hashKey = loaded, active, h
+        return nil
+}
+
+// ActiveKeyID is the ID of the master key new values are encrypted with.
+func ActiveKeyID() string {
+        return activeKeyID
+}
+
+// IsEncrypted reports whether a stored value was produced by Encrypt, as
+// opposed to plaintext written before the column was encrypted.
+func IsEncrypted(stored string) bool {
+        return strings.HasPrefix(stored, prefix)
+}
+
+// KeyID returns the ID of the master key a stored value was encrypted with.
+func KeyID(stored string) string {
+        if !IsEncrypted(stored) {
+                return ""
+        }
+        id, _, _ := strings.Cut(strings.TrimPrefix(stored, prefix), ":")
+        return id
+}
+
+// NeedsReencrypt reports whether a stored value is plaintext or sealed with
+// a key other than the active one.
+func NeedsReencrypt(stored string) bool {
+        return stored != "" && KeyID(stored) != activeKeyID
+}
+
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+        block, err := aes.NewCipher(key)
+        if err != nil {
+                return nil, err
+        }
+        gcm, err := cipher.NewGCM(block)
+        if err != nil {
+                return nil, err
+        }
+        nonce := make([]byte, gcm.NonceSize())
+        if _, err := rand.Read(nonce); err != nil {
+                return nil, err
+        }
+        return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+        block, err := aes.NewCipher(key)
+        if err != nil {
+                return nil, err
+        }
+        gcm, err := cipher.NewGCM(block)
+        if err != nil {
+                return nil, err
+        }
+        if len(sealed) < gcm.NonceSize() {
+                return nil, errors.New("ciphertext too short")
+        }
+        nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+        return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// Encrypt seals plaintext under a fresh data key wrapped with the active
+// master key. The result looks like enc:<key id>:<wrapped key>:<ciphertext>.
+func Encrypt(plaintext []byte) (string, error) {
+        if keys == nil {
+                return "", errNotConfigured
+        }
+
+        dataKey := make([]byte, 32)
+        if _, err := rand.Read(dataKey); err != nil {
+                return "", err
+        }
+
+        // Binding the key ID stops a wrapped key being replayed under another ID
+        wrapped, err := seal(keys[activeKeyID], dataKey, []byte(activeKeyID))
+        if err != nil {
+                return "", err
+        }
+        ciphertext, err := seal(dataKey, plaintext, nil)
+        if err != nil {
+                return "", err
+        }
+
+        return prefix + activeKeyID + ":" +
+                base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+                base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt opens a value produced by Encrypt with whichever configured master
+// key it names.
+func Decrypt(stored string) ([]byte, error) {
+        if keys == nil {
+                return nil, errNotConfigured
+        }
+
+        parts := strings.Split(strings.TrimPrefix(stored, prefix), ":")
+        if !IsEncrypted(stored) || len(parts) != 3 {
+                return nil, errors.New("malformed encrypted value")
+        }
+        keyID := parts[0]
+
+        key, ok := keys[keyID]
+        if !ok {
+                return nil, fmt.Errorf("encryption key %q is not configured", keyID)
+        }
+        wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+        if err != nil {
+                return nil, err
+        }
+        ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+        if err != nil {
+                return nil, err
+        }
+
+        dataKey, err := open(key, wrapped, []byte(keyID))
+        if err != nil {
+                return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+        }
+        return open(dataKey, ciphertext, nil)
+}
+
+// BlindIndex returns a deterministic keyed hash of value for equality lookups
+// and unique constraints. Case, spaces and dashes are ignored so "ab-12 3"
+// and "AB123" match. An empty value has no index.
+func BlindIndex(value string) string {
+        normalized := strings.NewReplacer(" ", "", "-", "").Replace(strings.ToUpper(strings.TrimSpace(value)))
+        if normalized == "" {
+                return ""
+        }
+        mac := hmac.New(sha256.New, hashKey)
+        mac.Write([]byte(normalized))
+        return hex.EncodeToString(mac.Sum(nil))
+}
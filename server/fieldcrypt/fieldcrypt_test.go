@@ -0,0 +1,186 @@
+package fieldcrypt
+
+import (
+        "context"
+        "encoding/base64"
+        "reflect"
+        "strings"
+        "sync"
+        "testing"
+        "time"
+
+        "gorm.io/gorm/schema"
+)
+
+// testKey returns a 32 byte key filled with b, base64 encoded.
+func testKey(b byte) string {
+        return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), 32)))
+}
+
+func initKeys(t *testing.T, encryptionKeys, active string) {
+        t.Helper()
+        t.Setenv("FIELD_ENCRYPTION_KEYS", encryptionKeys)
+        t.Setenv("FIELD_ENCRYPTION_ACTIVE_KEY", active)
+        t.Setenv("FIELD_HASH_KEY", testKey('h'))
+        if err := Init(); err != nil {
+                t.Fatalf("Init: %v", err)
+        }
+}
+
+func TestRoundTrip(t *testing.T) {
+        initKeys(t, "k1:"+testKey('1'), "")
+
+        stored, err := Encrypt([]byte("123-45-6789"))
+        if err != nil {
+                t.Fatalf("Encrypt: %v", err)
+        }
+        if !IsEncrypted(stored) || KeyID(stored) != "k1" || strings.Contains(stored, "6789") {
+                t.Errorf("stored = %q, want an enc: value under k1 without the plaintext", stored)
+        }
+        again, _ := Encrypt([]byte("123-45-6789"))
+        if again == stored {
+                t.Error("encrypting the same value twice gave the same output")
+        }
+
+        plaintext, err := Decrypt(stored)
+        if err != nil || string(plaintext) != "123-45-6789" {
+                t.Errorf("Decrypt = %q, %v, want the original value", plaintext, err)
+        }
+}
+
+func TestRotation(t *testing.T) {
+        initKeys(t, "k1:"+testKey('1'), "")
+        old, err := Encrypt([]byte("before rotation"))
+        if err != nil {
+                t.Fatal(err)
+        }
+
+        initKeys(t, "k1:"+testKey('1')+", k2:"+testKey('2'), "k2")
+        if ActiveKeyID() != "k2" {
+                t.Fatalf("ActiveKeyID = %q, want k2", ActiveKeyID())
+        }
+        if !NeedsReencrypt(old) {
+                t.Error("NeedsReencrypt of a k1 value after rotating to k2 = false")
+        }
+        if plaintext, err := Decrypt(old); err != nil || string(plaintext) != "before rotation" {
+                t.Errorf("Decrypt under the old key = %q, %v", plaintext, err)
+        }
+        current, _ := Encrypt([]byte("after rotation"))
+        if KeyID(current) != "k2" || NeedsReencrypt(current) {
+                t.Errorf("new value %q, want it under k2", current)
+        }
+
+        // Once the old key is retired its values can no longer be read
+        initKeys(t, "k2:"+testKey('2'), "")
+        if _, err := Decrypt(old); err == nil {
+                t.Error("Decrypt after removing k1: want an error")
+        }
+}
+
+func TestTampered(t *testing.T) {
+        initKeys(t, "k1:"+testKey('1')+",k2:"+testKey('2'), "k1")
+        stored, err := Encrypt([]byte("secret"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        parts := strings.Split(stored, ":")
+
+        flip := func(encoded string) string {
+                b, _ := base64.RawStdEncoding.DecodeString(encoded)
+                b[len(b)-1] ^= 1
+                return base64.RawStdEncoding.EncodeToString(b)
+        }
+        tests := map[string]string{
+                "ciphertext changed":      strings.Join([]string{parts[0], parts[1], parts[2], flip(parts[3])}, ":"),
+                "wrapped key changed":     strings.Join([]string{parts[0], parts[1], flip(parts[2]), parts[3]}, ":"),
+                "key ID swapped":          strings.Join([]string{parts[0], "k2", parts[2], parts[3]}, ":"),
+                "unknown key ID":          strings.Join([]string{parts[0], "k9", parts[2], parts[3]}, ":"),
+                "ciphertext truncated":    strings.Join([]string{parts[0], parts[1], parts[2], parts[3][:8]}, ":"),
+                "part missing":            strings.Join([]string{parts[0], parts[1], parts[2]}, ":"),
+                "not base64":              strings.Join([]string{parts[0], parts[1], parts[2], "!!!"}, ":"),
+                "plaintext with a prefix": "enc:not really",
+        }
+        for name, stored := range tests {
+                if plaintext, err := Decrypt(stored); err == nil {
+                        t.Errorf("%s: Decrypt = %q, want an error", name, plaintext)
+                }
+        }
+}
+
+func TestLegacyPlaintext(t *testing.T) {
+        initKeys(t, "k1:"+testKey('1'), "")
+
+        type record struct {
+                TaxID     string    `gorm:"type:text;serializer:encrypted"`
+                BirthDate time.Time `gorm:"type:text;serializer:encrypted"`
+        }
+        s, err := schema.Parse(&record{}, &sync.Map{}, schema.NamingStrategy{})
+        if err != nil {
+                t.Fatal(err)
+        }
+        scan := func(field string, dbValue interface{}) record {
+                t.Helper()
+                var r record
+                if err := (Serializer{}).Scan(context.Background(), s.LookUpField(field), reflect.ValueOf(&r).Elem(), dbValue); err != nil {
+                        t.Fatalf("Scan %s from %v: %v", field, dbValue, err)
+                }
+                return r
+        }
+
+        if got := scan("tax_id", "123-45-6789").TaxID; got != "123-45-6789" {
+                t.Errorf("plaintext string read as %q", got)
+        }
+        if got := scan("tax_id", []byte("123-45-6789")).TaxID; got != "123-45-6789" {
+                t.Errorf("plaintext bytes read as %q", got)
+        }
+        want := time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)
+        for _, stored := range []interface{}{"1990-05-17", "1990-05-17 00:00:00+00", want} {
+                if got := scan("birth_date", stored).BirthDate; !got.Equal(want) {
+                        t.Errorf("plaintext date %v read as %v", stored, got)
+                }
+        }
+        if got := scan("tax_id", nil).TaxID; got != "" {
+                t.Errorf("NULL read as %q", got)
+        }
+
+        // Values the serializer writes read back the same way
+        stored, err := (Serializer{}).Value(context.Background(), s.LookUpField("tax_id"), reflect.Value{}, "123-45-6789")
+        if err != nil || !IsEncrypted(stored.(string)) {
+                t.Fatalf("Value = %v, %v, want an encrypted value", stored, err)
+        }
+        if got := scan("tax_id", stored).TaxID; got != "123-45-6789" {
+                t.Errorf("encrypted value read as %q", got)
+        }
+        if !NeedsReencrypt("123-45-6789") || NeedsReencrypt("") {
+                t.Error("NeedsReencrypt should be true for plaintext and false for empty values")
+        }
+}
+
+func TestBlindIndex(t *testing.T) {
+        initKeys(t, "k1:"+testKey('1'), "")
+
+        // HMAC-SHA256 of "AB123" under the test hash key. It is pinned
+        // because a change to the normalisation or hash would make every
+        // stored index silently stop matching.
+        index := BlindIndex("AB123")
+        if want := "7a2bbffc9abe2ffc28d193d5c245ae87a72cec11d9b1324377348bb09c9e551d"; index != want {
+                t.Fatalf("BlindIndex(AB123) = %s, want %s", index, want)
+        }
+        for _, value := range []string{"ab123", " AB-12 3 ", "a-b-1-2-3"} {
+                if got := BlindIndex(value); got != index {
+                        t.Errorf("BlindIndex(%q) = %s, want %s", value, got, index)
+                }
+        }
+        if BlindIndex("AB124") == index {
+                t.Error("different values share a blind index")
+        }
+        if BlindIndex("  -") != "" {
+                t.Error("BlindIndex of a blank value should be empty")
+        }
+
+        // Encryption keys rotate without changing indexes
+        initKeys(t, "k2:"+testKey('2'), "")
+        if got := BlindIndex("AB123"); got != index {
+                t.Errorf("BlindIndex after rotating encryption keys = %s, want %s", got, index)
+        }
+}
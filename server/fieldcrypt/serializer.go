@@ -0,0 +1,97 @@
+package fieldcrypt
+
+import (
+        "context"
+        "encoding/json"
+        "fmt"
+        "reflect"
+        "strconv"
+        "time"
+
+        "gorm.io/gorm/schema"
+)
+
+// SerializerName is the GORM serializer that encrypts a field, used as
+// `gorm:"type:text;serializer:encrypted"`.
+const SerializerName = "encrypted"
+
+func init() {
+        schema.RegisterSerializer(SerializerName, Serializer{})
+}
+
+// Serializer stores a field as the JSON encoding of its value, encrypted.
+// Empty strings and nil pointers are stored as-is so that "not set" stays
+// distinguishable without decrypting.
+type Serializer struct{}
+
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+        rv := reflect.ValueOf(fieldValue)
+        if !rv.IsValid() || (rv.Kind() == reflect.Ptr && rv.IsNil()) {
+                return nil, nil
+        }
+        if rv.Kind() == reflect.String && rv.Len() == 0 {
+                return "", nil
+        }
+
+        plaintext, err := json.Marshal(fieldValue)
+        if err != nil {
+                return nil, err
+        }
+        return Encrypt(plaintext)
+}
+
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+        fieldValue := reflect.New(field.FieldType)
+
+        var stored string
+        switch v := dbValue.(type) {
+        case nil:
+        case string:
+                stored = v
+        case []byte:
+                stored = string(v)
+        case time.Time:
+                // The column hasn't been migrated to text yet
+                stored = v.Format(time.RFC3339Nano)
+        default:
+                return fmt.Errorf("unsupported encrypted column value %T", dbValue)
+        }
+
+        if stored != "" {
+                plaintext, err := decode(stored, field.IndirectFieldType)
+                if err != nil {
+                        return fmt.Errorf("failed to decrypt %s: %w", field.DBName, err)
+                }
+                if err := json.Unmarshal(plaintext, fieldValue.Interface()); err != nil {
+                        return fmt.Errorf("failed to decode %s: %w", field.DBName, err)
+                }
+        }
+
+        field.ReflectValueOf(ctx, dst).Set(fieldValue.Elem())
+        return nil
+}
+
+// Formats Postgres uses when a timestamp column is cast to text.
+var legacyTimeLayouts = []string{
+        time.RFC3339Nano,
+        "2006-01-02 15:04:05.999999999-07",
+        "2006-01-02 15:04:05.999999999",
+        "2006-01-02",
+}
+
+// decode returns the JSON plaintext of a stored value. Values written before
+// the column was encrypted are plain strings or timestamps; they are read
+// as-is until cmd/reencrypt rewrites them.
+func decode(stored string, target reflect.Type) ([]byte, error) {
+        if IsEncrypted(stored) {
+                return Decrypt(stored)
+        }
+        if target == reflect.TypeOf(time.Time{}) {
+                for _, layout := range legacyTimeLayouts {
+                        if t, err := time.Parse(layout, stored); err == nil {
+                                return json.Marshal(t)
+                        }
+                }
+        }
+        return []byte(strconv.Quote(stored)), nil
+}
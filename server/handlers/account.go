@@ -0,0 +1,285 @@
+package handlers
+
+import (
+        "fmt"
+        "log"
+        "net/http"
+        "os"
+        "time"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/mailer"
+        "hcm-backend/models"
+
+        "github.com/gin-gonic/gin"
+)
+
+const (
+        tokenPurposePasswordReset     = "password_reset"
+        tokenPurposeEmailVerification = "email_verification"
+
+        passwordResetTTL     = time.Hour
+        emailVerificationTTL = 24 * time.Hour
+)
+
+func appBaseURL() string {
+        if url := os.Getenv("APP_BASE_URL"); url != "" {
+                return url
+        }
+        return "http://localhost:5000"
+}
+
+// issueUserToken creates a single-use token for purpose, invalidating any
+// earlier unused token of the same purpose so only the latest link works.
+func issueUserToken(userID uint, purpose string, ttl time.Duration) (string, error) {
+        token, err := generateOpaqueToken()
+        if err != nil {
+                return "", err
+        }
+
+        now := time.Now()
+        database.DB.Model(&models.UserToken{}).
+                Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+                Update("used_at", now)
+
+        userToken := models.UserToken{
+                UserID:    userID,
+                Purpose:   purpose,
+                TokenHash: hashToken(token),
+                ExpiresAt: now.Add(ttl),
+        }
+        if err := database.DB.Create(&userToken).Error; err != nil {
+                return "", err
+        }
+
+        return token, nil
+}
+
+// consumeUserToken marks a token as used and returns its owner. The update is
+// conditional so a token can only ever be redeemed once.
+func consumeUserToken(token, purpose string) (uint, error) {
+        var userToken models.UserToken
+        if err := database.DB.Where("token_hash = ? AND purpose = ?", hashToken(token), purpose).First(&userToken).Error; err != nil {
+                return 0, fmt.Errorf("invalid or expired token")
+        }
+
+        if userToken.UsedAt != nil || time.Now().After(userToken.ExpiresAt) {
+                return 0, fmt.Errorf("invalid or expired token")
+        }
+
+        result := database.DB.Model(&models.UserToken{}).
+                Where("id = ? AND used_at IS NULL", userToken.ID).
+                Update("used_at", time.Now())
+        if result.Error != nil || result.RowsAffected != 1 {
+                return 0, fmt.Errorf("invalid or expired token")
+        }
+
+        return userToken.UserID, nil
+}
+
+func sendVerificationEmail(user models.User) error {
+        token, err := issueUserToken(user.ID, tokenPurposeEmailVerification, emailVerificationTTL)
+        if err != nil {
+                return err
+        }
+
+        return mailer.Default.Send(mailer.Message{
+                To:      user.Email,
+                Subject: "Verify your email address",
+                Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s/verify-email?token=%s\n\nThis link expires in 24 hours.\n",
+                        user.Username, appBaseURL(), token),
+        })
+}
+
+func ForgotPassword(c *gin.Context) {
+        var input struct {
+                Email string `json:"email" binding:"required,email"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        // Always answer the same way so the endpoint can't be used to probe
+        // which email addresses have accounts.
+        response := gin.H{"message": "If an account exists for that email, a reset link has been sent"}
+
+        // Single sign-on users have no password here to reset
+        var user models.User
+        if err := database.DB.Where("email = ?", input.Email).First(&user).Error; err != nil || isSSOUser(user) {
+                c.JSON(http.StatusOK, response)
+                return
+        }
+
+        token, err := issueUserToken(user.ID, tokenPurposePasswordReset, passwordResetTTL)
+        if err != nil {
+                log.Println("Failed to issue password reset token:", err)
+                c.JSON(http.StatusOK, response)
+                return
+        }
+
+        if err := mailer.Default.Send(mailer.Message{
+                To:      user.Email,
+                Subject: "Reset your password",
+                Body: fmt.Sprintf("Hi %s,\n\nSomeone requested a password reset for your account. To choose a new password, open the link below:\n\n%s/reset-password?token=%s\n\nThis link expires in 1 hour. If you didn't ask for this, you can ignore this email.\n",
+                        user.Username, appBaseURL(), token),
+        }); err != nil {
+                log.Println("Failed to send password reset email:", err)
+        }
+
+        c.JSON(http.StatusOK, response)
+}
+
+func ResetPassword(c *gin.Context) {
+        var input struct {
+                Token    string `json:"token" binding:"required"`
+                Password string `json:"password" binding:"required,min=6"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        userID, err := consumeUserToken(input.Token, tokenPurposePasswordReset)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+                return
+        }
+
+        hashedPassword, err := hashPassword(input.Password)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+                return
+        }
+
+        if err := database.DB.WithContext(audit.Context(c)).Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+                "password":             hashedPassword,
+                "must_change_password": false,
+        }).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+                return
+        }
+
+        // Whoever triggered the reset may not be the only one holding a session
+        revokeUserSessions(userID)
+
+        c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully, please log in"})
+}
+
+func ChangePassword(c *gin.Context) {
+        var input struct {
+                CurrentPassword string `json:"current_password" binding:"required"`
+                NewPassword     string `json:"new_password" binding:"required,min=6"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        var user models.User
+        if err := database.DB.First(&user, c.GetUint("userID")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+                return
+        }
+
+        if isSSOUser(user) {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "This account signs in through single sign-on and has no password"})
+                return
+        }
+
+        if !checkPassword(input.CurrentPassword, user.Password) {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+                return
+        }
+
+        if input.CurrentPassword == input.NewPassword {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "New password must be different from the current password"})
+                return
+        }
+
+        hashedPassword, err := hashPassword(input.NewPassword)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+                return
+        }
+
+        user.Password = hashedPassword
+        user.MustChangePassword = false
+        if err := database.DB.WithContext(audit.Context(c)).Save(&user).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+                return
+        }
+
+        // Sign out other devices and hand back a fresh session for this one
+        revokeUserSessions(user.ID)
+        token, refreshToken, err := createSession(c, user)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+                return
+        }
+
+        c.JSON(http.StatusOK, gin.H{
+                "message":       "Password changed successfully",
+                "token":         token,
+                "refresh_token": refreshToken,
+                "expires_in":    int(accessTokenTTL.Seconds()),
+        })
+}
+
+func RequestEmailVerification(c *gin.Context) {
+        var user models.User
+        if err := database.DB.First(&user, c.GetUint("userID")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+                return
+        }
+
+        if user.EmailVerifiedAt != nil {
+                c.JSON(http.StatusOK, gin.H{"message": "Email already verified"})
+                return
+        }
+
+        if err := sendVerificationEmail(user); err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+                return
+        }
+
+        c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+func VerifyEmail(c *gin.Context) {
+        var input struct {
+                Token string `json:"token" binding:"required"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        userID, err := consumeUserToken(input.Token, tokenPurposeEmailVerification)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+                return
+        }
+
+        var user models.User
+        if err := database.DB.First(&user, userID).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+                return
+        }
+
+        if err := database.DB.WithContext(audit.Context(c)).Model(&user).Update("email_verified_at", time.Now()).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+                return
+        }
+
+        // A self-registered user becomes linked to their employee record once
+        // they have proven they own its email address.
+        linkEmployeeByEmail(c, user)
+
+        c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
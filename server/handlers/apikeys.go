@@ -0,0 +1,111 @@
+package handlers
+
+import (
+        "net/http"
+        "time"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/middleware"
+        "hcm-backend/models"
+
+        "github.com/gin-gonic/gin"
+)
+
+const maxAPIKeyLifetime = 365 * 24 * time.Hour
+
+func GetAPIKeys(c *gin.Context) {
+        query := database.DB.Model(&models.APIKey{})
+        if c.Query("status") != "all" {
+                query = query.Where("revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", time.Now())
+        }
+
+        var keys []models.APIKey
+        if err := query.Order("created_at desc").Find(&keys).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+                return
+        }
+
+        c.JSON(http.StatusOK, keys)
+}
+
+// CreateAPIKey issues a new key. The key is only ever returned here; afterwards
+// just its prefix is shown so it can be recognised.
+func CreateAPIKey(c *gin.Context) {
+        var input struct {
+                Name          string   `json:"name" binding:"required"`
+                Scopes        []string `json:"scopes" binding:"required,min=1"`
+                ExpiresInDays int      `json:"expires_in_days"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        for _, scope := range input.Scopes {
+                if !models.IsValidAPIKeyScope(scope) {
+                        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope: " + scope, "valid_scopes": models.APIKeyScopes})
+                        return
+                }
+        }
+
+        // Keys always expire so a forgotten integration can't hold access forever
+        if input.ExpiresInDays <= 0 {
+                input.ExpiresInDays = 90
+        }
+        lifetime := time.Duration(input.ExpiresInDays) * 24 * time.Hour
+        if lifetime > maxAPIKeyLifetime {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_days cannot be more than 365"})
+                return
+        }
+        expiresAt := time.Now().Add(lifetime)
+
+        secret, err := generateOpaqueToken()
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+                return
+        }
+        key := middleware.APIKeyPrefix + secret
+
+        apiKey := models.APIKey{
+                Name:      input.Name,
+                Prefix:    key[:len(middleware.APIKeyPrefix)+6],
+                KeyHash:   middleware.HashAPIKey(key),
+                Scopes:    input.Scopes,
+                ExpiresAt: &expiresAt,
+        }
+        if uid, exists := c.Get("userID"); exists {
+                id := uid.(uint)
+                apiKey.CreatedByID = &id
+        }
+
+        if err := database.DB.WithContext(audit.Context(c)).Create(&apiKey).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+                return
+        }
+
+        c.JSON(http.StatusCreated, gin.H{
+                "api_key": apiKey,
+                "key":     key,
+                "message": "Store this key now; it will not be shown again",
+        })
+}
+
+func RevokeAPIKey(c *gin.Context) {
+        id := c.Param("id")
+
+        result := database.DB.WithContext(audit.Context(c)).Model(&models.APIKey{}).
+                Where("id = ? AND revoked_at IS NULL", id).
+                Update("revoked_at", time.Now())
+        if result.Error != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+                return
+        }
+        if result.RowsAffected == 0 {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Active API key not found"})
+                return
+        }
+
+        c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
@@ -1,15 +1,55 @@
 package handlers
 
 import (
+        "log"
         "net/http"
         "time"
 
+        "hcm-backend/audit"
         "hcm-backend/database"
+        "hcm-backend/middleware"
         "hcm-backend/models"
+        "hcm-backend/timezone"
+        "hcm-backend/views"
 
         "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
 )
 
+// maxOpenAttendance is how long after clocking in an employee can still
+// clock out, long enough for any shift running past midnight.
+const maxOpenAttendance = 24 * time.Hour
+
+// newAttendance starts a record of the employee clocking in at now, dated in
+// their timezone.
+func newAttendance(db *gorm.DB, employee models.Employee, now time.Time) (models.Attendance, *time.Location, error) {
+        loc, err := employeeLocation(db, employee)
+        if err != nil {
+                return models.Attendance{}, nil, err
+        }
+        return models.Attendance{
+                EmployeeID: employee.ID,
+                Date:       timezone.Day(now, loc),
+                Timezone:   loc.String(),
+                ClockIn:    now,
+        }, loc, nil
+}
+
+// openAttendance finds the employee's latest record that is still clocked
+// in, whichever day it started on, so shifts past midnight can be clocked
+// out of.
+func openAttendance(db *gorm.DB, employeeID uint, now time.Time) (models.Attendance, error) {
+        var attendance models.Attendance
+        err := db.Where("employee_id = ? AND clock_out IS NULL AND clock_in > ?", employeeID, now.Add(-maxOpenAttendance)).
+                Order("clock_in DESC").First(&attendance).Error
+        return attendance, err
+}
+
+// localClock formats t as a time of day in loc, with the zone's abbreviation.
+func localClock(t time.Time, loc *time.Location) string {
+        return t.In(loc).Format("3:04 PM MST")
+}
+
 func ClockIn(c *gin.Context) {
         var input struct {
                 EmployeeID uint   `json:"employee_id" binding:"required"`
@@ -21,23 +61,39 @@ func ClockIn(c *gin.Context) {
                 return
         }
 
-        attendance := models.Attendance{
-                EmployeeID: input.EmployeeID,
-                Date:       time.Now(),
-                ClockIn:    time.Now(),
-                Location:   input.Location,
+        employee, ok := employeeForCaller(c, input.EmployeeID, models.PermEmployeesWrite)
+        if !ok {
+                return
         }
 
-        result := database.DB.Create(&attendance)
+        now := time.Now()
+        if _, err := openAttendance(database.DB, employee.ID, now); err == nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Already clocked in"})
+                return
+        }
+        attendance, _, err := newAttendance(database.DB, employee, now)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+        }
+        attendance.Location = input.Location
+
+        result := database.DB.WithContext(audit.Context(c)).Create(&attendance)
         if result.Error != nil {
                 c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
                 return
         }
 
-        database.DB.Preload("Employee").First(&attendance, attendance.ID)
+        if err := evaluateAttendance(database.DB.WithContext(audit.Context(c)), attendance.EmployeeID, attendance.ClockIn, attendance.ClockIn); err != nil {
+                log.Printf("Failed to evaluate attendance %d: %v", attendance.ID, err)
+        }
+
+        database.DB.Preload("Employee", views.PublicEmployee).First(&attendance, attendance.ID)
         c.JSON(http.StatusCreated, attendance)
 }
 
+// ClockOut closes the employee's open attendance record, even if it was
+// opened on the previous day.
 func ClockOut(c *gin.Context) {
         var input struct {
                 EmployeeID uint   `json:"employee_id" binding:"required"`
@@ -49,49 +105,105 @@ func ClockOut(c *gin.Context) {
                 return
         }
 
-        // Find today's attendance record without clock out
-        var attendance models.Attendance
-        err := database.DB.Where("employee_id = ? AND DATE(date) = CURRENT_DATE AND clock_out IS NULL", input.EmployeeID).First(&attendance).Error
+        if _, ok := employeeForCaller(c, input.EmployeeID, models.PermEmployeesWrite); !ok {
+                return
+        }
+
+        now := time.Now()
+        attendance, err := openAttendance(database.DB, input.EmployeeID, now)
         if err != nil {
-                c.JSON(http.StatusNotFound, gin.H{"error": "No active clock-in found for today"})
+                c.JSON(http.StatusNotFound, gin.H{"error": "No active clock-in found"})
                 return
         }
 
         // Update with clock out time
-        now := time.Now()
         attendance.ClockOut = &now
         if input.Location != "" {
                 attendance.Location = input.Location
         }
 
-        result := database.DB.Save(&attendance)
+        result := database.DB.WithContext(audit.Context(c)).Save(&attendance)
         if result.Error != nil {
                 c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
                 return
         }
 
-        database.DB.Preload("Employee").First(&attendance, attendance.ID)
-        
+        if err := evaluateAttendance(database.DB.WithContext(audit.Context(c)), attendance.EmployeeID, attendance.ClockIn, attendance.ClockIn); err != nil {
+                log.Printf("Failed to evaluate attendance %d: %v", attendance.ID, err)
+        }
+
+        database.DB.Preload("Employee", views.PublicEmployee).First(&attendance, attendance.ID)
+
         // Calculate duration
         duration := now.Sub(attendance.ClockIn)
         hours := int(duration.Hours())
         minutes := int(duration.Minutes()) % 60
-        
+
         c.JSON(http.StatusOK, gin.H{
                 "attendance": attendance,
                 "duration": gin.H{
-                        "hours":   hours,
-                        "minutes": minutes,
+                        "hours":         hours,
+                        "minutes":       minutes,
                         "total_minutes": int(duration.Minutes()),
                 },
         })
 }
 
+var attendanceSorts = map[string]string{
+        "id":        "attendances.id",
+        "date":      "attendances.date",
+        "clock_in":  "attendances.clock_in",
+        "clock_out": "attendances.clock_out",
+        "location":  "attendances.location",
+        "status":    "attendances.status",
+        "employee":  "(SELECT name FROM employees WHERE employees.id = attendances.employee_id)",
+}
+
+// attendanceListQuery applies GetAttendance's filters: employee_id,
+// department_id, location, status and the from/to date range. Callers with
+// only attendance:self see just their own records.
+func attendanceListQuery(c *gin.Context) (*gorm.DB, error) {
+        from, to, err := parseDateRange(c)
+        if err != nil {
+                return nil, err
+        }
+
+        query := database.DB.Model(&models.Attendance{})
+        if !middleware.HasPermission(c, models.PermAttendanceRead) {
+                query = query.Where("attendances.employee_id = ?", callerEmployeeID(c))
+        }
+        if query, err = filterIDs(c, query, "employee_id", "attendances.employee_id"); err != nil {
+                return nil, err
+        }
+        if query, err = filterIDs(c, query, "department_id", "(SELECT department_id FROM employees WHERE employees.id = attendances.employee_id)"); err != nil {
+                return nil, err
+        }
+        query = filterValues(c, query, "location", "attendances.location")
+        query = filterValues(c, query, "status", "attendances.status")
+        if from != nil {
+                query = query.Where("attendances.date >= ?", *from)
+        }
+        if to != nil {
+                query = query.Where("attendances.date < ?", *to)
+        }
+        return query, nil
+}
+
 func GetAttendance(c *gin.Context) {
+        params, err := parseListParams(c, "attendances", attendanceSorts, "-date")
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        query, err := attendanceListQuery(c)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
         var attendances []models.Attendance
-        result := database.DB.Preload("Employee").Order("date desc").Find(&attendances)
-        if result.Error != nil {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+        if err := params.find(c, query.Preload("Employee", views.PublicEmployee), &attendances); err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
                 return
         }
         c.JSON(http.StatusOK, attendances)
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+        "net/http"
+        "strconv"
+        "time"
+
+        "hcm-backend/database"
+        "hcm-backend/models"
+
+        "github.com/gin-gonic/gin"
+)
+
+func GetAuditEvents(c *gin.Context) {
+        query := database.DB.Model(&models.AuditEvent{})
+
+        if actor := c.Query("actor_user_id"); actor != "" {
+                actorID, err := strconv.ParseUint(actor, 10, 64)
+                if err != nil {
+                        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid actor_user_id"})
+                        return
+                }
+                query = query.Where("actor_user_id = ?", actorID)
+        }
+        if entity := c.Query("entity"); entity != "" {
+                query = query.Where("entity = ?", entity)
+        }
+        if entityID := c.Query("entity_id"); entityID != "" {
+                query = query.Where("entity_id = ?", entityID)
+        }
+        if action := c.Query("action"); action != "" {
+                query = query.Where("action = ?", action)
+        }
+        if source := c.Query("source"); source != "" {
+                query = query.Where("source = ?", source)
+        }
+        if requestID := c.Query("request_id"); requestID != "" {
+                query = query.Where("request_id = ?", requestID)
+        }
+        if from := c.Query("from"); from != "" {
+                if t, err := time.Parse("2006-01-02", from); err == nil {
+                        query = query.Where("created_at >= ?", t)
+                }
+        }
+        if to := c.Query("to"); to != "" {
+                if t, err := time.Parse("2006-01-02", to); err == nil {
+                        query = query.Where("created_at < ?", t.AddDate(0, 0, 1))
+                }
+        }
+
+        limit := 100
+        if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+                limit = l
+        }
+
+        var events []models.AuditEvent
+        if err := query.Order("created_at desc, id desc").Limit(limit).Find(&events).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit events"})
+                return
+        }
+
+        c.JSON(http.StatusOK, events)
+}
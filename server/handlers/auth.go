@@ -2,10 +2,12 @@ package handlers
 
 import (
         "fmt"
+        "log"
         "net/http"
         "os"
         "time"
 
+        "hcm-backend/audit"
         "hcm-backend/database"
         "hcm-backend/models"
 
@@ -35,22 +37,78 @@ func checkPassword(password, hash string) bool {
         return err == nil
 }
 
-func generateToken(userID uint) (string, error) {
+func generateToken(user models.User, sessionID uint) (string, error) {
         jwtSecret := os.Getenv("JWT_SECRET")
         if jwtSecret == "" {
                 return "", fmt.Errorf("JWT_SECRET environment variable is required")
         }
 
         claims := jwt.MapClaims{
-                "user_id": userID,
-                "exp":     time.Now().Add(time.Hour * 24).Unix(),
+                "user_id": user.ID,
+                "role":    user.Role,
+                "sid":     sessionID,
+                "exp":     time.Now().Add(accessTokenTTL).Unix(),
+        }
+        if user.MustChangePassword {
+                claims["pwd_change"] = true
+        }
+        // Single sign-on users get their second factor from the identity provider
+        if !user.TOTPEnabled && !isSSOUser(user) && roleRequiresTwoFactor(user.Role) {
+                claims["mfa_setup"] = true
         }
 
         token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
         return token.SignedString([]byte(jwtSecret))
 }
 
+func userSummary(user models.User) gin.H {
+        return gin.H{
+                "id":       user.ID,
+                "username": user.Username,
+                "email":    user.Email,
+                "role":     user.Role,
+
+                "email_verified":       user.EmailVerifiedAt != nil,
+                "must_change_password": user.MustChangePassword,
+                "totp_enabled":         user.TOTPEnabled,
+                "two_factor_required":  !isSSOUser(user) && roleRequiresTwoFactor(user.Role),
+                "sso":                  isSSOUser(user),
+        }
+}
+
+// accountDeactivated reports whether the user's employee record has been
+// deactivated, for example by SCIM deprovisioning.
+func accountDeactivated(userID uint) bool {
+        var count int64
+        database.DB.Model(&models.Employee{}).
+                Where("user_id = ? AND employment_status IN ?", userID, models.DepartedStatuses).
+                Count(&count)
+        return count > 0
+}
+
+// respondWithSession starts a session for user and writes the token pair and
+// user summary that every successful sign-in returns.
+func respondWithSession(c *gin.Context, status int, user models.User) {
+        token, refreshToken, err := createSession(c, user)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+                return
+        }
+
+        c.JSON(status, gin.H{
+                "token":         token,
+                "refresh_token": refreshToken,
+                "expires_in":    int(accessTokenTTL.Seconds()),
+                "user":          userSummary(user),
+        })
+}
+
 func Signup(c *gin.Context) {
+        if !openSignupEnabled() {
+                c.JSON(http.StatusForbidden, gin.H{"error": "Signup is disabled. Please ask HR for an invitation."})
+                return
+        }
+
         var req SignupRequest
         if err := c.ShouldBindJSON(&req); err != nil {
                 c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -80,27 +138,19 @@ func Signup(c *gin.Context) {
                 Username: req.Username,
                 Email:    req.Email,
                 Password: hashedPassword,
+                Role:     models.RoleEmployee,
         }
 
-        if err := database.DB.Create(&user).Error; err != nil {
+        if err := database.DB.WithContext(audit.Context(c)).Create(&user).Error; err != nil {
                 c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
                 return
         }
 
-        token, err := generateToken(user.ID)
-        if err != nil {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
-                return
+        if err := sendVerificationEmail(user); err != nil {
+                log.Println("Failed to send verification email:", err)
         }
 
-        c.JSON(http.StatusCreated, gin.H{
-                "token": token,
-                "user": gin.H{
-                        "id":       user.ID,
-                        "username": user.Username,
-                        "email":    user.Email,
-                },
-        })
+        respondWithSession(c, http.StatusCreated, user)
 }
 
 func Login(c *gin.Context) {
@@ -110,31 +160,64 @@ func Login(c *gin.Context) {
                 return
         }
 
+        attempt, ok := reserveLoginAttempt(c, req.Username)
+        if !ok {
+                return
+        }
+
         var user models.User
         if err := database.DB.Where("username = ?", req.Username).First(&user).Error; err != nil {
+                // Spend the same bcrypt time as a real check so response timing
+                // doesn't reveal which usernames exist.
+                checkPassword(req.Password, dummyPasswordHash())
+                attempt.fail(c, nil, "unknown username")
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+                return
+        }
+
+        if isSSOUser(user) {
+                checkPassword(req.Password, dummyPasswordHash())
+                attempt.fail(c, &user.ID, "single sign-on account")
                 c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
                 return
         }
 
         if !checkPassword(req.Password, user.Password) {
+                attempt.fail(c, &user.ID, "wrong password")
                 c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
                 return
         }
+        attempt.release()
 
-        token, err := generateToken(user.ID)
-        if err != nil {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+        if accountDeactivated(user.ID) {
+                recordSecurityEvent(c, eventLoginFailure, req.Username, &user.ID, "account deactivated")
+                c.JSON(http.StatusForbidden, gin.H{"error": "This account has been deactivated"})
                 return
         }
 
-        c.JSON(http.StatusOK, gin.H{
-                "token": token,
-                "user": gin.H{
-                        "id":       user.ID,
-                        "username": user.Username,
-                        "email":    user.Email,
-                },
-        })
+        respondWithLogin(c, user)
+}
+
+// respondWithLogin finishes a sign-in whose first factor, a password or the
+// identity provider, has been checked. With 2FA on, that only earns a
+// short-lived challenge token that must be exchanged together with a code.
+func respondWithLogin(c *gin.Context, user models.User) {
+        if user.TOTPEnabled {
+                challengeToken, err := generateChallengeToken(user.ID)
+                if err != nil {
+                        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+                        return
+                }
+                c.JSON(http.StatusOK, gin.H{
+                        "two_factor_required": true,
+                        "challenge_token":     challengeToken,
+                        "expires_in":          int(challengeTokenTTL.Seconds()),
+                })
+                return
+        }
+
+        recordLoginSuccess(c, user)
+        respondWithSession(c, http.StatusOK, user)
 }
 
 func GetMe(c *gin.Context) {
@@ -150,9 +233,5 @@ func GetMe(c *gin.Context) {
                 return
         }
 
-        c.JSON(http.StatusOK, gin.H{
-                "id":       user.ID,
-                "username": user.Username,
-                "email":    user.Email,
-        })
+        c.JSON(http.StatusOK, userSummary(user))
 }
@@ -4,6 +4,7 @@ import (
         "context"
         "encoding/json"
         "fmt"
+        "log"
         "net/http"
         "strings"
         "sync"
@@ -11,8 +12,11 @@ import (
 
         "github.com/gin-gonic/gin"
         "github.com/openai/openai-go/v2"
+        "hcm-backend/audit"
         "hcm-backend/database"
         "hcm-backend/models"
+        "hcm-backend/timezone"
+        "hcm-backend/views"
 )
 
 var (
@@ -44,10 +48,23 @@ func truncateString(s string, maxLen int) string {
         return s[:maxLen] + "..."
 }
 
+func chatUserID(userID interface{}) uint {
+        if id, ok := userID.(uint); ok {
+                return id
+        }
+        return 0
+}
+
+func chatViewer(userID interface{}, role string) views.Viewer {
+        return views.NewViewer(chatUserID(userID), func(permission string) bool {
+                return models.RoleHasPermission(role, permission)
+        })
+}
+
 // handleChatWithAI uses OpenAI function calling to intelligently handle all chatbot operations
-func handleChatWithAI(userMessage string, history []map[string]string, verbose bool, userID interface{}) (string, []string, error) {
+// ctx carries the audit actor; writes made by tools are attributed to the tool.
+func handleChatWithAI(ctx context.Context, userMessage string, history []map[string]string, verbose bool, userID interface{}, role string) (string, []string, error) {
         client := getOpenAIClient()
-        ctx := context.Background()
         var verboseSteps []string
         
         // Define available functions for the AI to call
@@ -196,7 +213,7 @@ func handleChatWithAI(userMessage string, history []map[string]string, verbose b
                 }),
                 openai.ChatCompletionFunctionTool(openai.FunctionDefinitionParam{
                         Name:        "get_employee_salaries",
-                        Description: openai.String("Get salary information for all employees (base salary, currency, pay frequency)"),
+                        Description: openai.String("Get salary information (base salary, currency, pay frequency) for the employees the user is allowed to see"),
                 }),
                 openai.ChatCompletionFunctionTool(openai.FunctionDefinitionParam{
                         Name:        "get_my_salary",
@@ -318,6 +335,7 @@ func handleChatWithAI(userMessage string, history []map[string]string, verbose b
         for _, toolCall := range toolCalls {
                 functionName := toolCall.Function.Name
                 argumentsJSON := toolCall.Function.Arguments
+                toolDB := database.DB.WithContext(audit.WithSource(ctx, "chat:"+functionName))
                 
                 if verbose {
                         verboseSteps = append(verboseSteps, fmt.Sprintf("📞 Calling function: %s", functionName))
@@ -442,31 +460,25 @@ func handleChatWithAI(userMessage string, history []map[string]string, verbose b
                         return "❌ I couldn't find your employee record. Please contact HR.", verboseSteps, nil
                 }
                 
-                var existingAttendance models.Attendance
-                err := database.DB.Where("employee_id = ? AND DATE(date) = CURRENT_DATE", employee.ID).First(&existingAttendance).Error
-                if err == nil {
-                        clockOutStatus := ""
-                        if existingAttendance.ClockOut == nil {
-                                clockOutStatus = " (not yet clocked out)"
-                        } else {
-                                clockOutStatus = fmt.Sprintf(" (clocked out at %s)", existingAttendance.ClockOut.Format("3:04 PM"))
-                        }
-                        return fmt.Sprintf("✅ You already clocked in today at %s%s.", 
-                                existingAttendance.ClockIn.Format("3:04 PM"), clockOutStatus), verboseSteps, nil
+                now := time.Now()
+                if open, err := openAttendance(database.DB, employee.ID, now); err == nil {
+                        return fmt.Sprintf("✅ You already clocked in at %s (not yet clocked out).", 
+                                localClock(open.ClockIn, open.ClockInLocal.Location())), verboseSteps, nil
                 }
-                
-                attendance := models.Attendance{
-                        EmployeeID: employee.ID,
-                        Date:       time.Now(),
-                        ClockIn:    time.Now(),
+                attendance, loc, err := newAttendance(database.DB, employee, now)
+                if err != nil {
+                        return "", verboseSteps, fmt.Errorf("failed to record attendance: %v", err)
                 }
                 
-                if err := database.DB.Create(&attendance).Error; err != nil {
+                if err := toolDB.Create(&attendance).Error; err != nil {
                         return "", verboseSteps, fmt.Errorf("failed to record attendance: %v", err)
                 }
+                if err := evaluateAttendance(toolDB, attendance.EmployeeID, attendance.ClockIn, attendance.ClockIn); err != nil {
+                        log.Printf("Failed to evaluate attendance %d: %v", attendance.ID, err)
+                }
                 
                 result := fmt.Sprintf("✅ Attendance recorded successfully!\n\n👋 Welcome, %s!\n⏰ Clock-in time: %s\n\nHave a productive day!", 
-                        employee.Name, attendance.ClockIn.Format("3:04 PM"))
+                        employee.Name, localClock(attendance.ClockIn, loc))
                 return result, verboseSteps, nil
                 
         case "clock_out":
@@ -479,25 +491,26 @@ func handleChatWithAI(userMessage string, history []map[string]string, verbose b
                         return "❌ I couldn't find your employee record. Please contact HR.", verboseSteps, nil
                 }
                 
-                var attendance models.Attendance
-                err := database.DB.Where("employee_id = ? AND DATE(date) = CURRENT_DATE AND clock_out IS NULL", employee.ID).First(&attendance).Error
+                now := time.Now()
+                attendance, err := openAttendance(database.DB, employee.ID, now)
                 if err != nil {
-                        return "❌ You don't have an active clock-in for today. Please clock in first!", verboseSteps, nil
+                        return "❌ You don't have an active clock-in. Please clock in first!", verboseSteps, nil
                 }
-                
-                now := time.Now()
                 attendance.ClockOut = &now
                 
-                if err := database.DB.Save(&attendance).Error; err != nil {
+                if err := toolDB.Save(&attendance).Error; err != nil {
                         return "", verboseSteps, fmt.Errorf("failed to update attendance: %v", err)
                 }
+                if err := evaluateAttendance(toolDB, attendance.EmployeeID, attendance.ClockIn, attendance.ClockIn); err != nil {
+                        log.Printf("Failed to evaluate attendance %d: %v", attendance.ID, err)
+                }
                 
                 duration := now.Sub(attendance.ClockIn)
                 hours := int(duration.Hours())
                 minutes := int(duration.Minutes()) % 60
                 
                 result := fmt.Sprintf("✅ Successfully clocked out!\n\n👋 See you later, %s!\n⏰ Clock-out time: %s\n📊 Total time: %dh %dm\n\nHave a great evening!", 
-                        employee.Name, now.Format("3:04 PM"), hours, minutes)
+                        employee.Name, localClock(now, attendance.ClockInLocal.Location()), hours, minutes)
                 return result, verboseSteps, nil
                 
         case "record_attendance_for_employee":
@@ -536,46 +549,47 @@ func handleChatWithAI(userMessage string, history []map[string]string, verbose b
                 }
                 
                 if args.Action == "clock_out" {
-                        var attendance models.Attendance
-                        err := database.DB.Where("employee_id = ? AND DATE(date) = CURRENT_DATE AND clock_out IS NULL", targetEmployee.ID).First(&attendance).Error
+                        now := time.Now()
+                        attendance, err := openAttendance(database.DB, targetEmployee.ID, now)
                         if err != nil {
-                                return fmt.Sprintf("❌ %s doesn't have an active clock-in for today.", targetEmployee.Name), verboseSteps, nil
+                                return fmt.Sprintf("❌ %s doesn't have an active clock-in.", targetEmployee.Name), verboseSteps, nil
                         }
-                        
-                        now := time.Now()
                         attendance.ClockOut = &now
                         
-                        if err := database.DB.Save(&attendance).Error; err != nil {
+                        if err := toolDB.Save(&attendance).Error; err != nil {
                                 return "", verboseSteps, fmt.Errorf("failed to update attendance: %v", err)
                         }
+                        if err := evaluateAttendance(toolDB, attendance.EmployeeID, attendance.ClockIn, attendance.ClockIn); err != nil {
+                                log.Printf("Failed to evaluate attendance %d: %v", attendance.ID, err)
+                        }
                         
                         duration := now.Sub(attendance.ClockIn)
                         hours := int(duration.Hours())
                         minutes := int(duration.Minutes()) % 60
                         
                         result := fmt.Sprintf("✅ Clock-out recorded for %s!\n\n⏰ Clock-out time: %s\n📊 Total time: %dh %dm", 
-                                targetEmployee.Name, now.Format("3:04 PM"), hours, minutes)
+                                targetEmployee.Name, localClock(now, attendance.ClockInLocal.Location()), hours, minutes)
                         return result, verboseSteps, nil
                 } else {
-                        var existingAttendance models.Attendance
-                        err := database.DB.Where("employee_id = ? AND DATE(date) = CURRENT_DATE", targetEmployee.ID).First(&existingAttendance).Error
-                        if err == nil {
-                                return fmt.Sprintf("✅ %s already clocked in today at %s.", 
-                                        targetEmployee.Name, existingAttendance.ClockIn.Format("3:04 PM")), verboseSteps, nil
+                        now := time.Now()
+                        if open, err := openAttendance(database.DB, targetEmployee.ID, now); err == nil {
+                                return fmt.Sprintf("✅ %s already clocked in at %s (not yet clocked out).", 
+                                        targetEmployee.Name, localClock(open.ClockIn, open.ClockInLocal.Location())), verboseSteps, nil
                         }
-                        
-                        attendance := models.Attendance{
-                                EmployeeID: targetEmployee.ID,
-                                Date:       time.Now(),
-                                ClockIn:    time.Now(),
+                        attendance, loc, err := newAttendance(database.DB, targetEmployee, now)
+                        if err != nil {
+                                return "", verboseSteps, fmt.Errorf("failed to record attendance: %v", err)
                         }
                         
-                        if err := database.DB.Create(&attendance).Error; err != nil {
+                        if err := toolDB.Create(&attendance).Error; err != nil {
                                 return "", verboseSteps, fmt.Errorf("failed to record attendance: %v", err)
                         }
+                        if err := evaluateAttendance(toolDB, attendance.EmployeeID, attendance.ClockIn, attendance.ClockIn); err != nil {
+                                log.Printf("Failed to evaluate attendance %d: %v", attendance.ID, err)
+                        }
                         
                         result := fmt.Sprintf("✅ Attendance recorded for %s!\n\n⏰ Clock-in time: %s", 
-                                targetEmployee.Name, attendance.ClockIn.Format("3:04 PM"))
+                                targetEmployee.Name, localClock(attendance.ClockIn, loc))
                         return result, verboseSteps, nil
                 }
                 
@@ -619,10 +633,10 @@ func handleChatWithAI(userMessage string, history []map[string]string, verbose b
                         StartDate:  startDate,
                         EndDate:    endDate,
                         LeaveType:  args.LeaveType,
-                        Status:     "Pending",
+                        Status:     "pending",
                 }
                 
-                if err := database.DB.Create(&leaveRequest).Error; err != nil {
+                if err := toolDB.Create(&leaveRequest).Error; err != nil {
                         return "", verboseSteps, fmt.Errorf("failed to create leave request: %v", err)
                 }
                 
@@ -674,6 +688,21 @@ func handleChatWithAI(userMessage string, history []map[string]string, verbose b
                 if employee.WorkLocation != "" {
                         result += fmt.Sprintf("• Work Location: %s\n", employee.WorkLocation)
                 }
+                
+                // Identifiers and personal details are never sent to the model;
+                // the rest follows the same policy as GET /api/employees/:id.
+                viewer := chatViewer(userID, role)
+                if viewer.CanSeeCompensation(employee) && employee.BaseSalary > 0 {
+                        result += fmt.Sprintf("• Salary: %.2f %s (%s)\n", employee.BaseSalary, employee.Currency, employee.PayFrequency)
+                }
+                if viewer.CanSeePerformance(employee) {
+                        if employee.PerformanceRating != "" {
+                                result += fmt.Sprintf("• Performance Rating: %s\n", employee.PerformanceRating)
+                        }
+                        if employee.ProbationEndDate != nil {
+                                result += fmt.Sprintf("• Probation Ends: %s\n", employee.ProbationEndDate.Format("Jan 02, 2006"))
+                        }
+                }
                 return result, verboseSteps, nil
                 
         case "list_leave_requests":
@@ -683,7 +712,7 @@ func handleChatWithAI(userMessage string, history []map[string]string, verbose b
                 json.Unmarshal([]byte(argumentsJSON), &args)
                 
                 var leaveRequests []models.LeaveRequest
-                query := database.DB.Preload("Employee")
+                query := database.DB.Preload("Employee", views.PublicEmployee)
                 
                 // Parse month filter if provided
                 if args.Month != "" {
@@ -732,9 +761,20 @@ func handleChatWithAI(userMessage string, history []map[string]string, verbose b
                 return result, verboseSteps, nil
                 
         case "list_todays_attendance":
+                // Today is the caller's, and each record is dated in its
+                // employee's timezone
+                loc := timezone.Default()
+                var caller models.Employee
+                if userID != nil && database.DB.Where("user_id = ?", userID).First(&caller).Error == nil {
+                        if callerLoc, err := employeeLocation(database.DB, caller); err == nil {
+                                loc = callerLoc
+                        }
+                }
+                today := timezone.Day(time.Now(), loc)
+                
                 var attendances []models.Attendance
-                if err := database.DB.Preload("Employee").
-                        Where("DATE(date) = CURRENT_DATE").
+                if err := database.DB.Preload("Employee", views.PublicEmployee).
+                        Where("date = ?", today).
                         Find(&attendances).Error; err != nil {
                         return "", verboseSteps, fmt.Errorf("database error: %v", err)
                 }
@@ -743,10 +783,10 @@ func handleChatWithAI(userMessage string, history []map[string]string, verbose b
                         return "📊 No one has clocked in today yet.", verboseSteps, nil
                 }
                 
-                result := fmt.Sprintf("📊 Today's Attendance (%s):\n\n", time.Now().Format("Jan 02, 2006"))
+                result := fmt.Sprintf("📊 Today's Attendance (%s):\n\n", today.Format("Jan 02, 2006"))
                 for i, att := range attendances {
                         status := "Clocked In"
-                        timeInfo := fmt.Sprintf("at %s", att.ClockIn.Format("3:04 PM"))
+                        timeInfo := fmt.Sprintf("at %s", localClock(att.ClockIn, att.ClockInLocal.Location()))
                         
                         if att.ClockOut != nil {
                                 status = "Clocked Out"
@@ -838,8 +878,19 @@ func handleChatWithAI(userMessage string, history []map[string]string, verbose b
                         return "", verboseSteps, fmt.Errorf("database error: %v", err)
                 }
                 
-                result := "💰 Employee Salaries:\n\n"
+                viewer := chatViewer(userID, role)
+                var visible []models.Employee
                 for _, emp := range employees {
+                        if viewer.CanSeeCompensation(emp) {
+                                visible = append(visible, emp)
+                        }
+                }
+                if len(visible) == 0 {
+                        return "⚠️ You don't have permission to view other employees' salaries.", verboseSteps, nil
+                }
+                
+                result := "💰 Employee Salaries:\n\n"
+                for _, emp := range visible {
                         deptName := "N/A"
                         if emp.Department != nil {
                                 deptName = emp.Department.Name
@@ -976,10 +1027,11 @@ func Chat(c *gin.Context) {
         }
 
         userID, _ := c.Get("userID")
+        role := c.GetString("role")
         
         fmt.Printf("[DEBUG] Verbose mode: %v\n", input.Verbose)
 
-        aiResponse, verboseSteps, err := handleChatWithAI(input.Message, input.History, input.Verbose, userID)
+        aiResponse, verboseSteps, err := handleChatWithAI(audit.Context(c), input.Message, input.History, input.Verbose, userID, role)
         
         fmt.Printf("[DEBUG] Verbose steps count: %d\n", len(verboseSteps))
         if err != nil {
@@ -0,0 +1,402 @@
+package handlers
+
+import (
+        "encoding/json"
+        "errors"
+        "fmt"
+        "net/http"
+        "reflect"
+        "regexp"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+        "unicode/utf8"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/models"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+)
+
+// customFieldKey is the form of a custom field key: the name its values are
+// stored, returned and filtered under.
+var customFieldKey = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+var customFieldTypes = []string{
+        models.CustomFieldText,
+        models.CustomFieldNumber,
+        models.CustomFieldDate,
+        models.CustomFieldEnum,
+        models.CustomFieldMultiSelect,
+}
+
+var customFieldVisibilities = []string{
+        models.CustomFieldDirectory,
+        models.CustomFieldPersonal,
+        models.CustomFieldCompensation,
+        models.CustomFieldPerformance,
+        models.CustomFieldHR,
+}
+
+// customFieldInput is the body of CreateCustomField and UpdateCustomField.
+// A field's key and type are fixed once it is created, as the values stored
+// under it depend on them.
+type customFieldInput struct {
+        Key        string   `json:"key"`
+        Label      string   `json:"label" binding:"required"`
+        Type       string   `json:"type"`
+        Options    []string `json:"options"`
+        Required   bool     `json:"required"`
+        Min        *float64 `json:"min"`
+        Max        *float64 `json:"max"`
+        MaxLength  int      `json:"max_length"`
+        Visibility string   `json:"visibility"`
+        Position   int      `json:"position"`
+}
+
+func containsString(values []string, value string) bool {
+        for _, v := range values {
+                if v == value {
+                        return true
+                }
+        }
+        return false
+}
+
+// applyCustomFieldInput checks input and copies it onto field.
+func applyCustomFieldInput(field *models.CustomField, input customFieldInput) error {
+        field.Label = strings.TrimSpace(input.Label)
+        if field.Label == "" {
+                return errors.New("Label is required")
+        }
+
+        field.Options = nil
+        seen := map[string]bool{}
+        for _, option := range input.Options {
+                option = strings.TrimSpace(option)
+                if option == "" || seen[option] {
+                        return errors.New("Options must be unique and not empty")
+                }
+                seen[option] = true
+                field.Options = append(field.Options, option)
+        }
+        hasOptions := field.Type == models.CustomFieldEnum || field.Type == models.CustomFieldMultiSelect
+        if hasOptions && len(field.Options) == 0 {
+                return errors.New("Enum and multi-select fields need at least one option")
+        }
+        if !hasOptions && len(field.Options) > 0 {
+                return errors.New("Only enum and multi-select fields have options")
+        }
+
+        if (input.Min != nil || input.Max != nil) && field.Type != models.CustomFieldNumber {
+                return errors.New("Only number fields have a min and max")
+        }
+        if input.Min != nil && input.Max != nil && *input.Min > *input.Max {
+                return errors.New("Min cannot be greater than max")
+        }
+        if input.MaxLength != 0 && field.Type != models.CustomFieldText {
+                return errors.New("Only text fields have a max length")
+        }
+        if input.MaxLength < 0 {
+                return errors.New("Max length cannot be negative")
+        }
+        field.Min, field.Max, field.MaxLength = input.Min, input.Max, input.MaxLength
+
+        field.Visibility = input.Visibility
+        if field.Visibility == "" {
+                field.Visibility = models.CustomFieldDirectory
+        }
+        if !containsString(customFieldVisibilities, field.Visibility) {
+                return fmt.Errorf("Visibility must be one of %s", strings.Join(customFieldVisibilities, ", "))
+        }
+        field.Required = input.Required
+        field.Position = input.Position
+        return nil
+}
+
+// loadCustomFields returns the custom field definitions by key.
+func loadCustomFields(db *gorm.DB) (map[string]models.CustomField, error) {
+        var fields []models.CustomField
+        if err := db.Find(&fields).Error; err != nil {
+                return nil, err
+        }
+        byKey := make(map[string]models.CustomField, len(fields))
+        for _, field := range fields {
+                byKey[field.Key] = field
+        }
+        return byKey, nil
+}
+
+func GetCustomFields(c *gin.Context) {
+        var fields []models.CustomField
+        if err := database.DB.Order("position, id").Find(&fields).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch custom fields"})
+                return
+        }
+        c.JSON(http.StatusOK, fields)
+}
+
+func CreateCustomField(c *gin.Context) {
+        var input customFieldInput
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        if !customFieldKey.MatchString(input.Key) {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Key must start with a lowercase letter and contain only lowercase letters, digits and underscores"})
+                return
+        }
+        if !containsString(customFieldTypes, input.Type) {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Type must be one of " + strings.Join(customFieldTypes, ", ")})
+                return
+        }
+
+        field := models.CustomField{Key: input.Key, Type: input.Type}
+        if err := applyCustomFieldInput(&field, input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        var count int64
+        database.DB.Model(&models.CustomField{}).Where("key = ?", field.Key).Count(&count)
+        if count > 0 {
+                c.JSON(http.StatusConflict, gin.H{"error": "A custom field with this key already exists"})
+                return
+        }
+        if err := database.DB.WithContext(audit.Context(c)).Create(&field).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create custom field"})
+                return
+        }
+        c.JSON(http.StatusCreated, field)
+}
+
+// UpdateCustomField changes a field's definition. Values stored before the
+// change are kept even if they no longer pass, and only have to pass once
+// they are edited.
+func UpdateCustomField(c *gin.Context) {
+        var input customFieldInput
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        var field models.CustomField
+        if err := database.DB.First(&field, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Custom field not found"})
+                return
+        }
+        if (input.Key != "" && input.Key != field.Key) || (input.Type != "" && input.Type != field.Type) {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "A custom field's key and type cannot be changed"})
+                return
+        }
+        if err := applyCustomFieldInput(&field, input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        if err := database.DB.WithContext(audit.Context(c)).Save(&field).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update custom field"})
+                return
+        }
+        c.JSON(http.StatusOK, field)
+}
+
+// DeleteCustomField deletes a field and every employee's value of it, so the
+// key can be defined again from scratch.
+func DeleteCustomField(c *gin.Context) {
+        var field models.CustomField
+        if err := database.DB.First(&field, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Custom field not found"})
+                return
+        }
+
+        err := database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                if err := tx.Model(&models.Employee{}).
+                        Where("custom_fields -> CAST(? AS text) IS NOT NULL", field.Key).
+                        Update("custom_fields", gorm.Expr("custom_fields - CAST(? AS text)", field.Key)).Error; err != nil {
+                        return err
+                }
+                return tx.Delete(&field).Error
+        })
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete custom field"})
+                return
+        }
+        c.JSON(http.StatusOK, gin.H{"message": "Custom field deleted successfully"})
+}
+
+// customFieldValue checks a value of field and returns it normalised: text
+// trimmed, multi-select options de-duplicated. Empty values come back nil.
+func customFieldValue(field models.CustomField, value interface{}) (interface{}, error) {
+        name := "custom_fields." + field.Key
+        if value == nil {
+                return nil, nil
+        }
+
+        switch field.Type {
+        case models.CustomFieldNumber:
+                number, ok := value.(float64)
+                if !ok {
+                        return nil, fmt.Errorf("%s must be a number", name)
+                }
+                if field.Min != nil && number < *field.Min {
+                        return nil, fmt.Errorf("%s must be at least %v", name, *field.Min)
+                }
+                if field.Max != nil && number > *field.Max {
+                        return nil, fmt.Errorf("%s must be at most %v", name, *field.Max)
+                }
+                return number, nil
+
+        case models.CustomFieldMultiSelect:
+                list, ok := value.([]interface{})
+                if !ok {
+                        return nil, fmt.Errorf("%s must be a list of options", name)
+                }
+                selected := []interface{}{}
+                seen := map[string]bool{}
+                for _, item := range list {
+                        option, ok := item.(string)
+                        if !ok || !containsString(field.Options, option) {
+                                return nil, fmt.Errorf("%s can only hold %s", name, strings.Join(field.Options, ", "))
+                        }
+                        if !seen[option] {
+                                seen[option] = true
+                                selected = append(selected, option)
+                        }
+                }
+                if len(selected) == 0 {
+                        return nil, nil
+                }
+                return selected, nil
+        }
+
+        text, ok := value.(string)
+        if !ok {
+                return nil, fmt.Errorf("%s must be a string", name)
+        }
+        text = strings.TrimSpace(text)
+        if text == "" {
+                return nil, nil
+        }
+        switch field.Type {
+        case models.CustomFieldText:
+                if field.MaxLength > 0 && utf8.RuneCountInString(text) > field.MaxLength {
+                        return nil, fmt.Errorf("%s must be at most %d characters", name, field.MaxLength)
+                }
+        case models.CustomFieldDate:
+                if _, err := time.Parse("2006-01-02", text); err != nil {
+                        return nil, fmt.Errorf("%s must be a date (YYYY-MM-DD)", name)
+                }
+        case models.CustomFieldEnum:
+                if !containsString(field.Options, text) {
+                        return nil, fmt.Errorf("%s must be one of %s", name, strings.Join(field.Options, ", "))
+                }
+        }
+        return text, nil
+}
+
+// checkCustomFields checks an employee's new custom field values against the
+// definitions and returns them normalised, without empty values. Values left
+// as they were in previous aren't checked again, so tightening a definition
+// doesn't block unrelated edits. Required fields must have a value when an
+// employee is created, and can't lose it afterwards.
+func checkCustomFields(fields map[string]models.CustomField, values, previous map[string]interface{}, creating bool) (map[string]interface{}, error) {
+        keys := make([]string, 0, len(values))
+        for key := range values {
+                keys = append(keys, key)
+        }
+        sort.Strings(keys)
+
+        checked := map[string]interface{}{}
+        for _, key := range keys {
+                field, ok := fields[key]
+                if !ok {
+                        return nil, fmt.Errorf("Unknown custom field %q", key)
+                }
+                if old, ok := previous[key]; ok && reflect.DeepEqual(old, values[key]) {
+                        checked[key] = old
+                        continue
+                }
+                value, err := customFieldValue(field, values[key])
+                if err != nil {
+                        return nil, err
+                }
+                if value != nil {
+                        checked[key] = value
+                }
+        }
+
+        var missing []string
+        for key, field := range fields {
+                if _, ok := checked[key]; ok || !field.Required {
+                        continue
+                }
+                if _, had := previous[key]; creating || had {
+                        missing = append(missing, key)
+                }
+        }
+        if len(missing) > 0 {
+                sort.Strings(missing)
+                return nil, errors.New("Custom fields are required: " + strings.Join(missing, ", "))
+        }
+        return checked, nil
+}
+
+// filterCustomFields applies the custom.<key> filters of the employee list,
+// each a comma-separated list of values matching any of them. A multi-select
+// field matches if it holds any of the values. Only fields the caller can
+// see on every employee can be filtered on, so a filter can't reveal values.
+func filterCustomFields(c *gin.Context, query *gorm.DB) (*gorm.DB, error) {
+        var params []string
+        for param := range c.Request.URL.Query() {
+                if strings.HasPrefix(param, "custom.") {
+                        params = append(params, param)
+                }
+        }
+        if len(params) == 0 {
+                return query, nil
+        }
+        sort.Strings(params)
+
+        fields, err := loadCustomFields(database.DB)
+        if err != nil {
+                return nil, err
+        }
+        viewer := viewerFor(c)
+        for _, param := range params {
+                key := strings.TrimPrefix(param, "custom.")
+                field, ok := fields[key]
+                if !ok || !viewer.CanSeeCustomField(models.Employee{}, field.Visibility) {
+                        return nil, fmt.Errorf("cannot filter on unknown custom field %q", key)
+                }
+                value := c.Query(param)
+                if value == "" {
+                        continue
+                }
+
+                var conditions []string
+                var args []interface{}
+                for _, s := range strings.Split(value, ",") {
+                        s = strings.TrimSpace(s)
+                        var match interface{} = s
+                        switch field.Type {
+                        case models.CustomFieldNumber:
+                                number, err := strconv.ParseFloat(s, 64)
+                                if err != nil {
+                                        return nil, fmt.Errorf("invalid %s", param)
+                                }
+                                match = number
+                        case models.CustomFieldMultiSelect:
+                                match = []string{s}
+                        }
+                        // Containment is what the custom field index speeds up
+                        document, _ := json.Marshal(map[string]interface{}{key: match})
+                        conditions = append(conditions, "employees.custom_fields @> CAST(? AS jsonb)")
+                        args = append(args, string(document))
+                }
+                query = query.Where("("+strings.Join(conditions, " OR ")+")", args...)
+        }
+        return query, nil
+}
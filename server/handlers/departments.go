@@ -0,0 +1,353 @@
+package handlers
+
+import (
+        "errors"
+        "net/http"
+        "strconv"
+        "strings"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/models"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+)
+
+var departmentSorts = map[string]string{
+        "id":         "departments.id",
+        "name":       "departments.name",
+        "created_at": "departments.created_at",
+}
+
+// departmentNameTaken reports whether another department already uses name,
+// ignoring case.
+func departmentNameTaken(tx *gorm.DB, name string, id uint) bool {
+        var count int64
+        tx.Model(&models.Department{}).Where("LOWER(name) = LOWER(?) AND id <> ?", name, id).Count(&count)
+        return count > 0
+}
+
+// departmentParents maps every department to its parent.
+func departmentParents(tx *gorm.DB) (map[uint]*uint, error) {
+        var departments []models.Department
+        if err := tx.Select("id", "parent_id").Find(&departments).Error; err != nil {
+                return nil, err
+        }
+        parents := make(map[uint]*uint, len(departments))
+        for _, d := range departments {
+                parents[d.ID] = d.ParentID
+        }
+        return parents, nil
+}
+
+// createsCycle reports whether making parentID the parent of id would put id
+// among its own ancestors. parents maps each node to its parent, as for
+// departments and for employees and their managers.
+func createsCycle(parents map[uint]*uint, id, parentID uint) bool {
+        seen := map[uint]bool{}
+        for current := &parentID; current != nil; current = parents[*current] {
+                if *current == id {
+                        return true
+                }
+                // Stop on a cycle already in the data rather than loop forever
+                if seen[*current] {
+                        return false
+                }
+                seen[*current] = true
+        }
+        return false
+}
+
+// validateDepartmentParent checks that parentID exists and that moving the
+// department id under it keeps the hierarchy a tree. id is 0 for a new
+// department.
+func validateDepartmentParent(tx *gorm.DB, id uint, parentID *uint) (int, string) {
+        if parentID == nil {
+                return 0, ""
+        }
+        parents, err := departmentParents(tx)
+        if err != nil {
+                return http.StatusInternalServerError, "Failed to load departments"
+        }
+        if _, ok := parents[*parentID]; !ok {
+                return http.StatusBadRequest, "Parent department not found"
+        }
+        if id != 0 && createsCycle(parents, id, *parentID) {
+                return http.StatusConflict, "A department cannot be moved under itself or one of its sub-departments"
+        }
+        return 0, ""
+}
+
+func GetDepartments(c *gin.Context) {
+        params, err := parseListParams(c, "departments", departmentSorts, "name")
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        query := database.DB.Model(&models.Department{})
+        if query, err = filterIDs(c, query, "parent_id", "departments.parent_id"); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        if q := strings.TrimSpace(c.Query("q")); q != "" {
+                query = query.Where(`LOWER(departments.name) LIKE ? ESCAPE '\'`, likePattern(q))
+        }
+
+        var departments []models.Department
+        if err := params.find(c, query, &departments); err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch departments"})
+                return
+        }
+        c.JSON(http.StatusOK, departments)
+}
+
+func GetDepartment(c *gin.Context) {
+        var department models.Department
+        if err := database.DB.Preload("Parent").First(&department, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+                return
+        }
+        c.JSON(http.StatusOK, department)
+}
+
+// departmentNode is a department in the tree returned by GetDepartmentTree.
+// Headcount counts the department's own employees; TotalHeadcount adds those
+// of every sub-department.
+type departmentNode struct {
+        ID             uint              `json:"id"`
+        Name           string            `json:"name"`
+        ParentID       *uint             `json:"parent_id"`
+        Headcount      int64             `json:"headcount"`
+        TotalHeadcount int64             `json:"total_headcount"`
+        Children       []*departmentNode `json:"children"`
+}
+
+// GetDepartmentTree returns the department hierarchy as nested nodes.
+// Departments whose parent no longer exists are shown as roots. Employees who
+// have left don't count towards headcounts. as_of counts people in the
+// departments they were in on that day; the hierarchy is always today's.
+func GetDepartmentTree(c *gin.Context) {
+        asOf, err := parseAsOf(c)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        var departments []models.Department
+        if err := database.DB.Order("name, id").Find(&departments).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch departments"})
+                return
+        }
+
+        headcounts := map[uint]int64{}
+        if asOf != nil {
+                records, err := jobRecordsAsOf(database.DB, *asOf)
+                if err != nil {
+                        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count employees"})
+                        return
+                }
+                for _, record := range records {
+                        headcounts[record.DepartmentID]++
+                }
+        } else {
+                var counts []struct {
+                        DepartmentID uint
+                        Count        int64
+                }
+                if err := database.DB.Model(&models.Employee{}).
+                        Select("department_id, COUNT(*) AS count").
+                        Where("employment_status NOT IN ?", models.DepartedStatuses).
+                        Group("department_id").
+                        Scan(&counts).Error; err != nil {
+                        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count employees"})
+                        return
+                }
+                for _, count := range counts {
+                        headcounts[count.DepartmentID] = count.Count
+                }
+        }
+
+        nodes := make(map[uint]*departmentNode, len(departments))
+        for _, d := range departments {
+                nodes[d.ID] = &departmentNode{ID: d.ID, Name: d.Name, ParentID: d.ParentID, Children: []*departmentNode{}}
+        }
+        for id, count := range headcounts {
+                if node, ok := nodes[id]; ok {
+                        node.Headcount = count
+                }
+        }
+
+        children := map[uint][]*departmentNode{}
+        roots := []*departmentNode{}
+        for _, d := range departments {
+                if d.ParentID != nil && nodes[*d.ParentID] != nil {
+                        children[*d.ParentID] = append(children[*d.ParentID], nodes[d.ID])
+                } else {
+                        roots = append(roots, nodes[d.ID])
+                }
+        }
+
+        visited := map[uint]bool{}
+        var build func(node *departmentNode) int64
+        build = func(node *departmentNode) int64 {
+                visited[node.ID] = true
+                node.TotalHeadcount = node.Headcount
+                for _, child := range children[node.ID] {
+                        if visited[child.ID] {
+                                continue
+                        }
+                        node.Children = append(node.Children, child)
+                        node.TotalHeadcount += build(child)
+                }
+                return node.TotalHeadcount
+        }
+        for _, root := range roots {
+                build(root)
+        }
+        // Departments left over are caught in a cycle, which can only come
+        // from data written before moves were checked; show them as roots.
+        for _, d := range departments {
+                if !visited[d.ID] {
+                        roots = append(roots, nodes[d.ID])
+                        build(nodes[d.ID])
+                }
+        }
+
+        c.JSON(http.StatusOK, roots)
+}
+
+type departmentInput struct {
+        Name     string `json:"name" binding:"required"`
+        ParentID *uint  `json:"parent_id"`
+}
+
+func CreateDepartment(c *gin.Context) {
+        var input departmentInput
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        saveDepartment(c, &models.Department{}, input, http.StatusCreated)
+}
+
+// UpdateDepartment renames a department or moves it to another parent. A
+// null parent_id makes it top-level.
+func UpdateDepartment(c *gin.Context) {
+        var input departmentInput
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        var department models.Department
+        if err := database.DB.First(&department, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+                return
+        }
+        saveDepartment(c, &department, input, http.StatusOK)
+}
+
+func saveDepartment(c *gin.Context, department *models.Department, input departmentInput, status int) {
+        name := strings.TrimSpace(input.Name)
+        if name == "" {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+                return
+        }
+
+        var failStatus int
+        var failMessage string
+        err := database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                if departmentNameTaken(tx, name, department.ID) {
+                        failStatus, failMessage = http.StatusConflict, "A department with this name already exists"
+                        return errors.New(failMessage)
+                }
+                if failStatus, failMessage = validateDepartmentParent(tx, department.ID, input.ParentID); failStatus != 0 {
+                        return errors.New(failMessage)
+                }
+                department.Name = name
+                department.ParentID = input.ParentID
+                return tx.Omit("Parent").Save(department).Error
+        })
+        if failStatus != 0 {
+                c.JSON(failStatus, gin.H{"error": failMessage})
+                return
+        }
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save department"})
+                return
+        }
+        c.JSON(status, department)
+}
+
+// DeleteDepartment deletes a department once it has no employees and no
+// sub-departments. Passing reassign_to moves both to that department first,
+// in the same transaction.
+func DeleteDepartment(c *gin.Context) {
+        var department models.Department
+        if err := database.DB.First(&department, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+                return
+        }
+
+        var target *uint
+        if reassign := c.Query("reassign_to"); reassign != "" {
+                id, err := strconv.ParseUint(reassign, 10, 64)
+                if err != nil || uint(id) == department.ID {
+                        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reassign_to"})
+                        return
+                }
+                targetID := uint(id)
+                target = &targetID
+        }
+
+        var failStatus int
+        var failBody gin.H
+        err := database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                if target != nil {
+                        // The children move under the target, so the target
+                        // can't be one of them or their descendants.
+                        if status, message := validateDepartmentParent(tx, department.ID, target); status != 0 {
+                                switch status {
+                                case http.StatusBadRequest:
+                                        message = "Department to reassign to not found"
+                                case http.StatusConflict:
+                                        message = "Cannot reassign to one of this department's sub-departments"
+                                }
+                                failStatus, failBody = status, gin.H{"error": message}
+                                return errors.New(message)
+                        }
+                        if _, err := moveEmployees(tx, tx.Where("department_id = ?", department.ID), "department_id", *target,
+                                "Department "+department.Name+" was deleted", actorUserID(c)); err != nil {
+                                return err
+                        }
+                        if err := tx.Model(&models.Department{}).Where("parent_id = ?", department.ID).
+                                Update("parent_id", *target).Error; err != nil {
+                                return err
+                        }
+                }
+
+                var employees, children int64
+                tx.Model(&models.Employee{}).Where("department_id = ?", department.ID).Count(&employees)
+                tx.Model(&models.Department{}).Where("parent_id = ?", department.ID).Count(&children)
+                if employees > 0 || children > 0 {
+                        failStatus, failBody = http.StatusConflict, gin.H{
+                                "error":           "Reassign this department's employees and sub-departments before deleting it",
+                                "employees":       employees,
+                                "sub_departments": children,
+                        }
+                        return errors.New("department not empty")
+                }
+                return tx.Delete(&department).Error
+        })
+        if failStatus != 0 {
+                c.JSON(failStatus, failBody)
+                return
+        }
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete department"})
+                return
+        }
+        c.JSON(http.StatusOK, gin.H{"message": "Department deleted successfully"})
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+        "crypto/rand"
+        "crypto/sha256"
+        "encoding/base64"
+        "encoding/hex"
+        "errors"
+        "fmt"
+        "io"
+        "log"
+        "math"
+        "mime"
+        "net/http"
+        "os"
+        "path/filepath"
+        "strconv"
+        "strings"
+        "time"
+
+        "hcm-backend/audit"
+        "hcm-backend/blobstore"
+        "hcm-backend/database"
+        "hcm-backend/models"
+        "hcm-backend/views"
+
+        "github.com/gin-gonic/gin"
+)
+
+// defaultDocumentMaxSize applies when DOCUMENT_MAX_SIZE_MB is unset.
+const defaultDocumentMaxSize = 10 << 20
+
+// documentContentTypes are the types, as sniffed from their contents, that
+// documents may have. Anything a browser might run, such as HTML, is refused.
+var documentContentTypes = map[string]bool{
+        "application/pdf":              true,
+        "image/png":                    true,
+        "image/jpeg":                   true,
+        "image/gif":                    true,
+        "image/webp":                   true,
+        "text/plain; charset=utf-8":    true,
+        "text/plain; charset=utf-16be": true,
+        "text/plain; charset=utf-16le": true,
+}
+
+// officeContentTypes are the Office formats accepted by extension. They are
+// zip archives inside, which is all sniffing can tell.
+var officeContentTypes = map[string]string{
+        ".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+        ".xlsx": xlsxContentType,
+        ".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+}
+
+// documentMaxSize is the largest upload accepted, from DOCUMENT_MAX_SIZE_MB.
+func documentMaxSize() int64 {
+        if mb, err := strconv.ParseInt(os.Getenv("DOCUMENT_MAX_SIZE_MB"), 10, 64); err == nil && mb > 0 {
+                return mb << 20
+        }
+        return defaultDocumentMaxSize
+}
+
+// sniffDocumentType works out a file's type from its first bytes, and its
+// name for Office files. It returns "" for types documents may not have.
+func sniffDocumentType(head []byte, name string) string {
+        contentType := http.DetectContentType(head)
+        if contentType == "application/zip" {
+                return officeContentTypes[strings.ToLower(filepath.Ext(name))]
+        }
+        if documentContentTypes[contentType] {
+                return contentType
+        }
+        return ""
+}
+
+// newDocumentKey returns a fresh blob key under the employee's prefix. Keys
+// are random so names chosen by uploaders never reach the store.
+func newDocumentKey(employeeID uint) (string, error) {
+        b := make([]byte, 16)
+        if _, err := rand.Read(b); err != nil {
+                return "", err
+        }
+        return fmt.Sprintf("employees/%d/%s", employeeID, hex.EncodeToString(b)), nil
+}
+
+// documentAccess loads the employee behind a request for their documents
+// and checks the caller may see them. If not, it has already answered.
+func documentAccess(c *gin.Context, employeeID interface{}) (models.Employee, bool) {
+        var employee models.Employee
+        if err := database.DB.Scopes(views.PublicEmployee).First(&employee, employeeID).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+                return employee, false
+        }
+        if !viewerFor(c).CanSeeDocuments(employee) {
+                c.JSON(http.StatusForbidden, gin.H{"error": "You cannot see this employee's documents"})
+                return employee, false
+        }
+        return employee, true
+}
+
+// GetEmployeeDocuments lists an employee's documents, newest first. Only the
+// employee and HR may see them.
+func GetEmployeeDocuments(c *gin.Context) {
+        employee, ok := documentAccess(c, c.Param("id"))
+        if !ok {
+                return
+        }
+        query := database.DB.Where("employee_id = ?", employee.ID)
+        query = filterValues(c, query, "doc_type", "doc_type")
+
+        var documents []models.Document
+        if err := query.Order("created_at DESC, id DESC").Find(&documents).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch documents"})
+                return
+        }
+        c.JSON(http.StatusOK, documents)
+}
+
+// UploadDocument stores a file for an employee. Its type is sniffed from the
+// contents rather than trusted from the client, and its SHA-256 is kept so
+// downloads can be checked.
+func UploadDocument(c *gin.Context) {
+        var employee models.Employee
+        if err := database.DB.Select("id").First(&employee, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+                return
+        }
+
+        maxSize := documentMaxSize()
+        tooLarge := fmt.Sprintf("Documents can be at most %d MB", maxSize>>20)
+        // Leave room for the rest of the form
+        c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+1<<20)
+        header, err := c.FormFile("file")
+        if err != nil {
+                var maxBytesErr *http.MaxBytesError
+                if errors.As(err, &maxBytesErr) {
+                        c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": tooLarge})
+                        return
+                }
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Upload the document as the multipart field file"})
+                return
+        }
+        if header.Size > maxSize {
+                c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": tooLarge})
+                return
+        }
+        if header.Size == 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "The file is empty"})
+                return
+        }
+
+        docType := strings.TrimSpace(c.PostForm("doc_type"))
+        if docType == "" {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "doc_type is required"})
+                return
+        }
+        expiry, err := parseLifecycleDate(c.PostForm("expiry_date"), "expiry_date")
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        file, err := header.Open()
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read the file"})
+                return
+        }
+        defer file.Close()
+        head := make([]byte, 512)
+        n, _ := io.ReadFull(file, head)
+        name := filepath.Base(strings.ReplaceAll(header.Filename, "\\", "/"))
+        contentType := sniffDocumentType(head[:n], name)
+        if contentType == "" {
+                c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Documents must be PDFs, images, plain text or Word, Excel or PowerPoint files"})
+                return
+        }
+        if _, err := file.Seek(0, io.SeekStart); err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read the file"})
+                return
+        }
+
+        key, err := newDocumentKey(employee.ID)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store document"})
+                return
+        }
+        hash := sha256.New()
+        if err := blobstore.Default.Put(c.Request.Context(), key, io.TeeReader(file, hash), header.Size, contentType); err != nil {
+                log.Println("Failed to store document:", err)
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store document"})
+                return
+        }
+
+        document := models.Document{
+                EmployeeID:  employee.ID,
+                DocType:     docType,
+                FilePath:    key,
+                FileName:    name,
+                ContentType: contentType,
+                Size:        header.Size,
+                Checksum:    hex.EncodeToString(hash.Sum(nil)),
+                ExpiryDate:  expiry,
+                UploadedBy:  actorUserID(c),
+        }
+        if err := database.DB.WithContext(audit.Context(c)).Create(&document).Error; err != nil {
+                if err := blobstore.Default.Delete(c.Request.Context(), key); err != nil {
+                        log.Println("Failed to remove an orphaned document:", err)
+                }
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save document"})
+                return
+        }
+        c.JSON(http.StatusCreated, document)
+}
+
+// DownloadDocument streams a document's contents with the type it was
+// uploaded as. Contents that no longer match the stored checksum are logged;
+// by then the response has been sent.
+func DownloadDocument(c *gin.Context) {
+        var document models.Document
+        if err := database.DB.First(&document, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+                return
+        }
+        if _, ok := documentAccess(c, document.EmployeeID); !ok {
+                return
+        }
+
+        contents, err := blobstore.Default.Get(c.Request.Context(), document.FilePath)
+        if errors.Is(err, blobstore.ErrNotFound) {
+                c.JSON(http.StatusNotFound, gin.H{"error": "The document's file is missing"})
+                return
+        }
+        if err != nil {
+                log.Println("Failed to read document:", err)
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read document"})
+                return
+        }
+        defer contents.Close()
+
+        headers := map[string]string{
+                "Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": document.FileName}),
+                "X-Content-Type-Options": "nosniff",
+        }
+        if sum, err := hex.DecodeString(document.Checksum); err == nil {
+                headers["Repr-Digest"] = "sha-256=:" + base64.StdEncoding.EncodeToString(sum) + ":"
+        }
+        hash := sha256.New()
+        c.DataFromReader(http.StatusOK, document.Size, document.ContentType, io.TeeReader(contents, hash), headers)
+        if hex.EncodeToString(hash.Sum(nil)) != document.Checksum {
+                log.Printf("Document %d does not match its checksum", document.ID)
+        }
+}
+
+// DeleteDocument deletes a document and its contents.
+func DeleteDocument(c *gin.Context) {
+        var document models.Document
+        if err := database.DB.First(&document, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+                return
+        }
+        if err := database.DB.WithContext(audit.Context(c)).Delete(&document).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
+                return
+        }
+        // The record is gone either way; a blob left behind is only logged
+        if err := blobstore.Default.Delete(c.Request.Context(), document.FilePath); err != nil {
+                log.Println("Failed to remove document contents:", err)
+        }
+        c.JSON(http.StatusOK, gin.H{"message": "Document deleted successfully"})
+}
+
+// GetExpiringDocuments reports documents that expire within the next days
+// days (default 30), soonest first, with days_until_expiry on each.
+// include_expired adds those already past their expiry date.
+func GetExpiringDocuments(c *gin.Context) {
+        days := 30
+        if value := c.Query("days"); value != "" {
+                parsed, err := strconv.Atoi(value)
+                if err != nil || parsed < 0 || parsed > 3650 {
+                        c.JSON(http.StatusBadRequest, gin.H{"error": "days must be a number from 0 to 3650"})
+                        return
+                }
+                days = parsed
+        }
+
+        today := dateOnly(time.Now())
+        query := database.DB.Preload("Employee", views.PublicEmployee).
+                Where("expiry_date IS NOT NULL AND expiry_date < ?", today.AddDate(0, 0, days+1))
+        if c.Query("include_expired") != "true" {
+                query = query.Where("expiry_date >= ?", today)
+        }
+        query = filterValues(c, query, "doc_type", "doc_type")
+
+        var documents []models.Document
+        if err := query.Order("expiry_date, id").Find(&documents).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch documents"})
+                return
+        }
+
+        out := make([]map[string]interface{}, 0, len(documents))
+        for _, document := range documents {
+                record := shapeRecord(document)
+                record["days_until_expiry"] = int(math.Round(dateOnly(*document.ExpiryDate).Sub(today).Hours() / 24))
+                out = append(out, record)
+        }
+        c.JSON(http.StatusOK, out)
+}
@@ -1,23 +1,154 @@
 package handlers
 
 import (
+        "encoding/json"
+        "errors"
+        "log"
         "net/http"
+        "sort"
+        "strings"
         "time"
 
+        "hcm-backend/audit"
         "hcm-backend/database"
+        "hcm-backend/fieldcrypt"
+        "hcm-backend/middleware"
         "hcm-backend/models"
+        "hcm-backend/views"
 
         "github.com/gin-gonic/gin"
+        "github.com/gin-gonic/gin/binding"
+        "gorm.io/gorm"
 )
 
+// identifierConflict reports which unique identifier, if any, already belongs
+// to another employee. The identifiers are encrypted, so they are matched on
+// their blind indexes.
+func identifierConflict(db *gorm.DB, employee models.Employee) string {
+        checks := []struct {
+                column, value, label string
+        }{
+                {"national_id_hash", employee.NationalID, "national ID"},
+                {"tax_id_hash", employee.TaxID, "tax ID"},
+        }
+
+        for _, check := range checks {
+                hash := fieldcrypt.BlindIndex(check.value)
+                if hash == "" {
+                        continue
+                }
+                var count int64
+                db.Unscoped().Model(&models.Employee{}).
+                        Where(check.column+" = ? AND id <> ?", hash, employee.ID).
+                        Count(&count)
+                if count > 0 {
+                        return check.label
+                }
+        }
+        return ""
+}
+
+// viewerFor returns the view policy for the authenticated caller.
+func viewerFor(c *gin.Context) views.Viewer {
+        return views.NewViewer(c.GetUint("userID"), func(permission string) bool {
+                return middleware.HasPermission(c, permission)
+        })
+}
+
+// callerEmployeeID returns the ID of the caller's own employee record, or 0
+// if their account isn't linked to one or they are an API key.
+func callerEmployeeID(c *gin.Context) uint {
+        userID := c.GetUint("userID")
+        if userID == 0 {
+                return 0
+        }
+        var employee models.Employee
+        if err := database.DB.Select("id").Where("user_id = ?", userID).First(&employee).Error; err != nil {
+                return 0
+        }
+        return employee.ID
+}
+
+// employeeForCaller loads the employee a self-service request such as
+// clocking in names, and checks the caller may act for them: it must be the
+// caller, someone below them in the manager chain, or the caller must hold
+// one of permissions. It writes the error response and returns false
+// otherwise.
+func employeeForCaller(c *gin.Context, employeeID uint, permissions ...string) (models.Employee, bool) {
+        var employee models.Employee
+        if err := database.DB.First(&employee, employeeID).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+                return employee, false
+        }
+        for _, permission := range permissions {
+                if middleware.HasPermission(c, permission) {
+                        return employee, true
+                }
+        }
+        viewer := viewerFor(c)
+        if employee.ID == viewer.EmployeeID || viewer.ManagesEmployee(employee) {
+                return employee, true
+        }
+        c.JSON(http.StatusForbidden, gin.H{"error": "You can only do this for yourself or the employees who report to you"})
+        return employee, false
+}
+
+// employeeSorts are the fields employees can be sorted by. Masked fields are
+// left out so the order can't reveal them.
+var employeeSorts = map[string]string{
+        "id":                "employees.id",
+        "name":              "employees.name",
+        "email":             "employees.email",
+        "job_title":         "employees.job_title",
+        "hire_date":         "employees.hire_date",
+        "department":        "(SELECT name FROM departments WHERE departments.id = employees.department_id)",
+        "employee_number":   "employees.employee_number",
+        "employment_type":   "employees.employment_type",
+        "employment_status": "employees.employment_status",
+        "work_location":     "employees.work_location",
+        "created_at":        "employees.created_at",
+}
+
+// employeeListQuery applies GetEmployees' filters: department_id,
+// manager_id, employment_type, employment_status, work_location, q and
+// custom.<key>.
+func employeeListQuery(c *gin.Context) (*gorm.DB, error) {
+        query := database.DB.Model(&models.Employee{})
+        var err error
+        if query, err = filterIDs(c, query, "department_id", "employees.department_id"); err != nil {
+                return nil, err
+        }
+        if query, err = filterIDs(c, query, "manager_id", "employees.manager_id"); err != nil {
+                return nil, err
+        }
+        query = filterValues(c, query, "employment_type", "employees.employment_type")
+        query = filterValues(c, query, "employment_status", "employees.employment_status")
+        query = filterValues(c, query, "work_location", "employees.work_location")
+        // Every word has to appear somewhere in the name, email or job title
+        for _, term := range strings.Fields(c.Query("q")) {
+                query = query.Where(models.EmployeeSearchDocument+` LIKE ? ESCAPE '\'`, likePattern(term))
+        }
+        return filterCustomFields(c, query)
+}
+
 func GetEmployees(c *gin.Context) {
+        params, err := parseListParams(c, "employees", employeeSorts, "name")
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        query, err := employeeListQuery(c)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
         var employees []models.Employee
-        result := database.DB.Preload("Department").Preload("Manager").Find(&employees)
-        if result.Error != nil {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+        if err := params.find(c, query.Preload("Department").Preload("Manager"), &employees); err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
                 return
         }
-        c.JSON(http.StatusOK, employees)
+        c.JSON(http.StatusOK, viewerFor(c).Employees(employees))
 }
 
 func GetEmployee(c *gin.Context) {
@@ -28,7 +159,8 @@ func GetEmployee(c *gin.Context) {
                 c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
                 return
         }
-        c.JSON(http.StatusOK, employee)
+        c.Header("ETag", etag(employee.Version))
+        c.JSON(http.StatusOK, viewerFor(c).Employee(employee))
 }
 
 func CreateEmployee(c *gin.Context) {
@@ -49,6 +181,7 @@ func CreateEmployee(c *gin.Context) {
                 JobLevel           string  `json:"job_level"`
                 WorkLocation       string  `json:"work_location"`
                 WorkArrangement    string  `json:"work_arrangement"`
+                Timezone           string  `json:"timezone"`
                 BaseSalary         float64 `json:"base_salary"`
                 PayFrequency       string  `json:"pay_frequency"`
                 Currency           string  `json:"currency"`
@@ -59,6 +192,9 @@ func CreateEmployee(c *gin.Context) {
                 Skills             string  `json:"skills"`
                 TrainingCompleted  string  `json:"training_completed"`
                 CareerNotes        string  `json:"career_notes"`
+                SendInvite         bool    `json:"send_invite"`
+
+                CustomFields map[string]interface{} `json:"custom_fields"`
         }
 
         if err := c.ShouldBindJSON(&createData); err != nil {
@@ -66,12 +202,37 @@ func CreateEmployee(c *gin.Context) {
                 return
         }
 
+        // Later statuses are reached through the lifecycle endpoints so their
+        // side effects run
+        switch createData.EmploymentStatus {
+        case "", models.EmploymentStatusPending, models.EmploymentStatusProbation, models.EmploymentStatusActive:
+        default:
+                c.JSON(http.StatusBadRequest, gin.H{"error": "New employees must be pending, probation or active"})
+                return
+        }
+
+        if err := checkTimezone(createData.Timezone, "timezone"); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
         // Prevent self-reporting
         if createData.ManagerID != nil && *createData.ManagerID == 0 {
                 c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid manager ID"})
                 return
         }
 
+        fields, err := loadCustomFields(database.DB)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load custom fields"})
+                return
+        }
+        custom, err := checkCustomFields(fields, createData.CustomFields, nil, true)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
         employee := models.Employee{
                 Name:               createData.Name,
                 Email:              createData.Email,
@@ -87,6 +248,7 @@ func CreateEmployee(c *gin.Context) {
                 JobLevel:           createData.JobLevel,
                 WorkLocation:       createData.WorkLocation,
                 WorkArrangement:    createData.WorkArrangement,
+                Timezone:           createData.Timezone,
                 BaseSalary:         createData.BaseSalary,
                 PayFrequency:       createData.PayFrequency,
                 Currency:           createData.Currency,
@@ -96,6 +258,7 @@ func CreateEmployee(c *gin.Context) {
                 Skills:             createData.Skills,
                 TrainingCompleted:  createData.TrainingCompleted,
                 CareerNotes:        createData.CareerNotes,
+                CustomFields:       custom,
         }
 
         // Parse hire date if provided
@@ -122,69 +285,267 @@ func CreateEmployee(c *gin.Context) {
                 }
         }
 
-        result := database.DB.Create(&employee)
-        if result.Error != nil {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+        if err := checkManager(database.DB, 0, employee.ManagerID); err != nil {
+                c.JSON(managerErrorStatus(err), gin.H{"error": err.Error()})
+                return
+        }
+
+        if label := identifierConflict(database.DB, employee); label != "" {
+                c.JSON(http.StatusConflict, gin.H{"error": "Another employee already has this " + label})
+                return
+        }
+
+        err = database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                if err := tx.Create(&employee).Error; err != nil {
+                        return err
+                }
+                return recordJobChange(tx, models.Employee{}, employee, "", actorUserID(c))
+        })
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
                 return
         }
 
+        // Invite the new hire so their account is linked from the start
+        if createData.SendInvite {
+                if _, err := inviteEmployee(c, employee, models.RoleEmployee); err != nil {
+                        log.Println("Failed to invite employee:", err)
+                }
+        }
+
         database.DB.Preload("Department").Preload("Manager").First(&employee, employee.ID)
-        c.JSON(http.StatusCreated, employee)
+        c.JSON(http.StatusCreated, viewerFor(c).Employee(employee))
+}
+
+// employeeUpdate is the body of UpdateEmployee. PatchEmployee starts from
+// the employee's current values and applies the patch to it.
+type employeeUpdate struct {
+        Name               string  `json:"name" binding:"required"`
+        Email              string  `json:"email" binding:"required,email"`
+        DepartmentID       uint    `json:"department_id"`
+        JobTitle           string  `json:"job_title"`
+        HireDate           string  `json:"hire_date"`
+        ManagerID          *uint   `json:"manager_id"`
+        EmployeeNumber     string  `json:"employee_number"`
+        DateOfBirth        string  `json:"date_of_birth"`
+        NationalID         string  `json:"national_id"`
+        TaxID              string  `json:"tax_id"`
+        MaritalStatus      string  `json:"marital_status"`
+        EmploymentType     string  `json:"employment_type"`
+        EmploymentStatus   string  `json:"employment_status"`
+        JobLevel           string  `json:"job_level"`
+        WorkLocation       string  `json:"work_location"`
+        WorkArrangement    string  `json:"work_arrangement"`
+        Timezone           string  `json:"timezone"`
+        BaseSalary         float64 `json:"base_salary"`
+        PayFrequency       string  `json:"pay_frequency"`
+        Currency           string  `json:"currency"`
+        BankAccount        string  `json:"bank_account"`
+        BenefitEligibility string  `json:"benefit_eligibility"`
+        ProbationEndDate   string  `json:"probation_end_date"`
+        PerformanceRating  string  `json:"performance_rating"`
+        Skills             string  `json:"skills"`
+        TrainingCompleted  string  `json:"training_completed"`
+        CareerNotes        string  `json:"career_notes"`
+
+        // CustomFields replaces the employee's custom field values, apart
+        // from those the caller can't see. Left out, they are unchanged.
+        CustomFields map[string]interface{} `json:"custom_fields"`
+}
+
+// employeeUpdateFrom returns the employee's current values as an update.
+func employeeUpdateFrom(e models.Employee) employeeUpdate {
+        date := func(t *time.Time) string {
+                if t == nil || t.IsZero() {
+                        return ""
+                }
+                return t.Format("2006-01-02")
+        }
+        return employeeUpdate{
+                Name:               e.Name,
+                Email:              e.Email,
+                DepartmentID:       e.DepartmentID,
+                JobTitle:           e.JobTitle,
+                HireDate:           date(&e.HireDate),
+                ManagerID:          e.ManagerID,
+                EmployeeNumber:     e.EmployeeNumber,
+                DateOfBirth:        date(e.DateOfBirth),
+                NationalID:         e.NationalID,
+                TaxID:              e.TaxID,
+                MaritalStatus:      e.MaritalStatus,
+                EmploymentType:     e.EmploymentType,
+                EmploymentStatus:   e.EmploymentStatus,
+                JobLevel:           e.JobLevel,
+                WorkLocation:       e.WorkLocation,
+                WorkArrangement:    e.WorkArrangement,
+                Timezone:           e.Timezone,
+                BaseSalary:         e.BaseSalary,
+                PayFrequency:       e.PayFrequency,
+                Currency:           e.Currency,
+                BankAccount:        e.BankAccount,
+                BenefitEligibility: e.BenefitEligibility,
+                ProbationEndDate:   date(e.ProbationEndDate),
+                PerformanceRating:  e.PerformanceRating,
+                Skills:             e.Skills,
+                TrainingCompleted:  e.TrainingCompleted,
+                CareerNotes:        e.CareerNotes,
+                CustomFields:       e.CustomFields,
+        }
 }
 
 func UpdateEmployee(c *gin.Context) {
         id := c.Param("id")
         var employee models.Employee
-        
+
         if err := database.DB.First(&employee, id).Error; err != nil {
                 c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
                 return
         }
+        if !ifMatch(c, employee.Version, false) {
+                return
+        }
 
-        // Store original ID before binding
+        var updateData employeeUpdate
+        if err := c.ShouldBindJSON(&updateData); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        saveEmployeeUpdate(c, employee, updateData, false)
+}
+
+// PatchEmployee applies a JSON Merge Patch (RFC 7386) to an employee: fields
+// in the body are set, fields set to null are cleared, and the rest are left
+// as they are. custom_fields is merged the same way, value by value. If-Match is required, so edits made since the caller read the
+// employee are never overwritten.
+func PatchEmployee(c *gin.Context) {
+        var employee models.Employee
+        if err := database.DB.First(&employee, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+                return
+        }
+        if !ifMatch(c, employee.Version, true) {
+                return
+        }
+
+        var patch map[string]json.RawMessage
+        if err := json.NewDecoder(c.Request.Body).Decode(&patch); err != nil || patch == nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "The body must be a JSON object"})
+                return
+        }
+
+        current, _ := json.Marshal(employeeUpdateFrom(employee))
+        var fields map[string]json.RawMessage
+        json.Unmarshal(current, &fields)
+        var unknown []string
+        for field, value := range patch {
+                if _, ok := fields[field]; !ok {
+                        unknown = append(unknown, field)
+                } else if field == "custom_fields" {
+                        var values map[string]interface{}
+                        if err := json.Unmarshal(value, &values); err != nil {
+                                c.JSON(http.StatusBadRequest, gin.H{"error": "custom_fields must be an object"})
+                                return
+                        }
+                        custom := map[string]interface{}{}
+                        if values != nil {
+                                for key, v := range employee.CustomFields {
+                                        custom[key] = v
+                                }
+                        }
+                        for key, v := range values {
+                                if v == nil {
+                                        delete(custom, key)
+                                } else {
+                                        custom[key] = v
+                                }
+                        }
+                        fields[field], _ = json.Marshal(custom)
+                } else if string(value) == "null" {
+                        delete(fields, field)
+                } else {
+                        fields[field] = value
+                }
+        }
+        if len(unknown) > 0 {
+                sort.Strings(unknown)
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Fields cannot be patched: " + strings.Join(unknown, ", ")})
+                return
+        }
+
+        var updateData employeeUpdate
+        merged, _ := json.Marshal(fields)
+        if err := json.Unmarshal(merged, &updateData); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        if err := binding.Validator.ValidateStruct(&updateData); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        for field, value := range map[string]string{
+                "hire_date":          updateData.HireDate,
+                "date_of_birth":      updateData.DateOfBirth,
+                "probation_end_date": updateData.ProbationEndDate,
+        } {
+                if _, err := parseLifecycleDate(value, field); err != nil {
+                        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                        return
+                }
+        }
+        saveEmployeeUpdate(c, employee, updateData, true)
+}
+
+// saveEmployeeUpdate validates and saves an update of employee. UpdateEmployee
+// leaves dates the body leaves empty unchanged; with clearDates, as for a
+// patch, empty dates are cleared. The save fails with 412 if the employee
+// changed since it was loaded.
+func saveEmployeeUpdate(c *gin.Context, employee models.Employee, updateData employeeUpdate, clearDates bool) {
         originalID := employee.ID
-        
-        // Bind request into a separate struct to avoid overwriting ID
-        var updateData struct {
-                Name               string  `json:"name" binding:"required"`
-                Email              string  `json:"email" binding:"required,email"`
-                DepartmentID       uint    `json:"department_id"`
-                JobTitle           string  `json:"job_title"`
-                HireDate           string  `json:"hire_date"`
-                ManagerID          *uint   `json:"manager_id"`
-                EmployeeNumber     string  `json:"employee_number"`
-                DateOfBirth        string  `json:"date_of_birth"`
-                NationalID         string  `json:"national_id"`
-                TaxID              string  `json:"tax_id"`
-                MaritalStatus      string  `json:"marital_status"`
-                EmploymentType     string  `json:"employment_type"`
-                EmploymentStatus   string  `json:"employment_status"`
-                JobLevel           string  `json:"job_level"`
-                WorkLocation       string  `json:"work_location"`
-                WorkArrangement    string  `json:"work_arrangement"`
-                BaseSalary         float64 `json:"base_salary"`
-                PayFrequency       string  `json:"pay_frequency"`
-                Currency           string  `json:"currency"`
-                BankAccount        string  `json:"bank_account"`
-                BenefitEligibility string  `json:"benefit_eligibility"`
-                ProbationEndDate   string  `json:"probation_end_date"`
-                PerformanceRating  string  `json:"performance_rating"`
-                Skills             string  `json:"skills"`
-                TrainingCompleted  string  `json:"training_completed"`
-                CareerNotes        string  `json:"career_notes"`
+        before := employee
+
+        // Status changes go through the lifecycle endpoints so terminations
+        // and rehires are recorded and offboarding runs
+        if updateData.EmploymentStatus != "" && updateData.EmploymentStatus != employee.EmploymentStatus {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Use POST /api/employees/:id/lifecycle/:action to change employment status"})
+                return
         }
 
-        if err := c.ShouldBindJSON(&updateData); err != nil {
+        if err := checkTimezone(updateData.Timezone, "timezone"); err != nil {
                 c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
                 return
         }
 
-        // Prevent self-reporting using original ID
-        if updateData.ManagerID != nil && *updateData.ManagerID == originalID {
-                c.JSON(http.StatusBadRequest, gin.H{"error": "Employee cannot be their own manager"})
+        // Prevent self-reporting and longer reporting loops using original ID
+        if err := checkManager(database.DB, originalID, updateData.ManagerID); err != nil {
+                c.JSON(managerErrorStatus(err), gin.H{"error": err.Error()})
                 return
         }
 
+        viewer := viewerFor(c)
+        if updateData.CustomFields != nil {
+                fields, err := loadCustomFields(database.DB)
+                if err != nil {
+                        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load custom fields"})
+                        return
+                }
+                // The caller was never shown these, so leaving them out
+                // doesn't clear them
+                for key, value := range employee.CustomFields {
+                        if _, sent := updateData.CustomFields[key]; sent {
+                                continue
+                        }
+                        if field, ok := fields[key]; ok && !viewer.CanSeeCustomField(employee, field.Visibility) {
+                                updateData.CustomFields[key] = value
+                        }
+                }
+                custom, err := checkCustomFields(fields, updateData.CustomFields, employee.CustomFields, false)
+                if err != nil {
+                        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                        return
+                }
+                employee.CustomFields = custom
+        }
+
         // Update only permitted fields
         employee.Name = updateData.Name
         employee.Email = updateData.Email
@@ -196,10 +557,10 @@ func UpdateEmployee(c *gin.Context) {
         employee.TaxID = updateData.TaxID
         employee.MaritalStatus = updateData.MaritalStatus
         employee.EmploymentType = updateData.EmploymentType
-        employee.EmploymentStatus = updateData.EmploymentStatus
         employee.JobLevel = updateData.JobLevel
         employee.WorkLocation = updateData.WorkLocation
         employee.WorkArrangement = updateData.WorkArrangement
+        employee.Timezone = updateData.Timezone
         employee.BaseSalary = updateData.BaseSalary
         employee.PayFrequency = updateData.PayFrequency
         employee.Currency = updateData.Currency
@@ -209,13 +570,15 @@ func UpdateEmployee(c *gin.Context) {
         employee.Skills = updateData.Skills
         employee.TrainingCompleted = updateData.TrainingCompleted
         employee.CareerNotes = updateData.CareerNotes
-        
+
         // Parse hire date if provided
         if updateData.HireDate != "" {
                 hireDate, err := time.Parse("2006-01-02", updateData.HireDate)
                 if err == nil {
                         employee.HireDate = hireDate
                 }
+        } else if clearDates {
+                employee.HireDate = time.Time{}
         }
 
         // Parse date of birth if provided
@@ -224,6 +587,8 @@ func UpdateEmployee(c *gin.Context) {
                 if err == nil {
                         employee.DateOfBirth = &dob
                 }
+        } else if clearDates {
+                employee.DateOfBirth = nil
         }
 
         // Parse probation end date if provided
@@ -232,9 +597,49 @@ func UpdateEmployee(c *gin.Context) {
                 if err == nil {
                         employee.ProbationEndDate = &probationEnd
                 }
+        } else if clearDates {
+                employee.ProbationEndDate = nil
+        }
+
+        // Sensitive fields the caller can't see, or only sees masked, keep
+        // their stored values, so sending back a record they read doesn't
+        // blank them or overwrite them with masks
+        if !viewer.CanSeePersonal(before) {
+                employee.DateOfBirth, employee.MaritalStatus = before.DateOfBirth, before.MaritalStatus
+        }
+        if !viewer.CanSeeIdentifiers(before) {
+                employee.NationalID, employee.TaxID, employee.BankAccount = before.NationalID, before.TaxID, before.BankAccount
+        }
+        if !viewer.CanSeeCompensation(before) {
+                employee.BaseSalary, employee.PayFrequency = before.BaseSalary, before.PayFrequency
+                employee.Currency, employee.BenefitEligibility = before.Currency, before.BenefitEligibility
+        }
+        if !viewer.CanSeePerformance(before) {
+                employee.PerformanceRating, employee.ProbationEndDate = before.PerformanceRating, before.ProbationEndDate
+                employee.CareerNotes, employee.TrainingCompleted = before.CareerNotes, before.TrainingCompleted
+        }
+
+        if label := identifierConflict(database.DB, employee); label != "" {
+                c.JSON(http.StatusConflict, gin.H{"error": "Another employee already has this " + label})
+                return
+        }
+
+        err := database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                if err := saveVersioned(tx, &employee, before.Version); err != nil {
+                        return err
+                }
+                return recordJobChange(tx, before, employee, "", actorUserID(c))
+        })
+        if errors.Is(err, errVersionConflict) {
+                versionConflict(c, currentVersion(&models.Employee{}, employee.ID))
+                return
+        }
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update employee"})
+                return
         }
 
-        database.DB.Save(&employee)
         database.DB.Preload("Department").Preload("Manager").First(&employee, employee.ID)
-        c.JSON(http.StatusOK, employee)
+        c.Header("ETag", etag(employee.Version))
+        c.JSON(http.StatusOK, viewerFor(c).Employee(employee))
 }
@@ -0,0 +1,471 @@
+package handlers
+
+import (
+        "encoding/csv"
+        "encoding/json"
+        "fmt"
+        "io"
+        "log"
+        "math"
+        "net/http"
+        "strconv"
+        "strings"
+        "time"
+
+        "hcm-backend/database"
+        "hcm-backend/models"
+        "hcm-backend/views"
+        "hcm-backend/xlsx"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+)
+
+// exportBatchSize is how many rows an export reads before looking up the
+// related records they need and writing them out.
+const exportBatchSize = 500
+
+// exportFormats maps the format query parameter to the file extension and
+// content type of the response.
+var exportFormats = map[string]struct{ extension, contentType string }{
+        "csv":   {"csv", "text/csv; charset=utf-8"},
+        "xlsx":  {"xlsx", xlsxContentType},
+        "jsonl": {"jsonl", "application/x-ndjson"},
+}
+
+// exportDateColumns hold dates without a time of day. They are written as
+// YYYY-MM-DD, or as date cells in XLSX.
+var exportDateColumns = map[string]bool{
+        "hire_date":          true,
+        "date_of_birth":      true,
+        "probation_end_date": true,
+        "last_working_day":   true,
+        "date":               true,
+        "start_date":         true,
+        "end_date":           true,
+}
+
+// employeeExportColumns are the columns an employee export can have. The
+// defaults are named like the import's columns, so an export can be edited
+// and imported again.
+var (
+        employeeExportColumns = []string{
+                "id", "employee_number", "name", "email", "job_title", "job_level",
+                "department_id", "department", "manager_id", "manager_name", "manager_email",
+                "hire_date", "employment_type", "employment_status", "work_location", "work_arrangement", "timezone",
+                "last_working_day", "termination_reason", "date_of_birth", "marital_status",
+                "national_id", "tax_id", "bank_account", "base_salary", "pay_frequency", "currency",
+                "benefit_eligibility", "probation_end_date", "performance_rating", "skills",
+                "training_completed", "career_notes", "user_id", "external_id", "created_at", "updated_at",
+        }
+        employeeExportDefaults = []string{
+                "employee_number", "name", "email", "job_title", "department", "manager_email",
+                "employment_type", "employment_status", "hire_date", "work_location",
+        }
+
+        attendanceExportColumns = []string{
+                "id", "employee_id", "employee_name", "employee_email", "department",
+                "date", "timezone", "clock_in", "clock_out", "clock_in_local", "clock_out_local", "hours", "location",
+        }
+
+        leaveExportColumns = []string{
+                "id", "employee_id", "employee_name", "employee_email", "department",
+                "leave_type", "start_date", "end_date", "days", "status", "created_at",
+        }
+)
+
+// exportRequest is what a caller asked to export.
+type exportRequest struct {
+        format  string
+        columns []string
+        order   string
+}
+
+// parseExportRequest reads the format, columns and sort query parameters.
+// columns is a comma-separated list from allowed, defaulting to defaults.
+func parseExportRequest(c *gin.Context, table string, sortable map[string]string, defaultSort string, allowed, defaults []string) (exportRequest, error) {
+        request := exportRequest{format: c.DefaultQuery("format", "csv"), columns: defaults}
+        if _, ok := exportFormats[request.format]; !ok {
+                return request, fmt.Errorf("format must be csv, xlsx or jsonl")
+        }
+
+        if value := c.Query("columns"); value != "" {
+                known := map[string]bool{}
+                for _, column := range allowed {
+                        known[column] = true
+                }
+                request.columns = nil
+                seen := map[string]bool{}
+                for _, column := range strings.Split(value, ",") {
+                        column = strings.TrimSpace(column)
+                        if !known[column] {
+                                return request, fmt.Errorf("unknown column %q; columns are %s", column, strings.Join(allowed, ", "))
+                        }
+                        if !seen[column] {
+                                seen[column] = true
+                                request.columns = append(request.columns, column)
+                        }
+                }
+        }
+
+        params, err := parseListParams(c, table, sortable, defaultSort)
+        if err != nil {
+                return request, err
+        }
+        request.order = params.Order
+        return request, nil
+}
+
+// exportWriter writes the rows of an export in one of the formats.
+type exportWriter interface {
+        WriteRow(values []interface{}) error
+        Close() error
+}
+
+func newExportWriter(format string, w io.Writer, name string, columns []string) (exportWriter, error) {
+        switch format {
+        case "xlsx":
+                return xlsx.NewWriter(w, name, columns)
+        case "jsonl":
+                return &jsonlExportWriter{w: w, columns: columns}, nil
+        }
+        writer := &csvExportWriter{csv.NewWriter(w)}
+        return writer, writer.csv.Write(columns)
+}
+
+type csvExportWriter struct {
+        csv *csv.Writer
+}
+
+func (w *csvExportWriter) WriteRow(values []interface{}) error {
+        record := make([]string, len(values))
+        for i, value := range values {
+                text := exportText(value)
+                // Spreadsheets run cells starting with these as formulas
+                if _, ok := value.(string); ok && text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+                        text = "'" + text
+                }
+                record[i] = text
+        }
+        return w.csv.Write(record)
+}
+
+func (w *csvExportWriter) Close() error {
+        w.csv.Flush()
+        return w.csv.Error()
+}
+
+// jsonlExportWriter writes a JSON object per line, with its keys in column
+// order.
+type jsonlExportWriter struct {
+        w       io.Writer
+        columns []string
+}
+
+func (w *jsonlExportWriter) WriteRow(values []interface{}) error {
+        var line strings.Builder
+        line.WriteByte('{')
+        for i, column := range w.columns {
+                if i > 0 {
+                        line.WriteByte(',')
+                }
+                value := values[i]
+                if t, ok := value.(time.Time); ok {
+                        value = t.Format("2006-01-02")
+                }
+                key, _ := json.Marshal(column)
+                data, err := json.Marshal(value)
+                if err != nil {
+                        return err
+                }
+                line.Write(key)
+                line.WriteByte(':')
+                line.Write(data)
+        }
+        line.WriteString("}\n")
+        _, err := io.WriteString(w.w, line.String())
+        return err
+}
+
+func (w *jsonlExportWriter) Close() error {
+        return nil
+}
+
+// exportText formats a value of a shaped record for CSV.
+func exportText(value interface{}) string {
+        switch v := value.(type) {
+        case nil:
+                return ""
+        case string:
+                return v
+        case float64:
+                return strconv.FormatFloat(v, 'f', -1, 64)
+        case bool:
+                return strconv.FormatBool(v)
+        case time.Time:
+                return v.Format("2006-01-02")
+        }
+        data, _ := json.Marshal(value)
+        return string(data)
+}
+
+// exportValue returns column of a shaped record, turning dates into times so
+// each format can write them its own way. Unset dates, and columns the
+// caller may not see, come out empty.
+func exportValue(record map[string]interface{}, column string) interface{} {
+        value := record[column]
+        if s, ok := value.(string); ok && exportDateColumns[column] {
+                if t, err := time.Parse(time.RFC3339, s); err == nil {
+                        if t.IsZero() {
+                                return nil
+                        }
+                        return dateOnly(t)
+                }
+        }
+        return value
+}
+
+// shapeRecord turns a record into a JSON object, as the list endpoints would
+// return it.
+func shapeRecord(record interface{}) map[string]interface{} {
+        var out map[string]interface{}
+        data, _ := json.Marshal(record)
+        json.Unmarshal(data, &out)
+        return out
+}
+
+// streamExport writes every row query matches as name-YYYY-MM-DD.<format>.
+// Rows are read from a cursor and shaped a batch at a time, so only one
+// batch is ever in memory. Once the first bytes are sent the status can't
+// change, so a later failure is logged and ends the file early; an XLSX
+// file cut short won't open.
+func streamExport[T any](c *gin.Context, request exportRequest, name string, query *gorm.DB, shape func(batch []T) []map[string]interface{}) {
+        rows, err := query.Order(request.order).Rows()
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export " + name})
+                return
+        }
+        defer rows.Close()
+
+        format := exportFormats[request.format]
+        filename := fmt.Sprintf("%s-%s.%s", name, time.Now().Format("2006-01-02"), format.extension)
+        c.Header("Content-Type", format.contentType)
+        c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+        c.Status(http.StatusOK)
+
+        writer, err := newExportWriter(request.format, c.Writer, name, request.columns)
+        if err != nil {
+                log.Println("Export failed:", err)
+                return
+        }
+        batch := make([]T, 0, exportBatchSize)
+        flush := func() error {
+                for _, record := range shape(batch) {
+                        values := make([]interface{}, len(request.columns))
+                        for i, column := range request.columns {
+                                values[i] = exportValue(record, column)
+                        }
+                        if err := writer.WriteRow(values); err != nil {
+                                return err
+                        }
+                }
+                batch = batch[:0]
+                return nil
+        }
+
+        for rows.Next() {
+                var row T
+                if err := database.DB.ScanRows(rows, &row); err != nil {
+                        log.Println("Export failed:", err)
+                        return
+                }
+                batch = append(batch, row)
+                if len(batch) == exportBatchSize {
+                        if err := flush(); err != nil {
+                                log.Println("Export failed:", err)
+                                return
+                        }
+                        c.Writer.Flush()
+                }
+        }
+        if err := rows.Err(); err != nil {
+                log.Println("Export failed:", err)
+                return
+        }
+        if err := flush(); err != nil {
+                log.Println("Export failed:", err)
+                return
+        }
+        if err := writer.Close(); err != nil {
+                log.Println("Export failed:", err)
+        }
+}
+
+// departmentNames returns the name of every department by ID.
+func departmentNames() map[uint]string {
+        var departments []models.Department
+        database.DB.Select("id", "name").Find(&departments)
+        names := make(map[uint]string, len(departments))
+        for _, department := range departments {
+                names[department.ID] = department.Name
+        }
+        return names
+}
+
+// exportEmployees returns the directory fields of the employees with the
+// given IDs.
+func exportEmployees(ids []uint) map[uint]models.Employee {
+        var employees []models.Employee
+        database.DB.Scopes(views.PublicEmployee).Where("id IN ?", ids).Find(&employees)
+        byID := make(map[uint]models.Employee, len(employees))
+        for _, employee := range employees {
+                byID[employee.ID] = employee
+        }
+        return byID
+}
+
+// ExportEmployees streams the employees GetEmployees would list, all of them
+// rather than a page, as CSV, XLSX or JSON Lines. Fields the caller may not
+// see are left empty. Custom fields can be added as custom.<key> columns.
+func ExportEmployees(c *gin.Context) {
+        var fields []models.CustomField
+        if err := database.DB.Order("position, id").Find(&fields).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load custom fields"})
+                return
+        }
+        columns := append([]string{}, employeeExportColumns...)
+        for _, field := range fields {
+                columns = append(columns, "custom."+field.Key)
+        }
+
+        request, err := parseExportRequest(c, "employees", employeeSorts, "name", columns, employeeExportDefaults)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        query, err := employeeListQuery(c)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        viewer := viewerFor(c)
+        departments := departmentNames()
+        streamExport(c, request, "employees", query, func(batch []models.Employee) []map[string]interface{} {
+                var managerIDs []uint
+                for _, employee := range batch {
+                        if employee.ManagerID != nil {
+                                managerIDs = append(managerIDs, *employee.ManagerID)
+                        }
+                }
+                managers := exportEmployees(managerIDs)
+
+                out := make([]map[string]interface{}, 0, len(batch))
+                for _, employee := range batch {
+                        record := viewer.Employee(employee)
+                        record["department"] = departments[employee.DepartmentID]
+                        if employee.ManagerID != nil {
+                                if manager, ok := managers[*employee.ManagerID]; ok {
+                                        record["manager_name"] = manager.Name
+                                        record["manager_email"] = manager.Email
+                                }
+                        }
+                        custom, _ := record["custom_fields"].(map[string]interface{})
+                        for _, field := range fields {
+                                value := custom[field.Key]
+                                if s, ok := value.(string); ok && field.Type == models.CustomFieldDate {
+                                        if t, err := time.Parse("2006-01-02", s); err == nil {
+                                                value = t
+                                        }
+                                }
+                                // Spreadsheets get the options in one cell
+                                if options, ok := value.([]interface{}); ok && request.format != "jsonl" {
+                                        texts := make([]string, len(options))
+                                        for i, option := range options {
+                                                texts[i] = exportText(option)
+                                        }
+                                        value = strings.Join(texts, ", ")
+                                }
+                                record["custom."+field.Key] = value
+                        }
+                        out = append(out, record)
+                }
+                return out
+        })
+}
+
+// ExportAttendance streams the attendance GetAttendance would list, with the
+// hours worked on each closed record.
+func ExportAttendance(c *gin.Context) {
+        request, err := parseExportRequest(c, "attendances", attendanceSorts, "-date", attendanceExportColumns, attendanceExportColumns)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        query, err := attendanceListQuery(c)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        departments := departmentNames()
+        streamExport(c, request, "attendance", query, func(batch []models.Attendance) []map[string]interface{} {
+                ids := make([]uint, len(batch))
+                for i, attendance := range batch {
+                        ids[i] = attendance.EmployeeID
+                }
+                employees := exportEmployees(ids)
+
+                out := make([]map[string]interface{}, 0, len(batch))
+                for _, attendance := range batch {
+                        // ScanRows doesn't run hooks
+                        attendance.AfterFind(nil)
+                        record := shapeRecord(attendance)
+                        employee := employees[attendance.EmployeeID]
+                        record["employee_name"] = employee.Name
+                        record["employee_email"] = employee.Email
+                        record["department"] = departments[employee.DepartmentID]
+                        if attendance.ClockOut != nil {
+                                record["hours"] = math.Round(attendance.ClockOut.Sub(attendance.ClockIn).Hours()*100) / 100
+                        }
+                        out = append(out, record)
+                }
+                return out
+        })
+}
+
+// ExportLeaveRequests streams the leave requests GetLeaveRequests would
+// list, with the number of calendar days each covers.
+func ExportLeaveRequests(c *gin.Context) {
+        request, err := parseExportRequest(c, "leave_requests", leaveSorts, "-created_at", leaveExportColumns, leaveExportColumns)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        query, err := leaveListQuery(c)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        departments := departmentNames()
+        streamExport(c, request, "leave", query, func(batch []models.LeaveRequest) []map[string]interface{} {
+                ids := make([]uint, len(batch))
+                for i, leave := range batch {
+                        ids[i] = leave.EmployeeID
+                }
+                employees := exportEmployees(ids)
+
+                out := make([]map[string]interface{}, 0, len(batch))
+                for _, leave := range batch {
+                        record := shapeRecord(leave)
+                        employee := employees[leave.EmployeeID]
+                        record["employee_name"] = employee.Name
+                        record["employee_email"] = employee.Email
+                        record["department"] = departments[employee.DepartmentID]
+                        record["days"] = float64(dateOnly(leave.EndDate).Sub(dateOnly(leave.StartDate)).Hours()/24) + 1
+                        out = append(out, record)
+                }
+                return out
+        })
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+        "context"
+        "database/sql"
+        "database/sql/driver"
+        "io"
+        "strings"
+        "sync"
+        "testing"
+
+        "hcm-backend/database"
+
+        "gorm.io/driver/postgres"
+        "gorm.io/gorm"
+        "gorm.io/gorm/logger"
+)
+
+// fakeDB stands in for Postgres in handler tests. It records the statements
+// it is sent and answers queries from the results given for them; anything
+// else returns no rows. Inserts are given increasing IDs.
+type fakeDB struct {
+        gorm       *gorm.DB
+        mu         sync.Mutex
+        results    []fakeResult
+        statements []string
+        nextID     int64
+        commits    int
+        rollbacks  int
+}
+
+// fakeResult answers queries containing match.
+type fakeResult struct {
+        match   string
+        columns []string
+        rows    [][]driver.Value
+}
+
+// useFakeDB points database.DB at a new fakeDB for the rest of the test.
+func useFakeDB(t *testing.T, results ...fakeResult) *fakeDB {
+        t.Helper()
+        fake := &fakeDB{results: results}
+        db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(fake)}), &gorm.Config{
+                Logger: logger.Discard,
+        })
+        if err != nil {
+                t.Fatal(err)
+        }
+        fake.gorm = db
+        previous := database.DB
+        database.DB = db
+        t.Cleanup(func() { database.DB = previous })
+        return fake
+}
+
+// executed reports whether any statement sent contains s.
+func (f *fakeDB) executed(s string) bool {
+        f.mu.Lock()
+        defer f.mu.Unlock()
+        for _, statement := range f.statements {
+                if strings.Contains(statement, s) {
+                        return true
+                }
+        }
+        return false
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                         { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return fakeTx(c), nil }
+
+func (c fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+        return fakeTx(c), nil
+}
+
+// CheckNamedValue accepts any argument; the fake never looks at them.
+func (c fakeConn) CheckNamedValue(v *driver.NamedValue) error {
+        if valuer, ok := v.Value.(driver.Valuer); ok {
+                value, err := valuer.Value()
+                v.Value = value
+                return err
+        }
+        return nil
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+        c.db.mu.Lock()
+        defer c.db.mu.Unlock()
+        c.db.statements = append(c.db.statements, query)
+        return driver.RowsAffected(1), nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+        c.db.mu.Lock()
+        defer c.db.mu.Unlock()
+        c.db.statements = append(c.db.statements, query)
+
+        if strings.HasPrefix(query, "INSERT") && strings.Contains(query, "RETURNING") {
+                c.db.nextID++
+                return &fakeRows{columns: []string{"id"}, rows: [][]driver.Value{{c.db.nextID}}}, nil
+        }
+        if strings.Contains(query, "count(*)") {
+                return &fakeRows{columns: []string{"count"}, rows: [][]driver.Value{{int64(0)}}}, nil
+        }
+        for _, result := range c.db.results {
+                if strings.Contains(query, result.match) {
+                        return &fakeRows{columns: result.columns, rows: result.rows}, nil
+                }
+        }
+        return &fakeRows{}, nil
+}
+
+type fakeTx fakeConn
+
+func (tx fakeTx) Commit() error {
+        tx.db.mu.Lock()
+        defer tx.db.mu.Unlock()
+        tx.db.commits++
+        return nil
+}
+
+func (tx fakeTx) Rollback() error {
+        tx.db.mu.Lock()
+        defer tx.db.mu.Unlock()
+        tx.db.rollbacks++
+        return nil
+}
+
+type fakeRows struct {
+        columns []string
+        rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+        if len(r.rows) == 0 {
+                return io.EOF
+        }
+        copy(dest, r.rows[0])
+        r.rows = r.rows[1:]
+        return nil
+}
@@ -4,6 +4,7 @@ import (
         "net/http"
 
         "github.com/gin-gonic/gin"
+        "hcm-backend/audit"
         "hcm-backend/database"
         "hcm-backend/models"
 )
@@ -36,7 +37,7 @@ func CreateFeedback(c *gin.Context) {
                 Comment:  input.Comment,
         }
 
-        if err := database.DB.Create(&feedback).Error; err != nil {
+        if err := database.DB.WithContext(audit.Context(c)).Create(&feedback).Error; err != nil {
                 c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save feedback"})
                 return
         }
@@ -44,9 +45,40 @@ func CreateFeedback(c *gin.Context) {
         c.JSON(http.StatusOK, gin.H{"message": "Feedback saved successfully", "feedback": feedback})
 }
 
+var feedbackSorts = map[string]string{
+        "id":         "chat_feedbacks.id",
+        "created_at": "chat_feedbacks.created_at",
+        "rating":     "chat_feedbacks.rating",
+}
+
 func GetAllFeedback(c *gin.Context) {
+        params, err := parseListParams(c, "chat_feedbacks", feedbackSorts, "-created_at")
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        from, to, err := parseDateRange(c)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        query := database.DB.Model(&models.ChatFeedback{})
+        // Feedback has no workflow, so its rating serves as the status filter
+        query = filterValues(c, query, "rating", "chat_feedbacks.rating")
+        if query, err = filterIDs(c, query, "user_id", "chat_feedbacks.user_id"); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        if from != nil {
+                query = query.Where("chat_feedbacks.created_at >= ?", *from)
+        }
+        if to != nil {
+                query = query.Where("chat_feedbacks.created_at < ?", *to)
+        }
+
         var feedbacks []models.ChatFeedback
-        if err := database.DB.Preload("User").Order("created_at DESC").Find(&feedbacks).Error; err != nil {
+        if err := params.find(c, query.Preload("User"), &feedbacks); err != nil {
                 c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch feedback"})
                 return
         }
@@ -73,12 +105,17 @@ func UpdateFeedback(c *gin.Context) {
                 return
         }
 
+        if feedback.UserID == nil || *feedback.UserID != c.GetUint("userID") {
+                c.JSON(http.StatusForbidden, gin.H{"error": "You can only edit your own feedback"})
+                return
+        }
+
         feedback.Rating = input.Rating
         if input.Comment != "" {
                 feedback.Comment = input.Comment
         }
 
-        if err := database.DB.Save(&feedback).Error; err != nil {
+        if err := database.DB.WithContext(audit.Context(c)).Save(&feedback).Error; err != nil {
                 c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update feedback"})
                 return
         }
@@ -0,0 +1,540 @@
+package handlers
+
+import (
+        "bytes"
+        "encoding/csv"
+        "encoding/json"
+        "errors"
+        "fmt"
+        "io"
+        "log"
+        "net/http"
+        "net/mail"
+        "path/filepath"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/fieldcrypt"
+        "hcm-backend/models"
+        "hcm-backend/xlsx"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+        "gorm.io/gorm/clause"
+)
+
+const (
+        maxImportSize = 10 << 20
+        maxImportRows = 5000
+
+        xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// errImportRolledBack ends the import transaction after a dry run or when a
+// row failed, so nothing is written.
+var errImportRolledBack = errors.New("import rolled back")
+
+type importSetter func(e *models.Employee, value string) error
+
+func importText(field func(*models.Employee) *string) importSetter {
+        return func(e *models.Employee, value string) error {
+                *field(e) = value
+                return nil
+        }
+}
+
+func importDate(field func(*models.Employee) **time.Time) importSetter {
+        return func(e *models.Employee, value string) error {
+                date, err := time.Parse("2006-01-02", value)
+                if err != nil {
+                        return errors.New("must be a date in YYYY-MM-DD format")
+                }
+                *field(e) = &date
+                return nil
+        }
+}
+
+// employeeImportColumns are the columns an import sets directly on the
+// employee, named like CreateEmployee's fields. department, manager_email,
+// manager_id, employment_status and send_invite are handled separately.
+var employeeImportColumns = map[string]importSetter{
+        "name":                importText(func(e *models.Employee) *string { return &e.Name }),
+        "email":               importText(func(e *models.Employee) *string { return &e.Email }),
+        "job_title":           importText(func(e *models.Employee) *string { return &e.JobTitle }),
+        "employee_number":     importText(func(e *models.Employee) *string { return &e.EmployeeNumber }),
+        "national_id":         importText(func(e *models.Employee) *string { return &e.NationalID }),
+        "tax_id":              importText(func(e *models.Employee) *string { return &e.TaxID }),
+        "marital_status":      importText(func(e *models.Employee) *string { return &e.MaritalStatus }),
+        "employment_type":     importText(func(e *models.Employee) *string { return &e.EmploymentType }),
+        "job_level":           importText(func(e *models.Employee) *string { return &e.JobLevel }),
+        "work_location":       importText(func(e *models.Employee) *string { return &e.WorkLocation }),
+        "work_arrangement":    importText(func(e *models.Employee) *string { return &e.WorkArrangement }),
+        "timezone": func(e *models.Employee, value string) error {
+                if err := checkTimezone(value, "timezone"); err != nil {
+                        return errors.New("must be an IANA time zone such as Europe/Amsterdam")
+                }
+                e.Timezone = value
+                return nil
+        },
+        "pay_frequency":       importText(func(e *models.Employee) *string { return &e.PayFrequency }),
+        "currency":            importText(func(e *models.Employee) *string { return &e.Currency }),
+        "bank_account":        importText(func(e *models.Employee) *string { return &e.BankAccount }),
+        "benefit_eligibility": importText(func(e *models.Employee) *string { return &e.BenefitEligibility }),
+        "performance_rating":  importText(func(e *models.Employee) *string { return &e.PerformanceRating }),
+        "skills":              importText(func(e *models.Employee) *string { return &e.Skills }),
+        "training_completed":  importText(func(e *models.Employee) *string { return &e.TrainingCompleted }),
+        "career_notes":        importText(func(e *models.Employee) *string { return &e.CareerNotes }),
+        "date_of_birth":       importDate(func(e *models.Employee) **time.Time { return &e.DateOfBirth }),
+        "probation_end_date":  importDate(func(e *models.Employee) **time.Time { return &e.ProbationEndDate }),
+        "hire_date": func(e *models.Employee, value string) error {
+                date, err := time.Parse("2006-01-02", value)
+                if err != nil {
+                        return errors.New("must be a date in YYYY-MM-DD format")
+                }
+                e.HireDate = date
+                return nil
+        },
+        "base_salary": func(e *models.Employee, value string) error {
+                amount, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", ""), 64)
+                if err != nil || amount < 0 {
+                        return errors.New("must be a positive number")
+                }
+                e.BaseSalary = amount
+                return nil
+        },
+}
+
+var employeeImportSpecialColumns = map[string]bool{
+        "department":        true,
+        "department_id":     true,
+        "manager_email":     true,
+        "manager_id":        true,
+        "employment_status": true,
+        "send_invite":       true,
+}
+
+// importError is a problem with one cell or row. Row is the spreadsheet
+// line, so the header is row 1.
+type importError struct {
+        Row   int    `json:"row"`
+        Field string `json:"field,omitempty"`
+        Error string `json:"error"`
+}
+
+// importRow is a data row on its way into the database.
+type importRow struct {
+        line     int
+        values   map[string]string
+        employee models.Employee
+        before   models.Employee
+        created  bool
+        modified bool
+        failed   bool
+}
+
+// readImportFile returns the rows of the uploaded CSV or XLSX file. It is
+// sent as the multipart field "file", or as the request body with a CSV or
+// XLSX content type.
+func readImportFile(c *gin.Context) ([][]string, error) {
+        c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+
+        var data []byte
+        var name, contentType string
+        if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+                header, err := c.FormFile("file")
+                if err != nil {
+                        return nil, errors.New("upload the spreadsheet as the field \"file\"")
+                }
+                file, err := header.Open()
+                if err != nil {
+                        return nil, err
+                }
+                defer file.Close()
+                if data, err = io.ReadAll(file); err != nil {
+                        return nil, err
+                }
+                name, contentType = header.Filename, header.Header.Get("Content-Type")
+        } else {
+                var err error
+                if data, err = io.ReadAll(c.Request.Body); err != nil {
+                        return nil, errors.New("the file is larger than 10 MB")
+                }
+                contentType = c.ContentType()
+        }
+
+        switch {
+        case strings.EqualFold(filepath.Ext(name), ".xlsx") || contentType == xlsxContentType:
+                return xlsx.ReadRows(bytes.NewReader(data), int64(len(data)))
+        case strings.EqualFold(filepath.Ext(name), ".csv") || strings.HasPrefix(contentType, "text/csv") || name != "":
+                reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+                reader.FieldsPerRecord = -1
+                rows, err := reader.ReadAll()
+                if err != nil {
+                        return nil, fmt.Errorf("invalid CSV: %v", err)
+                }
+                return rows, nil
+        }
+        return nil, errors.New("send a CSV or XLSX file")
+}
+
+// ImportEmployees creates and updates employees from a CSV or XLSX file
+// whose header row names CreateEmployee's fields. Rows match existing
+// employees by employee_number, then by email; matches are updated and the
+// rest created. department and manager_email may be given instead of the
+// IDs. The whole file is applied in one transaction, and only if every row
+// is valid. With dry_run=true nothing is written and the response lists what
+// would happen.
+func ImportEmployees(c *gin.Context) {
+        rows, err := readImportFile(c)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        dryRun := c.Query("dry_run") == "true" || c.PostForm("dry_run") == "true"
+
+        // Drop blank lines, keeping the line numbers of the rest
+        type line struct {
+                number int
+                cells  []string
+        }
+        var lines []line
+        for i, cells := range rows {
+                for _, cell := range cells {
+                        if strings.TrimSpace(cell) != "" {
+                                lines = append(lines, line{i + 1, cells})
+                                break
+                        }
+                }
+        }
+        if len(lines) < 2 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "The file needs a header row and at least one employee"})
+                return
+        }
+        if len(lines)-1 > maxImportRows {
+                c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Import at most %d employees at a time", maxImportRows)})
+                return
+        }
+
+        columns := make([]string, len(lines[0].cells))
+        seen := map[string]bool{}
+        var unknown []string
+        for i, cell := range lines[0].cells {
+                column := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(cell)), " ", "_")
+                if column == "" {
+                        continue
+                }
+                if employeeImportColumns[column] == nil && !employeeImportSpecialColumns[column] {
+                        unknown = append(unknown, cell)
+                        continue
+                }
+                if seen[column] {
+                        c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + column + " appears twice"})
+                        return
+                }
+                seen[column] = true
+                columns[i] = column
+        }
+        if len(unknown) > 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown columns: " + strings.Join(unknown, ", ")})
+                return
+        }
+        if !seen["name"] || !seen["email"] {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "The name and email columns are required"})
+                return
+        }
+        if seen["department"] && seen["department_id"] || seen["manager_email"] && seen["manager_id"] {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Give departments and managers by name or email, or by ID, not both"})
+                return
+        }
+
+        importRows := make([]*importRow, 0, len(lines)-1)
+        for _, l := range lines[1:] {
+                row := &importRow{line: l.number, values: map[string]string{}}
+                for i, column := range columns {
+                        if column != "" && i < len(l.cells) {
+                                row.values[column] = strings.TrimSpace(l.cells[i])
+                        }
+                }
+                importRows = append(importRows, row)
+        }
+
+        var importErrors []importError
+        var created, updated int
+        err = database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                importErrors = importEmployeeRows(tx, importRows, actorUserID(c))
+                sort.SliceStable(importErrors, func(i, j int) bool {
+                        return importErrors[i].Row < importErrors[j].Row
+                })
+                for _, row := range importRows {
+                        switch {
+                        case row.failed:
+                        case row.created:
+                                created++
+                        case row.modified:
+                                updated++
+                        }
+                }
+                if dryRun || len(importErrors) > 0 {
+                        return errImportRolledBack
+                }
+                return nil
+        })
+        if err != nil && err != errImportRolledBack {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import employees"})
+                return
+        }
+
+        status := http.StatusOK
+        if len(importErrors) > 0 && !dryRun {
+                status = http.StatusUnprocessableEntity
+        }
+        if importErrors == nil {
+                importErrors = []importError{}
+        }
+        if status == http.StatusOK && !dryRun {
+                for _, row := range importRows {
+                        if row.created && row.values["send_invite"] == "true" {
+                                if _, err := inviteEmployee(c, row.employee, models.RoleEmployee); err != nil {
+                                        log.Println("Failed to invite imported employee:", err)
+                                }
+                        }
+                }
+        }
+        c.JSON(status, gin.H{
+                "dry_run":   dryRun,
+                "committed": status == http.StatusOK && !dryRun,
+                "rows":      len(importRows),
+                "created":   created,
+                "updated":   updated,
+                "unchanged": len(importRows) - created - updated - countFailed(importRows),
+                "errors":    importErrors,
+        })
+}
+
+func countFailed(rows []*importRow) int {
+        failed := 0
+        for _, row := range rows {
+                if row.failed {
+                        failed++
+                }
+        }
+        return failed
+}
+
+// unchanged reports whether the row leaves an existing employee as it was.
+func (row *importRow) unchanged() bool {
+        before, _ := json.Marshal(row.before)
+        after, _ := json.Marshal(row.employee)
+        return !row.created && bytes.Equal(before, after)
+}
+
+// importEmployeeRows validates and saves rows in tx, returning what was wrong
+// with them. Managers are set once every row is saved, so a file can hire a
+// team along with its manager.
+func importEmployeeRows(tx *gorm.DB, rows []*importRow, actor *uint) []importError {
+        var errs []importError
+        fail := func(row *importRow, field, message string) {
+                row.failed = true
+                errs = append(errs, importError{Row: row.line, Field: field, Error: message})
+        }
+
+        var departments []models.Department
+        tx.Select("id", "name").Find(&departments)
+        departmentIDs := map[string]uint{}
+        departmentExists := map[uint]bool{}
+        for _, d := range departments {
+                departmentIDs[strings.ToLower(d.Name)] = d.ID
+                departmentExists[d.ID] = true
+        }
+
+        var existing []models.Employee
+        tx.Unscoped().Select("id", "email", "employee_number", "deleted_at").Find(&existing)
+        byEmail := map[string]models.Employee{}
+        byNumber := map[string]models.Employee{}
+        for _, e := range existing {
+                byEmail[strings.ToLower(e.Email)] = e
+                if e.EmployeeNumber != "" {
+                        byNumber[e.EmployeeNumber] = e
+                }
+        }
+
+        fileEmails := map[string]int{}
+        fileNumbers := map[string]int{}
+        fileIdentifiers := map[string]int{}
+        for _, row := range rows {
+                email := strings.ToLower(row.values["email"])
+                number := row.values["employee_number"]
+                if first, ok := fileEmails[email]; ok && email != "" {
+                        fail(row, "email", fmt.Sprintf("Same email as row %d", first))
+                        continue
+                }
+                fileEmails[email] = row.line
+                if first, ok := fileNumbers[number]; ok && number != "" {
+                        fail(row, "employee_number", fmt.Sprintf("Same employee number as row %d", first))
+                        continue
+                }
+                fileNumbers[number] = row.line
+
+                // Find the employee the row is about
+                match, matchedByNumber := byNumber[number]
+                if number == "" || !matchedByNumber {
+                        match = byEmail[email]
+                } else if other, ok := byEmail[email]; ok && email != "" && other.ID != match.ID {
+                        fail(row, "email", fmt.Sprintf("Employee number %s belongs to another employee than this email", number))
+                        continue
+                }
+                if match.ID != 0 && match.DeletedAt.Valid {
+                        fail(row, "email", "This employee was deleted")
+                        continue
+                }
+
+                if match.ID != 0 {
+                        if err := tx.First(&row.employee, match.ID).Error; err != nil {
+                                fail(row, "", "Failed to load the employee")
+                                continue
+                        }
+                        row.before = row.employee
+                } else {
+                        row.created = true
+                }
+
+                // Empty cells leave existing employees' fields alone
+                for column, value := range row.values {
+                        if setter := employeeImportColumns[column]; setter != nil && value != "" {
+                                if err := setter(&row.employee, value); err != nil {
+                                        fail(row, column, err.Error())
+                                }
+                        }
+                }
+                if row.employee.Name == "" {
+                        fail(row, "name", "Name is required")
+                }
+                if address, err := mail.ParseAddress(row.employee.Email); err != nil || address.Address != row.employee.Email {
+                        fail(row, "email", "Must be a valid email address")
+                }
+
+                status := row.values["employment_status"]
+                if row.created {
+                        switch status {
+                        case "", models.EmploymentStatusPending, models.EmploymentStatusProbation, models.EmploymentStatusActive:
+                                row.employee.EmploymentStatus = status
+                        default:
+                                fail(row, "employment_status", "New employees must be pending, probation or active")
+                        }
+                } else if status != "" && status != row.employee.EmploymentStatus {
+                        fail(row, "employment_status", "Use the lifecycle actions to change employment status")
+                }
+                if invite := row.values["send_invite"]; invite != "" && invite != "true" && invite != "false" {
+                        fail(row, "send_invite", "Must be true or false")
+                }
+
+                if name := row.values["department"]; name != "" {
+                        if id, ok := departmentIDs[strings.ToLower(name)]; ok {
+                                row.employee.DepartmentID = id
+                        } else {
+                                fail(row, "department", "No department named "+name)
+                        }
+                }
+                if value := row.values["department_id"]; value != "" {
+                        id, err := strconv.ParseUint(value, 10, 64)
+                        if err != nil || !departmentExists[uint(id)] {
+                                fail(row, "department_id", "Department not found")
+                        } else {
+                                row.employee.DepartmentID = uint(id)
+                        }
+                }
+
+                for _, check := range []struct{ field, value string }{
+                        {"national_id", row.employee.NationalID},
+                        {"tax_id", row.employee.TaxID},
+                } {
+                        hash := fieldcrypt.BlindIndex(check.value)
+                        if hash == "" {
+                                continue
+                        }
+                        if first, ok := fileIdentifiers[check.field+hash]; ok {
+                                fail(row, check.field, fmt.Sprintf("Same %s as row %d", strings.ReplaceAll(check.field, "_", " "), first))
+                        }
+                        fileIdentifiers[check.field+hash] = row.line
+                }
+                if label := identifierConflict(tx, row.employee); label != "" {
+                        fail(row, strings.ReplaceAll(strings.ToLower(label), " ", "_"), "Another employee already has this "+label)
+                }
+                if row.failed || row.unchanged() {
+                        continue
+                }
+
+                row.modified = !row.created
+                if err := tx.Omit(clause.Associations).Save(&row.employee).Error; err != nil {
+                        fail(row, "", "Failed to save the employee")
+                        return errs
+                }
+                byEmail[strings.ToLower(row.employee.Email)] = row.employee
+        }
+
+        // Managers, now that everyone in the file has an ID
+        var links []models.Employee
+        tx.Select("id", "manager_id").Find(&links)
+        managers := make(map[uint]*uint, len(links))
+        for _, link := range links {
+                managers[link.ID] = link.ManagerID
+        }
+        for _, row := range rows {
+                if row.failed {
+                        continue
+                }
+                field, value := "manager_email", row.values["manager_email"]
+                var managerID uint
+                if value != "" {
+                        manager, ok := byEmail[strings.ToLower(value)]
+                        if !ok || manager.DeletedAt.Valid {
+                                fail(row, field, "No employee with the email "+value)
+                                continue
+                        }
+                        managerID = manager.ID
+                } else if value = row.values["manager_id"]; value != "" {
+                        field = "manager_id"
+                        id, err := strconv.ParseUint(value, 10, 64)
+                        if _, ok := managers[uint(id)]; err != nil || !ok {
+                                fail(row, field, errManagerNotFound.Error())
+                                continue
+                        }
+                        managerID = uint(id)
+                } else {
+                        continue
+                }
+
+                switch {
+                case managerID == row.employee.ID:
+                        fail(row, field, errOwnManager.Error())
+                case createsCycle(managers, row.employee.ID, managerID):
+                        fail(row, field, errManagerLoop.Error())
+                default:
+                        managers[row.employee.ID] = &managerID
+                        if sameManager(row.employee.ManagerID, &managerID) {
+                                continue
+                        }
+                        row.employee.ManagerID = &managerID
+                        row.modified = !row.created
+                        if err := tx.Model(&row.employee).Update("manager_id", managerID).Error; err != nil {
+                                fail(row, field, "Failed to save the manager")
+                                return errs
+                        }
+                }
+        }
+
+        for _, row := range rows {
+                if row.failed {
+                        continue
+                }
+                if err := recordJobChange(tx, row.before, row.employee, "Imported", actor); err != nil {
+                        fail(row, "", "Failed to record job history")
+                        return errs
+                }
+        }
+        return errs
+}
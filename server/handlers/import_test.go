@@ -0,0 +1,140 @@
+package handlers
+
+import (
+        "database/sql/driver"
+        "encoding/json"
+        "net/http"
+        "net/http/httptest"
+        "reflect"
+        "strings"
+        "testing"
+
+        "github.com/gin-gonic/gin"
+)
+
+// importResult is the body ImportEmployees responds with.
+type importResult struct {
+        DryRun    bool          `json:"dry_run"`
+        Committed bool          `json:"committed"`
+        Rows      int           `json:"rows"`
+        Created   int           `json:"created"`
+        Updated   int           `json:"updated"`
+        Errors    []importError `json:"errors"`
+}
+
+func postImport(t *testing.T, csv, query string) (int, importResult) {
+        t.Helper()
+        gin.SetMode(gin.TestMode)
+        w := httptest.NewRecorder()
+        c, _ := gin.CreateTestContext(w)
+        c.Request = httptest.NewRequest(http.MethodPost, "/api/employees/import"+query, strings.NewReader(csv))
+        c.Request.Header.Set("Content-Type", "text/csv")
+
+        ImportEmployees(c)
+
+        var result importResult
+        if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+                t.Fatalf("response %d %s: %v", w.Code, w.Body, err)
+        }
+        return w.Code, result
+}
+
+var engineering = fakeResult{
+        match:   `FROM "departments"`,
+        columns: []string{"id", "name"},
+        rows:    [][]driver.Value{{int64(1), "Engineering"}},
+}
+
+func TestImportEmployees(t *testing.T) {
+        fake := useFakeDB(t, engineering)
+
+        status, result := postImport(t, "Name,Email,Department\n"+
+                "Ada Lovelace,ada@example.com,engineering\n"+
+                ",,\n"+
+                "Grace Hopper,grace@example.com,\n", "")
+
+        if status != http.StatusOK || !result.Committed || result.Rows != 2 || result.Created != 2 || len(result.Errors) != 0 {
+                t.Errorf("import = %d %+v, want both rows created", status, result)
+        }
+        if !fake.executed(`INSERT INTO "employees"`) || fake.commits != 1 || fake.rollbacks != 0 {
+                t.Errorf("import committed %d times and rolled back %d, want one commit", fake.commits, fake.rollbacks)
+        }
+}
+
+func TestImportDryRun(t *testing.T) {
+        fake := useFakeDB(t, engineering)
+
+        status, result := postImport(t, "name,email,department\nAda Lovelace,ada@example.com,Engineering\n", "?dry_run=true")
+
+        if status != http.StatusOK || !result.DryRun || result.Committed || result.Created != 1 {
+                t.Errorf("dry run = %d %+v, want one row that would be created", status, result)
+        }
+        if fake.commits != 0 || fake.rollbacks != 1 {
+                t.Errorf("dry run committed %d times and rolled back %d, want it rolled back", fake.commits, fake.rollbacks)
+        }
+}
+
+func TestImportRowErrors(t *testing.T) {
+        tests := []struct {
+                name string
+                csv  string
+                want []importError
+        }{
+                {"duplicate email",
+                        "name,email\nAda,ada@example.com\nGrace,grace@example.com\nAda again,ADA@example.com\n",
+                        []importError{{Row: 4, Field: "email", Error: "Same email as row 2"}}},
+                {"duplicate employee number",
+                        "name,email,employee_number\nAda,ada@example.com,E1\nGrace,grace@example.com,E1\n",
+                        []importError{{Row: 3, Field: "employee_number", Error: "Same employee number as row 2"}}},
+                {"unknown department",
+                        "name,email,department\nAda,ada@example.com,Engineering\nGrace,grace@example.com,Sales\n",
+                        []importError{{Row: 3, Field: "department", Error: "No department named Sales"}}},
+                {"unknown department ID",
+                        "name,email,department_id\nAda,ada@example.com,7\n",
+                        []importError{{Row: 2, Field: "department_id", Error: "Department not found"}}},
+                {"invalid cells",
+                        "name,email,hire_date,employment_status\n,not an email,02/03/2026,terminated\n",
+                        []importError{
+                                {Row: 2, Field: "hire_date", Error: "must be a date in YYYY-MM-DD format"},
+                                {Row: 2, Field: "name", Error: "Name is required"},
+                                {Row: 2, Field: "email", Error: "Must be a valid email address"},
+                                {Row: 2, Field: "employment_status", Error: "New employees must be pending, probation or active"},
+                        }},
+                {"unknown manager",
+                        "name,email,manager_email\nAda,ada@example.com,boss@example.com\n",
+                        []importError{{Row: 2, Field: "manager_email", Error: "No employee with the email boss@example.com"}}},
+        }
+        for _, tt := range tests {
+                fake := useFakeDB(t, engineering)
+                status, result := postImport(t, tt.csv, "")
+                if status != http.StatusUnprocessableEntity || result.Committed {
+                        t.Errorf("%s: status %d, committed %v, want 422 and nothing committed", tt.name, status, result.Committed)
+                }
+                if !reflect.DeepEqual(result.Errors, tt.want) {
+                        t.Errorf("%s: errors = %+v\nwant %+v", tt.name, result.Errors, tt.want)
+                }
+                // One bad row stops the whole file
+                if fake.commits != 0 || fake.rollbacks != 1 {
+                        t.Errorf("%s: committed %d times and rolled back %d, want it rolled back", tt.name, fake.commits, fake.rollbacks)
+                }
+        }
+}
+
+func TestImportFileErrors(t *testing.T) {
+        tests := map[string]string{
+                "no rows":          "name,email\n",
+                "unknown column":   "name,email,shoe_size\nAda,ada@example.com,9\n",
+                "repeated column":  "name,email,Email\nAda,ada@example.com,ada@example.com\n",
+                "no email column":  "name\nAda\n",
+                "department twice": "name,email,department,department_id\nAda,ada@example.com,Engineering,1\n",
+        }
+        for name, csv := range tests {
+                fake := useFakeDB(t)
+                if status, _ := postImport(t, csv, ""); status != http.StatusBadRequest {
+                        t.Errorf("%s: status %d, want 400", name, status)
+                }
+                if len(fake.statements) != 0 {
+                        t.Errorf("%s: ran %v, want nothing sent to the database", name, fake.statements)
+                }
+        }
+}
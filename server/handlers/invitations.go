@@ -0,0 +1,259 @@
+package handlers
+
+import (
+        "errors"
+        "fmt"
+        "log"
+        "net/http"
+        "os"
+        "time"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/mailer"
+        "hcm-backend/middleware"
+        "hcm-backend/models"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+// openSignupEnabled reports whether anyone may create an account through
+// POST /api/auth/signup. Set ALLOW_OPEN_SIGNUP=false to require invitations.
+func openSignupEnabled() bool {
+        return os.Getenv("ALLOW_OPEN_SIGNUP") != "false"
+}
+
+// inviteEmployee issues an invitation for an employee who has no account yet
+// and emails it to them. Earlier pending invitations for the same employee are
+// withdrawn so only the newest link works.
+func inviteEmployee(c *gin.Context, employee models.Employee, role string) (models.Invitation, error) {
+        if employee.UserID != nil {
+                return models.Invitation{}, fmt.Errorf("employee already has a user account")
+        }
+
+        token, err := generateOpaqueToken()
+        if err != nil {
+                return models.Invitation{}, err
+        }
+
+        database.DB.WithContext(audit.Context(c)).Where("employee_id = ? AND accepted_at IS NULL", employee.ID).Delete(&models.Invitation{})
+
+        var invitedByID *uint
+        if uid, exists := c.Get("userID"); exists {
+                id := uid.(uint)
+                invitedByID = &id
+        }
+
+        invitation := models.Invitation{
+                EmployeeID:  employee.ID,
+                Email:       employee.Email,
+                Role:        role,
+                TokenHash:   hashToken(token),
+                ExpiresAt:   time.Now().Add(invitationTTL),
+                InvitedByID: invitedByID,
+        }
+        if err := database.DB.WithContext(audit.Context(c)).Create(&invitation).Error; err != nil {
+                return models.Invitation{}, err
+        }
+
+        if err := mailer.Default.Send(mailer.Message{
+                To:      employee.Email,
+                Subject: "You're invited to the HR portal",
+                Body: fmt.Sprintf("Hi %s,\n\nAn account has been set up for you. Choose a username and password here:\n\n%s/accept-invite?token=%s\n\nThis link expires in 7 days.\n",
+                        employee.Name, appBaseURL(), token),
+        }); err != nil {
+                log.Println("Failed to send invitation email:", err)
+        }
+
+        return invitation, nil
+}
+
+// findPendingInvitation looks up an unexpired, unaccepted invitation by token.
+func findPendingInvitation(token string) (models.Invitation, error) {
+        var invitation models.Invitation
+        if err := database.DB.Preload("Employee").Where("token_hash = ?", hashToken(token)).First(&invitation).Error; err != nil {
+                return invitation, fmt.Errorf("invalid invitation")
+        }
+        if invitation.AcceptedAt != nil || time.Now().After(invitation.ExpiresAt) || invitation.Employee == nil {
+                return invitation, fmt.Errorf("invalid invitation")
+        }
+        return invitation, nil
+}
+
+// linkEmployeeByEmail attaches a user to the employee record with the same
+// email, if that record isn't linked yet. Only call it once the user has
+// proven they own the address.
+func linkEmployeeByEmail(c *gin.Context, user models.User) {
+        database.DB.WithContext(audit.Context(c)).Model(&models.Employee{}).
+                Where("email = ? AND user_id IS NULL", user.Email).
+                Update("user_id", user.ID)
+}
+
+func InviteEmployee(c *gin.Context) {
+        id := c.Param("id")
+
+        var input struct {
+                Role string `json:"role"`
+        }
+        // The body is optional
+        c.ShouldBindJSON(&input)
+
+        if input.Role == "" {
+                input.Role = models.RoleEmployee
+        }
+        if !models.IsValidRole(input.Role) {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+                return
+        }
+        if input.Role != models.RoleEmployee && !middleware.HasPermission(c, models.PermUsersManage) {
+                c.JSON(http.StatusForbidden, gin.H{"error": "Only user administrators can invite with an elevated role"})
+                return
+        }
+
+        var employee models.Employee
+        if err := database.DB.First(&employee, id).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+                return
+        }
+
+        invitation, err := inviteEmployee(c, employee, input.Role)
+        if err != nil {
+                c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+                return
+        }
+
+        c.JSON(http.StatusCreated, gin.H{"message": "Invitation sent", "invitation": invitation})
+}
+
+func GetInvitations(c *gin.Context) {
+        query := database.DB.Preload("Employee")
+        if c.Query("status") != "all" {
+                query = query.Where("accepted_at IS NULL AND expires_at > ?", time.Now())
+        }
+
+        var invitations []models.Invitation
+        if err := query.Order("created_at desc").Find(&invitations).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invitations"})
+                return
+        }
+
+        c.JSON(http.StatusOK, invitations)
+}
+
+func RevokeInvitation(c *gin.Context) {
+        id := c.Param("id")
+
+        result := database.DB.WithContext(audit.Context(c)).Where("id = ? AND accepted_at IS NULL", id).Delete(&models.Invitation{})
+        if result.Error != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+                return
+        }
+        if result.RowsAffected == 0 {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Pending invitation not found"})
+                return
+        }
+
+        c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+}
+
+// GetInvitation lets the accept page show who the invitation is for before
+// the user picks a username.
+func GetInvitation(c *gin.Context) {
+        invitation, err := findPendingInvitation(c.Param("token"))
+        if err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Invitation is invalid or has expired"})
+                return
+        }
+
+        c.JSON(http.StatusOK, gin.H{
+                "name":       invitation.Employee.Name,
+                "email":      invitation.Email,
+                "expires_at": invitation.ExpiresAt,
+        })
+}
+
+func AcceptInvitation(c *gin.Context) {
+        var input struct {
+                Token    string `json:"token" binding:"required"`
+                Username string `json:"username" binding:"required"`
+                Password string `json:"password" binding:"required,min=6"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        invitation, err := findPendingInvitation(input.Token)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Invitation is invalid or has expired"})
+                return
+        }
+
+        var existingUser models.User
+        if err := database.DB.Where("username = ?", input.Username).First(&existingUser).Error; err == nil {
+                c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+                return
+        }
+        if err := database.DB.Where("email = ?", invitation.Email).First(&existingUser).Error; err == nil {
+                c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists"})
+                return
+        }
+
+        hashedPassword, err := hashPassword(input.Password)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+                return
+        }
+
+        // Receiving the invitation email proves ownership of the address
+        now := time.Now()
+        user := models.User{
+                Username:        input.Username,
+                Email:           invitation.Email,
+                Password:        hashedPassword,
+                Role:            invitation.Role,
+                EmailVerifiedAt: &now,
+        }
+
+        errAlreadyUsed := errors.New("invitation already used")
+        err = database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                result := tx.Model(&models.Invitation{}).
+                        Where("id = ? AND accepted_at IS NULL", invitation.ID).
+                        Update("accepted_at", now)
+                if result.Error != nil {
+                        return result.Error
+                }
+                if result.RowsAffected != 1 {
+                        return errAlreadyUsed
+                }
+
+                if err := tx.Create(&user).Error; err != nil {
+                        return err
+                }
+
+                result = tx.Model(&models.Employee{}).
+                        Where("id = ? AND user_id IS NULL", invitation.EmployeeID).
+                        Update("user_id", user.ID)
+                if result.Error != nil {
+                        return result.Error
+                }
+                if result.RowsAffected != 1 {
+                        return errAlreadyUsed
+                }
+                return nil
+        })
+        if errors.Is(err, errAlreadyUsed) {
+                c.JSON(http.StatusConflict, gin.H{"error": "Invitation has already been used"})
+                return
+        }
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+                return
+        }
+
+        respondWithSession(c, http.StatusCreated, user)
+}
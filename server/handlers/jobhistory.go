@@ -0,0 +1,491 @@
+package handlers
+
+import (
+        "context"
+        "encoding/json"
+        "errors"
+        "log"
+        "net/http"
+        "os"
+        "slices"
+        "strings"
+        "time"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/models"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+)
+
+// defaultJobSchedulerInterval is how often scheduled job changes are looked
+// for when JOB_SCHEDULER_INTERVAL isn't set.
+const defaultJobSchedulerInterval = time.Hour
+
+var errDepartmentNotFound = errors.New("Department not found")
+
+// dateOnly returns the calendar day of t as midnight UTC, the form dates are
+// stored in.
+func dateOnly(t time.Time) time.Time {
+        return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// actorUserID is the signed-in user behind the request, or nil for API keys.
+func actorUserID(c *gin.Context) *uint {
+        if userID := c.GetUint("userID"); userID != 0 {
+                return &userID
+        }
+        return nil
+}
+
+func sameManager(a, b *uint) bool {
+        if a == nil || b == nil {
+                return a == b
+        }
+        return *a == *b
+}
+
+// jobChanges lists the job fields, by JSON name, that differ between before
+// and after.
+func jobChanges(before, after models.Employee) []string {
+        var changed []string
+        if before.JobTitle != after.JobTitle {
+                changed = append(changed, "job_title")
+        }
+        if before.DepartmentID != after.DepartmentID {
+                changed = append(changed, "department_id")
+        }
+        if !sameManager(before.ManagerID, after.ManagerID) {
+                changed = append(changed, "manager_id")
+        }
+        if before.JobLevel != after.JobLevel {
+                changed = append(changed, "job_level")
+        }
+        if before.BaseSalary != after.BaseSalary {
+                changed = append(changed, "base_salary")
+        }
+        return changed
+}
+
+// recordJobChange writes a job record effective today if after's job differs
+// from before. before.ID is 0 for a new employee, whose first record starts
+// on their hire date, or today if that is still ahead.
+func recordJobChange(tx *gorm.DB, before, after models.Employee, reason string, actor *uint) error {
+        today := dateOnly(time.Now())
+        record := models.JobRecordFor(after, today)
+        if before.ID == 0 {
+                if hired := dateOnly(after.HireDate); after.HireDate.Year() > 1 && hired.Before(today) {
+                        record.EffectiveDate = hired
+                }
+        } else if record.ChangedFields = jobChanges(before, after); len(record.ChangedFields) == 0 {
+                return nil
+        }
+
+        now := time.Now()
+        record.Reason = reason
+        record.ActorUserID = actor
+        record.AppliedAt = &now
+        return tx.Create(&record).Error
+}
+
+// moveEmployees sets a job column on every employee query matches, adding
+// the change to each one's job history, and returns how many were moved.
+func moveEmployees(tx *gorm.DB, query *gorm.DB, column string, value interface{}, reason string, actor *uint) (int64, error) {
+        var employees []models.Employee
+        if err := query.Find(&employees).Error; err != nil {
+                return 0, err
+        }
+        for _, before := range employees {
+                // Update writes the new value into the model it is given
+                after := before
+                if err := tx.Model(&after).Update(column, value).Error; err != nil {
+                        return 0, err
+                }
+                if err := recordJobChange(tx, before, after, reason, actor); err != nil {
+                        return 0, err
+                }
+        }
+        return int64(len(employees)), nil
+}
+
+// checkJobRecord verifies that the department and manager record changes to
+// still exist and that the manager doesn't close a reporting loop.
+func checkJobRecord(tx *gorm.DB, record models.JobRecord) error {
+        if slices.Contains(record.ChangedFields, "department_id") && record.DepartmentID != 0 {
+                var count int64
+                tx.Model(&models.Department{}).Where("id = ?", record.DepartmentID).Count(&count)
+                if count == 0 {
+                        return errDepartmentNotFound
+                }
+        }
+        if slices.Contains(record.ChangedFields, "manager_id") {
+                return checkManager(tx, record.EmployeeID, record.ManagerID)
+        }
+        return nil
+}
+
+// jobErrorStatus is the response status for an error from checkJobRecord.
+func jobErrorStatus(err error) int {
+        if err == errDepartmentNotFound {
+                return http.StatusBadRequest
+        }
+        return managerErrorStatus(err)
+}
+
+// applyJobRecord copies a scheduled record's changed fields onto its
+// employee and marks it applied, refreshing the rest of its snapshot from the
+// employee. It reports false if the record had already been applied, so
+// schedulers running side by side never apply one twice.
+func applyJobRecord(tx *gorm.DB, record *models.JobRecord) (bool, error) {
+        now := time.Now()
+        claimed := tx.Model(&models.JobRecord{}).Where("id = ? AND applied_at IS NULL", record.ID).Update("applied_at", now)
+        if claimed.Error != nil || claimed.RowsAffected == 0 {
+                return false, claimed.Error
+        }
+        if err := checkJobRecord(tx, *record); err != nil {
+                return false, err
+        }
+
+        var employee models.Employee
+        if err := tx.First(&employee, record.EmployeeID).Error; err != nil {
+                return false, err
+        }
+        updates := map[string]interface{}{}
+        for _, field := range record.ChangedFields {
+                switch field {
+                case "job_title":
+                        employee.JobTitle = record.JobTitle
+                        updates[field] = record.JobTitle
+                case "department_id":
+                        employee.DepartmentID = record.DepartmentID
+                        updates[field] = record.DepartmentID
+                case "manager_id":
+                        employee.ManagerID = record.ManagerID
+                        updates[field] = record.ManagerID
+                case "job_level":
+                        employee.JobLevel = record.JobLevel
+                        updates[field] = record.JobLevel
+                case "base_salary":
+                        employee.BaseSalary = record.BaseSalary
+                        updates[field] = record.BaseSalary
+                }
+        }
+        if err := tx.Model(&employee).Updates(updates).Error; err != nil {
+                return false, err
+        }
+
+        snapshot := models.JobRecordFor(employee, record.EffectiveDate)
+        record.JobTitle = snapshot.JobTitle
+        record.DepartmentID = snapshot.DepartmentID
+        record.ManagerID = snapshot.ManagerID
+        record.JobLevel = snapshot.JobLevel
+        record.BaseSalary = snapshot.BaseSalary
+        record.AppliedAt = &now
+        err := tx.Model(record).
+                Select("job_title", "department_id", "manager_id", "job_level", "base_salary", "applied_at").
+                Updates(record).Error
+        return err == nil, err
+}
+
+// ApplyDueJobRecords applies every scheduled job record whose day has come,
+// oldest first, and returns how many it applied. A record that can no longer
+// be applied, say because its manager would now close a reporting loop, is
+// logged and left scheduled until it is fixed or cancelled.
+func ApplyDueJobRecords(ctx context.Context) (int, error) {
+        var due []models.JobRecord
+        tomorrow := dateOnly(time.Now()).AddDate(0, 0, 1)
+        if err := database.DB.Where("applied_at IS NULL AND effective_date < ?", tomorrow).
+                Order("effective_date, id").Find(&due).Error; err != nil {
+                return 0, err
+        }
+
+        applied := 0
+        for i := range due {
+                var ok bool
+                err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+                        var err error
+                        ok, err = applyJobRecord(tx, &due[i])
+                        return err
+                })
+                if err != nil {
+                        log.Printf("Failed to apply job change %d for employee %d: %v", due[i].ID, due[i].EmployeeID, err)
+                        continue
+                }
+                if ok {
+                        applied++
+                }
+        }
+        return applied, nil
+}
+
+// StartJobScheduler applies scheduled job changes and terminations in the
+// background, once at startup and then every JOB_SCHEDULER_INTERVAL, a
+// duration such as "15m" that defaults to an hour.
+func StartJobScheduler() {
+        interval := defaultJobSchedulerInterval
+        if raw := os.Getenv("JOB_SCHEDULER_INTERVAL"); raw != "" {
+                parsed, err := time.ParseDuration(raw)
+                if err != nil || parsed <= 0 {
+                        log.Println("Invalid JOB_SCHEDULER_INTERVAL, using", interval)
+                } else {
+                        interval = parsed
+                }
+        }
+
+        ctx := audit.WithActor(context.Background(), audit.Actor{Source: audit.SourceSystem})
+        run := func() {
+                applied, err := ApplyDueJobRecords(ctx)
+                if err != nil {
+                        log.Println("Failed to load scheduled job changes:", err)
+                } else if applied > 0 {
+                        log.Printf("Applied %d scheduled job changes", applied)
+                }
+                terminated, err := ApplyDueTerminations(ctx)
+                if err != nil {
+                        log.Println("Failed to load scheduled terminations:", err)
+                } else if terminated > 0 {
+                        log.Printf("Applied %d scheduled terminations", terminated)
+                }
+        }
+        go func() {
+                run()
+                for range time.Tick(interval) {
+                        run()
+                }
+        }()
+}
+
+// parseAsOf reads the as_of query parameter (YYYY-MM-DD). Only days up to
+// today can be asked for, since scheduled changes may still be cancelled.
+func parseAsOf(c *gin.Context) (*time.Time, error) {
+        value := c.Query("as_of")
+        if value == "" {
+                return nil, nil
+        }
+        day, err := time.Parse("2006-01-02", value)
+        if err != nil {
+                return nil, errors.New("as_of must be a date in YYYY-MM-DD format")
+        }
+        if day.After(dateOnly(time.Now())) {
+                return nil, errors.New("as_of cannot be in the future")
+        }
+        return &day, nil
+}
+
+// jobRecordsAsOf returns the job record in effect on day for everyone who
+// worked there that day. Whether someone had left is read from their
+// lifecycle events; for people without any, from their current status and
+// last working day.
+func jobRecordsAsOf(tx *gorm.DB, day time.Time) (map[uint]models.JobRecord, error) {
+        next := day.AddDate(0, 0, 1)
+
+        var records []models.JobRecord
+        if err := tx.Where("applied_at IS NOT NULL AND effective_date < ?", next).
+                Order("effective_date, id").Find(&records).Error; err != nil {
+                return nil, err
+        }
+        inEffect := map[uint]models.JobRecord{}
+        for _, record := range records {
+                inEffect[record.EmployeeID] = record
+        }
+
+        // Scheduled terminations haven't happened yet
+        var events []models.EmploymentEvent
+        if err := tx.Where("pending IS NOT TRUE").Order("effective_date, id").Find(&events).Error; err != nil {
+                return nil, err
+        }
+        status := map[uint]string{}
+        for _, event := range events {
+                if event.EffectiveDate.Before(next) {
+                        status[event.EmployeeID] = event.ToStatus
+                } else if _, ok := status[event.EmployeeID]; !ok {
+                        // The first event after day shows the status it had
+                        status[event.EmployeeID] = event.FromStatus
+                }
+        }
+
+        var employees []models.Employee
+        if err := tx.Select("id", "employment_status", "last_working_day").Find(&employees).Error; err != nil {
+                return nil, err
+        }
+        employed := map[uint]models.JobRecord{}
+        for _, e := range employees {
+                current, ok := status[e.ID]
+                if !ok {
+                        current = e.EmploymentStatus
+                        if e.LastWorkingDay != nil && e.LastWorkingDay.After(day) {
+                                current = models.EmploymentStatusActive
+                        }
+                }
+                if record, ok := inEffect[e.ID]; ok && !models.IsDeparted(current) {
+                        employed[e.ID] = record
+                }
+        }
+        return employed, nil
+}
+
+// GetJobHistory lists an employee's job records, newest first, including
+// changes scheduled for later. With as_of it returns the record in effect on
+// that day instead.
+func GetJobHistory(c *gin.Context) {
+        var employee models.Employee
+        if err := database.DB.Select("id").First(&employee, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+                return
+        }
+        asOf, err := parseAsOf(c)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        viewer := viewerFor(c)
+        query := database.DB.Where("employee_id = ?", employee.ID).Order("effective_date DESC, id DESC")
+        if asOf != nil {
+                var record models.JobRecord
+                if err := query.Where("applied_at IS NOT NULL AND effective_date < ?", asOf.AddDate(0, 0, 1)).
+                        First(&record).Error; err != nil {
+                        c.JSON(http.StatusNotFound, gin.H{"error": "No job record on that date"})
+                        return
+                }
+                c.JSON(http.StatusOK, viewer.JobRecord(employee, record))
+                return
+        }
+
+        var records []models.JobRecord
+        if err := query.Find(&records).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job history"})
+                return
+        }
+        c.JSON(http.StatusOK, viewer.JobRecords(employee, records))
+}
+
+// CreateJobChange records a promotion, transfer or pay change. Only the job
+// fields present in the body change; a null manager_id removes the manager.
+// Changes dated today or earlier apply at once, later ones are applied by
+// the scheduler on the day.
+func CreateJobChange(c *gin.Context) {
+        body, err := c.GetRawData()
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        var present map[string]json.RawMessage
+        var input struct {
+                EffectiveDate string  `json:"effective_date"`
+                Reason        string  `json:"reason"`
+                JobTitle      string  `json:"job_title"`
+                DepartmentID  uint    `json:"department_id"`
+                ManagerID     *uint   `json:"manager_id"`
+                JobLevel      string  `json:"job_level"`
+                BaseSalary    float64 `json:"base_salary"`
+        }
+        if err := json.Unmarshal(body, &present); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON body"})
+                return
+        }
+        if err := json.Unmarshal(body, &input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        today := dateOnly(time.Now())
+        effective := today
+        if input.EffectiveDate != "" {
+                parsed, err := time.Parse("2006-01-02", input.EffectiveDate)
+                if err != nil {
+                        c.JSON(http.StatusBadRequest, gin.H{"error": "effective_date must be a date in YYYY-MM-DD format"})
+                        return
+                }
+                effective = parsed
+        }
+
+        var employee models.Employee
+        if err := database.DB.First(&employee, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+                return
+        }
+
+        record := models.JobRecordFor(employee, effective)
+        for _, field := range models.JobFields {
+                if _, ok := present[field]; !ok {
+                        continue
+                }
+                record.ChangedFields = append(record.ChangedFields, field)
+                switch field {
+                case "job_title":
+                        record.JobTitle = strings.TrimSpace(input.JobTitle)
+                case "department_id":
+                        record.DepartmentID = input.DepartmentID
+                case "manager_id":
+                        record.ManagerID = input.ManagerID
+                case "job_level":
+                        record.JobLevel = strings.TrimSpace(input.JobLevel)
+                case "base_salary":
+                        record.BaseSalary = input.BaseSalary
+                }
+        }
+        if len(record.ChangedFields) == 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Give at least one of job_title, department_id, manager_id, job_level or base_salary"})
+                return
+        }
+        record.Reason = strings.TrimSpace(input.Reason)
+        record.ActorUserID = actorUserID(c)
+
+        var failStatus int
+        var failMessage string
+        err = database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                // History is only ever appended to, so a change can't slip in
+                // before one that has already taken effect.
+                var latest models.JobRecord
+                if err := tx.Where("employee_id = ? AND applied_at IS NOT NULL", employee.ID).
+                        Order("effective_date DESC").First(&latest).Error; err == nil && effective.Before(latest.EffectiveDate) {
+                        failStatus = http.StatusConflict
+                        failMessage = "effective_date cannot be before the last job change, on " + latest.EffectiveDate.Format("2006-01-02")
+                        return errors.New(failMessage)
+                }
+                if err := checkJobRecord(tx, record); err != nil {
+                        failStatus, failMessage = jobErrorStatus(err), err.Error()
+                        return err
+                }
+                if err := tx.Create(&record).Error; err != nil {
+                        return err
+                }
+                if effective.After(today) {
+                        return nil
+                }
+                _, err := applyJobRecord(tx, &record)
+                return err
+        })
+        if failStatus != 0 {
+                c.JSON(failStatus, gin.H{"error": failMessage})
+                return
+        }
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save job change"})
+                return
+        }
+        c.JSON(http.StatusCreated, viewerFor(c).JobRecord(employee, record))
+}
+
+// CancelJobChange deletes a change that is scheduled but hasn't taken
+// effect. Applied records are history and stay.
+func CancelJobChange(c *gin.Context) {
+        var record models.JobRecord
+        if err := database.DB.Where("employee_id = ?", c.Param("id")).First(&record, c.Param("record")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Job change not found"})
+                return
+        }
+
+        result := database.DB.WithContext(audit.Context(c)).Where("applied_at IS NULL").Delete(&record)
+        if result.Error != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job change"})
+                return
+        }
+        if result.RowsAffected == 0 {
+                c.JSON(http.StatusConflict, gin.H{"error": "This change has already taken effect"})
+                return
+        }
+        c.JSON(http.StatusOK, gin.H{"message": "Job change cancelled"})
+}
@@ -1,12 +1,17 @@
 package handlers
 
 import (
+        "errors"
         "net/http"
 
+        "hcm-backend/audit"
         "hcm-backend/database"
+        "hcm-backend/middleware"
         "hcm-backend/models"
+        "hcm-backend/views"
 
         "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
 )
 
 func CreateLeaveRequest(c *gin.Context) {
@@ -16,22 +21,79 @@ func CreateLeaveRequest(c *gin.Context) {
                 return
         }
 
+        if _, ok := employeeForCaller(c, leave.EmployeeID, models.PermEmployeesSensitive); !ok {
+                return
+        }
+
         leave.Status = "pending"
-        result := database.DB.Create(&leave)
+        result := database.DB.WithContext(audit.Context(c)).Create(&leave)
         if result.Error != nil {
                 c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
                 return
         }
 
-        database.DB.Preload("Employee").First(&leave, leave.ID)
+        database.DB.Preload("Employee", views.PublicEmployee).First(&leave, leave.ID)
         c.JSON(http.StatusCreated, leave)
 }
 
+var leaveSorts = map[string]string{
+        "id":         "leave_requests.id",
+        "created_at": "leave_requests.created_at",
+        "start_date": "leave_requests.start_date",
+        "end_date":   "leave_requests.end_date",
+        "status":     "leave_requests.status",
+        "leave_type": "leave_requests.leave_type",
+        "employee":   "(SELECT name FROM employees WHERE employees.id = leave_requests.employee_id)",
+}
+
+// leaveListQuery applies GetLeaveRequests' filters: employee_id,
+// department_id, status, leave_type and the from/to date range. Callers
+// without leave:approve or employees:sensitive see just their own requests
+// and those of everyone below them in the manager chain.
+func leaveListQuery(c *gin.Context) (*gorm.DB, error) {
+        from, to, err := parseDateRange(c)
+        if err != nil {
+                return nil, err
+        }
+
+        query := database.DB.Model(&models.LeaveRequest{})
+        if !middleware.HasPermission(c, models.PermLeaveApprove) && !middleware.HasPermission(c, models.PermEmployeesSensitive) {
+                self := callerEmployeeID(c)
+                query = query.Where("(leave_requests.employee_id = ? OR leave_requests.employee_id IN (?))", self, views.Reports(database.DB, self))
+        }
+        if query, err = filterIDs(c, query, "employee_id", "leave_requests.employee_id"); err != nil {
+                return nil, err
+        }
+        if query, err = filterIDs(c, query, "department_id", "(SELECT department_id FROM employees WHERE employees.id = leave_requests.employee_id)"); err != nil {
+                return nil, err
+        }
+        query = filterValues(c, query, "status", "leave_requests.status")
+        query = filterValues(c, query, "leave_type", "leave_requests.leave_type")
+        // A leave matches a date range when any of its days fall inside it
+        if from != nil {
+                query = query.Where("leave_requests.end_date >= ?", *from)
+        }
+        if to != nil {
+                query = query.Where("leave_requests.start_date < ?", *to)
+        }
+        return query, nil
+}
+
 func GetLeaveRequests(c *gin.Context) {
+        params, err := parseListParams(c, "leave_requests", leaveSorts, "-created_at")
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        query, err := leaveListQuery(c)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
         var leaves []models.LeaveRequest
-        result := database.DB.Preload("Employee").Order("created_at desc").Find(&leaves)
-        if result.Error != nil {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+        if err := params.find(c, query.Preload("Employee", views.PublicEmployee), &leaves); err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
                 return
         }
         c.JSON(http.StatusOK, leaves)
@@ -39,16 +101,16 @@ func GetLeaveRequests(c *gin.Context) {
 
 func UpdateLeaveStatus(c *gin.Context) {
         id := c.Param("id")
-        
+
         var input struct {
                 Status string `json:"status" binding:"required"`
         }
-        
+
         if err := c.ShouldBindJSON(&input); err != nil {
                 c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
                 return
         }
-        
+
         // Validate status
         validStatuses := []string{"pending", "approved", "rejected"}
         isValid := false
@@ -58,25 +120,41 @@ func UpdateLeaveStatus(c *gin.Context) {
                         break
                 }
         }
-        
+
         if !isValid {
                 c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status. Must be pending, approved, or rejected"})
                 return
         }
-        
+
         var leave models.LeaveRequest
         if err := database.DB.First(&leave, id).Error; err != nil {
                 c.JSON(http.StatusNotFound, gin.H{"error": "Leave request not found"})
                 return
         }
-        
+
+        // Managers decide leave for the employees below them; HR for anyone
+        if !viewerFor(c).ManagesEmployee(models.Employee{ID: leave.EmployeeID}) && !middleware.HasPermission(c, models.PermEmployeesSensitive) {
+                c.JSON(http.StatusForbidden, gin.H{"error": "You can only decide leave for the employees who report to you"})
+                return
+        }
+
+        if !ifMatch(c, leave.Version, false) {
+                return
+        }
+
+        version := leave.Version
         leave.Status = input.Status
-        
-        if err := database.DB.Save(&leave).Error; err != nil {
+
+        if err := saveVersioned(database.DB.WithContext(audit.Context(c)), &leave, version); err != nil {
+                if errors.Is(err, errVersionConflict) {
+                        versionConflict(c, currentVersion(&models.LeaveRequest{}, leave.ID))
+                        return
+                }
                 c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update leave status"})
                 return
         }
-        
-        database.DB.Preload("Employee").First(&leave, leave.ID)
+
+        database.DB.Preload("Employee", views.PublicEmployee).First(&leave, leave.ID)
+        c.Header("ETag", etag(leave.Version))
         c.JSON(http.StatusOK, gin.H{"message": "Leave status updated successfully", "leave": leave})
 }
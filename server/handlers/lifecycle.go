@@ -0,0 +1,430 @@
+package handlers
+
+import (
+        "context"
+        "errors"
+        "io"
+        "log"
+        "net/http"
+        "strings"
+        "time"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/models"
+        "hcm-backend/timezone"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+        "gorm.io/gorm/clause"
+)
+
+// LeaveStatusCancelled marks leave dropped because the employee left before
+// it started.
+const LeaveStatusCancelled = "cancelled"
+
+// lifecycleTransitions lists, for each action, the statuses it can start
+// from. An empty status is treated as active, the column default.
+var lifecycleTransitions = map[string][]string{
+        "hire":          {models.EmploymentStatusPending},
+        "end_probation": {models.EmploymentStatusProbation},
+        "start_leave":   {models.EmploymentStatusActive, models.EmploymentStatusProbation},
+        "end_leave":     {models.EmploymentStatusOnLeave},
+        "terminate": {
+                models.EmploymentStatusPending,
+                models.EmploymentStatusProbation,
+                models.EmploymentStatusActive,
+                models.EmploymentStatusOnLeave,
+        },
+        "rehire": models.DepartedStatuses,
+}
+
+type lifecycleInput struct {
+        EffectiveDate    string `json:"effective_date"`
+        ProbationEndDate string `json:"probation_end_date"`
+        LastWorkingDay   string `json:"last_working_day"`
+        Reason           string `json:"reason"`
+        Notes            string `json:"notes"`
+}
+
+// lifecycleEffects reports what a transition changed besides the employee.
+type lifecycleEffects struct {
+        SessionsRevoked   int64 `json:"sessions_revoked"`
+        AttendanceClosed  int64 `json:"attendance_closed"`
+        LeaveCancelled    int64 `json:"leave_cancelled"`
+        ReportsReassigned int64 `json:"reports_reassigned"`
+        ShiftsEnded       int64 `json:"shifts_ended"`
+}
+
+// parseLifecycleDate parses an optional YYYY-MM-DD field.
+func parseLifecycleDate(value, field string) (*time.Time, error) {
+        if value == "" {
+                return nil, nil
+        }
+        t, err := time.Parse("2006-01-02", value)
+        if err != nil {
+                return nil, errors.New(field + " must be a date in YYYY-MM-DD format")
+        }
+        return &t, nil
+}
+
+// closeOpenAttendance clocks out every open attendance row of the employee,
+// at the end of the row's day in its timezone if that is earlier than now.
+func closeOpenAttendance(tx *gorm.DB, employeeID uint) (int64, error) {
+        var open []models.Attendance
+        if err := tx.Where("employee_id = ? AND clock_out IS NULL", employeeID).Find(&open).Error; err != nil {
+                return 0, err
+        }
+        now := time.Now()
+        for _, row := range open {
+                clockOut := now
+                endOfDay := time.Date(row.Date.Year(), row.Date.Month(), row.Date.Day(), 23, 59, 59, 0, timezone.Resolve(row.Timezone))
+                if endOfDay.Before(clockOut) {
+                        clockOut = endOfDay
+                }
+                if err := tx.Model(&row).Update("clock_out", clockOut).Error; err != nil {
+                        return 0, err
+                }
+                if err := evaluateAttendance(tx, employeeID, row.ClockIn, row.ClockIn); err != nil {
+                        return 0, err
+                }
+        }
+        return int64(len(open)), nil
+}
+
+// offboard runs the side effects of employee leaving after lastWorkingDay:
+// open attendance is closed, leave after the day is cancelled, shift
+// assignments end on it and direct reports move to the employee's manager.
+// The linked account's sessions are left to the caller to revoke once tx
+// commits.
+func offboard(tx *gorm.DB, employee models.Employee, lastWorkingDay time.Time, actor *uint) (lifecycleEffects, error) {
+        var effects lifecycleEffects
+        var err error
+        if effects.AttendanceClosed, err = closeOpenAttendance(tx, employee.ID); err != nil {
+                return effects, err
+        }
+
+        result := tx.Model(&models.LeaveRequest{}).
+                Where("employee_id = ? AND status IN ? AND start_date > ?", employee.ID, []string{"pending", "approved"}, lastWorkingDay).
+                Update("status", LeaveStatusCancelled)
+        if result.Error != nil {
+                return effects, result.Error
+        }
+        effects.LeaveCancelled = result.RowsAffected
+
+        if effects.ShiftsEnded, err = endShiftAssignments(tx, employee.ID, lastWorkingDay); err != nil {
+                return effects, err
+        }
+
+        effects.ReportsReassigned, err = moveEmployees(tx, tx.Where("manager_id = ?", employee.ID),
+                "manager_id", employee.ManagerID, "Manager left", actor)
+        return effects, err
+}
+
+// GetEmployeeLifecycle returns the employee's lifecycle events, newest first.
+func GetEmployeeLifecycle(c *gin.Context) {
+        var employee models.Employee
+        if err := database.DB.Select("id").First(&employee, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+                return
+        }
+
+        events := []models.EmploymentEvent{}
+        if err := database.DB.Where("employee_id = ?", employee.ID).
+                Order("effective_date DESC, id DESC").Find(&events).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch lifecycle events"})
+                return
+        }
+        c.JSON(http.StatusOK, events)
+}
+
+// EmployeeLifecycleAction moves an employee through their lifecycle: hire,
+// end_probation, start_leave, end_leave, terminate or rehire. Terminations
+// also offboard: open attendance is closed, leave after the last working day
+// is cancelled, shift assignments end on it, direct reports move to the
+// employee's manager and the linked account's sessions are revoked. A
+// termination with the last working day still ahead is only scheduled;
+// ApplyDueTerminations carries it out once the day has passed.
+func EmployeeLifecycleAction(c *gin.Context) {
+        action := c.Param("action")
+        from, ok := lifecycleTransitions[action]
+        if !ok {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Unknown lifecycle action"})
+                return
+        }
+
+        // The body is optional; end_probation and the like need nothing
+        var input lifecycleInput
+        if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        input.Reason = strings.TrimSpace(input.Reason)
+
+        today := dateOnly(time.Now())
+        effective := today
+        probationEnd, err := parseLifecycleDate(input.ProbationEndDate, "probation_end_date")
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        lastWorkingDay, err := parseLifecycleDate(input.LastWorkingDay, "last_working_day")
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        if date, err := parseLifecycleDate(input.EffectiveDate, "effective_date"); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        } else if date != nil {
+                effective = *date
+        }
+
+        scheduled := false
+        if action == "terminate" {
+                if input.Reason == "" {
+                        c.JSON(http.StatusBadRequest, gin.H{"error": "A termination needs a reason"})
+                        return
+                }
+                if lastWorkingDay == nil {
+                        c.JSON(http.StatusBadRequest, gin.H{"error": "A termination needs a last_working_day"})
+                        return
+                }
+                scheduled = lastWorkingDay.After(today)
+                if input.EffectiveDate == "" {
+                        effective = *lastWorkingDay
+                }
+        }
+
+        var employee models.Employee
+        if err := database.DB.First(&employee, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+                return
+        }
+
+        current := employee.EmploymentStatus
+        if current == "" {
+                current = models.EmploymentStatusActive
+        }
+        allowed := false
+        for _, status := range from {
+                if current == status {
+                        allowed = true
+                }
+        }
+        if !allowed {
+                c.JSON(http.StatusConflict, gin.H{"error": "Cannot " + strings.ReplaceAll(action, "_", " ") + " an employee who is " + strings.ReplaceAll(current, "_", " ")})
+                return
+        }
+        if action == "terminate" {
+                var pending int64
+                database.DB.Model(&models.EmploymentEvent{}).Where("employee_id = ? AND pending", employee.ID).Count(&pending)
+                if pending > 0 {
+                        c.JSON(http.StatusConflict, gin.H{"error": "This employee already has a termination scheduled"})
+                        return
+                }
+        }
+
+        // startingStatus puts someone with a probation end date still ahead of
+        // them on probation.
+        startingStatus := func(end *time.Time) string {
+                if end != nil && end.After(effective) {
+                        return models.EmploymentStatusProbation
+                }
+                return models.EmploymentStatusActive
+        }
+
+        var effects lifecycleEffects
+        switch action {
+        case "hire":
+                employee.HireDate = effective
+                if probationEnd != nil {
+                        employee.ProbationEndDate = probationEnd
+                }
+                employee.EmploymentStatus = startingStatus(probationEnd)
+        case "end_probation":
+                employee.ProbationEndDate = &effective
+                employee.EmploymentStatus = models.EmploymentStatusActive
+        case "start_leave":
+                employee.EmploymentStatus = models.EmploymentStatusOnLeave
+        case "end_leave":
+                employee.EmploymentStatus = startingStatus(employee.ProbationEndDate)
+        case "terminate":
+                // Until a scheduled termination applies, the last working day
+                // alone already keeps the employee off later shifts.
+                employee.LastWorkingDay = lastWorkingDay
+                if !scheduled {
+                        employee.EmploymentStatus = models.EmploymentStatusTerminated
+                        employee.TerminationReason = input.Reason
+                }
+        case "rehire":
+                employee.HireDate = effective
+                employee.ProbationEndDate = probationEnd
+                employee.EmploymentStatus = startingStatus(probationEnd)
+                employee.TerminationReason = ""
+                employee.LastWorkingDay = nil
+        }
+
+        actor := actorUserID(c)
+        event := models.EmploymentEvent{
+                EmployeeID:    employee.ID,
+                Action:        action,
+                FromStatus:    current,
+                ToStatus:      employee.EmploymentStatus,
+                EffectiveDate: effective,
+                Reason:        input.Reason,
+                Notes:         input.Notes,
+                ActorUserID:   actor,
+                Pending:       scheduled,
+        }
+        if scheduled {
+                event.ToStatus = models.EmploymentStatusTerminated
+        }
+
+        err = database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                if err := tx.Omit(clause.Associations).Save(&employee).Error; err != nil {
+                        return err
+                }
+                if err := tx.Create(&event).Error; err != nil {
+                        return err
+                }
+
+                switch action {
+                case "start_leave":
+                        closed, err := closeOpenAttendance(tx, employee.ID)
+                        effects.AttendanceClosed = closed
+                        return err
+                case "terminate":
+                        if scheduled {
+                                return nil
+                        }
+                        effects, err = offboard(tx, employee, *lastWorkingDay, actor)
+                        return err
+                }
+                return nil
+        })
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update employee"})
+                return
+        }
+
+        if action == "terminate" && !scheduled && employee.UserID != nil {
+                effects.SessionsRevoked, _ = revokeUserSessions(*employee.UserID)
+        }
+
+        database.DB.Preload("Department").Preload("Manager").First(&employee, employee.ID)
+        c.JSON(http.StatusOK, gin.H{
+                "employee": viewerFor(c).Employee(employee),
+                "event":    event,
+                "effects":  effects,
+        })
+}
+
+// CancelLifecycleEvent cancels a termination that is scheduled but hasn't
+// taken effect, clearing the employee's last working day. Applied events are
+// history and stay.
+func CancelLifecycleEvent(c *gin.Context) {
+        var event models.EmploymentEvent
+        if err := database.DB.Where("employee_id = ?", c.Param("id")).First(&event, c.Param("event")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Lifecycle event not found"})
+                return
+        }
+
+        var cancelled int64
+        err := database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                result := tx.Where("pending").Delete(&event)
+                if result.Error != nil || result.RowsAffected == 0 {
+                        return result.Error
+                }
+                cancelled = result.RowsAffected
+                return tx.Model(&models.Employee{}).Where("id = ?", event.EmployeeID).Update("last_working_day", nil).Error
+        })
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel lifecycle event"})
+                return
+        }
+        if cancelled == 0 {
+                c.JSON(http.StatusConflict, gin.H{"error": "This event has already taken effect"})
+                return
+        }
+        c.JSON(http.StatusOK, gin.H{"message": "Termination cancelled"})
+}
+
+// ApplyDueTerminations terminates and offboards everyone whose scheduled
+// termination has come due, as the lifecycle action would have that day,
+// and returns how many it applied.
+func ApplyDueTerminations(ctx context.Context) (int, error) {
+        var due []models.EmploymentEvent
+        if err := database.DB.Where("pending").Order("effective_date, id").Find(&due).Error; err != nil {
+                return 0, err
+        }
+
+        today := dateOnly(time.Now())
+        applied := 0
+        for _, event := range due {
+                var employee models.Employee
+                var ok bool
+                err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+                        var err error
+                        employee, ok, err = applyTermination(tx, event, today)
+                        return err
+                })
+                if err != nil {
+                        log.Printf("Failed to apply termination %d for employee %d: %v", event.ID, event.EmployeeID, err)
+                        continue
+                }
+                if !ok {
+                        continue
+                }
+                applied++
+                if employee.UserID != nil {
+                        revokeUserSessions(*employee.UserID)
+                }
+        }
+        return applied, nil
+}
+
+// applyTermination carries out a scheduled termination once the employee's
+// last working day is before today. The event is claimed before anything
+// changes, so a termination is applied once however many schedulers run.
+func applyTermination(tx *gorm.DB, event models.EmploymentEvent, today time.Time) (models.Employee, bool, error) {
+        var employee models.Employee
+        err := tx.First(&employee, event.EmployeeID).Error
+        if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+                return employee, false, err
+        }
+        found := err == nil
+        if found && employee.LastWorkingDay != nil && !dateOnly(*employee.LastWorkingDay).Before(today) {
+                return employee, false, nil
+        }
+
+        current := employee.EmploymentStatus
+        if current == "" {
+                current = models.EmploymentStatusActive
+        }
+        // Deleted employees and those who left another way, say through
+        // SCIM, have nothing left to offboard, so the termination is dropped
+        if !found || models.IsDeparted(current) {
+                return employee, false, tx.Where("pending").Delete(&event).Error
+        }
+        claimed := tx.Model(&models.EmploymentEvent{}).Where("id = ? AND pending", event.ID).
+                Updates(map[string]interface{}{"pending": false, "from_status": current})
+        if claimed.Error != nil || claimed.RowsAffected == 0 {
+                return employee, false, claimed.Error
+        }
+
+        lastWorkingDay := event.EffectiveDate
+        if employee.LastWorkingDay != nil {
+                lastWorkingDay = *employee.LastWorkingDay
+        }
+        employee.EmploymentStatus = models.EmploymentStatusTerminated
+        employee.TerminationReason = event.Reason
+        employee.LastWorkingDay = &lastWorkingDay
+        if err := tx.Omit(clause.Associations).Save(&employee).Error; err != nil {
+                return employee, false, err
+        }
+        if _, err := offboard(tx, employee, lastWorkingDay, event.ActorUserID); err != nil {
+                return employee, false, err
+        }
+        return employee, true, nil
+}
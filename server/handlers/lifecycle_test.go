@@ -0,0 +1,105 @@
+package handlers
+
+import (
+        "database/sql/driver"
+        "encoding/json"
+        "net/http"
+        "net/http/httptest"
+        "strings"
+        "testing"
+        "time"
+
+        "hcm-backend/models"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+)
+
+// employeeRow answers the lookup of employee 5 by ID.
+func employeeRow(status string, lastWorkingDay time.Time) fakeResult {
+        return fakeResult{
+                match:   `WHERE "employees"."id" = $1`,
+                columns: []string{"id", "name", "employment_status", "last_working_day", "user_id"},
+                rows:    [][]driver.Value{{int64(5), "Ada", status, lastWorkingDay, int64(9)}},
+        }
+}
+
+func TestTerminateLater(t *testing.T) {
+        lastWorkingDay := dateOnly(time.Now()).AddDate(0, 0, 14)
+        fake := useFakeDB(t, employeeRow(models.EmploymentStatusActive, time.Time{}))
+
+        gin.SetMode(gin.TestMode)
+        w := httptest.NewRecorder()
+        c, _ := gin.CreateTestContext(w)
+        body := `{"reason": "Resigned", "last_working_day": "` + lastWorkingDay.Format("2006-01-02") + `"}`
+        c.Request = httptest.NewRequest(http.MethodPost, "/api/employees/5/lifecycle/terminate", strings.NewReader(body))
+        c.Request.Header.Set("Content-Type", "application/json")
+        c.Params = gin.Params{{Key: "id", Value: "5"}, {Key: "action", Value: "terminate"}}
+
+        EmployeeLifecycleAction(c)
+
+        var response struct {
+                Employee map[string]interface{} `json:"employee"`
+                Event    models.EmploymentEvent `json:"event"`
+        }
+        json.Unmarshal(w.Body.Bytes(), &response)
+        if w.Code != http.StatusOK {
+                t.Fatalf("terminate = %d %s, want 200", w.Code, w.Body)
+        }
+        if event := response.Event; !event.Pending || event.ToStatus != models.EmploymentStatusTerminated || !event.EffectiveDate.Equal(lastWorkingDay) {
+                t.Errorf("event = %+v, want a pending termination effective %v", event, lastWorkingDay)
+        }
+        if !fake.executed(`INSERT INTO "employment_events"`) {
+                t.Error("the scheduled termination wasn't recorded")
+        }
+        // Nothing is offboarded until the last working day has passed
+        for _, table := range []string{`"attendances"`, `"leave_requests"`, `"shift_assignments"`, `"sessions"`} {
+                if fake.executed("UPDATE " + table) {
+                        t.Errorf("terminating later updated %s straight away", table)
+                }
+        }
+}
+
+func TestApplyTermination(t *testing.T) {
+        today := dateOnly(time.Now())
+        event := models.EmploymentEvent{ID: 3, EmployeeID: 5, Action: "terminate", Reason: "Resigned", Pending: true}
+
+        tests := []struct {
+                name           string
+                status         string
+                lastWorkingDay time.Time
+                applied        bool
+                statements     []string
+        }{
+                {"last working day is today", models.EmploymentStatusActive, today, false, nil},
+                {"last working day has passed", models.EmploymentStatusOnLeave, today.AddDate(0, 0, -1), true,
+                        []string{`UPDATE "employment_events" SET "from_status"=$1,"pending"=$2`, `UPDATE "employees" SET`, `UPDATE "leave_requests" SET "status"=$1`}},
+                {"employee already left", models.EmploymentStatusTerminated, today.AddDate(0, 0, -1), false,
+                        []string{`DELETE FROM "employment_events" WHERE pending`}},
+        }
+        for _, tt := range tests {
+                fake := useFakeDB(t, employeeRow(tt.status, tt.lastWorkingDay))
+                var employee models.Employee
+                var applied bool
+                err := fake.gorm.Transaction(func(tx *gorm.DB) error {
+                        var err error
+                        employee, applied, err = applyTermination(tx, event, today)
+                        return err
+                })
+                if err != nil || applied != tt.applied {
+                        t.Errorf("%s: applyTermination = %v, %v, want %v", tt.name, applied, err, tt.applied)
+                        continue
+                }
+                if applied && (employee.EmploymentStatus != models.EmploymentStatusTerminated || employee.TerminationReason != "Resigned") {
+                        t.Errorf("%s: employee = %s %q, want terminated for the event's reason", tt.name, employee.EmploymentStatus, employee.TerminationReason)
+                }
+                for _, statement := range tt.statements {
+                        if !fake.executed(statement) {
+                                t.Errorf("%s: want %s", tt.name, statement)
+                        }
+                }
+                if tt.statements == nil && len(fake.statements) != 1 {
+                        t.Errorf("%s: ran %v, want only the employee looked up", tt.name, fake.statements)
+                }
+        }
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+        "errors"
+        "fmt"
+        "strconv"
+        "strings"
+        "time"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+)
+
+const (
+        defaultPageSize = 50
+        maxPageSize     = 500
+)
+
+// listParams are the paging and sorting options shared by list endpoints:
+// limit, offset and sort, a comma-separated list of fields each optionally
+// prefixed with "-" for descending order.
+type listParams struct {
+        Limit  int
+        Offset int
+        Order  string
+}
+
+// parseListParams reads the paging and sorting options. sortable maps the
+// field names clients may sort on to their columns, and defaultSort is used
+// when the request doesn't give one. The primary key is always appended so
+// pages stay stable when sort values tie.
+func parseListParams(c *gin.Context, table string, sortable map[string]string, defaultSort string) (listParams, error) {
+        params := listParams{Limit: defaultPageSize}
+
+        if limit := c.Query("limit"); limit != "" {
+                l, err := strconv.Atoi(limit)
+                if err != nil || l < 1 {
+                        return params, errors.New("limit must be a positive number")
+                }
+                if l > maxPageSize {
+                        l = maxPageSize
+                }
+                params.Limit = l
+        }
+        if offset := c.Query("offset"); offset != "" {
+                o, err := strconv.Atoi(offset)
+                if err != nil || o < 0 {
+                        return params, errors.New("offset must be zero or a positive number")
+                }
+                params.Offset = o
+        }
+
+        sort := c.DefaultQuery("sort", defaultSort)
+        var order []string
+        for _, field := range strings.Split(sort, ",") {
+                field = strings.TrimSpace(field)
+                direction := "ASC"
+                if strings.HasPrefix(field, "-") {
+                        field = field[1:]
+                        direction = "DESC"
+                }
+                column, ok := sortable[field]
+                if !ok {
+                        return params, fmt.Errorf("cannot sort by %q", field)
+                }
+                order = append(order, column+" "+direction)
+        }
+        params.Order = strings.Join(append(order, table+".id"), ", ")
+        return params, nil
+}
+
+// find counts the rows query matches, reports the total in the X-Total-Count
+// header and loads the requested page into dest.
+func (p listParams) find(c *gin.Context, query *gorm.DB, dest interface{}) error {
+        var total int64
+        if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+                return err
+        }
+        c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+        return query.Order(p.Order).Limit(p.Limit).Offset(p.Offset).Find(dest).Error
+}
+
+// filterValues restricts column to the comma-separated values of the query
+// parameter param, if given.
+func filterValues(c *gin.Context, query *gorm.DB, param, column string) *gorm.DB {
+        value := c.Query(param)
+        if value == "" {
+                return query
+        }
+        values := strings.Split(value, ",")
+        for i := range values {
+                values[i] = strings.TrimSpace(values[i])
+        }
+        return query.Where(column+" IN ?", values)
+}
+
+// filterIDs is filterValues for ID columns.
+func filterIDs(c *gin.Context, query *gorm.DB, param, column string) (*gorm.DB, error) {
+        value := c.Query(param)
+        if value == "" {
+                return query, nil
+        }
+        var ids []uint
+        for _, s := range strings.Split(value, ",") {
+                id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+                if err != nil {
+                        return query, fmt.Errorf("invalid %s", param)
+                }
+                ids = append(ids, uint(id))
+        }
+        return query.Where(column+" IN ?", ids), nil
+}
+
+// parseDateRange reads the from and to query parameters (YYYY-MM-DD). to is
+// returned as the start of the following day so ranges include it.
+func parseDateRange(c *gin.Context) (from, to *time.Time, err error) {
+        if s := c.Query("from"); s != "" {
+                t, err := time.Parse("2006-01-02", s)
+                if err != nil {
+                        return nil, nil, errors.New("from must be a date in YYYY-MM-DD format")
+                }
+                from = &t
+        }
+        if s := c.Query("to"); s != "" {
+                t, err := time.Parse("2006-01-02", s)
+                if err != nil {
+                        return nil, nil, errors.New("to must be a date in YYYY-MM-DD format")
+                }
+                t = t.AddDate(0, 0, 1)
+                to = &t
+        }
+        if from != nil && to != nil && !from.Before(*to) {
+                return nil, nil, errors.New("from must not be after to")
+        }
+        return from, to, nil
+}
+
+// likePattern escapes LIKE wildcards in term and wraps it for a substring
+// match against a lowercased column, used with ESCAPE '\'.
+func likePattern(term string) string {
+        term = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(term))
+        return "%" + term + "%"
+}
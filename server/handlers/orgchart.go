@@ -0,0 +1,324 @@
+package handlers
+
+import (
+        "errors"
+        "net/http"
+        "sort"
+        "strconv"
+        "time"
+
+        "hcm-backend/database"
+        "hcm-backend/models"
+        "hcm-backend/views"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+)
+
+// defaultSpanOfControl is the most direct reports a manager should have
+// before the span-of-control report flags them.
+const defaultSpanOfControl = 10
+
+var (
+        errOwnManager      = errors.New("Employee cannot be their own manager")
+        errManagerNotFound = errors.New("Manager not found")
+        errManagerLoop     = errors.New("This manager would create a reporting loop")
+)
+
+// checkManager verifies that managerID exists and that making them the
+// manager of employeeID, 0 for a new employee, doesn't close a loop in the
+// reporting lines.
+func checkManager(tx *gorm.DB, employeeID uint, managerID *uint) error {
+        if managerID == nil {
+                return nil
+        }
+        if *managerID == employeeID {
+                return errOwnManager
+        }
+
+        var links []models.Employee
+        if err := tx.Select("id", "manager_id").Find(&links).Error; err != nil {
+                return err
+        }
+        managers := make(map[uint]*uint, len(links))
+        for _, link := range links {
+                managers[link.ID] = link.ManagerID
+        }
+
+        if _, ok := managers[*managerID]; !ok {
+                return errManagerNotFound
+        }
+        if employeeID != 0 && createsCycle(managers, employeeID, *managerID) {
+                return errManagerLoop
+        }
+        return nil
+}
+
+// managerErrorStatus is the response status for an error from checkManager.
+func managerErrorStatus(err error) int {
+        switch err {
+        case errOwnManager, errManagerNotFound:
+                return http.StatusBadRequest
+        case errManagerLoop:
+                return http.StatusConflict
+        }
+        return http.StatusInternalServerError
+}
+
+// GetManagerChain returns the employee's managers, starting with their
+// direct manager and ending at the top of the organisation.
+func GetManagerChain(c *gin.Context) {
+        var employee models.Employee
+        if err := database.DB.Select("id", "manager_id").First(&employee, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+                return
+        }
+
+        var employees []models.Employee
+        if err := database.DB.Scopes(views.PublicEmployee).Find(&employees).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch employees"})
+                return
+        }
+        byID := make(map[uint]models.Employee, len(employees))
+        for _, e := range employees {
+                byID[e.ID] = e
+        }
+
+        // The visited check stops on a loop written before managers were
+        // validated instead of following it forever.
+        chain := []employeeSummary{}
+        visited := map[uint]bool{employee.ID: true}
+        for id := employee.ManagerID; id != nil && !visited[*id]; {
+                manager, ok := byID[*id]
+                if !ok {
+                        break
+                }
+                visited[manager.ID] = true
+                chain = append(chain, summarizeEmployee(manager))
+                id = manager.ManagerID
+        }
+
+        c.JSON(http.StatusOK, chain)
+}
+
+// employeeSummary is the part of an employee shown in reporting lines.
+type employeeSummary struct {
+        ID           uint   `json:"id"`
+        Name         string `json:"name"`
+        JobTitle     string `json:"job_title"`
+        DepartmentID uint   `json:"department_id"`
+        ManagerID    *uint  `json:"manager_id"`
+}
+
+func summarizeEmployee(e models.Employee) employeeSummary {
+        return employeeSummary{
+                ID:           e.ID,
+                Name:         e.Name,
+                JobTitle:     e.JobTitle,
+                DepartmentID: e.DepartmentID,
+                ManagerID:    e.ManagerID,
+        }
+}
+
+// orgChartNode is an employee in the org chart. DirectReports counts all of
+// their reports, including those cut off by the depth limit.
+type orgChartNode struct {
+        employeeSummary
+        DirectReports int             `json:"direct_reports"`
+        Reports       []*orgChartNode `json:"reports"`
+}
+
+// orgChart holds the active employees and who reports to whom.
+type orgChart struct {
+        nodes   map[uint]*orgChartNode
+        order   []uint
+        reports map[uint][]*orgChartNode
+}
+
+// loadOrgChart loads everyone who hasn't left, sorted by name. Anyone still
+// reporting to someone who left appears at the top. Given asOf, it loads
+// those who worked there that day, with the job they had then.
+func loadOrgChart(asOf *time.Time) (*orgChart, error) {
+        query := database.DB.Scopes(views.PublicEmployee).Order("name, id")
+        if asOf == nil {
+                query = query.Where("employment_status NOT IN ?", models.DepartedStatuses)
+        }
+        var employees []models.Employee
+        if err := query.Find(&employees).Error; err != nil {
+                return nil, err
+        }
+        if asOf != nil {
+                records, err := jobRecordsAsOf(database.DB, *asOf)
+                if err != nil {
+                        return nil, err
+                }
+                employed := employees[:0]
+                for _, e := range employees {
+                        if record, ok := records[e.ID]; ok {
+                                e.JobTitle = record.JobTitle
+                                e.DepartmentID = record.DepartmentID
+                                e.ManagerID = record.ManagerID
+                                employed = append(employed, e)
+                        }
+                }
+                employees = employed
+        }
+
+        chart := &orgChart{
+                nodes:   make(map[uint]*orgChartNode, len(employees)),
+                reports: map[uint][]*orgChartNode{},
+        }
+        for _, e := range employees {
+                chart.nodes[e.ID] = &orgChartNode{
+                        employeeSummary: summarizeEmployee(e),
+                        Reports:         []*orgChartNode{},
+                }
+                chart.order = append(chart.order, e.ID)
+        }
+        for _, id := range chart.order {
+                node := chart.nodes[id]
+                if node.ManagerID != nil && chart.nodes[*node.ManagerID] != nil {
+                        chart.reports[*node.ManagerID] = append(chart.reports[*node.ManagerID], node)
+                }
+        }
+        for id, reports := range chart.reports {
+                chart.nodes[id].DirectReports = len(reports)
+        }
+        return chart, nil
+}
+
+// GetOrgChart returns the reporting tree, from the top of the organisation or
+// from the employee given as root. depth limits how many levels below the
+// top are included; nodes at the limit keep their direct_reports count so
+// clients can load the next levels with root. as_of shows the tree as it was
+// on that day.
+func GetOrgChart(c *gin.Context) {
+        asOf, err := parseAsOf(c)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        depth := -1
+        if d := c.Query("depth"); d != "" {
+                parsed, err := strconv.Atoi(d)
+                if err != nil || parsed < 0 {
+                        c.JSON(http.StatusBadRequest, gin.H{"error": "depth must be zero or a positive number"})
+                        return
+                }
+                depth = parsed
+        }
+
+        chart, err := loadOrgChart(asOf)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch employees"})
+                return
+        }
+
+        visited := map[uint]bool{}
+        var build func(node *orgChartNode, level int)
+        build = func(node *orgChartNode, level int) {
+                visited[node.ID] = true
+                for _, report := range chart.reports[node.ID] {
+                        if visited[report.ID] {
+                                continue
+                        }
+                        // Levels past the limit are still walked so the loop
+                        // check below knows they were reached.
+                        if depth < 0 || level < depth {
+                                node.Reports = append(node.Reports, report)
+                        }
+                        build(report, level+1)
+                }
+        }
+
+        if root := c.Query("root"); root != "" {
+                id, err := strconv.ParseUint(root, 10, 64)
+                node, ok := chart.nodes[uint(id)]
+                if err != nil || !ok {
+                        c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+                        return
+                }
+                build(node, 0)
+                c.JSON(http.StatusOK, []*orgChartNode{node})
+                return
+        }
+
+        roots := []*orgChartNode{}
+        for _, id := range chart.order {
+                node := chart.nodes[id]
+                if node.ManagerID == nil || chart.nodes[*node.ManagerID] == nil {
+                        roots = append(roots, node)
+                        build(node, 0)
+                }
+        }
+        // Anyone not reached sits in a loop written before managers were
+        // validated; show them at the top so they aren't silently dropped.
+        for _, id := range chart.order {
+                if !visited[id] {
+                        roots = append(roots, chart.nodes[id])
+                        build(chart.nodes[id], 0)
+                }
+        }
+        c.JSON(http.StatusOK, roots)
+}
+
+type spanOfControlEntry struct {
+        employeeSummary
+        DirectReports int `json:"direct_reports"`
+}
+
+// GetSpanOfControl lists managers with more direct reports than max, and
+// employees with the manager role whom nobody reports to.
+func GetSpanOfControl(c *gin.Context) {
+        limit := defaultSpanOfControl
+        if m := c.Query("max"); m != "" {
+                parsed, err := strconv.Atoi(m)
+                if err != nil || parsed < 1 {
+                        c.JSON(http.StatusBadRequest, gin.H{"error": "max must be a positive number"})
+                        return
+                }
+                limit = parsed
+        }
+
+        chart, err := loadOrgChart(nil)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch employees"})
+                return
+        }
+
+        var managerRoles []uint
+        if err := database.DB.Model(&models.Employee{}).
+                Joins("JOIN users ON users.id = employees.user_id AND users.deleted_at IS NULL").
+                Where("users.role = ?", models.RoleManager).
+                Pluck("employees.id", &managerRoles).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch managers"})
+                return
+        }
+        isManager := map[uint]bool{}
+        for _, id := range managerRoles {
+                isManager[id] = true
+        }
+
+        overLimit := []spanOfControlEntry{}
+        withoutReports := []spanOfControlEntry{}
+        for _, id := range chart.order {
+                node := chart.nodes[id]
+                entry := spanOfControlEntry{employeeSummary: node.employeeSummary, DirectReports: node.DirectReports}
+                switch {
+                case node.DirectReports > limit:
+                        overLimit = append(overLimit, entry)
+                case node.DirectReports == 0 && isManager[id]:
+                        withoutReports = append(withoutReports, entry)
+                }
+        }
+        sort.SliceStable(overLimit, func(i, j int) bool {
+                return overLimit[i].DirectReports > overLimit[j].DirectReports
+        })
+
+        c.JSON(http.StatusOK, gin.H{
+                "max_direct_reports": limit,
+                "over_limit":         overLimit,
+                "without_reports":    withoutReports,
+        })
+}
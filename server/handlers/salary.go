@@ -5,7 +5,9 @@ import (
 	"net/http"
 	"time"
 
+	"hcm-backend/audit"
 	"hcm-backend/database"
+	"hcm-backend/middleware"
 	"hcm-backend/models"
 
 	"github.com/gin-gonic/gin"
@@ -26,7 +28,7 @@ func ExportSalary(c *gin.Context) {
 		ExportedAt: time.Now(),
 	}
 
-	database.DB.Create(&export)
+	database.DB.WithContext(audit.Context(c)).Create(&export)
 	c.JSON(http.StatusOK, gin.H{
 		"export_id": export.ID,
 		"period":    export.Period,
@@ -49,12 +51,17 @@ func GeneratePayslip(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
 		return
 	}
+	// Without payroll:read, employees can only see their own payslip
+	if !middleware.HasPermission(c, models.PermPayrollRead) && employee.ID != callerEmployeeID(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only generate your own payslip"})
+		return
+	}
 
 	var salaries []models.SalaryComponent
 	database.DB.Where("employee_id = ?", input.EmployeeID).Find(&salaries)
 
 	c.JSON(http.StatusOK, gin.H{
-		"employee": employee,
+		"employee": viewerFor(c).Employee(employee),
 		"salaries": salaries,
 		"period":   time.Now().Format("2006-01"),
 	})
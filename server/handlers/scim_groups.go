@@ -0,0 +1,342 @@
+package handlers
+
+import (
+        "encoding/json"
+        "errors"
+        "net/http"
+        "strconv"
+        "strings"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/models"
+        "hcm-backend/scim"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+        "gorm.io/gorm/clause"
+)
+
+// A SCIM Group is a department; its members are the employees in it.
+type scimGroup struct {
+        Schemas     []string          `json:"schemas"`
+        ID          string            `json:"id,omitempty"`
+        ExternalID  string            `json:"externalId,omitempty"`
+        DisplayName string            `json:"displayName"`
+        Members     []scimMultiValued `json:"members,omitempty"`
+        Meta        *scim.Meta        `json:"meta,omitempty"`
+}
+
+var scimGroupColumns = scim.Columns{
+        "id":                {Expr: "departments.id", Type: scim.Number},
+        "externalid":        {Expr: "departments.external_id", Type: scim.ExactString},
+        "displayname":       {Expr: "departments.name"},
+        "meta.created":      {Expr: "departments.created_at", Type: scim.DateTime},
+        "meta.lastmodified": {Expr: "departments.updated_at", Type: scim.DateTime},
+        "members.value": {Build: func(op string, value interface{}) (string, []interface{}, error) {
+                s, _ := value.(string)
+                id, err := strconv.ParseUint(s, 10, 64)
+                if op != "eq" || err != nil {
+                        return "", nil, errors.New("members can only be filtered with eq and a member ID")
+                }
+                return "departments.id IN (SELECT department_id FROM employees WHERE employees.id = ? AND employees.deleted_at IS NULL)", []interface{}{id}, nil
+        }},
+}
+
+func scimGroupLocation(c *gin.Context, id uint) string {
+        return scim.Location(c, "/scim/v2/Groups/"+strconv.FormatUint(uint64(id), 10))
+}
+
+func loadSCIMDepartment(id string) (models.Department, error) {
+        var department models.Department
+        if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+                return department, scim.NewError(http.StatusNotFound, "", "Group %s not found", id)
+        }
+        err := database.DB.First(&department, id).Error
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+                return department, scim.NewError(http.StatusNotFound, "", "Group %s not found", id)
+        }
+        return department, err
+}
+
+// toSCIMGroups renders departments, loading all their members in one query
+// unless withMembers is false.
+func toSCIMGroups(c *gin.Context, departments []models.Department, withMembers bool) ([]scimGroup, error) {
+        members := map[uint][]scimMultiValued{}
+        if withMembers && len(departments) > 0 {
+                ids := make([]uint, 0, len(departments))
+                for _, d := range departments {
+                        ids = append(ids, d.ID)
+                }
+                var employees []models.Employee
+                if err := database.DB.Select("id", "name", "department_id").Where("department_id IN ?", ids).
+                        Order("id").Find(&employees).Error; err != nil {
+                        return nil, err
+                }
+                for _, e := range employees {
+                        members[e.DepartmentID] = append(members[e.DepartmentID], scimMultiValued{
+                                Value:   strconv.FormatUint(uint64(e.ID), 10),
+                                Display: e.Name,
+                                Type:    "User",
+                                Ref:     scimUserLocation(c, e.ID),
+                        })
+                }
+        }
+
+        groups := make([]scimGroup, 0, len(departments))
+        for _, d := range departments {
+                groups = append(groups, scimGroup{
+                        Schemas:     []string{scim.GroupSchema},
+                        ID:          strconv.FormatUint(uint64(d.ID), 10),
+                        ExternalID:  d.ExternalID,
+                        DisplayName: d.Name,
+                        Members:     members[d.ID],
+                        Meta: &scim.Meta{
+                                ResourceType: "Group",
+                                Created:      d.CreatedAt,
+                                LastModified: d.UpdatedAt,
+                                Location:     scimGroupLocation(c, d.ID),
+                        },
+                })
+        }
+        return groups, nil
+}
+
+// excludesMembers reports whether the client asked to leave members out,
+// which directories do to keep large groups cheap to read.
+func excludesMembers(c *gin.Context) bool {
+        for _, attr := range strings.Split(c.Query("excludedAttributes"), ",") {
+                if scim.AttrName(strings.TrimSpace(attr)) == "members" {
+                        return true
+                }
+        }
+        return false
+}
+
+// saveSCIMGroup writes g onto department, creating it when its ID is 0.
+// Employees listed as members move into the department; employees no longer
+// listed are left without one.
+func saveSCIMGroup(c *gin.Context, department *models.Department, g scimGroup) error {
+        name := strings.TrimSpace(g.DisplayName)
+        if name == "" {
+                return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "displayName is required")
+        }
+
+        memberIDs := make([]uint, 0, len(g.Members))
+        for _, member := range g.Members {
+                id, err := strconv.ParseUint(member.Value, 10, 64)
+                if err != nil {
+                        return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "member %s not found", member.Value)
+                }
+                memberIDs = append(memberIDs, uint(id))
+        }
+
+        return database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                if departmentNameTaken(tx, name, department.ID) {
+                        return scim.NewError(http.StatusConflict, scim.ErrUniqueness, "A department named %s already exists", name)
+                }
+
+                if len(memberIDs) > 0 {
+                        var found int64
+                        tx.Model(&models.Employee{}).Where("id IN ?", memberIDs).Count(&found)
+                        if int(found) != len(uniqueIDs(memberIDs)) {
+                                return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "one or more members were not found")
+                        }
+                }
+
+                department.Name = name
+                department.ExternalID = g.ExternalID
+                if err := tx.Omit(clause.Associations).Save(department).Error; err != nil {
+                        return err
+                }
+
+                leaving := tx.Where("department_id = ?", department.ID)
+                if len(memberIDs) > 0 {
+                        leaving = leaving.Where("id NOT IN ?", memberIDs)
+                }
+                if _, err := moveEmployees(tx, leaving, "department_id", 0, "Directory sync", actorUserID(c)); err != nil {
+                        return err
+                }
+                if len(memberIDs) > 0 {
+                        joining := tx.Where("id IN ? AND department_id <> ?", memberIDs, department.ID)
+                        if _, err := moveEmployees(tx, joining, "department_id", department.ID, "Directory sync", actorUserID(c)); err != nil {
+                                return err
+                        }
+                }
+                return nil
+        })
+}
+
+func uniqueIDs(ids []uint) map[uint]bool {
+        set := map[uint]bool{}
+        for _, id := range ids {
+                set[id] = true
+        }
+        return set
+}
+
+func respondSCIMGroup(c *gin.Context, status int, department models.Department) {
+        groups, err := toSCIMGroups(c, []models.Department{department}, true)
+        if err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        c.Header("Location", scimGroupLocation(c, department.ID))
+        scim.JSON(c, status, groups[0])
+}
+
+func GetSCIMGroups(c *gin.Context) {
+        page := scim.ParsePage(c)
+        query := database.DB.Model(&models.Department{})
+
+        if filter := c.Query("filter"); filter != "" {
+                expr, err := scim.ParseFilter(filter)
+                if err != nil {
+                        scim.Error(c, http.StatusBadRequest, scim.ErrInvalidFilter, err.Error())
+                        return
+                }
+                where, args, err := scimGroupColumns.Where(expr)
+                if err != nil {
+                        scim.Error(c, http.StatusBadRequest, scim.ErrInvalidFilter, err.Error())
+                        return
+                }
+                query = query.Where(where, args...)
+        }
+
+        var total int64
+        if err := query.Count(&total).Error; err != nil {
+                scim.Fail(c, err)
+                return
+        }
+
+        var departments []models.Department
+        if page.Count > 0 {
+                if err := query.Order("departments.id").Offset(page.Offset()).Limit(page.Count).Find(&departments).Error; err != nil {
+                        scim.Fail(c, err)
+                        return
+                }
+        }
+
+        groups, err := toSCIMGroups(c, departments, !excludesMembers(c))
+        if err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        page.Count = len(groups)
+        scim.List(c, page, total, groups)
+}
+
+func GetSCIMGroup(c *gin.Context) {
+        department, err := loadSCIMDepartment(c.Param("id"))
+        if err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        groups, err := toSCIMGroups(c, []models.Department{department}, !excludesMembers(c))
+        if err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        scim.JSON(c, http.StatusOK, groups[0])
+}
+
+func CreateSCIMGroup(c *gin.Context) {
+        var g scimGroup
+        if err := c.ShouldBindJSON(&g); err != nil {
+                scim.Error(c, http.StatusBadRequest, scim.ErrInvalidSyntax, err.Error())
+                return
+        }
+
+        var department models.Department
+        if err := saveSCIMGroup(c, &department, g); err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        respondSCIMGroup(c, http.StatusCreated, department)
+}
+
+func ReplaceSCIMGroup(c *gin.Context) {
+        var g scimGroup
+        if err := c.ShouldBindJSON(&g); err != nil {
+                scim.Error(c, http.StatusBadRequest, scim.ErrInvalidSyntax, err.Error())
+                return
+        }
+
+        department, err := loadSCIMDepartment(c.Param("id"))
+        if err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        if err := saveSCIMGroup(c, &department, g); err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        respondSCIMGroup(c, http.StatusOK, department)
+}
+
+// PatchSCIMGroup applies the operations to the group as currently rendered,
+// members included, and saves the result like a PUT.
+func PatchSCIMGroup(c *gin.Context) {
+        var patch scim.PatchRequest
+        if err := c.ShouldBindJSON(&patch); err != nil {
+                scim.Error(c, http.StatusBadRequest, scim.ErrInvalidSyntax, err.Error())
+                return
+        }
+
+        department, err := loadSCIMDepartment(c.Param("id"))
+        if err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        groups, err := toSCIMGroups(c, []models.Department{department}, true)
+        if err != nil {
+                scim.Fail(c, err)
+                return
+        }
+
+        var resource map[string]interface{}
+        data, _ := json.Marshal(groups[0])
+        json.Unmarshal(data, &resource)
+
+        if err := patch.Apply(resource); err != nil {
+                scim.Fail(c, err)
+                return
+        }
+
+        var g scimGroup
+        data, _ = json.Marshal(resource)
+        if err := json.Unmarshal(data, &g); err != nil {
+                scim.Error(c, http.StatusBadRequest, scim.ErrInvalidValue, err.Error())
+                return
+        }
+        if err := saveSCIMGroup(c, &department, g); err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        respondSCIMGroup(c, http.StatusOK, department)
+}
+
+// DeleteSCIMGroup deletes an empty department. One that still has employees
+// or sub-departments is refused, so a directory change can't silently strip
+// everyone's department.
+func DeleteSCIMGroup(c *gin.Context) {
+        department, err := loadSCIMDepartment(c.Param("id"))
+        if err != nil {
+                scim.Fail(c, err)
+                return
+        }
+
+        var employees, children int64
+        database.DB.Model(&models.Employee{}).Where("department_id = ?", department.ID).Count(&employees)
+        database.DB.Model(&models.Department{}).Where("parent_id = ?", department.ID).Count(&children)
+        if employees > 0 || children > 0 {
+                scim.Error(c, http.StatusConflict, scim.ErrMutability, "Reassign this department's employees and sub-departments before deleting it")
+                return
+        }
+
+        if err := database.DB.WithContext(audit.Context(c)).Delete(&department).Error; err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,560 @@
+package handlers
+
+import (
+        "encoding/json"
+        "errors"
+        "net/http"
+        "strconv"
+        "strings"
+        "time"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/models"
+        "hcm-backend/scim"
+        "hcm-backend/views"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+        "gorm.io/gorm/clause"
+)
+
+// A SCIM User is an employee together with their user account. userName is
+// the account's username, or the employee's email while they have no account.
+type scimUser struct {
+        Schemas     []string          `json:"schemas"`
+        ID          string            `json:"id,omitempty"`
+        ExternalID  string            `json:"externalId,omitempty"`
+        UserName    string            `json:"userName"`
+        Name        *scimName         `json:"name,omitempty"`
+        DisplayName string            `json:"displayName,omitempty"`
+        Title       string            `json:"title,omitempty"`
+        UserType    string            `json:"userType,omitempty"`
+        Active      *scim.Bool        `json:"active,omitempty"`
+        Emails      []scimMultiValued `json:"emails,omitempty"`
+        Enterprise  *scimEnterprise   `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User,omitempty"`
+        Meta        *scim.Meta        `json:"meta,omitempty"`
+}
+
+type scimName struct {
+        Formatted  string `json:"formatted,omitempty"`
+        GivenName  string `json:"givenName,omitempty"`
+        FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimMultiValued struct {
+        Value   string `json:"value"`
+        Type    string `json:"type,omitempty"`
+        Primary bool   `json:"primary,omitempty"`
+        Display string `json:"display,omitempty"`
+        Ref     string `json:"$ref,omitempty"`
+}
+
+type scimEnterprise struct {
+        EmployeeNumber string       `json:"employeeNumber,omitempty"`
+        Department     string       `json:"department,omitempty"`
+        Manager        *scimManager `json:"manager,omitempty"`
+}
+
+type scimManager struct {
+        Value       string `json:"value,omitempty"`
+        DisplayName string `json:"displayName,omitempty"`
+        Ref         string `json:"$ref,omitempty"`
+}
+
+// UnmarshalJSON also accepts the manager's ID on its own, which Azure AD
+// sends in PATCH requests.
+func (m *scimManager) UnmarshalJSON(data []byte) error {
+        var id string
+        if err := json.Unmarshal(data, &id); err == nil {
+                *m = scimManager{Value: id}
+                return nil
+        }
+        type plain scimManager
+        return json.Unmarshal(data, (*plain)(m))
+}
+
+var scimUserColumns = scim.Columns{
+        "id":                             {Expr: "employees.id", Type: scim.Number},
+        "externalid":                     {Expr: "employees.external_id", Type: scim.ExactString},
+        "username":                       {Expr: "COALESCE((SELECT username FROM users WHERE users.id = employees.user_id), employees.email)"},
+        "displayname":                    {Expr: "employees.name"},
+        "name.formatted":                 {Expr: "employees.name"},
+        "emails.value":                   {Expr: "employees.email"},
+        "title":                          {Expr: "employees.job_title"},
+        "usertype":                       {Expr: "employees.employment_type"},
+        "meta.created":                   {Expr: "employees.created_at", Type: scim.DateTime},
+        "meta.lastmodified":              {Expr: "employees.updated_at", Type: scim.DateTime},
+        enterpriseAttr("employeenumber"): {Expr: "employees.employee_number"},
+        enterpriseAttr("department"):     {Expr: "(SELECT name FROM departments WHERE departments.id = employees.department_id)"},
+        enterpriseAttr("manager.value"):  {Expr: "employees.manager_id", Type: scim.Number},
+        "active": {Build: func(op string, value interface{}) (string, []interface{}, error) {
+                active, ok := value.(bool)
+                if !ok || (op != "eq" && op != "ne") {
+                        return "", nil, errors.New("active can only be compared with eq or ne to true or false")
+                }
+                if active == (op == "eq") {
+                        return "employees.employment_status NOT IN ?", []interface{}{models.DepartedStatuses}, nil
+                }
+                return "employees.employment_status IN ?", []interface{}{models.DepartedStatuses}, nil
+        }},
+}
+
+func enterpriseAttr(name string) string {
+        return strings.ToLower(scim.EnterpriseUserSchema) + ":" + name
+}
+
+func scimUserLocation(c *gin.Context, id uint) string {
+        return scim.Location(c, "/scim/v2/Users/"+strconv.FormatUint(uint64(id), 10))
+}
+
+// loadSCIMEmployee loads an employee with everything a SCIM User shows.
+func loadSCIMEmployee(id string) (models.Employee, error) {
+        var employee models.Employee
+        if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+                return employee, scim.NewError(http.StatusNotFound, "", "User %s not found", id)
+        }
+        err := database.DB.Preload("User").Preload("Department").Preload("Manager", views.PublicEmployee).
+                First(&employee, id).Error
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+                return employee, scim.NewError(http.StatusNotFound, "", "User %s not found", id)
+        }
+        return employee, err
+}
+
+func toSCIMUser(c *gin.Context, e models.Employee) scimUser {
+        userName := e.Email
+        if e.User != nil {
+                userName = e.User.Username
+        }
+        given, family, _ := strings.Cut(e.Name, " ")
+        active := scim.Bool(!models.IsDeparted(e.EmploymentStatus))
+
+        u := scimUser{
+                Schemas:     []string{scim.UserSchema, scim.EnterpriseUserSchema},
+                ID:          strconv.FormatUint(uint64(e.ID), 10),
+                ExternalID:  e.ExternalID,
+                UserName:    userName,
+                Name:        &scimName{Formatted: e.Name, GivenName: given, FamilyName: family},
+                DisplayName: e.Name,
+                Title:       e.JobTitle,
+                UserType:    e.EmploymentType,
+                Active:      &active,
+                Emails:      []scimMultiValued{{Value: e.Email, Type: "work", Primary: true}},
+                Enterprise:  &scimEnterprise{EmployeeNumber: e.EmployeeNumber},
+                Meta: &scim.Meta{
+                        ResourceType: "User",
+                        Created:      e.CreatedAt,
+                        LastModified: e.UpdatedAt,
+                        Location:     scimUserLocation(c, e.ID),
+                },
+        }
+        if e.Department != nil {
+                u.Enterprise.Department = e.Department.Name
+        }
+        if e.Manager != nil {
+                u.Enterprise.Manager = &scimManager{
+                        Value:       strconv.FormatUint(uint64(e.Manager.ID), 10),
+                        DisplayName: e.Manager.Name,
+                        Ref:         scimUserLocation(c, e.Manager.ID),
+                }
+        }
+        return u
+}
+
+// email is the primary email, falling back to the first one and then to a
+// userName that looks like an address.
+func (u scimUser) email() string {
+        for _, email := range u.Emails {
+                if email.Primary && email.Value != "" {
+                        return strings.TrimSpace(email.Value)
+                }
+        }
+        for _, email := range u.Emails {
+                if email.Value != "" {
+                        return strings.TrimSpace(email.Value)
+                }
+        }
+        if strings.Contains(u.UserName, "@") {
+                return strings.TrimSpace(u.UserName)
+        }
+        return ""
+}
+
+func (u scimUser) fullName() string {
+        if u.Name != nil {
+                if u.Name.Formatted != "" {
+                        return u.Name.Formatted
+                }
+                if full := strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName); full != "" {
+                        return full
+                }
+        }
+        if u.DisplayName != "" {
+                return u.DisplayName
+        }
+        return u.UserName
+}
+
+// saveSCIMUser writes u onto employee, creating the employee when its ID is
+// 0, and keeps the linked user account in step. Deactivating an employee
+// offboards them as of today, as a termination does, and revokes their
+// sessions; the record itself is never deleted.
+func saveSCIMUser(c *gin.Context, employee *models.Employee, u scimUser) error {
+        u.UserName = strings.TrimSpace(u.UserName)
+        email := u.email()
+        if u.UserName == "" {
+                return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "userName is required")
+        }
+        if !strings.Contains(email, "@") {
+                return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "a work email is required")
+        }
+
+        wasActive := employee.ID != 0 && !models.IsDeparted(employee.EmploymentStatus)
+        before := *employee
+        deactivated := false
+
+        err := database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                var conflicts int64
+                tx.Unscoped().Model(&models.Employee{}).Where("LOWER(email) = LOWER(?) AND id <> ?", email, employee.ID).Count(&conflicts)
+                if conflicts > 0 {
+                        return scim.NewError(http.StatusConflict, scim.ErrUniqueness, "Another employee already has the email %s", email)
+                }
+
+                employee.Name = u.fullName()
+                employee.Email = email
+                employee.JobTitle = u.Title
+                employee.EmploymentType = u.UserType
+                employee.ExternalID = u.ExternalID
+
+                if u.Enterprise != nil {
+                        employee.EmployeeNumber = u.Enterprise.EmployeeNumber
+
+                        departmentID, err := scimDepartmentID(tx, u.Enterprise.Department)
+                        if err != nil {
+                                return err
+                        }
+                        employee.DepartmentID = departmentID
+
+                        managerID, err := scimManagerID(tx, employee.ID, u.Enterprise.Manager)
+                        if err != nil {
+                                return err
+                        }
+                        employee.ManagerID = managerID
+                }
+
+                // The directory only switches people between active and
+                // inactive. Someone HR terminated comes back through a rehire.
+                switch {
+                case u.Active != nil && !bool(*u.Active):
+                        if !models.IsDeparted(employee.EmploymentStatus) {
+                                employee.EmploymentStatus = models.EmploymentStatusInactive
+                        }
+                case employee.EmploymentStatus == models.EmploymentStatusInactive || employee.EmploymentStatus == "":
+                        employee.EmploymentStatus = models.EmploymentStatusActive
+                case models.IsDeparted(employee.EmploymentStatus):
+                        return scim.NewError(http.StatusConflict, scim.ErrMutability, "User %d was terminated and must be rehired by HR", employee.ID)
+                }
+                deactivated = wasActive && models.IsDeparted(employee.EmploymentStatus)
+
+                // Associations are saved through their own IDs, never upserted
+                if err := tx.Omit(clause.Associations).Save(employee).Error; err != nil {
+                        return err
+                }
+                if err := recordJobChange(tx, before, *employee, "Directory sync", actorUserID(c)); err != nil {
+                        return err
+                }
+                if deactivated {
+                        if _, err := offboard(tx, *employee, dateOnly(time.Now()), actorUserID(c)); err != nil {
+                                return err
+                        }
+                }
+
+                user, err := scimAccount(tx, employee, u.UserName)
+                if err != nil {
+                        return err
+                }
+                employee.UserID = &user.ID
+                return nil
+        })
+        if err != nil {
+                return err
+        }
+
+        if deactivated && employee.UserID != nil {
+                revokeUserSessions(*employee.UserID)
+        }
+        return nil
+}
+
+// scimAccount finds or creates the user account for employee and brings its
+// username and email in line. An unlinked account with the employee's email
+// is adopted. New accounts have no password: they sign in through single
+// sign-on or by resetting their password.
+func scimAccount(tx *gorm.DB, employee *models.Employee, userName string) (models.User, error) {
+        var user models.User
+        var err error
+        if employee.UserID != nil {
+                err = tx.First(&user, *employee.UserID).Error
+        } else {
+                err = tx.Where("LOWER(email) = LOWER(?) AND id NOT IN (?)", employee.Email,
+                        tx.Model(&models.Employee{}).Select("user_id").Where("user_id IS NOT NULL")).
+                        First(&user).Error
+        }
+        if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+                return user, err
+        }
+
+        var conflicts int64
+        tx.Unscoped().Model(&models.User{}).Where("username = ? AND id <> ?", userName, user.ID).Count(&conflicts)
+        if conflicts > 0 {
+                return user, scim.NewError(http.StatusConflict, scim.ErrUniqueness, "userName %s is already taken", userName)
+        }
+        tx.Unscoped().Model(&models.User{}).Where("LOWER(email) = LOWER(?) AND id <> ?", employee.Email, user.ID).Count(&conflicts)
+        if conflicts > 0 {
+                return user, scim.NewError(http.StatusConflict, scim.ErrUniqueness, "Another account already has the email %s", employee.Email)
+        }
+
+        if user.ID == 0 {
+                now := time.Now()
+                user = models.User{
+                        Username:        userName,
+                        Email:           employee.Email,
+                        Role:            models.RoleEmployee,
+                        EmailVerifiedAt: &now,
+                }
+                if err := tx.Create(&user).Error; err != nil {
+                        return user, err
+                }
+        } else if user.Username != userName || user.Email != employee.Email {
+                if err := tx.Model(&user).Updates(map[string]interface{}{"username": userName, "email": employee.Email}).Error; err != nil {
+                        return user, err
+                }
+        }
+
+        if employee.UserID == nil || *employee.UserID != user.ID {
+                if err := tx.Model(employee).Update("user_id", user.ID).Error; err != nil {
+                        return user, err
+                }
+        }
+        return user, nil
+}
+
+// scimDepartmentID resolves a department name, creating the department if
+// the directory knows one we don't. An empty name means no department.
+func scimDepartmentID(tx *gorm.DB, name string) (uint, error) {
+        name = strings.TrimSpace(name)
+        if name == "" {
+                return 0, nil
+        }
+        var department models.Department
+        err := tx.Where("LOWER(name) = LOWER(?)", name).First(&department).Error
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+                department = models.Department{Name: name}
+                err = tx.Create(&department).Error
+        }
+        return department.ID, err
+}
+
+func scimManagerID(tx *gorm.DB, employeeID uint, manager *scimManager) (*uint, error) {
+        if manager == nil || manager.Value == "" {
+                return nil, nil
+        }
+        id, err := strconv.ParseUint(manager.Value, 10, 64)
+        if err != nil {
+                return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "manager %s not found", manager.Value)
+        }
+        managerID := uint(id)
+        switch err := checkManager(tx, employeeID, &managerID); err {
+        case nil:
+                return &managerID, nil
+        case errOwnManager:
+                return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "an employee cannot be their own manager")
+        case errManagerNotFound:
+                return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "manager %s not found", manager.Value)
+        case errManagerLoop:
+                return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "manager %s would create a reporting loop", manager.Value)
+        default:
+                return nil, err
+        }
+}
+
+func respondSCIMUser(c *gin.Context, status int, id uint) {
+        employee, err := loadSCIMEmployee(strconv.FormatUint(uint64(id), 10))
+        if err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        c.Header("Location", scimUserLocation(c, id))
+        scim.JSON(c, status, toSCIMUser(c, employee))
+}
+
+func GetSCIMUsers(c *gin.Context) {
+        page := scim.ParsePage(c)
+        query := database.DB.Model(&models.Employee{})
+
+        if filter := c.Query("filter"); filter != "" {
+                expr, err := scim.ParseFilter(filter)
+                if err != nil {
+                        scim.Error(c, http.StatusBadRequest, scim.ErrInvalidFilter, err.Error())
+                        return
+                }
+                where, args, err := scimUserColumns.Where(expr)
+                if err != nil {
+                        scim.Error(c, http.StatusBadRequest, scim.ErrInvalidFilter, err.Error())
+                        return
+                }
+                query = query.Where(where, args...)
+        }
+
+        var total int64
+        if err := query.Count(&total).Error; err != nil {
+                scim.Fail(c, err)
+                return
+        }
+
+        var employees []models.Employee
+        if page.Count > 0 {
+                if err := query.Preload("User").Preload("Department").Preload("Manager", views.PublicEmployee).
+                        Order("employees.id").Offset(page.Offset()).Limit(page.Count).Find(&employees).Error; err != nil {
+                        scim.Fail(c, err)
+                        return
+                }
+        }
+
+        resources := make([]scimUser, 0, len(employees))
+        for _, e := range employees {
+                resources = append(resources, toSCIMUser(c, e))
+        }
+        page.Count = len(resources)
+        scim.List(c, page, total, resources)
+}
+
+func GetSCIMUser(c *gin.Context) {
+        employee, err := loadSCIMEmployee(c.Param("id"))
+        if err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        scim.JSON(c, http.StatusOK, toSCIMUser(c, employee))
+}
+
+func CreateSCIMUser(c *gin.Context) {
+        var u scimUser
+        if err := c.ShouldBindJSON(&u); err != nil {
+                scim.Error(c, http.StatusBadRequest, scim.ErrInvalidSyntax, err.Error())
+                return
+        }
+
+        var employee models.Employee
+        if err := saveSCIMUser(c, &employee, u); err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        respondSCIMUser(c, http.StatusCreated, employee.ID)
+}
+
+func ReplaceSCIMUser(c *gin.Context) {
+        var u scimUser
+        if err := c.ShouldBindJSON(&u); err != nil {
+                scim.Error(c, http.StatusBadRequest, scim.ErrInvalidSyntax, err.Error())
+                return
+        }
+
+        employee, err := loadSCIMEmployee(c.Param("id"))
+        if err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        if err := saveSCIMUser(c, &employee, u); err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        respondSCIMUser(c, http.StatusOK, employee.ID)
+}
+
+// PatchSCIMUser applies the operations to the user as currently rendered and
+// saves the result like a PUT.
+func PatchSCIMUser(c *gin.Context) {
+        var patch scim.PatchRequest
+        if err := c.ShouldBindJSON(&patch); err != nil {
+                scim.Error(c, http.StatusBadRequest, scim.ErrInvalidSyntax, err.Error())
+                return
+        }
+
+        employee, err := loadSCIMEmployee(c.Param("id"))
+        if err != nil {
+                scim.Fail(c, err)
+                return
+        }
+
+        var resource map[string]interface{}
+        data, _ := json.Marshal(toSCIMUser(c, employee))
+        json.Unmarshal(data, &resource)
+
+        if err := patch.Apply(resource); err != nil {
+                scim.Fail(c, err)
+                return
+        }
+
+        var u scimUser
+        data, _ = json.Marshal(resource)
+        if err := json.Unmarshal(data, &u); err != nil {
+                scim.Error(c, http.StatusBadRequest, scim.ErrInvalidValue, err.Error())
+                return
+        }
+        name := patchedName(employee.Name, u)
+        u.Name = &scimName{Formatted: name}
+        u.DisplayName = name
+        if err := saveSCIMUser(c, &employee, u); err != nil {
+                scim.Fail(c, err)
+                return
+        }
+        respondSCIMUser(c, http.StatusOK, employee.ID)
+}
+
+// patchedName picks the name a PATCH changed. The rendered User repeats the
+// employee's name in name.formatted, name.givenName/familyName and
+// displayName, so a PATCH touching only one of them must win over the others.
+func patchedName(current string, u scimUser) string {
+        var candidates []string
+        if u.Name != nil {
+                candidates = append(candidates, u.Name.Formatted, strings.TrimSpace(u.Name.GivenName+" "+u.Name.FamilyName))
+        }
+        candidates = append(candidates, u.DisplayName)
+        for _, name := range candidates {
+                if name != "" && name != current {
+                        return name
+                }
+        }
+        return current
+}
+
+// DeleteSCIMUser deprovisions: the employee is marked inactive, offboarded
+// as of today and their sessions revoked, but the record is kept for HR
+// history.
+func DeleteSCIMUser(c *gin.Context) {
+        employee, err := loadSCIMEmployee(c.Param("id"))
+        if err != nil {
+                scim.Fail(c, err)
+                return
+        }
+
+        if !models.IsDeparted(employee.EmploymentStatus) {
+                err := database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                        if err := tx.Model(&employee).Update("employment_status", models.EmploymentStatusInactive).Error; err != nil {
+                                return err
+                        }
+                        _, err := offboard(tx, employee, dateOnly(time.Now()), actorUserID(c))
+                        return err
+                })
+                if err != nil {
+                        scim.Fail(c, err)
+                        return
+                }
+                if employee.UserID != nil {
+                        revokeUserSessions(*employee.UserID)
+                }
+        }
+        c.Status(http.StatusNoContent)
+}
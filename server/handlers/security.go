@@ -0,0 +1,319 @@
+package handlers
+
+import (
+        "fmt"
+        "log"
+        "math"
+        "net/http"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
+
+        "hcm-backend/database"
+        "hcm-backend/models"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+        "gorm.io/gorm/clause"
+)
+
+const (
+        // Failures older than the window are forgotten.
+        throttleWindow = 15 * time.Minute
+
+        // The first few failures are free; after that each one doubles the
+        // wait before the next attempt, up to maxLoginBackoff.
+        freeLoginAttempts = 3
+        maxLoginBackoff   = time.Minute
+
+        userLockoutThreshold = 10
+        ipLockoutThreshold   = 50
+        lockoutDuration      = 15 * time.Minute
+)
+
+const (
+        eventLoginSuccess = "login_success"
+        eventLoginFailure = "login_failure"
+        eventLoginBlocked = "login_blocked"
+        eventLockout      = "lockout"
+        eventUnlock       = "unlock"
+)
+
+var (
+        dummyHash     string
+        dummyHashOnce sync.Once
+)
+
+// dummyPasswordHash is compared against when the username does not exist, so
+// unknown and known usernames take the same bcrypt time to reject.
+func dummyPasswordHash() string {
+        dummyHashOnce.Do(func() {
+                dummyHash, _ = hashPassword("not-a-real-password")
+        })
+        return dummyHash
+}
+
+func userThrottleKey(username string) string {
+        return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func ipThrottleKey(ip string) string {
+        return "ip:" + ip
+}
+
+func loginBackoff(failures int) time.Duration {
+        if failures <= freeLoginAttempts {
+                return 0
+        }
+        backoff := time.Duration(math.Pow(2, float64(failures-freeLoginAttempts))) * time.Second
+        if backoff > maxLoginBackoff {
+                return maxLoginBackoff
+        }
+        return backoff
+}
+
+func recordSecurityEvent(c *gin.Context, event, username string, userID *uint, detail string) {
+        securityEvent := models.SecurityEvent{
+                Event:     event,
+                Username:  username,
+                UserID:    userID,
+                IPAddress: c.ClientIP(),
+                UserAgent: c.Request.UserAgent(),
+                Detail:    detail,
+        }
+        if err := database.DB.Create(&securityEvent).Error; err != nil {
+                log.Println("Failed to record security event:", err)
+        }
+}
+
+// loginAttempt is a login attempt counted as a failure against its username
+// and client IP before its credentials are checked, so a burst of parallel
+// guesses can't all get past the backoff before any of them is recorded. It
+// is settled with fail, or release if the credentials were right.
+type loginAttempt struct {
+        username string
+
+        // keys are the throttles the attempt was counted against, and locked
+        // those it locked, as they were once it had.
+        keys   []string
+        locked map[string]models.LoginThrottle
+}
+
+// lockThrottles creates any missing throttle rows for keys and locks them,
+// in key order so two attempts can't deadlock.
+func lockThrottles(tx *gorm.DB, keys []string) ([]models.LoginThrottle, error) {
+        for _, key := range keys {
+                // Another attempt may be creating the row at the same time
+                if err := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "key"}}, DoNothing: true}).
+                        Create(&models.LoginThrottle{Key: key}).Error; err != nil {
+                        return nil, err
+                }
+        }
+        var throttles []models.LoginThrottle
+        err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key IN ?", keys).Order("key").Find(&throttles).Error
+        return throttles, err
+}
+
+// reserveLoginAttempt counts an attempt at username from the caller's IP. If
+// either is locked or backing off it writes a 429 and returns false instead,
+// without counting the attempt. The check and the count happen under the
+// same row locks.
+func reserveLoginAttempt(c *gin.Context, username string) (*loginAttempt, bool) {
+        attempt := &loginAttempt{username: username, locked: map[string]models.LoginThrottle{}}
+        thresholds := map[string]int{
+                userThrottleKey(username):   userLockoutThreshold,
+                ipThrottleKey(c.ClientIP()): ipLockoutThreshold,
+        }
+        keys := []string{userThrottleKey(username), ipThrottleKey(c.ClientIP())}
+
+        now := time.Now()
+        var wait time.Duration
+        err := database.DB.Transaction(func(tx *gorm.DB) error {
+                throttles, err := lockThrottles(tx, keys)
+                if err != nil {
+                        return err
+                }
+                for _, t := range throttles {
+                        if t.LockedUntil != nil && t.LockedUntil.Sub(now) > wait {
+                                wait = t.LockedUntil.Sub(now)
+                        }
+                        if t.NextAttemptAt.Sub(now) > wait {
+                                wait = t.NextAttemptAt.Sub(now)
+                        }
+                }
+                if wait > 0 {
+                        return nil
+                }
+
+                for _, t := range throttles {
+                        if now.Sub(t.LastFailureAt) > throttleWindow {
+                                t.Failures = 0
+                        }
+                        t.Failures++
+                        t.LastFailureAt = now
+                        t.NextAttemptAt = now.Add(loginBackoff(t.Failures))
+                        if t.Failures >= thresholds[t.Key] && (t.LockedUntil == nil || t.LockedUntil.Before(now)) {
+                                lockedUntil := now.Add(lockoutDuration)
+                                t.LockedUntil = &lockedUntil
+                                attempt.locked[t.Key] = t
+                        }
+                        if err := tx.Save(&t).Error; err != nil {
+                                return err
+                        }
+                }
+                attempt.keys = keys
+                return nil
+        })
+        if err != nil {
+                // Don't lock everyone out because the throttle can't be kept
+                log.Println("Failed to update login throttle:", err)
+                return attempt, true
+        }
+        if wait <= 0 {
+                return attempt, true
+        }
+
+        seconds := int(math.Ceil(wait.Seconds()))
+        recordSecurityEvent(c, eventLoginBlocked, username, nil, fmt.Sprintf("retry after %ds", seconds))
+        c.Header("Retry-After", strconv.Itoa(seconds))
+        c.JSON(http.StatusTooManyRequests, gin.H{
+                "error":       "Too many failed login attempts. Please try again later.",
+                "retry_after": seconds,
+        })
+        return nil, false
+}
+
+// fail settles the attempt as a failed login. It stays counted against both
+// the username and the client IP, so one account can't be hammered from many
+// addresses and one address can't spray many accounts.
+func (a *loginAttempt) fail(c *gin.Context, userID *uint, reason string) {
+        recordSecurityEvent(c, eventLoginFailure, a.username, userID, reason)
+        for key, t := range a.locked {
+                recordSecurityEvent(c, eventLockout, a.username, userID,
+                        fmt.Sprintf("%s locked until %s after %d failures", key, t.LockedUntil.Format(time.RFC3339), t.Failures))
+        }
+}
+
+// release takes back the attempt once its credentials have been found right,
+// along with any lockout it caused.
+func (a *loginAttempt) release() {
+        if len(a.keys) == 0 {
+                return
+        }
+        now := time.Now()
+        err := database.DB.Transaction(func(tx *gorm.DB) error {
+                var throttles []models.LoginThrottle
+                if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key IN ?", a.keys).Order("key").Find(&throttles).Error; err != nil {
+                        return err
+                }
+                for _, t := range throttles {
+                        if t.Failures > 0 {
+                                t.Failures--
+                        }
+                        if next := now.Add(loginBackoff(t.Failures)); next.Before(t.NextAttemptAt) {
+                                t.NextAttemptAt = next
+                        }
+                        if _, ok := a.locked[t.Key]; ok {
+                                t.LockedUntil = nil
+                        }
+                        if err := tx.Save(&t).Error; err != nil {
+                                return err
+                        }
+                }
+                return nil
+        })
+        if err != nil {
+                log.Println("Failed to update login throttle:", err)
+        }
+        a.keys = nil
+}
+
+// recordLoginSuccess clears the username's failure count. The IP counter is
+// left to expire on its own so a single valid credential can't reset it.
+func recordLoginSuccess(c *gin.Context, user models.User) {
+        database.DB.Where("key = ?", userThrottleKey(user.Username)).Delete(&models.LoginThrottle{})
+        recordSecurityEvent(c, eventLoginSuccess, user.Username, &user.ID, "")
+}
+
+func GetSecurityEvents(c *gin.Context) {
+        query := database.DB.Model(&models.SecurityEvent{})
+
+        if event := c.Query("event"); event != "" {
+                query = query.Where("event = ?", event)
+        }
+        if username := c.Query("username"); username != "" {
+                query = query.Where("username = ?", username)
+        }
+        if ip := c.Query("ip"); ip != "" {
+                query = query.Where("ip_address = ?", ip)
+        }
+        if from := c.Query("from"); from != "" {
+                if t, err := time.Parse("2006-01-02", from); err == nil {
+                        query = query.Where("created_at >= ?", t)
+                }
+        }
+        if to := c.Query("to"); to != "" {
+                if t, err := time.Parse("2006-01-02", to); err == nil {
+                        query = query.Where("created_at < ?", t.AddDate(0, 0, 1))
+                }
+        }
+
+        limit := 100
+        if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+                limit = l
+        }
+
+        var events []models.SecurityEvent
+        if err := query.Order("created_at desc").Limit(limit).Find(&events).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch security events"})
+                return
+        }
+
+        c.JSON(http.StatusOK, events)
+}
+
+func GetLockouts(c *gin.Context) {
+        var throttles []models.LoginThrottle
+        if err := database.DB.Where("locked_until > ?", time.Now()).Order("locked_until desc").Find(&throttles).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch lockouts"})
+                return
+        }
+
+        c.JSON(http.StatusOK, throttles)
+}
+
+func UnlockLogin(c *gin.Context) {
+        var input struct {
+                Username string `json:"username"`
+                IP       string `json:"ip"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        var keys []string
+        if input.Username != "" {
+                keys = append(keys, userThrottleKey(input.Username))
+        }
+        if input.IP != "" {
+                keys = append(keys, ipThrottleKey(input.IP))
+        }
+        if len(keys) == 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "username or ip is required"})
+                return
+        }
+
+        if err := database.DB.Where("key IN ?", keys).Delete(&models.LoginThrottle{}).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock"})
+                return
+        }
+
+        adminID := c.GetUint("userID")
+        recordSecurityEvent(c, eventUnlock, input.Username, nil,
+                fmt.Sprintf("unlocked %s by user %d", strings.Join(keys, ", "), adminID))
+
+        c.JSON(http.StatusOK, gin.H{"message": "Unlocked successfully"})
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+        "crypto/rand"
+        "crypto/sha256"
+        "encoding/base64"
+        "encoding/hex"
+        "net/http"
+        "time"
+
+        "hcm-backend/database"
+        "hcm-backend/models"
+
+        "github.com/gin-gonic/gin"
+)
+
+const (
+        accessTokenTTL  = 15 * time.Minute
+        refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// hashToken returns the hex SHA-256 of an opaque token. Only hashes are
+// stored, so a database leak does not hand out usable refresh tokens.
+func hashToken(token string) string {
+        sum := sha256.Sum256([]byte(token))
+        return hex.EncodeToString(sum[:])
+}
+
+func generateOpaqueToken() (string, error) {
+        b := make([]byte, 32)
+        if _, err := rand.Read(b); err != nil {
+                return "", err
+        }
+        return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// createSession starts a new server-side session for user and returns a
+// short-lived access token bound to it plus the session's refresh token.
+func createSession(c *gin.Context, user models.User) (string, string, error) {
+        refreshToken, err := generateOpaqueToken()
+        if err != nil {
+                return "", "", err
+        }
+
+        now := time.Now()
+        session := models.Session{
+                UserID:     user.ID,
+                TokenHash:  hashToken(refreshToken),
+                ExpiresAt:  now.Add(refreshTokenTTL),
+                LastUsedAt: now,
+                UserAgent:  c.Request.UserAgent(),
+                IPAddress:  c.ClientIP(),
+        }
+        if err := database.DB.Create(&session).Error; err != nil {
+                return "", "", err
+        }
+
+        accessToken, err := generateToken(user, session.ID)
+        if err != nil {
+                return "", "", err
+        }
+
+        return accessToken, refreshToken, nil
+}
+
+// revokeUserSessions ends every active session of a user, which invalidates
+// their access tokens on the next request and their refresh tokens at once.
+func revokeUserSessions(userID uint) (int64, error) {
+        result := database.DB.Model(&models.Session{}).
+                Where("user_id = ? AND revoked_at IS NULL", userID).
+                Update("revoked_at", time.Now())
+        return result.RowsAffected, result.Error
+}
+
+func RefreshToken(c *gin.Context) {
+        var input struct {
+                RefreshToken string `json:"refresh_token" binding:"required"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        tokenHash := hashToken(input.RefreshToken)
+
+        var session models.Session
+        if err := database.DB.Where("token_hash = ?", tokenHash).First(&session).Error; err != nil {
+                // A rotated-out token being presented again means it was copied;
+                // kill the whole session so neither party can keep using it.
+                if database.DB.Where("previous_token_hash = ?", tokenHash).First(&session).Error == nil {
+                        database.DB.Model(&session).Update("revoked_at", time.Now())
+                }
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+                return
+        }
+
+        if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please log in again"})
+                return
+        }
+
+        var user models.User
+        if err := database.DB.First(&user, session.UserID).Error; err != nil {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+                return
+        }
+
+        if accountDeactivated(user.ID) {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please log in again"})
+                return
+        }
+
+        newRefreshToken, err := generateOpaqueToken()
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+                return
+        }
+
+        // Rotate conditionally so two concurrent refreshes with the same token
+        // cannot both succeed.
+        result := database.DB.Model(&models.Session{}).
+                Where("id = ? AND token_hash = ?", session.ID, tokenHash).
+                Updates(map[string]interface{}{
+                        "token_hash":          hashToken(newRefreshToken),
+                        "previous_token_hash": tokenHash,
+                        "last_used_at":        time.Now(),
+                        "ip_address":          c.ClientIP(),
+                })
+        if result.Error != nil || result.RowsAffected != 1 {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+                return
+        }
+
+        accessToken, err := generateToken(user, session.ID)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+                return
+        }
+
+        c.JSON(http.StatusOK, gin.H{
+                "token":         accessToken,
+                "refresh_token": newRefreshToken,
+                "expires_in":    int(accessTokenTTL.Seconds()),
+        })
+}
+
+func Logout(c *gin.Context) {
+        userID := c.GetUint("userID")
+        sessionID := c.GetUint("sessionID")
+
+        if c.Query("all") == "true" {
+                count, err := revokeUserSessions(userID)
+                if err != nil {
+                        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+                        return
+                }
+                c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions", "revoked": count})
+                return
+        }
+
+        if err := database.DB.Model(&models.Session{}).
+                Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+                Update("revoked_at", time.Now()).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+                return
+        }
+
+        c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+func GetUserSessions(c *gin.Context) {
+        id := c.Param("id")
+
+        var sessions []models.Session
+        if err := database.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", id, time.Now()).
+                Order("last_used_at desc").Find(&sessions).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+                return
+        }
+
+        c.JSON(http.StatusOK, sessions)
+}
+
+func RevokeUserSessions(c *gin.Context) {
+        id := c.Param("id")
+
+        var user models.User
+        if err := database.DB.First(&user, id).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+                return
+        }
+
+        count, err := revokeUserSessions(user.ID)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+                return
+        }
+
+        c.JSON(http.StatusOK, gin.H{"message": "All sessions revoked", "revoked": count})
+}
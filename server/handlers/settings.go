@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"hcm-backend/audit"
 	"hcm-backend/database"
 	"hcm-backend/models"
 )
@@ -27,6 +29,7 @@ func GetSetting(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", etag(setting.Version))
 	c.JSON(http.StatusOK, setting)
 }
 
@@ -46,6 +49,15 @@ func UpsertSetting(c *gin.Context) {
 	
 	// Check if setting exists
 	err := database.DB.Where("key = ?", input.Key).First(&setting).Error
+	if err == nil && c.GetHeader("If-None-Match") == "*" {
+		c.Header("ETag", etag(setting.Version))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Setting already exists"})
+		return
+	}
+	if err != nil && c.GetHeader("If-Match") != "" {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Setting not found"})
+		return
+	}
 	if err != nil {
 		// Create new setting
 		setting = models.ChatbotSettings{
@@ -53,27 +65,36 @@ func UpsertSetting(c *gin.Context) {
 			Value:       input.Value,
 			Description: input.Description,
 		}
-		if err := database.DB.Create(&setting).Error; err != nil {
+		if err := database.DB.WithContext(audit.Context(c)).Create(&setting).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create setting"})
 			return
 		}
 	} else {
 		// Update existing setting
+		if !ifMatch(c, setting.Version, false) {
+			return
+		}
+		version := setting.Version
 		setting.Value = input.Value
 		setting.Description = input.Description
-		if err := database.DB.Save(&setting).Error; err != nil {
+		if err := saveVersioned(database.DB.WithContext(audit.Context(c)), &setting, version); err != nil {
+			if errors.Is(err, errVersionConflict) {
+				versionConflict(c, currentVersion(&models.ChatbotSettings{}, setting.ID))
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update setting"})
 			return
 		}
 	}
 
+	c.Header("ETag", etag(setting.Version))
 	c.JSON(http.StatusOK, setting)
 }
 
 func DeleteSetting(c *gin.Context) {
 	key := c.Param("key")
 	
-	if err := database.DB.Where("key = ?", key).Delete(&models.ChatbotSettings{}).Error; err != nil {
+	if err := database.DB.WithContext(audit.Context(c)).Where("key = ?", key).Delete(&models.ChatbotSettings{}).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete setting"})
 		return
 	}
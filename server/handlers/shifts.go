@@ -0,0 +1,581 @@
+package handlers
+
+import (
+        "errors"
+        "fmt"
+        "net/http"
+        "slices"
+        "strings"
+        "time"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/models"
+        "hcm-backend/schedule"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+)
+
+// maxScheduleDays is the longest range GetAttendanceSchedule reports on.
+const maxScheduleDays = 62
+
+// attendanceReportEmployees reads the required from/to range of an
+// attendance report, of at most maxDays, and loads the page of employees it
+// covers: filtered by employee_id and department_id and paged like the
+// employee list, with X-Total-Count counting employees. It reports false
+// once it has responded with an error.
+func attendanceReportEmployees(c *gin.Context, maxDays int) (time.Time, time.Time, []models.Employee, bool) {
+        from, to, err := parseDateRange(c)
+        if err == nil && (from == nil || to == nil) {
+                err = errors.New("from and to are required")
+        }
+        if err == nil && to.Sub(*from) > time.Duration(maxDays)*24*time.Hour {
+                err = fmt.Errorf("The range can be at most %d days", maxDays)
+        }
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return time.Time{}, time.Time{}, nil, false
+        }
+        params, err := parseListParams(c, "employees", map[string]string{"id": "employees.id", "name": "employees.name"}, "name")
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return time.Time{}, time.Time{}, nil, false
+        }
+
+        query := database.DB.Model(&models.Employee{})
+        if query, err = filterIDs(c, query, "employee_id", "employees.id"); err == nil {
+                query, err = filterIDs(c, query, "department_id", "employees.department_id")
+        }
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return time.Time{}, time.Time{}, nil, false
+        }
+        var employees []models.Employee
+        if err := params.find(c, query, &employees); err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return time.Time{}, time.Time{}, nil, false
+        }
+        return *from, *to, employees, true
+}
+
+type shiftInput struct {
+        Name              string `json:"name" binding:"required"`
+        StartTime         string `json:"start_time" binding:"required"`
+        EndTime           string `json:"end_time" binding:"required"`
+        Days              []int  `json:"days"`
+        GraceMinutes      int    `json:"grace_minutes"`
+        BreakMinutes      int    `json:"break_minutes"`
+        BreakAfterMinutes int    `json:"break_after_minutes"`
+}
+
+func (input shiftInput) applyTo(shift *models.Shift) error {
+        shift.Name = strings.TrimSpace(input.Name)
+        shift.StartTime = input.StartTime
+        shift.EndTime = input.EndTime
+        shift.Days = slices.Clone(input.Days)
+        slices.Sort(shift.Days)
+        shift.GraceMinutes = input.GraceMinutes
+        shift.BreakMinutes = input.BreakMinutes
+        shift.BreakAfterMinutes = input.BreakAfterMinutes
+        return schedule.Check(*shift)
+}
+
+// loadRosters returns the shift rosters of the given employees, by employee,
+// in each one's timezone. Employees without an assignment get an empty
+// roster.
+func loadRosters(db *gorm.DB, employees []models.Employee) (map[uint]schedule.Roster, error) {
+        locations, err := employeeLocations(db, employees)
+        if err != nil {
+                return nil, err
+        }
+        employeeIDs := make([]uint, len(employees))
+        for i, employee := range employees {
+                employeeIDs[i] = employee.ID
+        }
+
+        var assignments []models.ShiftAssignment
+        if err := db.Where("employee_id IN ?", employeeIDs).Order("start_date, id").Find(&assignments).Error; err != nil {
+                return nil, err
+        }
+        var shifts []models.Shift
+        if err := db.Find(&shifts).Error; err != nil {
+                return nil, err
+        }
+        byID := make(map[uint]models.Shift, len(shifts))
+        for _, shift := range shifts {
+                byID[shift.ID] = shift
+        }
+
+        rosters := make(map[uint]schedule.Roster, len(employeeIDs))
+        for _, id := range employeeIDs {
+                rosters[id] = schedule.Roster{Shifts: byID, Location: locations[id]}
+        }
+        for _, assignment := range assignments {
+                roster := rosters[assignment.EmployeeID]
+                roster.Assignments = append(roster.Assignments, assignment)
+                rosters[assignment.EmployeeID] = roster
+        }
+        return rosters, nil
+}
+
+// shiftKey identifies one occurrence of a shift.
+type shiftKey struct {
+        ShiftID uint
+        Day     time.Time
+}
+
+// groupByShift sorts attendance rows by the shift each belongs to. Rows that
+// belong to none are returned separately.
+func groupByShift(roster schedule.Roster, rows []models.Attendance) (map[shiftKey][]models.Attendance, map[shiftKey]schedule.Instance, []models.Attendance) {
+        groups := map[shiftKey][]models.Attendance{}
+        instances := map[shiftKey]schedule.Instance{}
+        var unscheduled []models.Attendance
+        for _, row := range rows {
+                instance, ok := roster.Match(row.ClockIn)
+                if !ok {
+                        unscheduled = append(unscheduled, row)
+                        continue
+                }
+                key := shiftKey{instance.Shift.ID, instance.Day}
+                groups[key] = append(groups[key], row)
+                instances[key] = instance
+        }
+        return groups, instances, unscheduled
+}
+
+// evaluateAttendance evaluates the employee's attendance clocked in between
+// from and to against their shifts, and stores the result on each row. Rows
+// just outside the range are evaluated too, so a shift's rows are always
+// evaluated together.
+func evaluateAttendance(db *gorm.DB, employeeID uint, from, to time.Time) error {
+        var employee models.Employee
+        if err := db.First(&employee, employeeID).Error; err != nil {
+                return err
+        }
+        rosters, err := loadRosters(db, []models.Employee{employee})
+        if err != nil {
+                return err
+        }
+        var rows []models.Attendance
+        if err := db.Where("employee_id = ? AND clock_in >= ? AND clock_in < ?", employeeID, from.AddDate(0, 0, -2), to.AddDate(0, 0, 2)).
+                Find(&rows).Error; err != nil {
+                return err
+        }
+
+        groups, instances, unscheduled := groupByShift(rosters[employeeID], rows)
+        for key, group := range groups {
+                evaluation := schedule.Evaluate(instances[key], group)
+                shiftID := key.ShiftID
+                for _, row := range group {
+                        if err := storeEvaluation(db, row, &shiftID, evaluation); err != nil {
+                                return err
+                        }
+                }
+        }
+        for _, row := range unscheduled {
+                if err := storeEvaluation(db, row, nil, schedule.Evaluation{Status: models.AttendanceUnscheduled}); err != nil {
+                        return err
+                }
+        }
+        return nil
+}
+
+func storeEvaluation(db *gorm.DB, row models.Attendance, shiftID *uint, evaluation schedule.Evaluation) error {
+        return db.Model(&row).UpdateColumns(map[string]interface{}{
+                "shift_id":            shiftID,
+                "status":              evaluation.Status,
+                "late_minutes":        evaluation.LateMinutes,
+                "early_leave_minutes": evaluation.EarlyLeaveMinutes,
+                "overtime_minutes":    evaluation.OvertimeMinutes,
+        }).Error
+}
+
+// evaluateAssignment re-evaluates the attendance an assignment covers, up to
+// now.
+func evaluateAssignment(db *gorm.DB, assignment models.ShiftAssignment) error {
+        to := time.Now()
+        if assignment.EndDate != nil && assignment.EndDate.AddDate(0, 0, 1).Before(to) {
+                to = assignment.EndDate.AddDate(0, 0, 1)
+        }
+        if !assignment.StartDate.Before(to) {
+                return nil
+        }
+        return evaluateAttendance(db, assignment.EmployeeID, assignment.StartDate, to)
+}
+
+func GetShifts(c *gin.Context) {
+        var shifts []models.Shift
+        if err := database.DB.Order("name, id").Find(&shifts).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shifts"})
+                return
+        }
+        c.JSON(http.StatusOK, shifts)
+}
+
+func CreateShift(c *gin.Context) {
+        var input shiftInput
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        var shift models.Shift
+        if err := input.applyTo(&shift); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        if err := database.DB.WithContext(audit.Context(c)).Create(&shift).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create shift"})
+                return
+        }
+        c.JSON(http.StatusCreated, shift)
+}
+
+// UpdateShift changes a shift and re-evaluates the attendance of everyone
+// assigned to it.
+func UpdateShift(c *gin.Context) {
+        var input shiftInput
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        var shift models.Shift
+        if err := database.DB.First(&shift, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Shift not found"})
+                return
+        }
+        if err := input.applyTo(&shift); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        err := database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                if err := tx.Save(&shift).Error; err != nil {
+                        return err
+                }
+                var assignments []models.ShiftAssignment
+                if err := tx.Find(&assignments).Error; err != nil {
+                        return err
+                }
+                for _, assignment := range assignments {
+                        if !slices.Contains(assignment.ShiftIDs, shift.ID) {
+                                continue
+                        }
+                        if err := evaluateAssignment(tx, assignment); err != nil {
+                                return err
+                        }
+                }
+                return nil
+        })
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update shift"})
+                return
+        }
+        c.JSON(http.StatusOK, shift)
+}
+
+// DeleteShift deletes a shift no roster uses any more.
+func DeleteShift(c *gin.Context) {
+        var shift models.Shift
+        if err := database.DB.First(&shift, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Shift not found"})
+                return
+        }
+        var assignments []models.ShiftAssignment
+        if err := database.DB.Find(&assignments).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete shift"})
+                return
+        }
+        for _, assignment := range assignments {
+                if slices.Contains(assignment.ShiftIDs, shift.ID) {
+                        c.JSON(http.StatusConflict, gin.H{"error": "The shift is still assigned to employees"})
+                        return
+                }
+        }
+        if err := database.DB.WithContext(audit.Context(c)).Delete(&shift).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete shift"})
+                return
+        }
+        c.JSON(http.StatusOK, gin.H{"message": "Shift deleted successfully"})
+}
+
+// GetShiftAssignments returns the employee's shift assignments, oldest first.
+func GetShiftAssignments(c *gin.Context) {
+        var employee models.Employee
+        if err := database.DB.Select("id").First(&employee, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+                return
+        }
+        assignments := []models.ShiftAssignment{}
+        if err := database.DB.Where("employee_id = ?", employee.ID).Order("start_date, id").Find(&assignments).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shift assignments"})
+                return
+        }
+        c.JSON(http.StatusOK, assignments)
+}
+
+// CreateShiftAssignment puts the employee on a shift, or a rotating roster of
+// shifts, from start_date. An employee can only be on one roster at a time.
+// Attendance the assignment covers is evaluated again.
+func CreateShiftAssignment(c *gin.Context) {
+        var input struct {
+                ShiftIDs     []uint `json:"shift_ids" binding:"required"`
+                RotationDays int    `json:"rotation_days"`
+                StartDate    string `json:"start_date" binding:"required"`
+                EndDate      string `json:"end_date"`
+        }
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        var employee models.Employee
+        if err := database.DB.Select("id").First(&employee, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Employee not found"})
+                return
+        }
+
+        startDate, err := parseLifecycleDate(input.StartDate, "start_date")
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        endDate, err := parseLifecycleDate(input.EndDate, "end_date")
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        if endDate != nil && endDate.Before(*startDate) {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "end_date cannot be before start_date"})
+                return
+        }
+
+        var shiftIDs []uint
+        for _, id := range input.ShiftIDs {
+                if id != 0 && !slices.Contains(shiftIDs, id) {
+                        shiftIDs = append(shiftIDs, id)
+                }
+        }
+        if len(shiftIDs) == 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "shift_ids must name at least one shift"})
+                return
+        }
+        var count int64
+        database.DB.Model(&models.Shift{}).Where("id IN ?", shiftIDs).Count(&count)
+        if int(count) != len(shiftIDs) {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Shift not found"})
+                return
+        }
+        if len(input.ShiftIDs) > 1 && input.RotationDays < 1 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "A rotating roster needs rotation_days of at least 1"})
+                return
+        }
+
+        assignment := models.ShiftAssignment{
+                EmployeeID:   employee.ID,
+                ShiftIDs:     input.ShiftIDs,
+                RotationDays: input.RotationDays,
+                StartDate:    *startDate,
+                EndDate:      endDate,
+        }
+        if len(input.ShiftIDs) == 1 {
+                assignment.RotationDays = 0
+        }
+
+        overlap := database.DB.Model(&models.ShiftAssignment{}).
+                Where("employee_id = ? AND (end_date IS NULL OR end_date >= ?)", employee.ID, assignment.StartDate)
+        if endDate != nil {
+                overlap = overlap.Where("start_date <= ?", *endDate)
+        }
+        overlap.Count(&count)
+        if count > 0 {
+                c.JSON(http.StatusConflict, gin.H{"error": "The employee already has a shift assignment in this period"})
+                return
+        }
+
+        err = database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                if err := tx.Create(&assignment).Error; err != nil {
+                        return err
+                }
+                return evaluateAssignment(tx, assignment)
+        })
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create shift assignment"})
+                return
+        }
+        c.JSON(http.StatusCreated, assignment)
+}
+
+// DeleteShiftAssignment takes an employee off a roster. The attendance it
+// covered is evaluated again, so becomes unscheduled.
+func DeleteShiftAssignment(c *gin.Context) {
+        var assignment models.ShiftAssignment
+        if err := database.DB.First(&assignment, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Shift assignment not found"})
+                return
+        }
+        err := database.DB.WithContext(audit.Context(c)).Transaction(func(tx *gorm.DB) error {
+                if err := tx.Delete(&assignment).Error; err != nil {
+                        return err
+                }
+                return evaluateAssignment(tx, assignment)
+        })
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete shift assignment"})
+                return
+        }
+        c.JSON(http.StatusOK, gin.H{"message": "Shift assignment deleted successfully"})
+}
+
+// endShiftAssignments ends the employee's assignments on their last working
+// day, deleting any that would only have started after it, and returns how
+// many it changed.
+func endShiftAssignments(tx *gorm.DB, employeeID uint, lastWorkingDay time.Time) (int64, error) {
+        deleted := tx.Where("employee_id = ? AND start_date > ?", employeeID, lastWorkingDay).Delete(&models.ShiftAssignment{})
+        if deleted.Error != nil {
+                return 0, deleted.Error
+        }
+        ended := tx.Model(&models.ShiftAssignment{}).
+                Where("employee_id = ? AND (end_date IS NULL OR end_date > ?)", employeeID, lastWorkingDay).
+                Update("end_date", lastWorkingDay)
+        return deleted.RowsAffected + ended.RowsAffected, ended.Error
+}
+
+// scheduleDay is one line of GetAttendanceSchedule: a shift the employee was
+// due to work, or a day they clocked in without one.
+type scheduleDay struct {
+        EmployeeID     uint       `json:"employee_id"`
+        EmployeeName   string     `json:"employee_name"`
+        Date           string     `json:"date"`
+        ShiftID        *uint      `json:"shift_id"`
+        ShiftName      string     `json:"shift_name,omitempty"`
+        ScheduledStart *time.Time `json:"scheduled_start"`
+        ScheduledEnd   *time.Time `json:"scheduled_end"`
+        schedule.Evaluation
+        AttendanceIDs []uint `json:"attendance_ids"`
+}
+
+func attendanceIDs(rows []models.Attendance) []uint {
+        ids := []uint{}
+        for _, row := range rows {
+                ids = append(ids, row.ID)
+        }
+        slices.Sort(ids)
+        return ids
+}
+
+// GetAttendanceSchedule compares employees' attendance with their shifts,
+// day by day from from to to. Shifts nobody clocked in for are absent once
+// they have ended, or on leave if the employee had approved leave that day;
+// days outside their employment are left out. Employee filters and paging
+// are those of the employee list, and X-Total-Count counts employees.
+func GetAttendanceSchedule(c *gin.Context) {
+        from, to, employees, ok := attendanceReportEmployees(c, maxScheduleDays)
+        if !ok {
+                return
+        }
+        employeeIDs := make([]uint, len(employees))
+        for i, employee := range employees {
+                employeeIDs[i] = employee.ID
+        }
+
+        rosters, err := loadRosters(database.DB, employees)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shift assignments"})
+                return
+        }
+        var rows []models.Attendance
+        if err := database.DB.Where("employee_id IN ? AND clock_in >= ? AND clock_in < ?", employeeIDs, from.AddDate(0, 0, -2), to.AddDate(0, 0, 2)).
+                Find(&rows).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attendance"})
+                return
+        }
+        var leave []models.LeaveRequest
+        if err := database.DB.Where("employee_id IN ? AND status = ? AND start_date < ? AND end_date >= ?", employeeIDs, "approved", to, from).
+                Find(&leave).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch leave"})
+                return
+        }
+        rowsByEmployee := map[uint][]models.Attendance{}
+        for _, row := range rows {
+                rowsByEmployee[row.EmployeeID] = append(rowsByEmployee[row.EmployeeID], row)
+        }
+        onLeave := func(employeeID uint, day time.Time) bool {
+                for _, request := range leave {
+                        if request.EmployeeID == employeeID && !day.Before(dateOnly(request.StartDate)) && !day.After(dateOnly(request.EndDate)) {
+                                return true
+                        }
+                }
+                return false
+        }
+
+        now := time.Now()
+        days := []scheduleDay{}
+        for _, employee := range employees {
+                roster := rosters[employee.ID]
+                groups, instances, unscheduled := groupByShift(roster, rowsByEmployee[employee.ID])
+
+                unscheduledByDay := map[time.Time][]models.Attendance{}
+                for _, row := range unscheduled {
+                        unscheduledByDay[row.Date] = append(unscheduledByDay[row.Date], row)
+                }
+
+                for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+                        if instance, ok := roster.On(day); ok {
+                                key := shiftKey{instance.Shift.ID, instance.Day}
+                                if group, ok := groups[key]; ok {
+                                        instance = instances[key]
+                                        days = append(days, shiftDay(employee, instance, schedule.Evaluate(instance, group), group))
+                                } else if employed(employee, day) {
+                                        evaluation := schedule.Evaluation{Status: models.AttendanceAbsent}
+                                        if onLeave(employee.ID, day) {
+                                                evaluation.Status = models.AttendanceOnLeave
+                                        } else if instance.End.After(now) {
+                                                evaluation.Status = models.AttendanceScheduled
+                                        }
+                                        days = append(days, shiftDay(employee, instance, evaluation, nil))
+                                }
+                        }
+                        if group := unscheduledByDay[day]; len(group) > 0 {
+                                var worked time.Duration
+                                for _, row := range group {
+                                        if row.ClockOut != nil {
+                                                worked += row.ClockOut.Sub(row.ClockIn)
+                                        }
+                                }
+                                days = append(days, scheduleDay{
+                                        EmployeeID:    employee.ID,
+                                        EmployeeName:  employee.Name,
+                                        Date:          day.Format("2006-01-02"),
+                                        Evaluation:    schedule.Evaluation{Status: models.AttendanceUnscheduled, WorkedMinutes: int(worked.Minutes())},
+                                        AttendanceIDs: attendanceIDs(group),
+                                })
+                        }
+                }
+        }
+        c.JSON(http.StatusOK, days)
+}
+
+func shiftDay(employee models.Employee, instance schedule.Instance, evaluation schedule.Evaluation, rows []models.Attendance) scheduleDay {
+        shiftID := instance.Shift.ID
+        return scheduleDay{
+                EmployeeID:     employee.ID,
+                EmployeeName:   employee.Name,
+                Date:           instance.Day.Format("2006-01-02"),
+                ShiftID:        &shiftID,
+                ShiftName:      instance.Shift.Name,
+                ScheduledStart: &instance.Start,
+                ScheduledEnd:   &instance.End,
+                Evaluation:     evaluation,
+                AttendanceIDs:  attendanceIDs(rows),
+        }
+}
+
+// employed reports whether day falls between the employee's hire date and
+// last working day.
+func employed(employee models.Employee, day time.Time) bool {
+        if employee.HireDate.Year() > 1 && day.Before(dateOnly(employee.HireDate)) {
+                return false
+        }
+        return employee.LastWorkingDay == nil || !day.After(dateOnly(*employee.LastWorkingDay))
+}
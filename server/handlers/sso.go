@@ -0,0 +1,345 @@
+package handlers
+
+import (
+        "errors"
+        "fmt"
+        "log"
+        "net/http"
+        "net/url"
+        "strings"
+        "time"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/models"
+        "hcm-backend/oidc"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+)
+
+const (
+        tokenPurposeSSOLogin = "sso_login"
+
+        oidcLoginStateTTL = 10 * time.Minute
+        ssoLoginCodeTTL   = time.Minute
+)
+
+func isSSOUser(user models.User) bool {
+        return user.OIDCSubject != nil
+}
+
+// ssoFailure is a reason a single sign-on attempt was turned away that is
+// safe to show the user.
+type ssoFailure struct {
+        message string
+}
+
+func (f ssoFailure) Error() string {
+        return f.message
+}
+
+func GetSSOConfig(c *gin.Context) {
+        if oidc.Default == nil {
+                c.JSON(http.StatusOK, gin.H{"enabled": false})
+                return
+        }
+        c.JSON(http.StatusOK, gin.H{"enabled": true, "login_url": "/api/auth/oidc/login"})
+}
+
+// OIDCLogin starts a single sign-on attempt by sending the browser to the
+// identity provider.
+func OIDCLogin(c *gin.Context) {
+        if oidc.Default == nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+                return
+        }
+
+        state, err := generateOpaqueToken()
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-on"})
+                return
+        }
+        nonce, err := generateOpaqueToken()
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-on"})
+                return
+        }
+        verifier, err := oidc.NewVerifier()
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-on"})
+                return
+        }
+
+        authURL, err := oidc.Default.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+        if err != nil {
+                log.Println("OIDC login failed:", err)
+                c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+                return
+        }
+
+        now := time.Now()
+        database.DB.Where("expires_at < ?", now).Delete(&models.OIDCLoginState{})
+        loginState := models.OIDCLoginState{
+                StateHash:    hashToken(state),
+                Nonce:        nonce,
+                CodeVerifier: verifier,
+                ExpiresAt:    now.Add(oidcLoginStateTTL),
+        }
+        if err := database.DB.Create(&loginState).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-on"})
+                return
+        }
+
+        c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback is where the identity provider returns the browser. On
+// success the browser is sent back to the app's login page with a one-time
+// code, which the app exchanges for a session through ExchangeSSOCode; the
+// tokens themselves never appear in a URL.
+func OIDCCallback(c *gin.Context) {
+        if oidc.Default == nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+                return
+        }
+
+        if providerError := c.Query("error"); providerError != "" {
+                recordSecurityEvent(c, eventLoginFailure, "", nil, "sso: provider returned "+providerError)
+                redirectToLogin(c, "sso_error", "Sign-on was cancelled or refused by the identity provider")
+                return
+        }
+
+        loginState, ok := consumeOIDCLoginState(c.Query("state"))
+        if !ok {
+                redirectToLogin(c, "sso_error", "Sign-on link expired, please try again")
+                return
+        }
+
+        ctx := c.Request.Context()
+        rawIDToken, err := oidc.Default.Exchange(ctx, c.Query("code"), loginState.CodeVerifier)
+        if err != nil {
+                log.Println("OIDC code exchange failed:", err)
+                recordSecurityEvent(c, eventLoginFailure, "", nil, "sso: code exchange failed")
+                redirectToLogin(c, "sso_error", "Sign-on failed, please try again")
+                return
+        }
+
+        identity, err := oidc.Default.Verify(ctx, rawIDToken, loginState.Nonce)
+        if err != nil {
+                log.Println("OIDC token rejected:", err)
+                recordSecurityEvent(c, eventLoginFailure, "", nil, "sso: "+err.Error())
+                redirectToLogin(c, "sso_error", "Sign-on failed, please try again")
+                return
+        }
+
+        user, err := provisionSSOUser(c, identity)
+        if err != nil {
+                var failure ssoFailure
+                if !errors.As(err, &failure) {
+                        log.Println("OIDC user provisioning failed:", err)
+                        failure = ssoFailure{"Sign-on failed, please try again"}
+                }
+                recordSecurityEvent(c, eventLoginFailure, identity.Email, nil, "sso: "+err.Error())
+                redirectToLogin(c, "sso_error", failure.message)
+                return
+        }
+
+        code, err := issueUserToken(user.ID, tokenPurposeSSOLogin, ssoLoginCodeTTL)
+        if err != nil {
+                redirectToLogin(c, "sso_error", "Sign-on failed, please try again")
+                return
+        }
+        redirectToLogin(c, "sso_code", code)
+}
+
+// ExchangeSSOCode trades the one-time code from OIDCCallback for a session,
+// or for a two-factor challenge like Login.
+func ExchangeSSOCode(c *gin.Context) {
+        var input struct {
+                Code string `json:"code" binding:"required"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        userID, err := consumeUserToken(input.Code, tokenPurposeSSOLogin)
+        if err != nil {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired sign-on code"})
+                return
+        }
+
+        var user models.User
+        if err := database.DB.First(&user, userID).Error; err != nil {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired sign-on code"})
+                return
+        }
+
+        // Users who enrolled in 2FA still need their code, so signing in
+        // through the identity provider isn't a way around it
+        respondWithLogin(c, user)
+}
+
+func redirectToLogin(c *gin.Context, param, value string) {
+        c.Redirect(http.StatusFound, appBaseURL()+"/login?"+url.Values{param: {value}}.Encode())
+}
+
+// consumeOIDCLoginState looks up and deletes the stored attempt for state.
+// The delete is conditional so a state can only be used once.
+func consumeOIDCLoginState(state string) (models.OIDCLoginState, bool) {
+        var loginState models.OIDCLoginState
+        if state == "" {
+                return loginState, false
+        }
+        if err := database.DB.Where("state_hash = ?", hashToken(state)).First(&loginState).Error; err != nil {
+                return loginState, false
+        }
+
+        result := database.DB.Where("id = ?", loginState.ID).Delete(&models.OIDCLoginState{})
+        if result.Error != nil || result.RowsAffected != 1 {
+                return loginState, false
+        }
+        return loginState, time.Now().Before(loginState.ExpiresAt)
+}
+
+// provisionSSOUser finds or creates the account for a verified identity.
+// Accounts are matched by the provider's subject first, then by the email on
+// an employee record, so only people HR has on file can sign in. When group
+// mapping is configured the provider also decides the user's role.
+func provisionSSOUser(c *gin.Context, identity *oidc.Identity) (models.User, error) {
+        db := database.DB.WithContext(audit.Context(c))
+
+        var user models.User
+        err := database.DB.Where("oidc_subject = ?", identity.Subject).First(&user).Error
+        if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+                return user, err
+        }
+
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+                email := strings.TrimSpace(identity.Email)
+                if email == "" {
+                        return user, ssoFailure{"Your identity provider did not share an email address"}
+                }
+                // Matching by email hands over the account, so the provider
+                // must vouch for the address rather than just not deny it
+                if identity.EmailVerified == nil || !*identity.EmailVerified {
+                        return user, ssoFailure{"Your email address is not verified with the identity provider"}
+                }
+
+                var employee models.Employee
+                if err := database.DB.Where("LOWER(email) = LOWER(?)", email).First(&employee).Error; err != nil {
+                        return user, ssoFailure{"No employee record matches your email address"}
+                }
+
+                user, err = linkSSOAccount(db, employee, identity.Subject)
+                if err != nil {
+                        return user, err
+                }
+        }
+
+        if accountDeactivated(user.ID) {
+                return user, ssoFailure{"This account has been deactivated"}
+        }
+
+        if oidc.Default.MapsRoles() {
+                role := oidc.Default.Role(identity.Groups)
+                if role == "" {
+                        role = models.RoleEmployee
+                }
+                if !models.IsValidRole(role) {
+                        return user, fmt.Errorf("OIDC_ROLE_MAPPING maps to unknown role %q", role)
+                }
+                if user.Role != role {
+                        if err := db.Model(&user).Update("role", role).Error; err != nil {
+                                return user, err
+                        }
+                }
+        }
+
+        return user, nil
+}
+
+// linkSSOAccount attaches subject to the account for employee, creating the
+// account if the employee has none. A linked account loses its local
+// password so the identity provider is the only way in.
+func linkSSOAccount(db *gorm.DB, employee models.Employee, subject string) (models.User, error) {
+        var user models.User
+
+        var err error
+        if employee.UserID != nil {
+                err = database.DB.First(&user, *employee.UserID).Error
+        } else {
+                err = database.DB.Where("LOWER(email) = LOWER(?)", employee.Email).First(&user).Error
+        }
+
+        switch {
+        case err == nil:
+                if isSSOUser(user) {
+                        return user, ssoFailure{"This employee is already linked to a different sign-on account"}
+                }
+                now := time.Now()
+                updates := map[string]interface{}{
+                        "oidc_subject":         subject,
+                        "password":             "",
+                        "must_change_password": false,
+                }
+                if user.EmailVerifiedAt == nil {
+                        updates["email_verified_at"] = now
+                }
+                if err := db.Model(&user).Updates(updates).Error; err != nil {
+                        return user, err
+                }
+                revokeUserSessions(user.ID)
+
+        case errors.Is(err, gorm.ErrRecordNotFound):
+                username, err := availableUsername(employee)
+                if err != nil {
+                        return user, err
+                }
+                now := time.Now()
+                user = models.User{
+                        Username:        username,
+                        Email:           employee.Email,
+                        Role:            models.RoleEmployee,
+                        EmailVerifiedAt: &now,
+                        OIDCSubject:     &subject,
+                }
+                if err := db.Create(&user).Error; err != nil {
+                        return user, err
+                }
+
+        default:
+                return user, err
+        }
+
+        if employee.UserID == nil {
+                db.Model(&models.Employee{}).
+                        Where("id = ? AND user_id IS NULL", employee.ID).
+                        Update("user_id", user.ID)
+        }
+        return user, nil
+}
+
+// availableUsername derives a username from the employee's email, adding a
+// number if it is already taken.
+func availableUsername(employee models.Employee) (string, error) {
+        base, _, _ := strings.Cut(strings.ToLower(employee.Email), "@")
+        if base == "" {
+                base = fmt.Sprintf("employee%d", employee.ID)
+        }
+
+        candidate := base
+        for i := 2; i < 100; i++ {
+                var count int64
+                if err := database.DB.Unscoped().Model(&models.User{}).Where("username = ?", candidate).Count(&count).Error; err != nil {
+                        return "", err
+                }
+                if count == 0 {
+                        return candidate, nil
+                }
+                candidate = fmt.Sprintf("%s%d", base, i)
+        }
+        return "", fmt.Errorf("no free username for %s", employee.Email)
+}
@@ -0,0 +1,431 @@
+package handlers
+
+import (
+        "crypto/rand"
+        "encoding/base32"
+        "fmt"
+        "net/http"
+        "os"
+        "strings"
+        "time"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/models"
+        "hcm-backend/totp"
+
+        "github.com/gin-gonic/gin"
+        "github.com/golang-jwt/jwt/v5"
+)
+
+const (
+        challengeTokenTTL = 5 * time.Minute
+        challengePurpose  = "2fa_challenge"
+        recoveryCodeCount = 10
+        defaultTOTPIssuer = "SunFish HCM"
+)
+
+func roleRequiresTwoFactor(role string) bool {
+        var policy models.RolePolicy
+        if err := database.DB.Where("role = ?", role).First(&policy).Error; err != nil {
+                return false
+        }
+        return policy.RequireTwoFactor
+}
+
+// generateChallengeToken signs the intermediate token handed out after a
+// correct password. It carries no session ID, so AuthMiddleware rejects it
+// as an access token.
+func generateChallengeToken(userID uint) (string, error) {
+        jwtSecret := os.Getenv("JWT_SECRET")
+        if jwtSecret == "" {
+                return "", fmt.Errorf("JWT_SECRET environment variable is required")
+        }
+
+        claims := jwt.MapClaims{
+                "user_id": userID,
+                "purpose": challengePurpose,
+                "exp":     time.Now().Add(challengeTokenTTL).Unix(),
+        }
+
+        token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+        return token.SignedString([]byte(jwtSecret))
+}
+
+func parseChallengeToken(tokenString string) (uint, error) {
+        token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+                return []byte(os.Getenv("JWT_SECRET")), nil
+        }, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+        if err != nil || !token.Valid {
+                return 0, fmt.Errorf("invalid or expired challenge token")
+        }
+
+        claims, ok := token.Claims.(jwt.MapClaims)
+        if !ok || claims["purpose"] != challengePurpose {
+                return 0, fmt.Errorf("invalid challenge token")
+        }
+
+        userID, ok := claims["user_id"].(float64)
+        if !ok {
+                return 0, fmt.Errorf("invalid challenge token")
+        }
+        return uint(userID), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+        return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// generateRecoveryCodes replaces the user's recovery codes and returns the new
+// plaintext codes. They are only ever shown at this point.
+func generateRecoveryCodes(userID uint) ([]string, error) {
+        if err := database.DB.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+                return nil, err
+        }
+
+        codes := make([]string, 0, recoveryCodeCount)
+        for i := 0; i < recoveryCodeCount; i++ {
+                b := make([]byte, 5)
+                if _, err := rand.Read(b); err != nil {
+                        return nil, err
+                }
+                raw := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+                code := raw[:4] + "-" + raw[4:]
+
+                if err := database.DB.Create(&models.RecoveryCode{
+                        UserID:   userID,
+                        CodeHash: hashToken(normalizeRecoveryCode(code)),
+                }).Error; err != nil {
+                        return nil, err
+                }
+                codes = append(codes, code)
+        }
+
+        return codes, nil
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery
+// code. TOTP codes are bound to their time step so each can be used once.
+func verifySecondFactor(user models.User, code string) bool {
+        if user.TOTPSecret == "" {
+                return false
+        }
+
+        if step, ok := totpStep(user, code, time.Now()); ok {
+                // The conditional update settles concurrent uses of one code
+                result := database.DB.Model(&models.User{}).
+                        Where("id = ? AND totp_last_step < ?", user.ID, step).
+                        Update("totp_last_step", step)
+                return result.Error == nil && result.RowsAffected == 1
+        }
+
+        if !user.TOTPEnabled {
+                return false
+        }
+
+        result := database.DB.Model(&models.RecoveryCode{}).
+                Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalizeRecoveryCode(code))).
+                Update("used_at", time.Now())
+        return result.Error == nil && result.RowsAffected == 1
+}
+
+// totpStep returns the step a TOTP code matches at now, refusing steps at or
+// before the last one the user spent.
+func totpStep(user models.User, code string, now time.Time) (int64, bool) {
+        step, ok := totp.Validate(user.TOTPSecret, code, now)
+        if !ok || step <= user.TOTPLastStep {
+                return 0, false
+        }
+        return step, true
+}
+
+func VerifyTwoFactor(c *gin.Context) {
+        var input struct {
+                ChallengeToken string `json:"challenge_token" binding:"required"`
+                Code           string `json:"code" binding:"required"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        userID, err := parseChallengeToken(input.ChallengeToken)
+        if err != nil {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge, please log in again"})
+                return
+        }
+
+        var user models.User
+        if err := database.DB.First(&user, userID).Error; err != nil || !user.TOTPEnabled {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge, please log in again"})
+                return
+        }
+
+        attempt, ok := reserveLoginAttempt(c, user.Username)
+        if !ok {
+                return
+        }
+
+        if accountDeactivated(user.ID) {
+                attempt.release()
+                c.JSON(http.StatusForbidden, gin.H{"error": "This account has been deactivated"})
+                return
+        }
+
+        if !verifySecondFactor(user, input.Code) {
+                attempt.fail(c, &user.ID, "invalid two-factor code")
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid verification code"})
+                return
+        }
+
+        attempt.release()
+        recordLoginSuccess(c, user)
+        respondWithSession(c, http.StatusOK, user)
+}
+
+func SetupTwoFactor(c *gin.Context) {
+        var user models.User
+        if err := database.DB.First(&user, c.GetUint("userID")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+                return
+        }
+
+        if user.TOTPEnabled {
+                c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+                return
+        }
+
+        secret, err := totp.GenerateSecret()
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+                return
+        }
+
+        // The secret stays pending until the user proves their app produces
+        // valid codes for it. It is saved from the struct so the serializer
+        // encrypts it; map updates bypass serializers.
+        user.TOTPSecret, user.TOTPLastStep = secret, 0
+        if err := database.DB.WithContext(audit.Context(c)).Model(&user).
+                Select("totp_secret", "totp_last_step").Updates(&user).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start two-factor setup"})
+                return
+        }
+
+        issuer := os.Getenv("TOTP_ISSUER")
+        if issuer == "" {
+                issuer = defaultTOTPIssuer
+        }
+
+        c.JSON(http.StatusOK, gin.H{
+                "secret":           secret,
+                "provisioning_uri": totp.ProvisioningURI(issuer, user.Email, secret),
+        })
+}
+
+func EnableTwoFactor(c *gin.Context) {
+        var input struct {
+                Code string `json:"code" binding:"required"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        var user models.User
+        if err := database.DB.First(&user, c.GetUint("userID")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+                return
+        }
+
+        if user.TOTPEnabled {
+                c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+                return
+        }
+        if user.TOTPSecret == "" {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Start two-factor setup first"})
+                return
+        }
+
+        if !verifySecondFactor(user, input.Code) {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid verification code"})
+                return
+        }
+
+        if err := database.DB.WithContext(audit.Context(c)).Model(&user).Update("totp_enabled", true).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+                return
+        }
+        user.TOTPEnabled = true
+
+        codes, err := generateRecoveryCodes(user.ID)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+                return
+        }
+
+        // Replace the current token, which may still carry the enrollment
+        // restriction, with one reflecting the enabled state.
+        revokeUserSessions(user.ID)
+        token, refreshToken, err := createSession(c, user)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+                return
+        }
+
+        c.JSON(http.StatusOK, gin.H{
+                "message":        "Two-factor authentication enabled",
+                "recovery_codes": codes,
+                "token":          token,
+                "refresh_token":  refreshToken,
+                "expires_in":     int(accessTokenTTL.Seconds()),
+        })
+}
+
+func DisableTwoFactor(c *gin.Context) {
+        var input struct {
+                Password string `json:"password" binding:"required"`
+                Code     string `json:"code" binding:"required"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        var user models.User
+        if err := database.DB.First(&user, c.GetUint("userID")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+                return
+        }
+
+        if !user.TOTPEnabled {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+                return
+        }
+
+        if roleRequiresTwoFactor(user.Role) {
+                c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for your role"})
+                return
+        }
+
+        if !checkPassword(input.Password, user.Password) || !verifySecondFactor(user, input.Code) {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password or verification code"})
+                return
+        }
+
+        if err := clearTwoFactor(c, user.ID); err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+                return
+        }
+
+        c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+func RegenerateRecoveryCodes(c *gin.Context) {
+        var input struct {
+                Code string `json:"code" binding:"required"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        var user models.User
+        if err := database.DB.First(&user, c.GetUint("userID")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+                return
+        }
+
+        if !user.TOTPEnabled {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+                return
+        }
+
+        if !verifySecondFactor(user, input.Code) {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid verification code"})
+                return
+        }
+
+        codes, err := generateRecoveryCodes(user.ID)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+                return
+        }
+
+        c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func clearTwoFactor(c *gin.Context, userID uint) error {
+        if err := database.DB.WithContext(audit.Context(c)).Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+                "totp_secret":    "",
+                "totp_enabled":   false,
+                "totp_last_step": 0,
+        }).Error; err != nil {
+                return err
+        }
+        return database.DB.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+}
+
+// ResetUserTwoFactor lets an admin clear 2FA for a user who lost their device.
+// The user's sessions are revoked so they must sign in (and re-enroll if
+// their role requires it).
+func ResetUserTwoFactor(c *gin.Context) {
+        id := c.Param("id")
+
+        var user models.User
+        if err := database.DB.First(&user, id).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+                return
+        }
+
+        if err := clearTwoFactor(c, user.ID); err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset two-factor authentication"})
+                return
+        }
+        revokeUserSessions(user.ID)
+
+        c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication reset"})
+}
+
+func GetRolePolicies(c *gin.Context) {
+        var policies []models.RolePolicy
+        if err := database.DB.Order("role").Find(&policies).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch role policies"})
+                return
+        }
+
+        c.JSON(http.StatusOK, policies)
+}
+
+func UpdateRolePolicy(c *gin.Context) {
+        role := c.Param("role")
+        if !models.IsValidRole(role) {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+                return
+        }
+
+        var input struct {
+                RequireTwoFactor bool `json:"require_two_factor"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        var policy models.RolePolicy
+        if err := database.DB.Where("role = ?", role).First(&policy).Error; err != nil {
+                policy = models.RolePolicy{Role: role}
+        }
+        policy.RequireTwoFactor = input.RequireTwoFactor
+
+        if err := database.DB.WithContext(audit.Context(c)).Save(&policy).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role policy"})
+                return
+        }
+
+        c.JSON(http.StatusOK, policy)
+}
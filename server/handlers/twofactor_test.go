@@ -0,0 +1,46 @@
+package handlers
+
+import (
+        "testing"
+        "time"
+
+        "hcm-backend/models"
+        "hcm-backend/totp"
+)
+
+func TestTOTPStep(t *testing.T) {
+        secret, err := totp.GenerateSecret()
+        if err != nil {
+                t.Fatal(err)
+        }
+        now := time.Now()
+        step := totp.Step(now)
+        code := func(step int64) string {
+                c, _ := totp.Code(secret, step)
+                return c
+        }
+
+        tests := []struct {
+                name     string
+                lastStep int64
+                code     string
+                ok       bool
+        }{
+                {"fresh code", 0, code(step), true},
+                {"code from the previous step", step - 2, code(step - 1), true},
+                {"replayed code", step, code(step), false},
+                {"code older than one spent", step, code(step - 1), false},
+                {"code after one spent", step, code(step + 1), true},
+                {"code outside the window", 0, code(step + 2), false},
+        }
+        for _, tt := range tests {
+                user := models.User{TOTPSecret: secret, TOTPLastStep: tt.lastStep}
+                got, ok := totpStep(user, tt.code, now)
+                if ok != tt.ok {
+                        t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.ok)
+                }
+                if ok && got <= tt.lastStep {
+                        t.Errorf("%s: step = %d, want one after %d", tt.name, got, tt.lastStep)
+                }
+        }
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+        "log"
+        "net/http"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/models"
+
+        "github.com/gin-gonic/gin"
+)
+
+func GetUsers(c *gin.Context) {
+        var users []models.User
+        if err := database.DB.Order("username").Find(&users).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+                return
+        }
+
+        c.JSON(http.StatusOK, users)
+}
+
+func CreateUser(c *gin.Context) {
+        var input struct {
+                Username          string `json:"username" binding:"required"`
+                Email             string `json:"email" binding:"required,email"`
+                TemporaryPassword string `json:"temporary_password" binding:"required,min=6"`
+                Role              string `json:"role"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        if input.Role == "" {
+                input.Role = models.RoleEmployee
+        }
+        if !models.IsValidRole(input.Role) {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role. Must be employee, manager, hr_admin, payroll_admin, or system_admin"})
+                return
+        }
+
+        var existingUser models.User
+        if err := database.DB.Where("username = ? OR email = ?", input.Username, input.Email).First(&existingUser).Error; err == nil {
+                c.JSON(http.StatusConflict, gin.H{"error": "Username or email already exists"})
+                return
+        }
+
+        hashedPassword, err := hashPassword(input.TemporaryPassword)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+                return
+        }
+
+        // Admin-chosen passwords are known to someone else, so the user must
+        // replace it before doing anything else.
+        user := models.User{
+                Username:           input.Username,
+                Email:              input.Email,
+                Password:           hashedPassword,
+                Role:               input.Role,
+                MustChangePassword: true,
+        }
+
+        if err := database.DB.WithContext(audit.Context(c)).Create(&user).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+                return
+        }
+
+        if err := sendVerificationEmail(user); err != nil {
+                log.Println("Failed to send verification email:", err)
+        }
+
+        c.JSON(http.StatusCreated, user)
+}
+
+func UpdateUserRole(c *gin.Context) {
+        id := c.Param("id")
+
+        var input struct {
+                Role string `json:"role" binding:"required"`
+        }
+
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        if !models.IsValidRole(input.Role) {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role. Must be employee, manager, hr_admin, payroll_admin, or system_admin"})
+                return
+        }
+
+        var user models.User
+        if err := database.DB.First(&user, id).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+                return
+        }
+
+        // Prevent an admin from locking everyone out by demoting themselves
+        if currentUserID, exists := c.Get("userID"); exists && currentUserID.(uint) == user.ID && input.Role != models.RoleSystemAdmin {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot remove your own system admin role"})
+                return
+        }
+
+        changed := user.Role != input.Role
+        user.Role = input.Role
+        if err := database.DB.WithContext(audit.Context(c)).Save(&user).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
+                return
+        }
+
+        // The role travels in the access token, so sessions issued under the
+        // old one would keep its permissions until they expire
+        if changed {
+                revokeUserSessions(user.ID)
+        }
+
+        c.JSON(http.StatusOK, gin.H{"message": "User role updated successfully", "user": user})
+}
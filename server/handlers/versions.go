@@ -0,0 +1,75 @@
+package handlers
+
+import (
+        "errors"
+        "fmt"
+        "net/http"
+        "strings"
+
+        "hcm-backend/database"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+        "gorm.io/gorm/clause"
+)
+
+// errVersionConflict is returned when a record changed between being read
+// and written.
+var errVersionConflict = errors.New("The record was changed by someone else; fetch it again and retry")
+
+// etag formats a record version as an entity tag.
+func etag(version uint) string {
+        return fmt.Sprintf(`"%d"`, version)
+}
+
+// ifMatch checks the If-Match header against a record's current version and
+// reports whether the write may go ahead. If not, it has already answered:
+// 412 for another version, or 428 if the header is required but missing.
+func ifMatch(c *gin.Context, version uint, required bool) bool {
+        header := strings.TrimSpace(c.GetHeader("If-Match"))
+        if header == "" {
+                if required {
+                        c.JSON(http.StatusPreconditionRequired, gin.H{"error": "Send the record's ETag in If-Match"})
+                        return false
+                }
+                return true
+        }
+        if header == "*" {
+                return true
+        }
+        for _, tag := range strings.Split(header, ",") {
+                if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag(version) {
+                        return true
+                }
+        }
+        versionConflict(c, version)
+        return false
+}
+
+// currentVersion reads the stored version of the record of model's type with
+// the given ID.
+func currentVersion(model interface{}, id uint) uint {
+        var version uint
+        database.DB.Model(model).Select("version").Where("id = ?", id).Scan(&version)
+        return version
+}
+
+// versionConflict answers 412 with the record's current version.
+func versionConflict(c *gin.Context, version uint) {
+        c.Header("ETag", etag(version))
+        c.JSON(http.StatusPreconditionFailed, gin.H{"error": errVersionConflict.Error(), "version": version})
+}
+
+// saveVersioned saves record, whose version field still holds version, only
+// if nobody has written it since it was loaded. The update hook bumps the
+// version as it saves.
+func saveVersioned(tx *gorm.DB, record interface{}, version uint) error {
+        result := tx.Select("*").Omit(clause.Associations).Where("version = ?", version).Save(record)
+        if result.Error != nil {
+                return result.Error
+        }
+        if result.RowsAffected == 0 {
+                return errVersionConflict
+        }
+        return nil
+}
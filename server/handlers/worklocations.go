@@ -0,0 +1,150 @@
+package handlers
+
+import (
+        "errors"
+        "net/http"
+        "strings"
+        "time"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/models"
+        "hcm-backend/timezone"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+)
+
+// employeeLocations returns the zone each employee's attendance is recorded
+// in: their own timezone, else their work location's, else the default.
+func employeeLocations(db *gorm.DB, employees []models.Employee) (map[uint]*time.Location, error) {
+        var places []models.WorkLocation
+        if err := db.Find(&places).Error; err != nil {
+                return nil, err
+        }
+        zones := make(map[string]string, len(places))
+        for _, place := range places {
+                zones[place.Name] = place.Timezone
+        }
+        locations := make(map[uint]*time.Location, len(employees))
+        for _, employee := range employees {
+                locations[employee.ID] = timezone.Resolve(employee.Timezone, zones[employee.WorkLocation])
+        }
+        return locations, nil
+}
+
+// employeeLocation is employeeLocations for a single employee.
+func employeeLocation(db *gorm.DB, employee models.Employee) (*time.Location, error) {
+        locations, err := employeeLocations(db, []models.Employee{employee})
+        if err != nil {
+                return nil, err
+        }
+        return locations[employee.ID], nil
+}
+
+// checkTimezone verifies an optional IANA zone given for field.
+func checkTimezone(name, field string) error {
+        if name == "" {
+                return nil
+        }
+        if _, err := timezone.Load(name); err != nil {
+                return errors.New(field + " must be an IANA time zone such as Europe/Amsterdam")
+        }
+        return nil
+}
+
+type workLocationInput struct {
+        Name     string `json:"name" binding:"required"`
+        Timezone string `json:"timezone" binding:"required"`
+}
+
+func (input workLocationInput) check() (string, error) {
+        name := strings.TrimSpace(input.Name)
+        if name == "" {
+                return "", errors.New("Name is required")
+        }
+        return name, checkTimezone(input.Timezone, "timezone")
+}
+
+func GetWorkLocations(c *gin.Context) {
+        var places []models.WorkLocation
+        if err := database.DB.Order("name").Find(&places).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch work locations"})
+                return
+        }
+        c.JSON(http.StatusOK, places)
+}
+
+// CreateWorkLocation gives a work location a timezone. Name matches the
+// work_location of employees exactly.
+func CreateWorkLocation(c *gin.Context) {
+        var input workLocationInput
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        name, err := input.check()
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        var count int64
+        database.DB.Model(&models.WorkLocation{}).Where("name = ?", name).Count(&count)
+        if count > 0 {
+                c.JSON(http.StatusConflict, gin.H{"error": "A work location with this name already exists"})
+                return
+        }
+        place := models.WorkLocation{Name: name, Timezone: input.Timezone}
+        if err := database.DB.WithContext(audit.Context(c)).Create(&place).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create work location"})
+                return
+        }
+        c.JSON(http.StatusCreated, place)
+}
+
+// UpdateWorkLocation changes a work location's timezone. Attendance already
+// recorded keeps the zone it was recorded in.
+func UpdateWorkLocation(c *gin.Context) {
+        var input workLocationInput
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        name, err := input.check()
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        var place models.WorkLocation
+        if err := database.DB.First(&place, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Work location not found"})
+                return
+        }
+        var count int64
+        database.DB.Model(&models.WorkLocation{}).Where("name = ? AND id <> ?", name, place.ID).Count(&count)
+        if count > 0 {
+                c.JSON(http.StatusConflict, gin.H{"error": "A work location with this name already exists"})
+                return
+        }
+        place.Name, place.Timezone = name, input.Timezone
+        if err := database.DB.WithContext(audit.Context(c)).Save(&place).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update work location"})
+                return
+        }
+        c.JSON(http.StatusOK, place)
+}
+
+func DeleteWorkLocation(c *gin.Context) {
+        var place models.WorkLocation
+        if err := database.DB.First(&place, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Work location not found"})
+                return
+        }
+        if err := database.DB.WithContext(audit.Context(c)).Delete(&place).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete work location"})
+                return
+        }
+        c.JSON(http.StatusOK, gin.H{"message": "Work location deleted successfully"})
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+        "errors"
+        "net/http"
+        "slices"
+        "time"
+
+        "hcm-backend/audit"
+        "hcm-backend/database"
+        "hcm-backend/models"
+        "hcm-backend/schedule"
+        "hcm-backend/worktime"
+
+        "github.com/gin-gonic/gin"
+        "gorm.io/gorm"
+)
+
+// maxSummaryDays is the longest range GetAttendanceSummary totals.
+const maxSummaryDays = 366
+
+var roundingModes = []string{models.RoundNearest, models.RoundUp, models.RoundDown}
+
+// loadOvertimePolicy returns the saved overtime policy, or the default if
+// none has been saved.
+func loadOvertimePolicy(db *gorm.DB) (models.OvertimePolicy, error) {
+        var policy models.OvertimePolicy
+        err := db.First(&policy, 1).Error
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+                return models.DefaultOvertimePolicy(), nil
+        }
+        return policy, err
+}
+
+// checkOvertimePolicy verifies the thresholds, days, multipliers and
+// rounding of a policy.
+func checkOvertimePolicy(policy models.OvertimePolicy) error {
+        if policy.DailyThresholdMinutes < 0 || policy.WeeklyThresholdMinutes < 0 {
+                return errors.New("Thresholds cannot be negative")
+        }
+        if policy.WeekStartDay < 0 || policy.WeekStartDay > 6 {
+                return errors.New("week_start_day must be a day of the week, 0 for Sunday to 6 for Saturday")
+        }
+        seen := map[int]bool{}
+        for _, day := range policy.WeekendDays {
+                if day < 0 || day > 6 || seen[day] {
+                        return errors.New("weekend_days must be unique days of the week, 0 for Sunday to 6 for Saturday")
+                }
+                seen[day] = true
+        }
+        if policy.OvertimeMultiplier < 1 || policy.WeekendMultiplier < 1 || policy.HolidayMultiplier < 1 {
+                return errors.New("Multipliers must be at least 1")
+        }
+        if policy.RoundingMinutes < 0 || policy.RoundingMinutes > 60 {
+                return errors.New("rounding_minutes must be from 0 to 60")
+        }
+        if !slices.Contains(roundingModes, policy.RoundingMode) {
+                return errors.New("rounding_mode must be nearest, up or down")
+        }
+        if policy.BreakMinutes < 0 || policy.BreakAfterMinutes < 0 {
+                return errors.New("Break minutes cannot be negative")
+        }
+        return nil
+}
+
+func GetOvertimePolicy(c *gin.Context) {
+        policy, err := loadOvertimePolicy(database.DB)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch overtime policy"})
+                return
+        }
+        c.JSON(http.StatusOK, policy)
+}
+
+// UpdateOvertimePolicy replaces the overtime policy. Fields left out of the
+// body keep their current values.
+func UpdateOvertimePolicy(c *gin.Context) {
+        policy, err := loadOvertimePolicy(database.DB)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch overtime policy"})
+                return
+        }
+        if err := c.ShouldBindJSON(&policy); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        policy.ID = 1
+        slices.Sort(policy.WeekendDays)
+        if err := checkOvertimePolicy(policy); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        if err := database.DB.WithContext(audit.Context(c)).Save(&policy).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update overtime policy"})
+                return
+        }
+        c.JSON(http.StatusOK, policy)
+}
+
+// GetHolidays lists holidays, optionally only those from from to to.
+func GetHolidays(c *gin.Context) {
+        from, to, err := parseDateRange(c)
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        query := database.DB.Order("date")
+        if from != nil {
+                query = query.Where("date >= ?", *from)
+        }
+        if to != nil {
+                query = query.Where("date < ?", *to)
+        }
+        holidays := []models.Holiday{}
+        if err := query.Find(&holidays).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch holidays"})
+                return
+        }
+        c.JSON(http.StatusOK, holidays)
+}
+
+func CreateHoliday(c *gin.Context) {
+        var input struct {
+                Date string `json:"date" binding:"required"`
+                Name string `json:"name" binding:"required"`
+        }
+        if err := c.ShouldBindJSON(&input); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+        date, err := parseLifecycleDate(input.Date, "date")
+        if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+        }
+
+        var count int64
+        database.DB.Model(&models.Holiday{}).Where("date = ?", *date).Count(&count)
+        if count > 0 {
+                c.JSON(http.StatusConflict, gin.H{"error": "There is already a holiday on this date"})
+                return
+        }
+        holiday := models.Holiday{Date: *date, Name: input.Name}
+        if err := database.DB.WithContext(audit.Context(c)).Create(&holiday).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create holiday"})
+                return
+        }
+        c.JSON(http.StatusCreated, holiday)
+}
+
+func DeleteHoliday(c *gin.Context) {
+        var holiday models.Holiday
+        if err := database.DB.First(&holiday, c.Param("id")).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "Holiday not found"})
+                return
+        }
+        if err := database.DB.WithContext(audit.Context(c)).Delete(&holiday).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete holiday"})
+                return
+        }
+        c.JSON(http.StatusOK, gin.H{"message": "Holiday deleted successfully"})
+}
+
+// workedByDay adds up an employee's worked minutes by day. Time on a shift
+// counts towards the day the shift starts and has the shift's break taken
+// off; other time counts towards the day it was clocked in on, with the
+// policy's break.
+func workedByDay(roster schedule.Roster, rows []models.Attendance, policy models.OvertimePolicy) map[time.Time]int {
+        worked := map[time.Time]int{}
+        groups, instances, unscheduled := groupByShift(roster, rows)
+        for key, group := range groups {
+                worked[key.Day] += schedule.Evaluate(instances[key], group).WorkedMinutes
+        }
+
+        byDay := map[time.Time][]models.Attendance{}
+        for _, row := range unscheduled {
+                byDay[row.Date] = append(byDay[row.Date], row)
+        }
+        for day, group := range byDay {
+                var first, last time.Time
+                for _, row := range group {
+                        if first.IsZero() || row.ClockIn.Before(first) {
+                                first = row.ClockIn
+                        }
+                        if row.ClockOut != nil && row.ClockOut.After(last) {
+                                last = *row.ClockOut
+                        }
+                }
+                worked[day] += schedule.WorkedMinutes(group, policy.BreakMinutes, policy.BreakAfterMinutes, first, last)
+        }
+        return worked
+}
+
+// attendanceSummary is an employee's line of GetAttendanceSummary.
+type attendanceSummary struct {
+        EmployeeID   uint   `json:"employee_id"`
+        EmployeeName string `json:"employee_name"`
+        worktime.Summary
+}
+
+// GetAttendanceSummary totals employees' worked time by day and week from
+// from to to, split into regular time and overtime under the overtime
+// policy, for payroll. Records still clocked in don't count yet.
+func GetAttendanceSummary(c *gin.Context) {
+        from, to, employees, ok := attendanceReportEmployees(c, maxSummaryDays)
+        if !ok {
+                return
+        }
+        policy, err := loadOvertimePolicy(database.DB)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch overtime policy"})
+                return
+        }
+        employeeIDs := make([]uint, len(employees))
+        for i, employee := range employees {
+                employeeIDs[i] = employee.ID
+        }
+
+        rosters, err := loadRosters(database.DB, employees)
+        if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shift assignments"})
+                return
+        }
+        // Whole weeks are needed for the weekly threshold, and a day either
+        // side for shifts past midnight
+        start, end := worktime.Weeks(policy, from, to)
+        var rows []models.Attendance
+        if err := database.DB.Where("employee_id IN ? AND clock_in >= ? AND clock_in < ?", employeeIDs, start.AddDate(0, 0, -2), end.AddDate(0, 0, 2)).
+                Find(&rows).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attendance"})
+                return
+        }
+        var holidayList []models.Holiday
+        if err := database.DB.Where("date >= ? AND date < ?", start, end).Find(&holidayList).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch holidays"})
+                return
+        }
+        holidays := map[time.Time]string{}
+        for _, holiday := range holidayList {
+                holidays[dateOnly(holiday.Date.UTC())] = holiday.Name
+        }
+        rowsByEmployee := map[uint][]models.Attendance{}
+        for _, row := range rows {
+                rowsByEmployee[row.EmployeeID] = append(rowsByEmployee[row.EmployeeID], row)
+        }
+
+        summaries := make([]attendanceSummary, 0, len(employees))
+        for _, employee := range employees {
+                worked := workedByDay(rosters[employee.ID], rowsByEmployee[employee.ID], policy)
+                summaries = append(summaries, attendanceSummary{
+                        EmployeeID:   employee.ID,
+                        EmployeeName: employee.Name,
+                        Summary:      worktime.Summarize(policy, worked, holidays, from, to),
+                })
+        }
+        c.JSON(http.StatusOK, summaries)
+}
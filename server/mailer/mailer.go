@@ -0,0 +1,111 @@
+package mailer
+
+import (
+        "fmt"
+        "log"
+        "net/smtp"
+        "os"
+        "strings"
+        "sync"
+        "time"
+)
+
+type Message struct {
+        To      string
+        Subject string
+        Body    string
+}
+
+// Mailer delivers outbound email. Handlers use Default, which Init configures
+// from the environment.
+type Mailer interface {
+        Send(msg Message) error
+}
+
+var Default Mailer = &LogMailer{}
+
+// Init selects the transport from MAIL_TRANSPORT ("smtp" or "log").
+func Init() {
+        switch os.Getenv("MAIL_TRANSPORT") {
+        case "smtp":
+                Default = &SMTPMailer{
+                        Host:     os.Getenv("SMTP_HOST"),
+                        Port:     os.Getenv("SMTP_PORT"),
+                        Username: os.Getenv("SMTP_USERNAME"),
+                        Password: os.Getenv("SMTP_PASSWORD"),
+                        From:     os.Getenv("MAIL_FROM"),
+                }
+                log.Println("Mailer using SMTP transport")
+        default:
+                Default = &LogMailer{Path: os.Getenv("MAIL_LOG_FILE")}
+                log.Println("Mailer using log transport")
+        }
+}
+
+type SMTPMailer struct {
+        Host     string
+        Port     string
+        Username string
+        Password string
+        From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+        if m.Host == "" || m.From == "" {
+                return fmt.Errorf("SMTP_HOST and MAIL_FROM are required for the smtp transport")
+        }
+
+        port := m.Port
+        if port == "" {
+                port = "587"
+        }
+
+        var auth smtp.Auth
+        if m.Username != "" {
+                auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+        }
+
+        return smtp.SendMail(m.Host+":"+port, auth, m.From, []string{msg.To}, []byte(formatMessage(m.From, msg)))
+}
+
+// LogMailer writes messages to a file, or to the standard logger when Path is
+// empty. It never fails delivery, which makes it suitable for development and
+// tests that read the file back.
+type LogMailer struct {
+        Path string
+
+        mu sync.Mutex
+}
+
+func (m *LogMailer) Send(msg Message) error {
+        m.mu.Lock()
+        defer m.mu.Unlock()
+
+        formatted := formatMessage("noreply@localhost", msg)
+        if m.Path == "" {
+                log.Printf("[MAIL]\n%s", formatted)
+                return nil
+        }
+
+        f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+        if err != nil {
+                return err
+        }
+        defer f.Close()
+
+        _, err = f.WriteString(formatted + "\n.\n")
+        return err
+}
+
+func formatMessage(from string, msg Message) string {
+        var b strings.Builder
+        b.WriteString("From: " + from + "\r\n")
+        b.WriteString("To: " + msg.To + "\r\n")
+        b.WriteString("Subject: " + msg.Subject + "\r\n")
+        b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+        b.WriteString("MIME-Version: 1.0\r\n")
+        b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+        b.WriteString("\r\n")
+        b.WriteString(msg.Body)
+        return b.String()
+}
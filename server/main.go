@@ -5,9 +5,16 @@ import (
         "os"
         "strings"
 
+        "hcm-backend/blobstore"
         "hcm-backend/database"
+        "hcm-backend/fieldcrypt"
         "hcm-backend/handlers"
+        "hcm-backend/mailer"
         "hcm-backend/middleware"
+        "hcm-backend/models"
+        "hcm-backend/oidc"
+        "hcm-backend/scim"
+        "hcm-backend/timezone"
 
         "github.com/gin-contrib/cors"
         "github.com/gin-gonic/gin"
@@ -19,9 +26,25 @@ func main() {
                 log.Println("No .env file found, using environment variables")
         }
 
+        if err := fieldcrypt.Init(); err != nil {
+                log.Fatal("Failed to load field encryption keys:", err)
+        }
+        if err := timezone.Init(); err != nil {
+                log.Fatal("Failed to load DEFAULT_TIMEZONE:", err)
+        }
+
         database.Connect()
         database.Migrate()
         database.SeedData()
+        database.BootstrapAdmin()
+        mailer.Init()
+        if err := blobstore.Init(); err != nil {
+                log.Fatal("Failed to configure document storage:", err)
+        }
+        if err := oidc.Init(); err != nil {
+                log.Fatal("Failed to configure single sign-on:", err)
+        }
+        handlers.StartJobScheduler()
 
         r := gin.Default()
 
@@ -29,11 +52,12 @@ func main() {
                 AllowOriginFunc: func(origin string) bool {
                         return true
                 },
-                AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-                AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-                ExposeHeaders:    []string{"Content-Length"},
+                AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+                AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Request-ID", "X-API-Key", "If-Match", "If-None-Match"},
+                ExposeHeaders:    []string{"Content-Length", "X-Request-ID", "X-Total-Count", "Content-Disposition", "ETag"},
                 AllowCredentials: true,
         }))
+        r.Use(middleware.RequestID())
 
         r.GET("/", func(c *gin.Context) {
                 accept := c.GetHeader("Accept")
@@ -52,41 +76,158 @@ func main() {
         {
                 api.POST("/auth/signup", handlers.Signup)
                 api.POST("/auth/login", handlers.Login)
+                api.POST("/auth/refresh", handlers.RefreshToken)
+                api.POST("/auth/password/forgot", handlers.ForgotPassword)
+                api.POST("/auth/password/reset", handlers.ResetPassword)
+                api.POST("/auth/verify-email", handlers.VerifyEmail)
+                api.POST("/auth/2fa/verify", handlers.VerifyTwoFactor)
+                api.GET("/auth/invitations/:token", handlers.GetInvitation)
+                api.POST("/auth/invitations/accept", handlers.AcceptInvitation)
+                api.GET("/auth/oidc", handlers.GetSSOConfig)
+                api.GET("/auth/oidc/login", handlers.OIDCLogin)
+                api.GET("/auth/oidc/callback", handlers.OIDCCallback)
+                api.POST("/auth/oidc/exchange", handlers.ExchangeSSOCode)
 
                 protected := api.Group("/")
                 protected.Use(middleware.AuthMiddleware())
                 {
                         protected.GET("/me", handlers.GetMe)
-
-                        protected.GET("/employees", handlers.GetEmployees)
-                        protected.GET("/employees/:id", handlers.GetEmployee)
-                        protected.POST("/employees", handlers.CreateEmployee)
-                        protected.PUT("/employees/:id", handlers.UpdateEmployee)
-
-                        protected.POST("/attendance/clockin", handlers.ClockIn)
-                        protected.POST("/attendance/clockout", handlers.ClockOut)
-                        protected.GET("/attendance", handlers.GetAttendance)
-
-                        protected.POST("/leave", handlers.CreateLeaveRequest)
-                        protected.GET("/leave", handlers.GetLeaveRequests)
-                        protected.PUT("/leave/:id", handlers.UpdateLeaveStatus)
-
-                        protected.GET("/salary/export", handlers.ExportSalary)
-                        protected.POST("/salary/payslip", handlers.GeneratePayslip)
-
-                        protected.POST("/chat", handlers.Chat)
+                        protected.POST("/auth/logout", handlers.Logout)
+                        protected.POST("/auth/password/change", handlers.ChangePassword)
+                        protected.POST("/auth/verify-email/request", handlers.RequestEmailVerification)
+                        protected.POST("/auth/2fa/setup", handlers.SetupTwoFactor)
+                        protected.POST("/auth/2fa/enable", handlers.EnableTwoFactor)
+                        protected.POST("/auth/2fa/disable", handlers.DisableTwoFactor)
+                        protected.POST("/auth/2fa/recovery-codes", handlers.RegenerateRecoveryCodes)
+
+                        protected.GET("/users", middleware.RequirePermission(models.PermUsersManage), handlers.GetUsers)
+                        protected.POST("/users", middleware.RequirePermission(models.PermUsersManage), handlers.CreateUser)
+                        protected.PUT("/users/:id/role", middleware.RequirePermission(models.PermUsersManage), handlers.UpdateUserRole)
+                        protected.GET("/users/:id/sessions", middleware.RequirePermission(models.PermUsersManage), handlers.GetUserSessions)
+                        protected.POST("/users/:id/sessions/revoke", middleware.RequirePermission(models.PermUsersManage), handlers.RevokeUserSessions)
+                        protected.POST("/users/:id/2fa/reset", middleware.RequirePermission(models.PermUsersManage), handlers.ResetUserTwoFactor)
+                        protected.GET("/roles/policies", middleware.RequirePermission(models.PermUsersManage), handlers.GetRolePolicies)
+                        protected.PUT("/roles/:role/policy", middleware.RequirePermission(models.PermUsersManage), handlers.UpdateRolePolicy)
+
+                        protected.GET("/api-keys", middleware.RequirePermission(models.PermUsersManage), handlers.GetAPIKeys)
+                        protected.POST("/api-keys", middleware.RequirePermission(models.PermUsersManage), handlers.CreateAPIKey)
+                        protected.DELETE("/api-keys/:id", middleware.RequirePermission(models.PermUsersManage), handlers.RevokeAPIKey)
+
+                        protected.GET("/security/events", middleware.RequirePermission(models.PermUsersManage), handlers.GetSecurityEvents)
+                        protected.GET("/security/lockouts", middleware.RequirePermission(models.PermUsersManage), handlers.GetLockouts)
+                        protected.POST("/security/unlock", middleware.RequirePermission(models.PermUsersManage), handlers.UnlockLogin)
+
+                        protected.GET("/audit", middleware.RequirePermission(models.PermAuditRead), handlers.GetAuditEvents)
+
+                        protected.GET("/employees", middleware.RequirePermission(models.PermEmployeesRead), handlers.GetEmployees)
+                        protected.GET("/employees/export", middleware.RequirePermission(models.PermEmployeesRead), handlers.ExportEmployees)
+                        protected.GET("/employees/:id", middleware.RequirePermission(models.PermEmployeesRead), handlers.GetEmployee)
+                        protected.POST("/employees", middleware.RequirePermission(models.PermEmployeesWrite), handlers.CreateEmployee)
+                        protected.POST("/employees/import", middleware.RequirePermission(models.PermEmployeesWrite), handlers.ImportEmployees)
+                        protected.PUT("/employees/:id", middleware.RequirePermission(models.PermEmployeesWrite), handlers.UpdateEmployee)
+                        protected.PATCH("/employees/:id", middleware.RequirePermission(models.PermEmployeesWrite), handlers.PatchEmployee)
+                        protected.POST("/employees/:id/invite", middleware.RequirePermission(models.PermEmployeesWrite), handlers.InviteEmployee)
+                        protected.GET("/employees/:id/chain", middleware.RequirePermission(models.PermEmployeesRead), handlers.GetManagerChain)
+                        protected.GET("/employees/:id/lifecycle", middleware.RequirePermission(models.PermEmployeesWrite), handlers.GetEmployeeLifecycle)
+                        protected.POST("/employees/:id/lifecycle/:action", middleware.RequirePermission(models.PermEmployeesWrite), handlers.EmployeeLifecycleAction)
+                        protected.DELETE("/employees/:id/lifecycle/:event", middleware.RequirePermission(models.PermEmployeesWrite), handlers.CancelLifecycleEvent)
+                        protected.GET("/employees/:id/history", middleware.RequirePermission(models.PermEmployeesRead), handlers.GetJobHistory)
+                        protected.POST("/employees/:id/history", middleware.RequirePermission(models.PermEmployeesWrite), handlers.CreateJobChange)
+                        protected.DELETE("/employees/:id/history/:record", middleware.RequirePermission(models.PermEmployeesWrite), handlers.CancelJobChange)
+
+                        protected.GET("/employees/:id/documents", middleware.RequirePermission(models.PermEmployeesRead), handlers.GetEmployeeDocuments)
+                        protected.POST("/employees/:id/documents", middleware.RequirePermission(models.PermEmployeesWrite), handlers.UploadDocument)
+                        protected.GET("/documents/expiring", middleware.RequirePermission(models.PermEmployeesSensitive), handlers.GetExpiringDocuments)
+                        protected.GET("/documents/:id/download", middleware.RequirePermission(models.PermEmployeesRead), handlers.DownloadDocument)
+                        protected.DELETE("/documents/:id", middleware.RequirePermission(models.PermEmployeesWrite), handlers.DeleteDocument)
+
+                        protected.GET("/orgchart", middleware.RequirePermission(models.PermEmployeesRead), handlers.GetOrgChart)
+                        protected.GET("/orgchart/span-of-control", middleware.RequirePermission(models.PermEmployeesRead), handlers.GetSpanOfControl)
+
+                        protected.GET("/work-locations", middleware.RequirePermission(models.PermEmployeesRead), handlers.GetWorkLocations)
+                        protected.POST("/work-locations", middleware.RequirePermission(models.PermEmployeesWrite), handlers.CreateWorkLocation)
+                        protected.PUT("/work-locations/:id", middleware.RequirePermission(models.PermEmployeesWrite), handlers.UpdateWorkLocation)
+                        protected.DELETE("/work-locations/:id", middleware.RequirePermission(models.PermEmployeesWrite), handlers.DeleteWorkLocation)
+
+                        protected.GET("/custom-fields", middleware.RequirePermission(models.PermEmployeesRead), handlers.GetCustomFields)
+                        protected.POST("/custom-fields", middleware.RequirePermission(models.PermEmployeesWrite), handlers.CreateCustomField)
+                        protected.PUT("/custom-fields/:id", middleware.RequirePermission(models.PermEmployeesWrite), handlers.UpdateCustomField)
+                        protected.DELETE("/custom-fields/:id", middleware.RequirePermission(models.PermEmployeesWrite), handlers.DeleteCustomField)
+
+                        protected.GET("/departments", middleware.RequirePermission(models.PermEmployeesRead), handlers.GetDepartments)
+                        protected.GET("/departments/tree", middleware.RequirePermission(models.PermEmployeesRead), handlers.GetDepartmentTree)
+                        protected.GET("/departments/:id", middleware.RequirePermission(models.PermEmployeesRead), handlers.GetDepartment)
+                        protected.POST("/departments", middleware.RequirePermission(models.PermEmployeesWrite), handlers.CreateDepartment)
+                        protected.PUT("/departments/:id", middleware.RequirePermission(models.PermEmployeesWrite), handlers.UpdateDepartment)
+                        protected.DELETE("/departments/:id", middleware.RequirePermission(models.PermEmployeesWrite), handlers.DeleteDepartment)
+
+                        protected.GET("/invitations", middleware.RequirePermission(models.PermEmployeesWrite), handlers.GetInvitations)
+                        protected.DELETE("/invitations/:id", middleware.RequirePermission(models.PermEmployeesWrite), handlers.RevokeInvitation)
+
+                        protected.POST("/attendance/clockin", middleware.RequirePermission(models.PermAttendanceSelf), handlers.ClockIn)
+                        protected.POST("/attendance/clockout", middleware.RequirePermission(models.PermAttendanceSelf), handlers.ClockOut)
+                        protected.GET("/attendance", middleware.RequireAnyPermission(models.PermAttendanceRead, models.PermAttendanceSelf), handlers.GetAttendance)
+                        protected.GET("/attendance/export", middleware.RequirePermission(models.PermAttendanceRead), handlers.ExportAttendance)
+                        protected.GET("/attendance/schedule", middleware.RequirePermission(models.PermAttendanceRead), handlers.GetAttendanceSchedule)
+                        protected.GET("/attendance/summary", middleware.RequirePermission(models.PermAttendanceRead), handlers.GetAttendanceSummary)
+                        protected.GET("/overtime-policy", middleware.RequirePermission(models.PermAttendanceRead), handlers.GetOvertimePolicy)
+                        protected.PUT("/overtime-policy", middleware.RequirePermission(models.PermPayrollExport), handlers.UpdateOvertimePolicy)
+                        protected.GET("/holidays", middleware.RequirePermission(models.PermAttendanceRead), handlers.GetHolidays)
+                        protected.POST("/holidays", middleware.RequirePermission(models.PermPayrollExport), handlers.CreateHoliday)
+                        protected.DELETE("/holidays/:id", middleware.RequirePermission(models.PermPayrollExport), handlers.DeleteHoliday)
+
+                        protected.GET("/shifts", middleware.RequirePermission(models.PermAttendanceRead), handlers.GetShifts)
+                        protected.POST("/shifts", middleware.RequirePermission(models.PermEmployeesWrite), handlers.CreateShift)
+                        protected.PUT("/shifts/:id", middleware.RequirePermission(models.PermEmployeesWrite), handlers.UpdateShift)
+                        protected.DELETE("/shifts/:id", middleware.RequirePermission(models.PermEmployeesWrite), handlers.DeleteShift)
+                        protected.GET("/employees/:id/shifts", middleware.RequirePermission(models.PermAttendanceRead), handlers.GetShiftAssignments)
+                        protected.POST("/employees/:id/shifts", middleware.RequirePermission(models.PermEmployeesWrite), handlers.CreateShiftAssignment)
+                        protected.DELETE("/shift-assignments/:id", middleware.RequirePermission(models.PermEmployeesWrite), handlers.DeleteShiftAssignment)
+
+                        protected.POST("/leave", middleware.RequirePermission(models.PermLeaveRequest), handlers.CreateLeaveRequest)
+                        protected.GET("/leave", middleware.RequirePermission(models.PermLeaveRead), handlers.GetLeaveRequests)
+                        protected.GET("/leave/export", middleware.RequirePermission(models.PermLeaveRead), handlers.ExportLeaveRequests)
+                        protected.PUT("/leave/:id", middleware.RequirePermission(models.PermLeaveApprove), handlers.UpdateLeaveStatus)
+
+                        protected.GET("/salary/export", middleware.RequirePermission(models.PermPayrollExport), handlers.ExportSalary)
+                        protected.POST("/salary/payslip", middleware.RequireAnyPermission(models.PermPayrollRead, models.PermPayrollSelf), handlers.GeneratePayslip)
+
+                        protected.POST("/chat", middleware.RequirePermission(models.PermChatUse), handlers.Chat)
                         
-                        protected.POST("/feedback", handlers.CreateFeedback)
-                        protected.GET("/feedback", handlers.GetAllFeedback)
-                        protected.PUT("/feedback/:id", handlers.UpdateFeedback)
+                        protected.POST("/feedback", middleware.RequirePermission(models.PermFeedbackWrite), handlers.CreateFeedback)
+                        protected.GET("/feedback", middleware.RequirePermission(models.PermFeedbackRead), handlers.GetAllFeedback)
+                        protected.PUT("/feedback/:id", middleware.RequirePermission(models.PermFeedbackWrite), handlers.UpdateFeedback)
                         
-                        protected.GET("/settings", handlers.GetSettings)
-                        protected.GET("/settings/:key", handlers.GetSetting)
-                        protected.POST("/settings", handlers.UpsertSetting)
-                        protected.DELETE("/settings/:key", handlers.DeleteSetting)
+                        protected.GET("/settings", middleware.RequirePermission(models.PermSettingsRead), handlers.GetSettings)
+                        protected.GET("/settings/:key", middleware.RequirePermission(models.PermSettingsRead), handlers.GetSetting)
+                        protected.POST("/settings", middleware.RequirePermission(models.PermSettingsWrite), handlers.UpsertSetting)
+                        protected.DELETE("/settings/:key", middleware.RequirePermission(models.PermSettingsWrite), handlers.DeleteSetting)
                 }
         }
 
+        // SCIM 2.0 provisioning for identity providers, authenticated with an
+        // API key holding the scim:provision scope
+        scimAPI := r.Group("/scim/v2")
+        scimAPI.Use(middleware.AuthMiddleware(), middleware.RequirePermission(models.PermSCIMProvision))
+        {
+                scimAPI.GET("/ServiceProviderConfig", scim.ServiceProviderConfig)
+                scimAPI.GET("/ResourceTypes", scim.ResourceTypes)
+
+                scimAPI.GET("/Users", handlers.GetSCIMUsers)
+                scimAPI.POST("/Users", handlers.CreateSCIMUser)
+                scimAPI.GET("/Users/:id", handlers.GetSCIMUser)
+                scimAPI.PUT("/Users/:id", handlers.ReplaceSCIMUser)
+                scimAPI.PATCH("/Users/:id", handlers.PatchSCIMUser)
+                scimAPI.DELETE("/Users/:id", handlers.DeleteSCIMUser)
+
+                scimAPI.GET("/Groups", handlers.GetSCIMGroups)
+                scimAPI.POST("/Groups", handlers.CreateSCIMGroup)
+                scimAPI.GET("/Groups/:id", handlers.GetSCIMGroup)
+                scimAPI.PUT("/Groups/:id", handlers.ReplaceSCIMGroup)
+                scimAPI.PATCH("/Groups/:id", handlers.PatchSCIMGroup)
+                scimAPI.DELETE("/Groups/:id", handlers.DeleteSCIMGroup)
+        }
+
         r.Static("/assets", "../client/dist/assets")
         r.StaticFile("/logo.png", "../client/dist/logo.png")
         r.StaticFile("/vite.svg", "../client/dist/vite.svg")
@@ -4,13 +4,45 @@ import (
         "net/http"
         "os"
         "strings"
+        "time"
+
+        "hcm-backend/database"
+        "hcm-backend/models"
 
         "github.com/gin-gonic/gin"
         "github.com/golang-jwt/jwt/v5"
 )
 
+// passwordChangeAllowed lists the routes a user flagged for a forced password
+// change can still reach.
+var passwordChangeAllowed = map[string]bool{
+        "/api/me":                   true,
+        "/api/auth/password/change": true,
+        "/api/auth/logout":          true,
+}
+
+// twoFactorSetupAllowed lists the routes a user whose role requires 2FA can
+// reach before they have enrolled.
+var twoFactorSetupAllowed = map[string]bool{
+        "/api/me":              true,
+        "/api/auth/2fa/setup":  true,
+        "/api/auth/2fa/enable": true,
+        "/api/auth/logout":     true,
+}
+
+// AuthMiddleware accepts either a user's access token or an API key, sent as
+// "Authorization: Bearer <token or key>" or "X-API-Key: <key>".
 func AuthMiddleware() gin.HandlerFunc {
         return func(c *gin.Context) {
+                if key := c.GetHeader("X-API-Key"); key != "" {
+                        if !authenticateAPIKey(c, key) {
+                                c.Abort()
+                                return
+                        }
+                        c.Next()
+                        return
+                }
+
                 authHeader := c.GetHeader("Authorization")
                 if authHeader == "" {
                         c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
@@ -26,9 +58,18 @@ func AuthMiddleware() gin.HandlerFunc {
                 }
 
                 tokenString := bearerToken[1]
+                if strings.HasPrefix(tokenString, APIKeyPrefix) {
+                        if !authenticateAPIKey(c, tokenString) {
+                                c.Abort()
+                                return
+                        }
+                        c.Next()
+                        return
+                }
+
                 token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
                         return []byte(os.Getenv("JWT_SECRET")), nil
-                })
+                }, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 
                 if err != nil || !token.Valid {
                         c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
@@ -40,6 +81,37 @@ func AuthMiddleware() gin.HandlerFunc {
                         if userID, exists := claims["user_id"]; exists {
                                 c.Set("userID", uint(userID.(float64)))
                         }
+                        role, _ := claims["role"].(string)
+                        if role == "" {
+                                role = models.RoleEmployee
+                        }
+                        c.Set("role", role)
+
+                        // Access tokens are bound to a server-side session so that
+                        // logout and admin revocation take effect immediately.
+                        sid, _ := claims["sid"].(float64)
+                        var session models.Session
+                        if err := database.DB.Select("id").
+                                Where("id = ? AND revoked_at IS NULL AND expires_at > ?", uint(sid), time.Now()).
+                                First(&session).Error; err != nil {
+                                c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please log in again"})
+                                c.Abort()
+                                return
+                        }
+                        c.Set("sessionID", session.ID)
+
+                        // Accounts with a temporary password may only change it
+                        if mustChange, _ := claims["pwd_change"].(bool); mustChange && !passwordChangeAllowed[c.FullPath()] {
+                                c.JSON(http.StatusForbidden, gin.H{"error": "Password change required", "code": "password_change_required"})
+                                c.Abort()
+                                return
+                        }
+
+                        if mustEnroll, _ := claims["mfa_setup"].(bool); mustEnroll && !twoFactorSetupAllowed[c.FullPath()] {
+                                c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication setup required", "code": "two_factor_setup_required"})
+                                c.Abort()
+                                return
+                        }
                 }
 
                 c.Next()
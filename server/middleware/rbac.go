@@ -0,0 +1,38 @@
+package middleware
+
+import (
+        "net/http"
+
+        "github.com/gin-gonic/gin"
+)
+
+// RequirePermission aborts with 403 unless the authenticated caller's role,
+// or API key scopes, grant the given permission. It must run after
+// AuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+        return func(c *gin.Context) {
+                if !HasPermission(c, permission) {
+                        c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+                        c.Abort()
+                        return
+                }
+
+                c.Next()
+        }
+}
+
+// RequireAnyPermission is RequirePermission for routes that serve callers
+// holding any one of permissions, such as a self-service variant of a
+// report. The handler narrows what it returns by which one they hold.
+func RequireAnyPermission(permissions ...string) gin.HandlerFunc {
+        return func(c *gin.Context) {
+                for _, permission := range permissions {
+                        if HasPermission(c, permission) {
+                                c.Next()
+                                return
+                        }
+                }
+                c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+                c.Abort()
+        }
+}
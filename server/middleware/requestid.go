@@ -0,0 +1,28 @@
+package middleware
+
+import (
+        "crypto/rand"
+        "encoding/hex"
+
+        "github.com/gin-gonic/gin"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// RequestID tags each request with an ID, reusing one supplied by a proxy if
+// present, and echoes it back so clients can quote it in support requests.
+// Audit events record the same ID.
+func RequestID() gin.HandlerFunc {
+        return func(c *gin.Context) {
+                id := c.GetHeader(requestIDHeader)
+                if id == "" || len(id) > 128 {
+                        b := make([]byte, 16)
+                        rand.Read(b)
+                        id = hex.EncodeToString(b)
+                }
+
+                c.Set("requestID", id)
+                c.Header(requestIDHeader, id)
+                c.Next()
+        }
+}
@@ -2,6 +2,10 @@ package models
 
 import (
         "time"
+
+        "hcm-backend/fieldcrypt"
+        "hcm-backend/timezone"
+
         "gorm.io/gorm"
 )
 
@@ -12,32 +16,40 @@ type Employee struct {
         DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
         Name         string         `json:"name" binding:"required"`
         Email        string         `gorm:"unique" json:"email" binding:"required,email"`
-        DepartmentID uint           `json:"department_id"`
+        DepartmentID uint           `gorm:"index" json:"department_id"`
         Department   *Department    `gorm:"foreignKey:DepartmentID" json:"department,omitempty"`
         JobTitle     string         `json:"job_title"`
         HireDate     time.Time      `json:"hire_date"`
-        ManagerID    *uint          `json:"manager_id"`
+        ManagerID    *uint          `gorm:"index" json:"manager_id"`
         Manager      *Employee      `gorm:"foreignKey:ManagerID" json:"manager,omitempty"`
         Reports      []Employee     `gorm:"foreignKey:ManagerID" json:"reports,omitempty"`
         UserID       *uint          `json:"user_id"`
         User         *User          `gorm:"foreignKey:UserID" json:"user,omitempty"`
+        ExternalID   string         `gorm:"index" json:"external_id"`
+        Version      uint           `gorm:"not null;default:1" json:"version"`
         
         EmployeeNumber      string     `json:"employee_number"`
-        DateOfBirth         *time.Time `json:"date_of_birth"`
-        NationalID          string     `json:"national_id"`
-        TaxID               string     `json:"tax_id"`
+        DateOfBirth         *time.Time `gorm:"type:text;serializer:encrypted" json:"date_of_birth"`
+        NationalID          string     `gorm:"type:text;serializer:encrypted" json:"national_id"`
+        NationalIDHash      *string    `gorm:"uniqueIndex" json:"-"`
+        TaxID               string     `gorm:"type:text;serializer:encrypted" json:"tax_id"`
+        TaxIDHash           *string    `gorm:"uniqueIndex" json:"-"`
         MaritalStatus       string     `json:"marital_status"`
         
         EmploymentType      string     `json:"employment_type"`
-        EmploymentStatus    string     `json:"employment_status" gorm:"default:'active'"`
+        EmploymentStatus    string     `json:"employment_status" gorm:"index;default:'active'"`
         JobLevel            string     `json:"job_level"`
         WorkLocation        string     `json:"work_location"`
         WorkArrangement     string     `json:"work_arrangement"`
+        // Timezone is an IANA zone overriding that of the work location.
+        Timezone            string     `json:"timezone"`
+        TerminationReason   string     `gorm:"type:text" json:"termination_reason"`
+        LastWorkingDay      *time.Time `json:"last_working_day"`
         
         BaseSalary          float64    `json:"base_salary"`
         PayFrequency        string     `json:"pay_frequency"`
         Currency            string     `json:"currency" gorm:"default:'USD'"`
-        BankAccount         string     `json:"bank_account"`
+        BankAccount         string     `gorm:"type:text;serializer:encrypted" json:"bank_account"`
         BenefitEligibility  string     `json:"benefit_eligibility"`
         
         ProbationEndDate    *time.Time `json:"probation_end_date"`
@@ -45,16 +57,201 @@ type Employee struct {
         Skills              string     `gorm:"type:text" json:"skills"`
         TrainingCompleted   string     `gorm:"type:text" json:"training_completed"`
         CareerNotes         string     `gorm:"type:text" json:"career_notes"`
+
+        // CustomFields holds the values of the fields HR has defined, by
+        // CustomField key.
+        CustomFields map[string]interface{} `gorm:"type:jsonb;serializer:json" json:"custom_fields"`
+}
+
+// Employment statuses. Apart from the initial one they are set by the
+// lifecycle transitions, or by SCIM for inactive.
+const (
+        EmploymentStatusPending    = "pending"
+        EmploymentStatusProbation  = "probation"
+        EmploymentStatusActive     = "active"
+        EmploymentStatusOnLeave    = "on_leave"
+        EmploymentStatusTerminated = "terminated"
+        EmploymentStatusInactive   = "inactive"
+
+        // EmploymentStatusResigned was set by hand before terminations
+        // recorded a reason; it is treated like terminated.
+        EmploymentStatusResigned = "resigned"
+)
+
+// DepartedStatuses are the statuses of people who have left. They can't sign
+// in and don't count towards headcounts or appear in the org chart.
+var DepartedStatuses = []string{EmploymentStatusTerminated, EmploymentStatusResigned, EmploymentStatusInactive}
+
+// IsDeparted reports whether status is one of DepartedStatuses.
+func IsDeparted(status string) bool {
+        for _, departed := range DepartedStatuses {
+                if status == departed {
+                        return true
+                }
+        }
+        return false
+}
+
+// EmploymentEvent records a lifecycle transition such as a termination or a
+// rehire. A termination whose last working day is still ahead waits with
+// Pending set until the scheduler offboards the employee after that day.
+type EmploymentEvent struct {
+        ID            uint      `gorm:"primarykey" json:"id"`
+        CreatedAt     time.Time `json:"created_at"`
+        EmployeeID    uint      `gorm:"index" json:"employee_id"`
+        Action        string    `json:"action"`
+        FromStatus    string    `json:"from_status"`
+        ToStatus      string    `json:"to_status"`
+        EffectiveDate time.Time `json:"effective_date"`
+        Reason        string    `gorm:"type:text" json:"reason"`
+        Notes         string    `gorm:"type:text" json:"notes"`
+        ActorUserID   *uint     `json:"actor_user_id"`
+        Pending       bool      `gorm:"index" json:"pending"`
+}
+
+// JobRecord is an employee's job from EffectiveDate on: title, department,
+// manager, level and pay. One is written whenever any of them changes.
+// Records dated in the future wait with AppliedAt unset until the scheduler
+// copies their ChangedFields onto the employee on that day.
+type JobRecord struct {
+        ID            uint       `gorm:"primarykey" json:"id"`
+        CreatedAt     time.Time  `json:"created_at"`
+        UpdatedAt     time.Time  `json:"updated_at"`
+        EmployeeID    uint       `gorm:"index:idx_job_records_employee_date" json:"employee_id"`
+        EffectiveDate time.Time  `gorm:"index:idx_job_records_employee_date" json:"effective_date"`
+        JobTitle      string     `json:"job_title"`
+        DepartmentID  uint       `json:"department_id"`
+        ManagerID     *uint      `json:"manager_id"`
+        JobLevel      string     `json:"job_level"`
+        BaseSalary    float64    `json:"base_salary"`
+        ChangedFields []string   `gorm:"type:text;serializer:json" json:"changed_fields"`
+        Reason        string     `gorm:"type:text" json:"reason"`
+        ActorUserID   *uint      `json:"actor_user_id"`
+        AppliedAt     *time.Time `gorm:"index" json:"applied_at"`
+}
+
+// JobFields are the employee fields a JobRecord tracks, by JSON name.
+var JobFields = []string{"job_title", "department_id", "manager_id", "job_level", "base_salary"}
+
+// JobRecordFor snapshots e's current job as a record effective from the
+// given day.
+func JobRecordFor(e Employee, effective time.Time) JobRecord {
+        return JobRecord{
+                EmployeeID:    e.ID,
+                EffectiveDate: effective,
+                JobTitle:      e.JobTitle,
+                DepartmentID:  e.DepartmentID,
+                ManagerID:     e.ManagerID,
+                JobLevel:      e.JobLevel,
+                BaseSalary:    e.BaseSalary,
+        }
+}
+
+// EmployeeSearchDocument is the text employee search matches against: name,
+// email and job title, lowercased. The trigram index created in
+// database.Migrate is built on this exact expression.
+const EmployeeSearchDocument = "LOWER(COALESCE(employees.name, '') || ' ' || COALESCE(employees.email, '') || ' ' || COALESCE(employees.job_title, ''))"
+
+// BeforeSave keeps the blind indexes in step with the encrypted identifiers
+// so they can still be looked up and kept unique.
+func (e *Employee) BeforeSave(tx *gorm.DB) error {
+        e.NationalIDHash = blindIndex(e.NationalID)
+        e.TaxIDHash = blindIndex(e.TaxID)
+        return nil
+}
+
+func (e *Employee) BeforeUpdate(tx *gorm.DB) error {
+        bumpVersion(tx, &e.Version)
+        return nil
+}
+
+func (l *LeaveRequest) BeforeUpdate(tx *gorm.DB) error {
+        bumpVersion(tx, &l.Version)
+        return nil
+}
+
+func (s *ChatbotSettings) BeforeUpdate(tx *gorm.DB) error {
+        bumpVersion(tx, &s.Version)
+        return nil
+}
+
+// bumpVersion makes every update of a record increment its version, so a
+// client holding an older version can tell it changed. Saving a record
+// writes the incremented field; column updates, which may cover many rows,
+// increment the column itself.
+func bumpVersion(tx *gorm.DB, version *uint) {
+        if _, ok := tx.Statement.Dest.(map[string]interface{}); ok {
+                tx.Statement.SetColumn("version", gorm.Expr("version + 1"), true)
+                return
+        }
+        *version++
+}
+
+func blindIndex(value string) *string {
+        if hash := fieldcrypt.BlindIndex(value); hash != "" {
+                return &hash
+        }
+        return nil
+}
+
+// WorkLocation gives employees whose WorkLocation is Name, and who have no
+// zone of their own, the IANA zone Timezone.
+type WorkLocation struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `json:"created_at"`
+        UpdatedAt time.Time `json:"updated_at"`
+        Name      string    `gorm:"uniqueIndex" json:"name"`
+        Timezone  string    `json:"timezone"`
+}
+
+// CustomField is an employee attribute defined by HR rather than in code.
+// Employees keep their values in CustomFields under Key. Visibility says who
+// may see a value, in the same terms as the built-in fields.
+type CustomField struct {
+        ID         uint      `gorm:"primarykey" json:"id"`
+        CreatedAt  time.Time `json:"created_at"`
+        UpdatedAt  time.Time `json:"updated_at"`
+        Key        string    `gorm:"uniqueIndex" json:"key"`
+        Label      string    `json:"label"`
+        Type       string    `json:"type"`
+        Options    []string  `gorm:"type:text;serializer:json" json:"options"`
+        Required   bool      `json:"required"`
+        Min        *float64  `json:"min"`
+        Max        *float64  `json:"max"`
+        MaxLength  int       `json:"max_length"`
+        Visibility string    `gorm:"default:'directory'" json:"visibility"`
+        Position   int       `json:"position"`
 }
 
+// Custom field types.
+const (
+        CustomFieldText        = "text"
+        CustomFieldNumber      = "number"
+        CustomFieldDate        = "date"
+        CustomFieldEnum        = "enum"
+        CustomFieldMultiSelect = "multi_select"
+)
+
+// Custom field visibilities. Directory values are shown to anyone who can
+// read the employee; the others follow the built-in field groups of the same
+// name, and hr values are shown to HR only.
+const (
+        CustomFieldDirectory    = "directory"
+        CustomFieldPersonal     = "personal"
+        CustomFieldCompensation = "compensation"
+        CustomFieldPerformance  = "performance"
+        CustomFieldHR           = "hr"
+)
+
 type Department struct {
-        ID        uint           `gorm:"primarykey" json:"id"`
-        CreatedAt time.Time      `json:"created_at"`
-        UpdatedAt time.Time      `json:"updated_at"`
-        DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
-        Name      string         `json:"name" binding:"required"`
-        ParentID  *uint          `json:"parent_id"`
-        Parent    *Department    `gorm:"foreignKey:ParentID" json:"parent,omitempty"`
+        ID         uint           `gorm:"primarykey" json:"id"`
+        CreatedAt  time.Time      `json:"created_at"`
+        UpdatedAt  time.Time      `json:"updated_at"`
+        DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+        Name       string         `json:"name" binding:"required"`
+        ParentID   *uint          `json:"parent_id"`
+        Parent     *Department    `gorm:"foreignKey:ParentID" json:"parent,omitempty"`
+        ExternalID string         `gorm:"index" json:"external_id"`
 }
 
 type Attendance struct {
@@ -62,12 +259,156 @@ type Attendance struct {
         CreatedAt  time.Time      `json:"created_at"`
         UpdatedAt  time.Time      `json:"updated_at"`
         DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
-        EmployeeID uint           `json:"employee_id" binding:"required"`
+        EmployeeID uint           `gorm:"index" json:"employee_id" binding:"required"`
         Employee   *Employee      `gorm:"foreignKey:EmployeeID" json:"employee,omitempty"`
-        Date       time.Time      `json:"date" binding:"required"`
-        ClockIn    time.Time      `json:"clock_in"`
-        ClockOut   *time.Time     `json:"clock_out"`
         Location   string         `json:"location"`
+
+        // Date is the day the employee clocked in on in Timezone, as
+        // midnight UTC. ClockIn and ClockOut are returned in UTC, and again
+        // in Timezone as ClockInLocal and ClockOutLocal.
+        Date          time.Time  `gorm:"index" json:"date" binding:"required"`
+        Timezone      string     `json:"timezone"`
+        ClockIn       time.Time  `json:"clock_in"`
+        ClockOut      *time.Time `json:"clock_out"`
+        ClockInLocal  time.Time  `gorm:"-" json:"clock_in_local"`
+        ClockOutLocal *time.Time `gorm:"-" json:"clock_out_local"`
+
+        // Set by the attendance evaluator against the shift the employee
+        // was scheduled for. Every row of the same shift carries the same
+        // evaluation.
+        ShiftID           *uint  `json:"shift_id"`
+        Status            string `gorm:"index" json:"status"`
+        LateMinutes       int    `json:"late_minutes"`
+        EarlyLeaveMinutes int    `json:"early_leave_minutes"`
+        OvertimeMinutes   int    `json:"overtime_minutes"`
+}
+
+// AfterFind puts the times in UTC and fills in their local equivalents.
+func (a *Attendance) AfterFind(tx *gorm.DB) error {
+        loc := timezone.Resolve(a.Timezone)
+        a.Date = a.Date.UTC()
+        a.ClockIn = a.ClockIn.UTC()
+        a.ClockInLocal = a.ClockIn.In(loc)
+        if a.ClockOut != nil {
+                clockOut := a.ClockOut.UTC()
+                local := clockOut.In(loc)
+                a.ClockOut, a.ClockOutLocal = &clockOut, &local
+        }
+        return nil
+}
+
+// Attendance statuses. Absent is only reported for scheduled shifts nobody
+// clocked in for; it is never stored, as there is no row to store it on. Nor
+// are scheduled, for shifts still to come, and on leave.
+const (
+        AttendanceOnTime      = "on_time"
+        AttendanceLate        = "late"
+        AttendanceEarlyLeave  = "early_leave"
+        AttendanceOvertime    = "overtime"
+        AttendanceAbsent      = "absent"
+        AttendanceUnscheduled = "unscheduled"
+        AttendanceScheduled   = "scheduled"
+        AttendanceOnLeave     = "on_leave"
+)
+
+// Shift is a template for a working day. StartTime and EndTime are HH:MM;
+// a shift that ends at or before its start runs past midnight. Days are the
+// days of the week it is worked, 0 for Sunday to 6 for Saturday.
+type Shift struct {
+        ID           uint           `gorm:"primarykey" json:"id"`
+        CreatedAt    time.Time      `json:"created_at"`
+        UpdatedAt    time.Time      `json:"updated_at"`
+        DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+        Name         string         `json:"name"`
+        StartTime    string         `json:"start_time"`
+        EndTime      string         `json:"end_time"`
+        Days         []int          `gorm:"type:text;serializer:json" json:"days"`
+        GraceMinutes int            `json:"grace_minutes"`
+
+        // BreakMinutes of unpaid break are deducted from shifts worked for
+        // longer than BreakAfterMinutes. Time clocked out during the shift
+        // counts towards the break.
+        BreakMinutes      int `json:"break_minutes"`
+        BreakAfterMinutes int `json:"break_after_minutes"`
+}
+
+// ShiftAssignment puts an employee on a roster from StartDate until EndDate,
+// or indefinitely if EndDate is nil. The roster is ShiftIDs in turn, each
+// for RotationDays days, starting again after the last; a single shift is
+// simply worked throughout. A 0 in ShiftIDs is a stretch of days off.
+type ShiftAssignment struct {
+        ID           uint       `gorm:"primarykey" json:"id"`
+        CreatedAt    time.Time  `json:"created_at"`
+        UpdatedAt    time.Time  `json:"updated_at"`
+        EmployeeID   uint       `gorm:"index" json:"employee_id"`
+        ShiftIDs     []uint     `gorm:"type:text;serializer:json" json:"shift_ids"`
+        RotationDays int        `json:"rotation_days"`
+        StartDate    time.Time  `json:"start_date"`
+        EndDate      *time.Time `json:"end_date"`
+}
+
+// OvertimePolicy is how worked time is split into regular time and overtime
+// and what each is paid at. There is one policy, with ID 1; until it is
+// saved DefaultOvertimePolicy applies.
+type OvertimePolicy struct {
+        ID        uint      `gorm:"primarykey" json:"-"`
+        UpdatedAt time.Time `json:"updated_at"`
+
+        // Time worked beyond either threshold is overtime; 0 turns a
+        // threshold off. Weeks start on WeekStartDay, 0 for Sunday.
+        DailyThresholdMinutes  int `json:"daily_threshold_minutes"`
+        WeeklyThresholdMinutes int `json:"weekly_threshold_minutes"`
+        WeekStartDay           int `json:"week_start_day"`
+
+        // Multipliers of the base rate. Overtime on a weekend or holiday is
+        // paid at the highest that applies.
+        OvertimeMultiplier float64 `json:"overtime_multiplier"`
+        WeekendDays        []int   `gorm:"type:text;serializer:json" json:"weekend_days"`
+        WeekendMultiplier  float64 `json:"weekend_multiplier"`
+        HolidayMultiplier  float64 `json:"holiday_multiplier"`
+
+        // Each day's worked time is rounded to a multiple of RoundingMinutes,
+        // by RoundingMode: nearest, up or down. 0 leaves it unrounded.
+        RoundingMinutes int    `json:"rounding_minutes"`
+        RoundingMode    string `json:"rounding_mode"`
+
+        // Break rules, as on Shift, for time worked without a shift.
+        BreakMinutes      int `json:"break_minutes"`
+        BreakAfterMinutes int `json:"break_after_minutes"`
+}
+
+// Rounding modes of OvertimePolicy.
+const (
+        RoundNearest = "nearest"
+        RoundUp      = "up"
+        RoundDown    = "down"
+)
+
+// DefaultOvertimePolicy is overtime after 8 hours a day or 40 a week, from
+// Monday, at time and a half, with weekends at time and a half and holidays
+// at double time.
+func DefaultOvertimePolicy() OvertimePolicy {
+        return OvertimePolicy{
+                ID:                     1,
+                DailyThresholdMinutes:  8 * 60,
+                WeeklyThresholdMinutes: 40 * 60,
+                WeekStartDay:           int(time.Monday),
+                OvertimeMultiplier:     1.5,
+                WeekendDays:            []int{int(time.Saturday), int(time.Sunday)},
+                WeekendMultiplier:      1.5,
+                HolidayMultiplier:      2,
+                RoundingMode:           RoundNearest,
+        }
+}
+
+// Holiday is a public holiday, paid at the overtime policy's holiday
+// multiplier. Date is midnight UTC.
+type Holiday struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `json:"created_at"`
+        UpdatedAt time.Time `json:"updated_at"`
+        Date      time.Time `gorm:"uniqueIndex" json:"date"`
+        Name      string    `json:"name"`
 }
 
 type LeaveRequest struct {
@@ -75,12 +416,13 @@ type LeaveRequest struct {
         CreatedAt  time.Time      `json:"created_at"`
         UpdatedAt  time.Time      `json:"updated_at"`
         DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
-        EmployeeID uint           `json:"employee_id" binding:"required"`
+        EmployeeID uint           `gorm:"index" json:"employee_id" binding:"required"`
         Employee   *Employee      `gorm:"foreignKey:EmployeeID" json:"employee,omitempty"`
         LeaveType  string         `json:"leave_type" binding:"required"`
         StartDate  time.Time      `json:"start_date" binding:"required"`
         EndDate    time.Time      `json:"end_date" binding:"required"`
-        Status     string         `json:"status" gorm:"default:'pending'"`
+        Status     string         `json:"status" gorm:"index;default:'pending'"`
+        Version    uint           `gorm:"not null;default:1" json:"version"`
 }
 
 type SalaryComponent struct {
@@ -95,16 +437,23 @@ type SalaryComponent struct {
         EffectiveDate time.Time      `json:"effective_date"`
 }
 
+// Document is a file kept for an employee, such as a contract or passport
+// scan. Its contents are in the blob store under FilePath.
 type Document struct {
-        ID         uint           `gorm:"primarykey" json:"id"`
-        CreatedAt  time.Time      `json:"created_at"`
-        UpdatedAt  time.Time      `json:"updated_at"`
-        DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
-        EmployeeID uint           `json:"employee_id" binding:"required"`
-        Employee   *Employee      `gorm:"foreignKey:EmployeeID" json:"employee,omitempty"`
-        DocType    string         `json:"doc_type" binding:"required"`
-        FilePath   string         `json:"file_path"`
-        ExpiryDate *time.Time     `json:"expiry_date"`
+        ID          uint           `gorm:"primarykey" json:"id"`
+        CreatedAt   time.Time      `json:"created_at"`
+        UpdatedAt   time.Time      `json:"updated_at"`
+        DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+        EmployeeID  uint           `gorm:"index" json:"employee_id" binding:"required"`
+        Employee    *Employee      `gorm:"foreignKey:EmployeeID" json:"employee,omitempty"`
+        DocType     string         `json:"doc_type" binding:"required"`
+        FilePath    string         `json:"-"`
+        FileName    string         `json:"file_name"`
+        ContentType string         `json:"content_type"`
+        Size        int64          `json:"size"`
+        Checksum    string         `json:"checksum"`
+        ExpiryDate  *time.Time     `gorm:"index" json:"expiry_date"`
+        UploadedBy  *uint          `json:"uploaded_by"`
 }
 
 type PayrollExport struct {
@@ -125,6 +474,18 @@ type User struct {
         Username  string         `gorm:"unique" json:"username" binding:"required"`
         Email     string         `gorm:"unique" json:"email" binding:"required,email"`
         Password  string         `json:"-"`
+        Role      string         `gorm:"default:'employee'" json:"role"`
+
+        EmailVerifiedAt    *time.Time `json:"email_verified_at"`
+        MustChangePassword bool       `json:"must_change_password"`
+
+        TOTPSecret   string `gorm:"type:text;serializer:encrypted" json:"-"`
+        TOTPEnabled  bool   `json:"totp_enabled"`
+        TOTPLastStep int64  `json:"-"`
+
+        // OIDCSubject is the identity provider's ID for a single sign-on
+        // user. Those users have no local password.
+        OIDCSubject *string `gorm:"column:oidc_subject;uniqueIndex" json:"-"`
 }
 
 type ChatFeedback struct {
@@ -148,4 +509,144 @@ type ChatbotSettings struct {
         Key         string         `gorm:"unique" json:"key" binding:"required"`
         Value       string         `gorm:"type:text" json:"value"`
         Description string         `gorm:"type:text" json:"description"`
+        Version     uint           `gorm:"not null;default:1" json:"version"`
+}
+
+type Session struct {
+        ID                uint           `gorm:"primarykey" json:"id"`
+        CreatedAt         time.Time      `json:"created_at"`
+        UpdatedAt         time.Time      `json:"updated_at"`
+        DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
+        UserID            uint           `gorm:"index" json:"user_id"`
+        User              *User          `gorm:"foreignKey:UserID" json:"user,omitempty"`
+        TokenHash         string         `gorm:"uniqueIndex" json:"-"`
+        PreviousTokenHash string         `gorm:"index" json:"-"`
+        ExpiresAt         time.Time      `json:"expires_at"`
+        LastUsedAt        time.Time      `json:"last_used_at"`
+        RevokedAt         *time.Time     `json:"revoked_at"`
+        UserAgent         string         `json:"user_agent"`
+        IPAddress         string         `json:"ip_address"`
+}
+
+// OIDCLoginState holds what a single sign-on attempt needs to check the
+// provider's callback: the nonce expected in the ID token and the PKCE
+// verifier. It is deleted when the callback arrives.
+type OIDCLoginState struct {
+        ID           uint      `gorm:"primarykey" json:"id"`
+        CreatedAt    time.Time `json:"created_at"`
+        StateHash    string    `gorm:"uniqueIndex" json:"-"`
+        Nonce        string    `json:"-"`
+        CodeVerifier string    `json:"-"`
+        ExpiresAt    time.Time `gorm:"index" json:"expires_at"`
+}
+
+func (OIDCLoginState) TableName() string {
+        return "oidc_login_states"
+}
+
+type UserToken struct {
+        ID        uint           `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time      `json:"created_at"`
+        UpdatedAt time.Time      `json:"updated_at"`
+        DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+        UserID    uint           `gorm:"index" json:"user_id"`
+        User      *User          `gorm:"foreignKey:UserID" json:"user,omitempty"`
+        Purpose   string         `gorm:"index" json:"purpose"`
+        TokenHash string         `gorm:"uniqueIndex" json:"-"`
+        ExpiresAt time.Time      `json:"expires_at"`
+        UsedAt    *time.Time     `json:"used_at"`
+}
+
+type RecoveryCode struct {
+        ID        uint           `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time      `json:"created_at"`
+        UpdatedAt time.Time      `json:"updated_at"`
+        DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+        UserID    uint           `gorm:"index" json:"user_id"`
+        CodeHash  string         `gorm:"uniqueIndex" json:"-"`
+        UsedAt    *time.Time     `json:"used_at"`
+}
+
+type RolePolicy struct {
+        ID               uint           `gorm:"primarykey" json:"id"`
+        CreatedAt        time.Time      `json:"created_at"`
+        UpdatedAt        time.Time      `json:"updated_at"`
+        DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+        Role             string         `gorm:"unique" json:"role" binding:"required"`
+        RequireTwoFactor bool           `json:"require_two_factor"`
+}
+
+type LoginThrottle struct {
+        ID            uint       `gorm:"primarykey" json:"id"`
+        CreatedAt     time.Time  `json:"created_at"`
+        UpdatedAt     time.Time  `json:"updated_at"`
+        Key           string     `gorm:"unique" json:"key"`
+        Failures      int        `json:"failures"`
+        LastFailureAt time.Time  `json:"last_failure_at"`
+        NextAttemptAt time.Time  `json:"next_attempt_at"`
+        LockedUntil   *time.Time `json:"locked_until"`
+}
+
+type SecurityEvent struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `gorm:"index" json:"created_at"`
+        Event     string    `gorm:"index" json:"event"`
+        Username  string    `gorm:"index" json:"username"`
+        UserID    *uint     `json:"user_id"`
+        IPAddress string    `gorm:"index" json:"ip_address"`
+        UserAgent string    `json:"user_agent"`
+        Detail    string    `gorm:"type:text" json:"detail"`
+}
+
+// APIKey lets another system call the API without a user account. Only the
+// hash of the key is stored; the key itself is shown once, at creation.
+type APIKey struct {
+        ID          uint       `gorm:"primarykey" json:"id"`
+        CreatedAt   time.Time  `json:"created_at"`
+        UpdatedAt   time.Time  `json:"updated_at"`
+        Name        string     `json:"name"`
+        Prefix      string     `json:"prefix"`
+        KeyHash     string     `gorm:"uniqueIndex" json:"-"`
+        Scopes      []string   `gorm:"type:text;serializer:json" json:"scopes"`
+        ExpiresAt   *time.Time `json:"expires_at"`
+        LastUsedAt  *time.Time `json:"last_used_at"`
+        LastUsedIP  string     `json:"last_used_ip"`
+        RevokedAt   *time.Time `json:"revoked_at"`
+        CreatedByID *uint      `json:"created_by_id"`
+}
+
+// AuditEvent records a single create, update or delete of an audited row.
+type AuditEvent struct {
+        ID          uint                   `gorm:"primarykey" json:"id"`
+        CreatedAt   time.Time              `gorm:"index" json:"created_at"`
+        ActorUserID *uint                  `gorm:"index" json:"actor_user_id"`
+        Action      string                 `gorm:"index" json:"action"`
+        Entity      string                 `gorm:"index:idx_audit_entity" json:"entity"`
+        EntityID    string                 `gorm:"index:idx_audit_entity" json:"entity_id"`
+        Changes     map[string]AuditChange `gorm:"type:text;serializer:json" json:"changes"`
+        RequestID   string                 `gorm:"index" json:"request_id"`
+        IPAddress   string                 `json:"ip_address"`
+        Source      string                 `gorm:"index" json:"source"`
+}
+
+// AuditChange is the before and after value of one column. Old is null for
+// creates and New is null for deletes.
+type AuditChange struct {
+        Old interface{} `json:"old"`
+        New interface{} `json:"new"`
+}
+
+type Invitation struct {
+        ID          uint           `gorm:"primarykey" json:"id"`
+        CreatedAt   time.Time      `json:"created_at"`
+        UpdatedAt   time.Time      `json:"updated_at"`
+        DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+        EmployeeID  uint           `gorm:"index" json:"employee_id"`
+        Employee    *Employee      `gorm:"foreignKey:EmployeeID" json:"employee,omitempty"`
+        Email       string         `json:"email"`
+        Role        string         `json:"role"`
+        TokenHash   string         `gorm:"uniqueIndex" json:"-"`
+        ExpiresAt   time.Time      `json:"expires_at"`
+        AcceptedAt  *time.Time     `json:"accepted_at"`
+        InvitedByID *uint          `json:"invited_by_id"`
 }
@@ -0,0 +1,122 @@
+package models
+
+// User roles. A user has exactly one role; permissions are derived from it.
+const (
+        RoleEmployee     = "employee"
+        RoleManager      = "manager"
+        RoleHRAdmin      = "hr_admin"
+        RolePayrollAdmin = "payroll_admin"
+        RoleSystemAdmin  = "system_admin"
+)
+
+// Permissions checked by the route middleware and, for employee fields, the
+// views package.
+const (
+        PermEmployeesRead      = "employees:read"
+        PermEmployeesWrite     = "employees:write"
+        PermEmployeesSensitive = "employees:sensitive"
+        PermAttendanceRead     = "attendance:read"
+        PermAttendanceSelf     = "attendance:self"
+        PermLeaveRequest       = "leave:request"
+        PermLeaveRead          = "leave:read"
+        PermLeaveApprove       = "leave:approve"
+        PermPayrollRead        = "payroll:read"
+        PermPayrollSelf        = "payroll:self"
+        PermPayrollExport      = "payroll:export"
+        PermChatUse            = "chat:use"
+        PermFeedbackWrite      = "feedback:write"
+        PermFeedbackRead       = "feedback:read"
+        PermSettingsRead       = "settings:read"
+        PermSettingsWrite      = "settings:write"
+        PermUsersManage        = "users:manage"
+        PermAuditRead          = "audit:read"
+        PermSCIMProvision      = "scim:provision"
+)
+
+var baseEmployeePermissions = []string{
+        PermEmployeesRead,
+        PermAttendanceSelf,
+        PermLeaveRequest,
+        PermLeaveRead,
+        PermPayrollSelf,
+        PermChatUse,
+        PermFeedbackWrite,
+        PermSettingsRead,
+}
+
+var rolePermissions = map[string][]string{
+        RoleEmployee: baseEmployeePermissions,
+        RoleManager: append([]string{
+                PermAttendanceRead,
+                PermLeaveApprove,
+        }, baseEmployeePermissions...),
+        RoleHRAdmin: append([]string{
+                PermEmployeesWrite,
+                PermEmployeesSensitive,
+                PermAttendanceRead,
+                PermLeaveApprove,
+                PermPayrollRead,
+                PermFeedbackRead,
+                PermAuditRead,
+        }, baseEmployeePermissions...),
+        RolePayrollAdmin: append([]string{
+                PermAttendanceRead,
+                PermPayrollRead,
+                PermPayrollExport,
+        }, baseEmployeePermissions...),
+        RoleSystemAdmin: append([]string{
+                PermEmployeesWrite,
+                PermEmployeesSensitive,
+                PermAttendanceRead,
+                PermLeaveApprove,
+                PermPayrollRead,
+                PermPayrollExport,
+                PermFeedbackRead,
+                PermSettingsWrite,
+                PermUsersManage,
+                PermAuditRead,
+                PermSCIMProvision,
+        }, baseEmployeePermissions...),
+}
+
+// IsValidRole reports whether role is one of the known roles.
+func IsValidRole(role string) bool {
+        _, ok := rolePermissions[role]
+        return ok
+}
+
+// APIKeyScopes are the permissions an API key may be granted. Permissions
+// that only make sense for a person, such as clocking in, and user
+// administration are left out.
+var APIKeyScopes = []string{
+        PermEmployeesRead,
+        PermEmployeesWrite,
+        PermEmployeesSensitive,
+        PermAttendanceRead,
+        PermLeaveRead,
+        PermPayrollRead,
+        PermPayrollExport,
+        PermFeedbackRead,
+        PermSettingsRead,
+        PermAuditRead,
+        PermSCIMProvision,
+}
+
+func IsValidAPIKeyScope(scope string) bool {
+        for _, s := range APIKeyScopes {
+                if s == scope {
+                        return true
+                }
+        }
+        return false
+}
+
+// RoleHasPermission reports whether the given role grants permission.
+func RoleHasPermission(role, permission string) bool {
+        for _, p := range rolePermissions[role] {
+                if p == permission {
+                        return true
+                }
+        }
+        return false
+}
@@ -0,0 +1,285 @@
+// Package fakeidp is an OpenID Connect provider for testing single sign-on
+// without a real one. It serves discovery, an authorization endpoint whose
+// sign-in form accepts any email and groups, a token endpoint that checks
+// PKCE, and its signing keys. Never expose it: it authenticates anyone as
+// anyone.
+package fakeidp
+
+import (
+        "crypto/rand"
+        "crypto/rsa"
+        "crypto/sha256"
+        "encoding/base64"
+        "encoding/json"
+        "fmt"
+        "html/template"
+        "math/big"
+        "net/http"
+        "net/url"
+        "strings"
+        "sync"
+        "time"
+
+        "github.com/golang-jwt/jwt/v5"
+)
+
+type authorization struct {
+        ClientID      string
+        RedirectURI   string
+        Nonce         string
+        CodeChallenge string
+        Email         string
+        EmailVerified bool
+        Name          string
+        Groups        []string
+        ExpiresAt     time.Time
+}
+
+type signingKey struct {
+        id  string
+        key *rsa.PrivateKey
+}
+
+// Server is the provider. Issuer must match the URL it is reached at, so
+// tests behind httptest set it once the listener is up.
+type Server struct {
+        Issuer   string
+        ClientID string
+
+        mu    sync.Mutex
+        keys  []signingKey
+        codes map[string]authorization
+}
+
+var signInPage = template.Must(template.New("signin").Parse(`<!doctype html>
+<title>Mock IdP</title>
+<h1>Mock identity provider</h1>
+<form method="post">
+  {{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+  {{end}}
+  <p><label>Email <input name="email" type="email" required autofocus></label></p>
+  <p><label>Name <input name="name"></label></p>
+  <p><label>Groups (comma separated) <input name="groups"></label></p>
+  <p><label><input type="checkbox" name="unverified"> Email not verified</label></p>
+  <button>Sign in</button>
+</form>
+`))
+
+// New returns a provider that only accepts clientID, with one signing key.
+func New(issuer, clientID string) (*Server, error) {
+        s := &Server{Issuer: issuer, ClientID: clientID, codes: map[string]authorization{}}
+        if err := s.Rotate(); err != nil {
+                return nil, err
+        }
+        return s, nil
+}
+
+// Rotate publishes a new signing key and signs with it from now on. Earlier
+// keys stay published, as providers keep them until their tokens expire.
+func (s *Server) Rotate() error {
+        key, err := rsa.GenerateKey(rand.Reader, 2048)
+        if err != nil {
+                return fmt.Errorf("failed to generate signing key: %w", err)
+        }
+        s.mu.Lock()
+        defer s.mu.Unlock()
+        s.keys = append(s.keys, signingKey{id: fmt.Sprintf("fakeidp-%d", len(s.keys)+1), key: key})
+        return nil
+}
+
+// Sign returns claims as an ID token signed with the current key, for tests
+// that need tokens the token endpoint wouldn't issue.
+func (s *Server) Sign(claims jwt.MapClaims) (string, error) {
+        s.mu.Lock()
+        current := s.keys[len(s.keys)-1]
+        s.mu.Unlock()
+
+        token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+        token.Header["kid"] = current.id
+        return token.SignedString(current.key)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/.well-known/openid-configuration":
+                s.discovery(w, r)
+        case "/authorize":
+                s.authorize(w, r)
+        case "/token":
+                s.token(w, r)
+        case "/jwks":
+                s.jwks(w, r)
+        default:
+                http.NotFound(w, r)
+        }
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(status)
+        json.NewEncoder(w).Encode(body)
+}
+
+func tokenError(w http.ResponseWriter, code, description string) {
+        writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, map[string]interface{}{
+                "issuer":                                s.Issuer,
+                "authorization_endpoint":                s.Issuer + "/authorize",
+                "token_endpoint":                        s.Issuer + "/token",
+                "jwks_uri":                              s.Issuer + "/jwks",
+                "response_types_supported":              []string{"code"},
+                "subject_types_supported":               []string{"public"},
+                "id_token_signing_alg_values_supported": []string{"RS256"},
+                "code_challenge_methods_supported":      []string{"S256"},
+        })
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+        s.mu.Lock()
+        keys := []map[string]string{}
+        for _, k := range s.keys {
+                keys = append(keys, map[string]string{
+                        "kid": k.id,
+                        "kty": "RSA",
+                        "use": "sig",
+                        "alg": "RS256",
+                        "n":   base64.RawURLEncoding.EncodeToString(k.key.PublicKey.N.Bytes()),
+                        "e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.PublicKey.E)).Bytes()),
+                })
+        }
+        s.mu.Unlock()
+        writeJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+// authorize shows the sign-in form on GET and issues a code on POST.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+        if err := r.ParseForm(); err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+        }
+
+        params := map[string]string{}
+        for _, name := range []string{"response_type", "client_id", "redirect_uri", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+                params[name] = r.Form.Get(name)
+        }
+        if params["response_type"] != "code" || params["client_id"] != s.ClientID || params["redirect_uri"] == "" {
+                http.Error(w, "expected response_type=code, a redirect_uri and client_id "+s.ClientID, http.StatusBadRequest)
+                return
+        }
+        if params["code_challenge_method"] != "S256" || params["code_challenge"] == "" {
+                http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+                return
+        }
+
+        if r.Method != http.MethodPost {
+                signInPage.Execute(w, map[string]interface{}{"Params": params})
+                return
+        }
+
+        var groups []string
+        for _, group := range strings.Split(r.PostForm.Get("groups"), ",") {
+                if group = strings.TrimSpace(group); group != "" {
+                        groups = append(groups, group)
+                }
+        }
+
+        code := randomToken()
+        s.mu.Lock()
+        s.codes[code] = authorization{
+                ClientID:      params["client_id"],
+                RedirectURI:   params["redirect_uri"],
+                Nonce:         params["nonce"],
+                CodeChallenge: params["code_challenge"],
+                Email:         r.PostForm.Get("email"),
+                EmailVerified: r.PostForm.Get("unverified") == "",
+                Name:          r.PostForm.Get("name"),
+                Groups:        groups,
+                ExpiresAt:     time.Now().Add(time.Minute),
+        }
+        s.mu.Unlock()
+
+        redirect, err := url.Parse(params["redirect_uri"])
+        if err != nil {
+                http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+                return
+        }
+        query := redirect.Query()
+        query.Set("code", code)
+        query.Set("state", params["state"])
+        redirect.RawQuery = query.Encode()
+        http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+                http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+                return
+        }
+        if err := r.ParseForm(); err != nil {
+                tokenError(w, "invalid_request", err.Error())
+                return
+        }
+        if r.PostForm.Get("grant_type") != "authorization_code" {
+                tokenError(w, "unsupported_grant_type", "only authorization_code is supported")
+                return
+        }
+
+        clientID := r.PostForm.Get("client_id")
+        if user, _, ok := r.BasicAuth(); ok {
+                clientID, _ = url.QueryUnescape(user)
+        }
+
+        s.mu.Lock()
+        code := r.PostForm.Get("code")
+        auth, ok := s.codes[code]
+        delete(s.codes, code)
+        s.mu.Unlock()
+
+        if !ok || time.Now().After(auth.ExpiresAt) {
+                tokenError(w, "invalid_grant", "unknown or expired code")
+                return
+        }
+        if clientID != auth.ClientID || r.PostForm.Get("redirect_uri") != auth.RedirectURI {
+                tokenError(w, "invalid_grant", "client_id or redirect_uri does not match the authorization")
+                return
+        }
+        sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+        if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.CodeChallenge {
+                tokenError(w, "invalid_grant", "code_verifier does not match code_challenge")
+                return
+        }
+
+        now := time.Now()
+        signed, err := s.Sign(jwt.MapClaims{
+                "iss":            s.Issuer,
+                "sub":            "mock|" + strings.ToLower(auth.Email),
+                "aud":            auth.ClientID,
+                "iat":            now.Unix(),
+                "exp":            now.Add(5 * time.Minute).Unix(),
+                "nonce":          auth.Nonce,
+                "email":          auth.Email,
+                "email_verified": auth.EmailVerified,
+                "name":           auth.Name,
+                "groups":         auth.Groups,
+        })
+        if err != nil {
+                tokenError(w, "server_error", err.Error())
+                return
+        }
+
+        writeJSON(w, http.StatusOK, map[string]interface{}{
+                "access_token": randomToken(),
+                "token_type":   "Bearer",
+                "expires_in":   300,
+                "id_token":     signed,
+        })
+}
+
+func randomToken() string {
+        b := make([]byte, 24)
+        rand.Read(b)
+        return base64.RawURLEncoding.EncodeToString(b)
+}
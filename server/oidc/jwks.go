@@ -0,0 +1,142 @@
+package oidc
+
+import (
+        "context"
+        "crypto/ecdsa"
+        "crypto/elliptic"
+        "crypto/rsa"
+        "encoding/base64"
+        "errors"
+        "fmt"
+        "math/big"
+        "time"
+)
+
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// Providers rotate keys by publishing the new one before signing with it, so
+// an unknown key ID triggers a refetch, but no more often than this.
+const jwksRefreshInterval = time.Minute
+
+type jsonWebKey struct {
+        Kid string `json:"kid"`
+        Kty string `json:"kty"`
+        Use string `json:"use"`
+        N   string `json:"n"`
+        E   string `json:"e"`
+        Crv string `json:"crv"`
+        X   string `json:"x"`
+        Y   string `json:"y"`
+}
+
+// key returns the provider's public key with the given ID. Tokens without a
+// kid are accepted only while the provider publishes a single key.
+func (p *Provider) key(ctx context.Context, kid string) (interface{}, error) {
+        p.mu.Lock()
+        defer p.mu.Unlock()
+
+        if k := p.lookupKey(kid); k != nil {
+                return k, nil
+        }
+        if time.Since(p.keysFetched) < jwksRefreshInterval {
+                return nil, fmt.Errorf("unknown signing key %q", kid)
+        }
+
+        keys, err := p.fetchKeys(ctx)
+        if err != nil {
+                return nil, err
+        }
+        p.keys, p.keysFetched = keys, time.Now()
+
+        if k := p.lookupKey(kid); k != nil {
+                return k, nil
+        }
+        return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (p *Provider) lookupKey(kid string) interface{} {
+        if kid == "" && len(p.keys) == 1 {
+                for _, k := range p.keys {
+                        return k
+                }
+        }
+        return p.keys[kid]
+}
+
+// fetchKeys reads the JWKS. The caller holds p.mu, so discovery has already
+// succeeded and p.metadata is set.
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]interface{}, error) {
+        var set struct {
+                Keys []jsonWebKey `json:"keys"`
+        }
+        if err := p.getJSON(ctx, p.metadata.JWKSURI, &set); err != nil {
+                return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+        }
+
+        keys := map[string]interface{}{}
+        for _, jwk := range set.Keys {
+                if jwk.Use != "" && jwk.Use != "sig" {
+                        continue
+                }
+                // Key types we don't use are skipped rather than failing the set
+                if k, err := jwk.publicKey(); err == nil {
+                        keys[jwk.Kid] = k
+                }
+        }
+        if len(keys) == 0 {
+                return nil, errors.New("provider publishes no usable signing keys")
+        }
+        return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+        switch k.Kty {
+        case "RSA":
+                n, err := decodeBigInt(k.N)
+                if err != nil {
+                        return nil, err
+                }
+                e, err := decodeBigInt(k.E)
+                if err != nil {
+                        return nil, err
+                }
+                if !e.IsInt64() || e.Int64() < 3 {
+                        return nil, errors.New("invalid RSA exponent")
+                }
+                return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+        case "EC":
+                var curve elliptic.Curve
+                switch k.Crv {
+                case "P-256":
+                        curve = elliptic.P256()
+                case "P-384":
+                        curve = elliptic.P384()
+                case "P-521":
+                        curve = elliptic.P521()
+                default:
+                        return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+                }
+                x, err := decodeBigInt(k.X)
+                if err != nil {
+                        return nil, err
+                }
+                y, err := decodeBigInt(k.Y)
+                if err != nil {
+                        return nil, err
+                }
+                if !curve.IsOnCurve(x, y) {
+                        return nil, errors.New("EC point is not on the curve")
+                }
+                return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+        }
+        return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+        b, err := base64.RawURLEncoding.DecodeString(s)
+        if err != nil || len(b) == 0 {
+                return nil, errors.New("invalid key parameter")
+        }
+        return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,347 @@
+// Package oidc signs users in through an external OpenID Connect identity
+// provider using the authorization code flow with PKCE.
+//
+// The provider's endpoints come from its discovery document and ID tokens are
+// checked against its published signing keys (JWKS), so the only
+// configuration needed is the issuer URL and the client registration.
+package oidc
+
+import (
+        "context"
+        "crypto/rand"
+        "crypto/sha256"
+        "encoding/base64"
+        "encoding/json"
+        "errors"
+        "fmt"
+        "io"
+        "net/http"
+        "net/url"
+        "os"
+        "strings"
+        "sync"
+        "time"
+
+        "github.com/golang-jwt/jwt/v5"
+)
+
+// Default is the configured provider, or nil when single sign-on is off.
+var Default *Provider
+
+type Config struct {
+        Issuer       string
+        ClientID     string
+        ClientSecret string
+        RedirectURL  string
+        Scopes       []string
+
+        // GroupsClaim names the ID token claim that lists the user's groups.
+        GroupsClaim string
+
+        // RoleMapping maps groups to roles, checked in order; the first group
+        // the user belongs to decides their role.
+        RoleMapping []GroupRole
+}
+
+type GroupRole struct {
+        Group string
+        Role  string
+}
+
+// Identity is what a verified ID token says about the user.
+type Identity struct {
+        Subject string
+        Email   string
+        Name    string
+        Groups  []string
+
+        // EmailVerified is nil when the provider doesn't send the claim.
+        EmailVerified *bool
+}
+
+// Init configures Default from the environment. Single sign-on stays off
+// unless OIDC_ISSUER is set.
+//
+//	OIDC_ISSUER         issuer URL; discovery is read from below it
+//	OIDC_CLIENT_ID      client registered with the provider
+//	OIDC_CLIENT_SECRET  optional; public clients rely on PKCE alone
+//	OIDC_REDIRECT_URL   this server's /api/auth/oidc/callback URL
+//	OIDC_SCOPES         space separated (default: openid email profile)
+//	OIDC_GROUPS_CLAIM   claim holding group names (default: groups)
+//	OIDC_ROLE_MAPPING   comma separated group=role pairs, highest role first
+func Init() error {
+        issuer := os.Getenv("OIDC_ISSUER")
+        if issuer == "" {
+                Default = nil
+                return nil
+        }
+
+        cfg := Config{
+                Issuer:       issuer,
+                ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+                ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+                RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+                Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+                GroupsClaim:  os.Getenv("OIDC_GROUPS_CLAIM"),
+        }
+        if cfg.ClientID == "" || cfg.RedirectURL == "" {
+                return fmt.Errorf("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required when OIDC_ISSUER is set")
+        }
+        if len(cfg.Scopes) == 0 {
+                cfg.Scopes = []string{"openid", "email", "profile"}
+        }
+        if cfg.GroupsClaim == "" {
+                cfg.GroupsClaim = "groups"
+        }
+
+        if raw := os.Getenv("OIDC_ROLE_MAPPING"); raw != "" {
+                for _, entry := range strings.Split(raw, ",") {
+                        group, role, ok := strings.Cut(strings.TrimSpace(entry), "=")
+                        if !ok || group == "" || role == "" {
+                                return fmt.Errorf("OIDC_ROLE_MAPPING entries must look like group=role")
+                        }
+                        cfg.RoleMapping = append(cfg.RoleMapping, GroupRole{Group: group, Role: role})
+                }
+        }
+
+        Default = New(cfg)
+        return nil
+}
+
+// Provider talks to one identity provider. Discovery and keys are fetched on
+// first use, so the server still starts while the provider is unreachable.
+type Provider struct {
+        cfg    Config
+        client *http.Client
+
+        mu          sync.Mutex
+        metadata    *metadata
+        keys        map[string]interface{}
+        keysFetched time.Time
+}
+
+type metadata struct {
+        Issuer                string `json:"issuer"`
+        AuthorizationEndpoint string `json:"authorization_endpoint"`
+        TokenEndpoint         string `json:"token_endpoint"`
+        JWKSURI               string `json:"jwks_uri"`
+}
+
+func New(cfg Config) *Provider {
+        return &Provider{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (p *Provider) Config() Config {
+        return p.cfg
+}
+
+// MapsRoles reports whether roles are managed by the provider's groups.
+func (p *Provider) MapsRoles() bool {
+        return len(p.cfg.RoleMapping) > 0
+}
+
+// Role returns the role for the first mapping entry whose group is in
+// groups, or "" if none match.
+func (p *Provider) Role(groups []string) string {
+        member := map[string]bool{}
+        for _, group := range groups {
+                member[group] = true
+        }
+        for _, mapping := range p.cfg.RoleMapping {
+                if member[mapping.Group] {
+                        return mapping.Role
+                }
+        }
+        return ""
+}
+
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+        p.mu.Lock()
+        defer p.mu.Unlock()
+        if p.metadata != nil {
+                return p.metadata, nil
+        }
+
+        var doc metadata
+        if err := p.getJSON(ctx, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", &doc); err != nil {
+                return nil, fmt.Errorf("discovery failed: %w", err)
+        }
+        if doc.Issuer != p.cfg.Issuer {
+                return nil, fmt.Errorf("discovery returned issuer %q, expected %q", doc.Issuer, p.cfg.Issuer)
+        }
+        if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+                return nil, errors.New("discovery document is missing required endpoints")
+        }
+
+        p.metadata = &doc
+        return p.metadata, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, out interface{}) error {
+        req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+        if err != nil {
+                return err
+        }
+        resp, err := p.client.Do(req)
+        if err != nil {
+                return err
+        }
+        defer resp.Body.Close()
+
+        if resp.StatusCode != http.StatusOK {
+                return fmt.Errorf("%s returned %s", url, resp.Status)
+        }
+        return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// NewVerifier returns a random PKCE code verifier.
+func NewVerifier() (string, error) {
+        b := make([]byte, 32)
+        if _, err := rand.Read(b); err != nil {
+                return "", err
+        }
+        return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE challenge for verifier.
+func Challenge(verifier string) string {
+        sum := sha256.Sum256([]byte(verifier))
+        return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the provider URL to send the browser to. state and
+// nonce must be stored alongside verifier until the callback.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+        meta, err := p.discover(ctx)
+        if err != nil {
+                return "", err
+        }
+
+        params := url.Values{
+                "response_type":         {"code"},
+                "client_id":             {p.cfg.ClientID},
+                "redirect_uri":          {p.cfg.RedirectURL},
+                "scope":                 {strings.Join(p.cfg.Scopes, " ")},
+                "state":                 {state},
+                "nonce":                 {nonce},
+                "code_challenge":        {Challenge(verifier)},
+                "code_challenge_method": {"S256"},
+        }
+
+        separator := "?"
+        if strings.Contains(meta.AuthorizationEndpoint, "?") {
+                separator = "&"
+        }
+        return meta.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+        meta, err := p.discover(ctx)
+        if err != nil {
+                return "", err
+        }
+
+        form := url.Values{
+                "grant_type":    {"authorization_code"},
+                "code":          {code},
+                "redirect_uri":  {p.cfg.RedirectURL},
+                "client_id":     {p.cfg.ClientID},
+                "code_verifier": {verifier},
+        }
+        req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+        if err != nil {
+                return "", err
+        }
+        req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+        req.Header.Set("Accept", "application/json")
+        if p.cfg.ClientSecret != "" {
+                req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+        }
+
+        resp, err := p.client.Do(req)
+        if err != nil {
+                return "", fmt.Errorf("token request failed: %w", err)
+        }
+        defer resp.Body.Close()
+
+        var body struct {
+                IDToken          string `json:"id_token"`
+                Error            string `json:"error"`
+                ErrorDescription string `json:"error_description"`
+        }
+        if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+                return "", fmt.Errorf("token response from %s could not be read: %w", meta.TokenEndpoint, err)
+        }
+        if resp.StatusCode != http.StatusOK {
+                return "", fmt.Errorf("token request rejected: %s %s", body.Error, body.ErrorDescription)
+        }
+        if body.IDToken == "" {
+                return "", errors.New("token response has no id_token")
+        }
+        return body.IDToken, nil
+}
+
+// Verify checks an ID token's signature, issuer, audience, expiry and nonce
+// and returns the identity it asserts.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*Identity, error) {
+        if _, err := p.discover(ctx); err != nil {
+                return nil, err
+        }
+
+        claims := jwt.MapClaims{}
+        _, err := jwt.ParseWithClaims(rawIDToken, claims,
+                func(token *jwt.Token) (interface{}, error) {
+                        kid, _ := token.Header["kid"].(string)
+                        return p.key(ctx, kid)
+                },
+                jwt.WithValidMethods(signingMethods),
+                jwt.WithIssuer(p.cfg.Issuer),
+                jwt.WithAudience(p.cfg.ClientID),
+                jwt.WithExpirationRequired(),
+                jwt.WithLeeway(time.Minute),
+        )
+        if err != nil {
+                return nil, fmt.Errorf("invalid ID token: %w", err)
+        }
+
+        if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+                return nil, errors.New("invalid ID token: nonce mismatch")
+        }
+        // With several audiences the token must say it was issued to us
+        if aud, _ := claims.GetAudience(); len(aud) > 1 {
+                if azp, _ := claims["azp"].(string); azp != p.cfg.ClientID {
+                        return nil, errors.New("invalid ID token: azp mismatch")
+                }
+        }
+
+        identity := &Identity{}
+        identity.Subject, _ = claims["sub"].(string)
+        identity.Email, _ = claims["email"].(string)
+        identity.Name, _ = claims["name"].(string)
+        if identity.Subject == "" {
+                return nil, errors.New("invalid ID token: missing sub")
+        }
+
+        // Some providers send email_verified as a string
+        switch v := claims["email_verified"].(type) {
+        case bool:
+                identity.EmailVerified = &v
+        case string:
+                verified := strings.EqualFold(v, "true")
+                identity.EmailVerified = &verified
+        }
+
+        switch v := claims[p.cfg.GroupsClaim].(type) {
+        case []interface{}:
+                for _, group := range v {
+                        if name, ok := group.(string); ok {
+                                identity.Groups = append(identity.Groups, name)
+                        }
+                }
+        case string:
+                identity.Groups = []string{v}
+        }
+
+        return identity, nil
+}
@@ -0,0 +1,266 @@
+package oidc
+
+import (
+        "context"
+        "net/http"
+        "net/http/httptest"
+        "net/url"
+        "strings"
+        "testing"
+        "time"
+
+        "hcm-backend/oidc/fakeidp"
+
+        "github.com/golang-jwt/jwt/v5"
+)
+
+const testClientID = "hcm-test"
+
+func newTestProvider(t *testing.T) (*Provider, *fakeidp.Server) {
+        t.Helper()
+        idp, err := fakeidp.New("", testClientID)
+        if err != nil {
+                t.Fatal(err)
+        }
+        server := httptest.NewServer(idp)
+        t.Cleanup(server.Close)
+        idp.Issuer = server.URL
+
+        provider := New(Config{
+                Issuer:      server.URL,
+                ClientID:    testClientID,
+                RedirectURL: "http://hcm.test/api/auth/oidc/callback",
+                Scopes:      []string{"openid", "email"},
+                GroupsClaim: "groups",
+        })
+        return provider, idp
+}
+
+// claims are valid ID token claims for the test provider.
+func claims(issuer string) jwt.MapClaims {
+        now := time.Now()
+        return jwt.MapClaims{
+                "iss":   issuer,
+                "sub":   "user-1",
+                "aud":   testClientID,
+                "iat":   now.Unix(),
+                "exp":   now.Add(5 * time.Minute).Unix(),
+                "nonce": "nonce-1",
+                "email": "ada@example.com",
+        }
+}
+
+func TestSignInFlow(t *testing.T) {
+        provider, _ := newTestProvider(t)
+        ctx := context.Background()
+
+        verifier, err := NewVerifier()
+        if err != nil {
+                t.Fatal(err)
+        }
+        authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", verifier)
+        if err != nil {
+                t.Fatalf("AuthCodeURL: %v", err)
+        }
+        parsed, err := url.Parse(authURL)
+        if err != nil {
+                t.Fatal(err)
+        }
+        if got := parsed.Query().Get("code_challenge"); got != Challenge(verifier) {
+                t.Errorf("code_challenge = %q, want the S256 challenge of the verifier", got)
+        }
+
+        // Sign in on the provider's form and follow it back with the code
+        form := parsed.Query()
+        form.Set("email", "ada@example.com")
+        form.Set("name", "Ada")
+        form.Set("groups", "hr, managers")
+        client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+        parsed.RawQuery = ""
+        resp, err := client.PostForm(parsed.String(), form)
+        if err != nil {
+                t.Fatal(err)
+        }
+        resp.Body.Close()
+        callback, err := url.Parse(resp.Header.Get("Location"))
+        if err != nil || callback.Query().Get("state") != "state-1" {
+                t.Fatalf("authorize redirected to %q, want the callback with state-1", resp.Header.Get("Location"))
+        }
+
+        if _, err := provider.Exchange(ctx, callback.Query().Get("code"), "wrong-verifier"); err == nil {
+                t.Error("Exchange with the wrong code verifier: want an error")
+        }
+        // The code is single use, so a rejected exchange spends it
+        resp, err = client.PostForm(parsed.String(), form)
+        if err != nil {
+                t.Fatal(err)
+        }
+        resp.Body.Close()
+        callback, _ = url.Parse(resp.Header.Get("Location"))
+
+        rawIDToken, err := provider.Exchange(ctx, callback.Query().Get("code"), verifier)
+        if err != nil {
+                t.Fatalf("Exchange: %v", err)
+        }
+        identity, err := provider.Verify(ctx, rawIDToken, "nonce-1")
+        if err != nil {
+                t.Fatalf("Verify: %v", err)
+        }
+        if identity.Email != "ada@example.com" || identity.Name != "Ada" || identity.EmailVerified == nil || !*identity.EmailVerified {
+                t.Errorf("identity = %+v, want ada@example.com with a verified email", identity)
+        }
+        if strings.Join(identity.Groups, ",") != "hr,managers" {
+                t.Errorf("groups = %v, want [hr managers]", identity.Groups)
+        }
+}
+
+func TestVerifyRejects(t *testing.T) {
+        provider, idp := newTestProvider(t)
+        issuer := idp.Issuer
+
+        tests := []struct {
+                name   string
+                change func(jwt.MapClaims)
+        }{
+                {"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+                {"wrong audience", func(c jwt.MapClaims) { c["aud"] = "someone-else" }},
+                {"several audiences without azp", func(c jwt.MapClaims) { c["aud"] = []string{testClientID, "someone-else"} }},
+                {"several audiences with another azp", func(c jwt.MapClaims) {
+                        c["aud"] = []string{testClientID, "someone-else"}
+                        c["azp"] = "someone-else"
+                }},
+                {"wrong nonce", func(c jwt.MapClaims) { c["nonce"] = "nonce-2" }},
+                {"no nonce", func(c jwt.MapClaims) { delete(c, "nonce") }},
+                {"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }},
+                {"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }},
+                {"no subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+        }
+        for _, tt := range tests {
+                c := claims(issuer)
+                tt.change(c)
+                token, err := idp.Sign(c)
+                if err != nil {
+                        t.Fatal(err)
+                }
+                if identity, err := provider.Verify(context.Background(), token, "nonce-1"); err == nil {
+                        t.Errorf("%s: Verify = %+v, want an error", tt.name, identity)
+                }
+        }
+
+        // A token signed by a key the provider doesn't publish
+        other, err := fakeidp.New(issuer, testClientID)
+        if err != nil {
+                t.Fatal(err)
+        }
+        forged, _ := other.Sign(claims(issuer))
+        if _, err := provider.Verify(context.Background(), forged, "nonce-1"); err == nil {
+                t.Error("token signed by an unpublished key: want an error")
+        }
+
+        // Unsigned and HMAC tokens are never accepted
+        unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims(issuer)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+        hmac, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(issuer)).SignedString([]byte(testClientID))
+        for name, token := range map[string]string{"alg none": unsigned, "HS256": hmac} {
+                if _, err := provider.Verify(context.Background(), token, "nonce-1"); err == nil {
+                        t.Errorf("%s: want an error", name)
+                }
+        }
+}
+
+func TestVerifyAccepts(t *testing.T) {
+        provider, idp := newTestProvider(t)
+        issuer := idp.Issuer
+
+        tests := []struct {
+                name     string
+                change   func(jwt.MapClaims)
+                verified *bool
+        }{
+                {"no email_verified", func(c jwt.MapClaims) {}, nil},
+                {"email_verified true", func(c jwt.MapClaims) { c["email_verified"] = true }, boolPtr(true)},
+                {"email_verified as a string", func(c jwt.MapClaims) { c["email_verified"] = "false" }, boolPtr(false)},
+                {"several audiences with our azp", func(c jwt.MapClaims) {
+                        c["aud"] = []string{"someone-else", testClientID}
+                        c["azp"] = testClientID
+                }, nil},
+                {"expired within the leeway", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-30 * time.Second).Unix() }, nil},
+        }
+        for _, tt := range tests {
+                c := claims(issuer)
+                tt.change(c)
+                token, err := idp.Sign(c)
+                if err != nil {
+                        t.Fatal(err)
+                }
+                identity, err := provider.Verify(context.Background(), token, "nonce-1")
+                if err != nil {
+                        t.Errorf("%s: Verify: %v", tt.name, err)
+                        continue
+                }
+                if identity.Subject != "user-1" || (identity.EmailVerified == nil) != (tt.verified == nil) ||
+                        (tt.verified != nil && *identity.EmailVerified != *tt.verified) {
+                        t.Errorf("%s: identity = %+v, want email_verified %v", tt.name, identity, tt.verified)
+                }
+        }
+}
+
+func TestKeyRotation(t *testing.T) {
+        provider, idp := newTestProvider(t)
+        ctx := context.Background()
+        issuer := idp.Issuer
+
+        before, _ := idp.Sign(claims(issuer))
+        if _, err := provider.Verify(ctx, before, "nonce-1"); err != nil {
+                t.Fatalf("Verify before rotation: %v", err)
+        }
+
+        if err := idp.Rotate(); err != nil {
+                t.Fatal(err)
+        }
+        after, _ := idp.Sign(claims(issuer))
+
+        // The keys were fetched moments ago, so the new one isn't looked for yet
+        if _, err := provider.Verify(ctx, after, "nonce-1"); err == nil {
+                t.Error("Verify with a new key within the refresh interval: want an error")
+        }
+
+        provider.mu.Lock()
+        provider.keysFetched = time.Now().Add(-jwksRefreshInterval)
+        provider.mu.Unlock()
+        if _, err := provider.Verify(ctx, after, "nonce-1"); err != nil {
+                t.Errorf("Verify with the new key after the refresh interval: %v", err)
+        }
+        if _, err := provider.Verify(ctx, before, "nonce-1"); err != nil {
+                t.Errorf("Verify with the old key while it is still published: %v", err)
+        }
+
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+        provider, idp := newTestProvider(t)
+        idp.Issuer = "https://elsewhere.example.com"
+        if _, err := provider.AuthCodeURL(context.Background(), "s", "n", "v"); err == nil {
+                t.Error("AuthCodeURL with a discovery document for another issuer: want an error")
+        }
+}
+
+func TestRole(t *testing.T) {
+        provider := New(Config{RoleMapping: []GroupRole{{"admins", "system_admin"}, {"hr", "hr_admin"}}})
+        tests := map[string]struct {
+                groups []string
+                want   string
+        }{
+                "first mapping wins": {[]string{"hr", "admins"}, "system_admin"},
+                "one group":          {[]string{"staff", "hr"}, "hr_admin"},
+                "no mapped group":    {[]string{"staff"}, ""},
+        }
+        for name, tt := range tests {
+                if got := provider.Role(tt.groups); got != tt.want {
+                        t.Errorf("%s: Role = %q, want %q", name, got, tt.want)
+                }
+        }
+}
+
+func boolPtr(v bool) *bool {
+        return &v
+}
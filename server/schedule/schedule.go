@@ -0,0 +1,286 @@
+// Package schedule works out when employees are due to work from their shift
+// assignments, and evaluates their attendance against it.
+package schedule
+
+import (
+        "errors"
+        "fmt"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+
+        "hcm-backend/models"
+        "hcm-backend/timezone"
+)
+
+// MatchWindow is how far outside a shift a clock-in can be and still count
+// towards it, so early arrivals and very late ones aren't unscheduled.
+const MatchWindow = 4 * time.Hour
+
+// ParseClock parses an HH:MM time of day into minutes after midnight.
+func ParseClock(value string) (int, error) {
+        hours, minutes, ok := strings.Cut(value, ":")
+        h, err1 := strconv.Atoi(hours)
+        m, err2 := strconv.Atoi(minutes)
+        if !ok || len(hours) != 2 || len(minutes) != 2 || err1 != nil || err2 != nil || h > 23 || m > 59 {
+                return 0, fmt.Errorf("%q is not a time in HH:MM format", value)
+        }
+        return h*60 + m, nil
+}
+
+// Check verifies a shift's times, days and break rules.
+func Check(shift models.Shift) error {
+        if strings.TrimSpace(shift.Name) == "" {
+                return errors.New("Name is required")
+        }
+        start, err := ParseClock(shift.StartTime)
+        if err != nil {
+                return errors.New("start_time must be a time in HH:MM format")
+        }
+        end, err := ParseClock(shift.EndTime)
+        if err != nil {
+                return errors.New("end_time must be a time in HH:MM format")
+        }
+        if len(shift.Days) == 0 {
+                return errors.New("A shift needs at least one day")
+        }
+        seen := map[int]bool{}
+        for _, day := range shift.Days {
+                if day < 0 || day > 6 || seen[day] {
+                        return errors.New("Days must be unique days of the week, 0 for Sunday to 6 for Saturday")
+                }
+                seen[day] = true
+        }
+        if shift.GraceMinutes < 0 || shift.BreakMinutes < 0 || shift.BreakAfterMinutes < 0 {
+                return errors.New("Grace and break minutes cannot be negative")
+        }
+        length := end - start
+        if length <= 0 {
+                length += 24 * 60
+        }
+        if shift.BreakMinutes >= length {
+                return errors.New("The break must be shorter than the shift")
+        }
+        return nil
+}
+
+// Instance is one occurrence of a shift: the day it starts on, as midnight
+// UTC, and when it is due to start and end.
+type Instance struct {
+        Shift models.Shift
+        Day   time.Time
+        Start time.Time
+        End   time.Time
+}
+
+// On returns the occurrence of shift starting on day, with its times in loc.
+// The shift's times are assumed to have been checked.
+func On(shift models.Shift, day time.Time, loc *time.Location) Instance {
+        start, _ := ParseClock(shift.StartTime)
+        end, _ := ParseClock(shift.EndTime)
+        midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+        instance := Instance{
+                Shift: shift,
+                Day:   time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC),
+                Start: midnight.Add(time.Duration(start) * time.Minute),
+                End:   midnight.Add(time.Duration(end) * time.Minute),
+        }
+        if !instance.End.After(instance.Start) {
+                instance.End = instance.End.AddDate(0, 0, 1)
+        }
+        return instance
+}
+
+// ScheduledMinutes is how long the shift is due to be worked, less its break.
+func (i Instance) ScheduledMinutes() int {
+        minutes := int(i.End.Sub(i.Start).Minutes())
+        if i.Shift.BreakMinutes > 0 && minutes > i.Shift.BreakAfterMinutes {
+                minutes -= i.Shift.BreakMinutes
+        }
+        return minutes
+}
+
+// RosterShiftID is the shift the assignment has the employee on for day, a
+// midnight UTC date, or 0 if it doesn't cover the day or it's a day off.
+func RosterShiftID(assignment models.ShiftAssignment, day time.Time) uint {
+        if len(assignment.ShiftIDs) == 0 || day.Before(assignment.StartDate) {
+                return 0
+        }
+        if assignment.EndDate != nil && day.After(*assignment.EndDate) {
+                return 0
+        }
+        if len(assignment.ShiftIDs) == 1 {
+                return assignment.ShiftIDs[0]
+        }
+        rotation := assignment.RotationDays
+        if rotation < 1 {
+                rotation = 1
+        }
+        days := int(day.Sub(assignment.StartDate).Hours() / 24)
+        return assignment.ShiftIDs[(days/rotation)%len(assignment.ShiftIDs)]
+}
+
+// Roster is an employee's shift assignments together with the shifts they
+// name, by ID, and the zone their shift times are in.
+type Roster struct {
+        Assignments []models.ShiftAssignment
+        Shifts      map[uint]models.Shift
+        Location    *time.Location
+}
+
+// On returns the shift the employee is due to start on day, if any. Shifts
+// only run on their days of the week, so a roster can leave gaps.
+func (r Roster) On(day time.Time) (Instance, bool) {
+        day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+        for _, assignment := range r.Assignments {
+                shift, ok := r.Shifts[RosterShiftID(assignment, day)]
+                if !ok {
+                        continue
+                }
+                for _, weekday := range shift.Days {
+                        if time.Weekday(weekday) == day.Weekday() {
+                                return On(shift, day, r.location()), true
+                        }
+                }
+        }
+        return Instance{}, false
+}
+
+// Match returns the shift a clock-in belongs to: the nearest one starting the
+// day before, the same day or the day after, within MatchWindow.
+func (r Roster) Match(clockIn time.Time) (Instance, bool) {
+        local := clockIn.In(r.location())
+        var best Instance
+        var bestDistance time.Duration = -1
+        for offset := -1; offset <= 1; offset++ {
+                instance, ok := r.On(local.AddDate(0, 0, offset))
+                if !ok {
+                        continue
+                }
+                var distance time.Duration
+                if clockIn.Before(instance.Start) {
+                        distance = instance.Start.Sub(clockIn)
+                } else if !clockIn.Before(instance.End) {
+                        distance = clockIn.Sub(instance.End)
+                }
+                if distance <= MatchWindow && (bestDistance < 0 || distance < bestDistance) {
+                        best, bestDistance = instance, distance
+                }
+        }
+        return best, bestDistance >= 0
+}
+
+func (r Roster) location() *time.Location {
+        if r.Location == nil {
+                return timezone.Default()
+        }
+        return r.Location
+}
+
+// Evaluation is how the attendance rows of one shift compare with it.
+// WorkedMinutes is time clocked in less any break not already taken off the
+// clock.
+type Evaluation struct {
+        Status            string `json:"status"`
+        LateMinutes       int    `json:"late_minutes"`
+        EarlyLeaveMinutes int    `json:"early_leave_minutes"`
+        OvertimeMinutes   int    `json:"overtime_minutes"`
+        WorkedMinutes     int    `json:"worked_minutes"`
+}
+
+// Evaluate compares the rows of attendance for a shift with it. Arriving or
+// leaving within the shift's grace minutes counts as on time, and so does
+// overtime within them. Early leave and overtime are only known once every
+// row is clocked out. When more than one applies, late is reported over early
+// leave, and early leave over overtime; the minutes of each are still set.
+func Evaluate(instance Instance, rows []models.Attendance) Evaluation {
+        if len(rows) == 0 {
+                return Evaluation{Status: models.AttendanceAbsent}
+        }
+        rows = append([]models.Attendance(nil), rows...)
+        sort.Slice(rows, func(i, j int) bool { return rows[i].ClockIn.Before(rows[j].ClockIn) })
+
+        grace := time.Duration(instance.Shift.GraceMinutes) * time.Minute
+        var evaluation Evaluation
+        if late := rows[0].ClockIn.Sub(instance.Start); late > grace {
+                evaluation.LateMinutes = int(late.Minutes())
+        }
+
+        var lastOut time.Time
+        open := false
+        for _, row := range rows {
+                if row.ClockOut == nil {
+                        open = true
+                } else if row.ClockOut.After(lastOut) {
+                        lastOut = *row.ClockOut
+                }
+        }
+        workedMinutes := WorkedMinutes(rows, instance.Shift.BreakMinutes, instance.Shift.BreakAfterMinutes, instance.Start, instance.End)
+        evaluation.WorkedMinutes = workedMinutes
+
+        if !open {
+                if early := instance.End.Sub(lastOut); early > grace {
+                        evaluation.EarlyLeaveMinutes = int(early.Minutes())
+                }
+                if extra := workedMinutes - instance.ScheduledMinutes(); extra > instance.Shift.GraceMinutes {
+                        evaluation.OvertimeMinutes = extra
+                }
+        }
+
+        switch {
+        case evaluation.LateMinutes > 0:
+                evaluation.Status = models.AttendanceLate
+        case evaluation.EarlyLeaveMinutes > 0:
+                evaluation.Status = models.AttendanceEarlyLeave
+        case evaluation.OvertimeMinutes > 0:
+                evaluation.Status = models.AttendanceOvertime
+        default:
+                evaluation.Status = models.AttendanceOnTime
+        }
+        return evaluation
+}
+
+// WorkedMinutes adds up the time rows were clocked in for, ignoring any still
+// open. More than breakAfter minutes of it has breakMinutes of break taken
+// off, less any time clocked out between rows from start to end.
+func WorkedMinutes(rows []models.Attendance, breakMinutes, breakAfter int, start, end time.Time) int {
+        rows = append([]models.Attendance(nil), rows...)
+        sort.Slice(rows, func(i, j int) bool { return rows[i].ClockIn.Before(rows[j].ClockIn) })
+
+        var worked, breakTaken time.Duration
+        for i, row := range rows {
+                if row.ClockOut == nil {
+                        continue
+                }
+                worked += row.ClockOut.Sub(row.ClockIn)
+                if i+1 < len(rows) {
+                        breakTaken += overlap(*row.ClockOut, rows[i+1].ClockIn, start, end)
+                }
+        }
+        minutes := int(worked.Minutes())
+        if breakMinutes > 0 && minutes > breakAfter {
+                if owed := breakMinutes - int(breakTaken.Minutes()); owed > 0 {
+                        minutes -= owed
+                }
+        }
+        if minutes < 0 {
+                return 0
+        }
+        return minutes
+}
+
+// overlap is how much of the interval from a to b falls between start and
+// end.
+func overlap(a, b, start, end time.Time) time.Duration {
+        if a.Before(start) {
+                a = start
+        }
+        if b.After(end) {
+                b = end
+        }
+        if !b.After(a) {
+                return 0
+        }
+        return b.Sub(a)
+}
@@ -0,0 +1,309 @@
+package schedule
+
+import (
+        "testing"
+        "time"
+
+        "hcm-backend/models"
+)
+
+// 2 March 2026 is a Monday.
+var monday = time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+
+var dayShift = models.Shift{
+        ID:                1,
+        Name:              "Day",
+        StartTime:         "09:00",
+        EndTime:           "17:00",
+        Days:              []int{1, 2, 3, 4, 5},
+        GraceMinutes:      5,
+        BreakMinutes:      30,
+        BreakAfterMinutes: 360,
+}
+
+var nightShift = models.Shift{
+        ID:        2,
+        Name:      "Night",
+        StartTime: "22:00",
+        EndTime:   "06:00",
+        Days:      []int{0, 1, 2, 3, 4, 5, 6},
+}
+
+// at is the time on the day offset days from monday, in UTC.
+func at(days, hour, minute int) time.Time {
+        return monday.AddDate(0, 0, days).Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+}
+
+func row(in time.Time, out ...time.Time) models.Attendance {
+        attendance := models.Attendance{ClockIn: in}
+        if len(out) > 0 {
+                attendance.ClockOut = &out[0]
+        }
+        return attendance
+}
+
+func TestParseClock(t *testing.T) {
+        valid := map[string]int{"00:00": 0, "09:30": 570, "23:59": 1439}
+        for value, want := range valid {
+                if got, err := ParseClock(value); err != nil || got != want {
+                        t.Errorf("ParseClock(%q) = %d, %v; want %d", value, got, err, want)
+                }
+        }
+        for _, value := range []string{"", "9:30", "24:00", "12:60", "12-30", "ab:cd", "12:30:00"} {
+                if _, err := ParseClock(value); err == nil {
+                        t.Errorf("ParseClock(%q): want an error", value)
+                }
+        }
+}
+
+func TestCheck(t *testing.T) {
+        if err := Check(dayShift); err != nil {
+                t.Errorf("Check(day shift): %v", err)
+        }
+        if err := Check(nightShift); err != nil {
+                t.Errorf("Check(night shift): %v", err)
+        }
+
+        tests := map[string]func(*models.Shift){
+                "no name":             func(s *models.Shift) { s.Name = " " },
+                "bad start":           func(s *models.Shift) { s.StartTime = "9am" },
+                "bad end":             func(s *models.Shift) { s.EndTime = "25:00" },
+                "no days":             func(s *models.Shift) { s.Days = nil },
+                "repeated day":        func(s *models.Shift) { s.Days = []int{1, 1} },
+                "day out of range":    func(s *models.Shift) { s.Days = []int{7} },
+                "negative grace":      func(s *models.Shift) { s.GraceMinutes = -1 },
+                "break as long as it": func(s *models.Shift) { s.BreakMinutes = 480 },
+        }
+        for name, change := range tests {
+                shift := dayShift
+                shift.Days = append([]int(nil), dayShift.Days...)
+                change(&shift)
+                if err := Check(shift); err == nil {
+                        t.Errorf("%s: want an error", name)
+                }
+        }
+}
+
+func TestOn(t *testing.T) {
+        day := On(dayShift, monday, time.UTC)
+        if !day.Start.Equal(at(0, 9, 0)) || !day.End.Equal(at(0, 17, 0)) {
+                t.Errorf("day shift runs %v to %v", day.Start, day.End)
+        }
+        if got := day.ScheduledMinutes(); got != 450 {
+                t.Errorf("day shift ScheduledMinutes = %d, want 450", got)
+        }
+
+        night := On(nightShift, monday, time.UTC)
+        if !night.Start.Equal(at(0, 22, 0)) || !night.End.Equal(at(1, 6, 0)) {
+                t.Errorf("night shift runs %v to %v, want to end the next morning", night.Start, night.End)
+        }
+        if got := night.ScheduledMinutes(); got != 480 {
+                t.Errorf("night shift ScheduledMinutes = %d, want 480", got)
+        }
+
+        sydney := time.FixedZone("AEDT", 11*60*60)
+        local := On(dayShift, monday, sydney)
+        if !local.Start.Equal(at(-1, 22, 0)) || !local.Day.Equal(monday) {
+                t.Errorf("day shift in UTC+11 starts %v on %v, want %v on %v", local.Start, local.Day, at(-1, 22, 0), monday)
+        }
+}
+
+func TestRosterShiftID(t *testing.T) {
+        end := monday.AddDate(0, 0, 18)
+        rotation := models.ShiftAssignment{
+                ShiftIDs:     []uint{1, 2, 0},
+                RotationDays: 2,
+                StartDate:    monday,
+                EndDate:      &end,
+        }
+        tests := []struct {
+                name       string
+                assignment models.ShiftAssignment
+                days       int
+                want       uint
+        }{
+                {"before the start", rotation, -1, 0},
+                {"first day", rotation, 0, 1},
+                {"second day of the first shift", rotation, 1, 1},
+                {"second shift", rotation, 2, 2},
+                {"days off", rotation, 5, 0},
+                {"back to the first shift", rotation, 6, 1},
+                {"last day", rotation, 18, 1},
+                {"after the end", rotation, 19, 0},
+                {"single shift", models.ShiftAssignment{ShiftIDs: []uint{3}, StartDate: monday}, 400, 3},
+                {"no rotation days alternates daily", models.ShiftAssignment{ShiftIDs: []uint{1, 2}, StartDate: monday}, 3, 2},
+                {"no shifts", models.ShiftAssignment{StartDate: monday}, 0, 0},
+        }
+        for _, tt := range tests {
+                if got := RosterShiftID(tt.assignment, monday.AddDate(0, 0, tt.days)); got != tt.want {
+                        t.Errorf("%s: RosterShiftID = %d, want %d", tt.name, got, tt.want)
+                }
+        }
+}
+
+func TestRosterOn(t *testing.T) {
+        roster := Roster{
+                Assignments: []models.ShiftAssignment{{ShiftIDs: []uint{1, 2}, RotationDays: 7, StartDate: monday}},
+                Shifts:      map[uint]models.Shift{1: dayShift, 2: nightShift},
+                Location:    time.UTC,
+        }
+        tests := []struct {
+                name  string
+                days  int
+                shift uint
+        }{
+                {"day shift week", 0, 1},
+                {"day shift doesn't run on Saturday", 5, 0},
+                {"night shift week", 7, 2},
+                {"night shift runs on Saturday", 12, 2},
+                {"back to days", 14, 1},
+        }
+        for _, tt := range tests {
+                instance, ok := roster.On(monday.AddDate(0, 0, tt.days).Add(15 * time.Hour))
+                if got := instance.Shift.ID; ok != (tt.shift != 0) || got != tt.shift {
+                        t.Errorf("%s: On = shift %d, %v; want shift %d", tt.name, got, ok, tt.shift)
+                }
+        }
+}
+
+func TestMatch(t *testing.T) {
+        days := Roster{
+                Assignments: []models.ShiftAssignment{{ShiftIDs: []uint{1}, StartDate: monday}},
+                Shifts:      map[uint]models.Shift{1: dayShift},
+                Location:    time.UTC,
+        }
+        nights := Roster{
+                Assignments: []models.ShiftAssignment{{ShiftIDs: []uint{2}, StartDate: monday.AddDate(0, 0, -7)}},
+                Shifts:      map[uint]models.Shift{2: nightShift},
+                Location:    time.UTC,
+        }
+        sydney := days
+        sydney.Location = time.FixedZone("AEDT", 11*60*60)
+
+        tests := []struct {
+                name    string
+                roster  Roster
+                clockIn time.Time
+                day     time.Time // zero for no match
+        }{
+                {"on time", days, at(0, 9, 0), monday},
+                {"early arrival", days, at(0, 6, 0), monday},
+                {"too early", days, at(0, 4, 30), time.Time{}},
+                {"very late", days, at(0, 16, 0), monday},
+                {"evening after the shift", days, at(0, 20, 30), monday},
+                {"Saturday", days, at(5, 9, 0), time.Time{}},
+                {"night shift start", nights, at(0, 21, 45), monday},
+                {"past midnight is the previous day's shift", nights, at(1, 2, 0), monday},
+                {"midday between night shifts", nights, at(0, 12, 0), time.Time{}},
+                {"shift times in the roster's zone", sydney, at(-1, 22, 10), monday},
+        }
+        for _, tt := range tests {
+                instance, ok := tt.roster.Match(tt.clockIn)
+                if tt.day.IsZero() {
+                        if ok {
+                                t.Errorf("%s: matched the shift on %v, want no match", tt.name, instance.Day)
+                        }
+                        continue
+                }
+                if !ok || !instance.Day.Equal(tt.day) {
+                        t.Errorf("%s: Match = %v, %v; want the shift on %v", tt.name, instance.Day, ok, tt.day)
+                }
+        }
+}
+
+func TestEvaluate(t *testing.T) {
+        instance := On(dayShift, monday, time.UTC)
+        tests := []struct {
+                name string
+                rows []models.Attendance
+                want Evaluation
+        }{
+                {"absent", nil, Evaluation{Status: models.AttendanceAbsent}},
+                {
+                        "late within grace",
+                        []models.Attendance{row(at(0, 9, 5), at(0, 17, 0))},
+                        Evaluation{Status: models.AttendanceOnTime, WorkedMinutes: 445},
+                },
+                {
+                        "late",
+                        []models.Attendance{row(at(0, 9, 20), at(0, 17, 0))},
+                        Evaluation{Status: models.AttendanceLate, LateMinutes: 20, WorkedMinutes: 430},
+                },
+                {
+                        "early leave",
+                        []models.Attendance{row(at(0, 9, 0), at(0, 16, 0))},
+                        Evaluation{Status: models.AttendanceEarlyLeave, EarlyLeaveMinutes: 60, WorkedMinutes: 390},
+                },
+                {
+                        "overtime",
+                        []models.Attendance{row(at(0, 9, 0), at(0, 18, 0))},
+                        Evaluation{Status: models.AttendanceOvertime, OvertimeMinutes: 60, WorkedMinutes: 510},
+                },
+                {
+                        "overtime within grace",
+                        []models.Attendance{row(at(0, 9, 0), at(0, 17, 4))},
+                        Evaluation{Status: models.AttendanceOnTime, WorkedMinutes: 454},
+                },
+                {
+                        "late is reported over early leave",
+                        []models.Attendance{row(at(0, 9, 30), at(0, 16, 0))},
+                        Evaluation{Status: models.AttendanceLate, LateMinutes: 30, EarlyLeaveMinutes: 60, WorkedMinutes: 360},
+                },
+                {
+                        "still clocked in",
+                        []models.Attendance{row(at(0, 9, 0))},
+                        Evaluation{Status: models.AttendanceOnTime},
+                },
+                {
+                        "break clocked out, rows out of order",
+                        []models.Attendance{row(at(0, 12, 45), at(0, 17, 0)), row(at(0, 9, 0), at(0, 12, 0))},
+                        Evaluation{Status: models.AttendanceOnTime, WorkedMinutes: 435},
+                },
+                {
+                        "open second row",
+                        []models.Attendance{row(at(0, 9, 0), at(0, 12, 0)), row(at(0, 13, 0))},
+                        Evaluation{Status: models.AttendanceOnTime, WorkedMinutes: 180},
+                },
+        }
+        for _, tt := range tests {
+                if got := Evaluate(instance, tt.rows); got != tt.want {
+                        t.Errorf("%s: Evaluate = %+v, want %+v", tt.name, got, tt.want)
+                }
+        }
+}
+
+func TestEvaluateNightShift(t *testing.T) {
+        instance := On(nightShift, monday, time.UTC)
+        got := Evaluate(instance, []models.Attendance{row(at(0, 22, 15), at(1, 5, 0))})
+        want := Evaluation{Status: models.AttendanceLate, LateMinutes: 15, EarlyLeaveMinutes: 60, WorkedMinutes: 405}
+        if got != want {
+                t.Errorf("Evaluate = %+v, want %+v", got, want)
+        }
+}
+
+func TestWorkedMinutes(t *testing.T) {
+        start, end := at(0, 9, 0), at(0, 17, 0)
+        tests := []struct {
+                name string
+                rows []models.Attendance
+                want int
+        }{
+                {"short day has no break", []models.Attendance{row(at(0, 9, 0), at(0, 13, 0))}, 240},
+                {"break taken off", []models.Attendance{row(at(0, 9, 0), at(0, 17, 0))}, 450},
+                {
+                        "part of the break clocked out",
+                        []models.Attendance{row(at(0, 9, 0), at(0, 12, 0)), row(at(0, 12, 10), at(0, 17, 0))},
+                        450,
+                },
+                {
+                        "time out after the shift isn't break",
+                        []models.Attendance{row(at(0, 9, 0), at(0, 17, 0)), row(at(0, 18, 0), at(0, 19, 0))},
+                        510,
+                },
+        }
+        for _, tt := range tests {
+                if got := WorkedMinutes(tt.rows, 30, 360, start, end); got != tt.want {
+                        t.Errorf("%s: WorkedMinutes = %d, want %d", tt.name, got, tt.want)
+                }
+        }
+}
@@ -0,0 +1,532 @@
+package scim
+
+import (
+        "encoding/json"
+        "fmt"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// Expr is a parsed SCIM filter (RFC 7644 section 3.4.2.2).
+type Expr interface {
+        isExpr()
+}
+
+// Compare is attrPath op value, or attrPath pr when Op is "pr".
+type Compare struct {
+        Attr  string
+        Op    string
+        Value interface{}
+}
+
+// Logical joins two filters with "and" or "or".
+type Logical struct {
+        Op          string
+        Left, Right Expr
+}
+
+type Not struct {
+        Expr Expr
+}
+
+// ValuePath filters the elements of a multi-valued attribute, as in
+// emails[type eq "work"].
+type ValuePath struct {
+        Attr   string
+        Filter Expr
+}
+
+func (Compare) isExpr()   {}
+func (Logical) isExpr()   {}
+func (Not) isExpr()       {}
+func (ValuePath) isExpr() {}
+
+var compareOps = map[string]bool{
+        "eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+        "gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+type token struct {
+        text   string
+        quoted bool
+}
+
+func tokenize(s string) ([]token, error) {
+        var tokens []token
+        for i := 0; i < len(s); {
+                switch ch := s[i]; {
+                case ch == ' ' || ch == '\t' || ch == '\n':
+                        i++
+                case strings.ContainsRune("()[]", rune(ch)):
+                        tokens = append(tokens, token{text: string(ch)})
+                        i++
+                case ch == '"':
+                        end := i + 1
+                        for end < len(s) && s[end] != '"' {
+                                if s[end] == '\\' {
+                                        end++
+                                }
+                                end++
+                        }
+                        if end >= len(s) {
+                                return nil, fmt.Errorf("unterminated string")
+                        }
+                        var value string
+                        if err := json.Unmarshal([]byte(s[i:end+1]), &value); err != nil {
+                                return nil, fmt.Errorf("invalid string %s", s[i:end+1])
+                        }
+                        tokens = append(tokens, token{text: value, quoted: true})
+                        i = end + 1
+                default:
+                        end := i
+                        for end < len(s) && !strings.ContainsRune(" \t\n()[]\"", rune(s[end])) {
+                                end++
+                        }
+                        tokens = append(tokens, token{text: s[i:end]})
+                        i = end
+                }
+        }
+        return tokens, nil
+}
+
+type parser struct {
+        tokens []token
+        pos    int
+}
+
+func (p *parser) peek() (token, bool) {
+        if p.pos >= len(p.tokens) {
+                return token{}, false
+        }
+        return p.tokens[p.pos], true
+}
+
+func (p *parser) next() (token, bool) {
+        t, ok := p.peek()
+        if ok {
+                p.pos++
+        }
+        return t, ok
+}
+
+func (p *parser) keyword(word string) bool {
+        t, ok := p.peek()
+        if ok && !t.quoted && strings.EqualFold(t.text, word) {
+                p.pos++
+                return true
+        }
+        return false
+}
+
+func (p *parser) expect(text string) error {
+        t, ok := p.next()
+        if !ok || t.quoted || t.text != text {
+                return fmt.Errorf("expected %q", text)
+        }
+        return nil
+}
+
+// ParseFilter parses a filter such as
+//
+//	userName eq "bjensen" and (emails co "@example.com" or not (active eq false))
+func ParseFilter(s string) (Expr, error) {
+        tokens, err := tokenize(s)
+        if err != nil {
+                return nil, err
+        }
+        p := &parser{tokens: tokens}
+        expr, err := p.parseOr()
+        if err != nil {
+                return nil, err
+        }
+        if t, ok := p.peek(); ok {
+                return nil, fmt.Errorf("unexpected %q", t.text)
+        }
+        return expr, nil
+}
+
+func (p *parser) parseOr() (Expr, error) {
+        left, err := p.parseAnd()
+        if err != nil {
+                return nil, err
+        }
+        for p.keyword("or") {
+                right, err := p.parseAnd()
+                if err != nil {
+                        return nil, err
+                }
+                left = Logical{Op: "or", Left: left, Right: right}
+        }
+        return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+        left, err := p.parseUnary()
+        if err != nil {
+                return nil, err
+        }
+        for p.keyword("and") {
+                right, err := p.parseUnary()
+                if err != nil {
+                        return nil, err
+                }
+                left = Logical{Op: "and", Left: left, Right: right}
+        }
+        return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+        if p.keyword("not") {
+                if err := p.expect("("); err != nil {
+                        return nil, err
+                }
+                inner, err := p.parseOr()
+                if err != nil {
+                        return nil, err
+                }
+                if err := p.expect(")"); err != nil {
+                        return nil, err
+                }
+                return Not{Expr: inner}, nil
+        }
+
+        if p.keyword("(") {
+                inner, err := p.parseOr()
+                if err != nil {
+                        return nil, err
+                }
+                if err := p.expect(")"); err != nil {
+                        return nil, err
+                }
+                return inner, nil
+        }
+
+        attr, ok := p.next()
+        if !ok || attr.quoted || strings.ContainsAny(attr.text, "()[]") {
+                return nil, fmt.Errorf("expected an attribute name")
+        }
+
+        if p.keyword("[") {
+                inner, err := p.parseOr()
+                if err != nil {
+                        return nil, err
+                }
+                if err := p.expect("]"); err != nil {
+                        return nil, err
+                }
+                return ValuePath{Attr: attr.text, Filter: inner}, nil
+        }
+
+        opToken, ok := p.next()
+        op := strings.ToLower(opToken.text)
+        if !ok || opToken.quoted || !compareOps[op] {
+                return nil, fmt.Errorf("expected an operator after %s", attr.text)
+        }
+        if op == "pr" {
+                return Compare{Attr: attr.text, Op: op}, nil
+        }
+
+        valueToken, ok := p.next()
+        if !ok {
+                return nil, fmt.Errorf("expected a value after %s %s", attr.text, op)
+        }
+        value, err := literal(valueToken)
+        if err != nil {
+                return nil, err
+        }
+        return Compare{Attr: attr.text, Op: op, Value: value}, nil
+}
+
+func literal(t token) (interface{}, error) {
+        if t.quoted {
+                return t.text, nil
+        }
+        switch strings.ToLower(t.text) {
+        case "true":
+                return true, nil
+        case "false":
+                return false, nil
+        case "null":
+                return nil, nil
+        }
+        if n, err := strconv.ParseFloat(t.text, 64); err == nil {
+                return n, nil
+        }
+        return nil, fmt.Errorf("invalid value %q", t.text)
+}
+
+// AttrType decides how a Column is compared.
+type AttrType int
+
+const (
+        // String compares case-insensitively, as most SCIM attributes do.
+        String AttrType = iota
+        ExactString
+        Number
+        Boolean
+        DateTime
+)
+
+// Column maps a filterable attribute onto SQL.
+type Column struct {
+        // Expr is the SQL expression holding the attribute's value.
+        Expr string
+        Type AttrType
+
+        // Build, if set, replaces the default translation. It is used for
+        // attributes that aren't stored as a plain column.
+        Build func(op string, value interface{}) (string, []interface{}, error)
+}
+
+// Columns maps attribute paths to columns. Keys are lower case, such as
+// "username", "name.formatted" or a full extension URN path.
+type Columns map[string]Column
+
+// Where translates a filter into a SQL condition and its arguments.
+// Attributes outside cols are rejected, so clients learn their filter wasn't
+// applied rather than getting unfiltered results.
+func (cols Columns) Where(e Expr) (string, []interface{}, error) {
+        return cols.where(e, "")
+}
+
+func (cols Columns) where(e Expr, prefix string) (string, []interface{}, error) {
+        switch e := e.(type) {
+        case Logical:
+                left, leftArgs, err := cols.where(e.Left, prefix)
+                if err != nil {
+                        return "", nil, err
+                }
+                right, rightArgs, err := cols.where(e.Right, prefix)
+                if err != nil {
+                        return "", nil, err
+                }
+                return "(" + left + " " + strings.ToUpper(e.Op) + " " + right + ")", append(leftArgs, rightArgs...), nil
+
+        case Not:
+                inner, args, err := cols.where(e.Expr, prefix)
+                if err != nil {
+                        return "", nil, err
+                }
+                return "NOT (" + inner + ")", args, nil
+
+        case ValuePath:
+                return cols.where(e.Filter, AttrName(e.Attr)+".")
+
+        case Compare:
+                name := prefix + AttrName(e.Attr)
+                col, ok := cols[name]
+                if !ok && prefix == "" {
+                        // "emails" on its own means the primary value
+                        col, ok = cols[name+".value"]
+                }
+                if !ok {
+                        return "", nil, fmt.Errorf("filtering on %s is not supported", e.Attr)
+                }
+                if col.Build != nil {
+                        return col.Build(e.Op, e.Value)
+                }
+                return compareSQL(col, e.Op, e.Value)
+        }
+        return "", nil, fmt.Errorf("unsupported filter")
+}
+
+// AttrName normalises an attribute path for lookups: lower case, with the
+// core schema URN dropped since core attributes may be written either way.
+func AttrName(path string) string {
+        lower := strings.ToLower(path)
+        for _, urn := range []string{UserSchema, GroupSchema} {
+                if rest, ok := strings.CutPrefix(lower, strings.ToLower(urn)+":"); ok {
+                        return rest
+                }
+        }
+        return lower
+}
+
+func escapeLike(s string) string {
+        return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+var sqlOps = map[string]string{"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+
+func compareSQL(col Column, op string, value interface{}) (string, []interface{}, error) {
+        expr := col.Expr
+
+        if op == "pr" {
+                if col.Type == String || col.Type == ExactString {
+                        return "(" + expr + " IS NOT NULL AND " + expr + " <> '')", nil, nil
+                }
+                return expr + " IS NOT NULL", nil, nil
+        }
+
+        if value == nil {
+                switch op {
+                case "eq":
+                        return expr + " IS NULL", nil, nil
+                case "ne":
+                        return expr + " IS NOT NULL", nil, nil
+                }
+                return "", nil, fmt.Errorf("null can only be compared with eq or ne")
+        }
+
+        switch col.Type {
+        case String, ExactString:
+                s, ok := value.(string)
+                if !ok {
+                        return "", nil, fmt.Errorf("expected a string value")
+                }
+                if col.Type == String {
+                        expr, s = "LOWER("+expr+")", strings.ToLower(s)
+                }
+                switch op {
+                case "co":
+                        return expr + ` LIKE ? ESCAPE '\'`, []interface{}{"%" + escapeLike(s) + "%"}, nil
+                case "sw":
+                        return expr + ` LIKE ? ESCAPE '\'`, []interface{}{escapeLike(s) + "%"}, nil
+                case "ew":
+                        return expr + ` LIKE ? ESCAPE '\'`, []interface{}{"%" + escapeLike(s)}, nil
+                }
+                return expr + " " + sqlOps[op] + " ?", []interface{}{s}, nil
+
+        case Number:
+                var n float64
+                switch v := value.(type) {
+                case float64:
+                        n = v
+                case string:
+                        // SCIM ids are strings even when they are numbers here
+                        parsed, err := strconv.ParseFloat(v, 64)
+                        if err != nil {
+                                if op == "ne" {
+                                        return "1 = 1", nil, nil
+                                }
+                                return "1 = 0", nil, nil
+                        }
+                        n = parsed
+                default:
+                        return "", nil, fmt.Errorf("expected a number")
+                }
+                if sqlOps[op] == "" {
+                        return "", nil, fmt.Errorf("operator %s is not supported for numbers", op)
+                }
+                return expr + " " + sqlOps[op] + " ?", []interface{}{n}, nil
+
+        case Boolean:
+                b, ok := value.(bool)
+                if !ok || (op != "eq" && op != "ne") {
+                        return "", nil, fmt.Errorf("booleans can only be compared with eq or ne to true or false")
+                }
+                return expr + " " + sqlOps[op] + " ?", []interface{}{b}, nil
+
+        case DateTime:
+                s, ok := value.(string)
+                if !ok {
+                        return "", nil, fmt.Errorf("expected a date-time string")
+                }
+                t, err := time.Parse(time.RFC3339Nano, s)
+                if err != nil {
+                        return "", nil, fmt.Errorf("invalid date-time %q", s)
+                }
+                if sqlOps[op] == "" {
+                        return "", nil, fmt.Errorf("operator %s is not supported for dates", op)
+                }
+                return expr + " " + sqlOps[op] + " ?", []interface{}{t}, nil
+        }
+        return "", nil, fmt.Errorf("unsupported attribute type")
+}
+
+// Match evaluates a filter against one element of a multi-valued attribute,
+// such as a member or email in a PATCH path. Strings compare
+// case-insensitively.
+func Match(e Expr, element map[string]interface{}) bool {
+        switch e := e.(type) {
+        case Logical:
+                if e.Op == "and" {
+                        return Match(e.Left, element) && Match(e.Right, element)
+                }
+                return Match(e.Left, element) || Match(e.Right, element)
+        case Not:
+                return !Match(e.Expr, element)
+        case Compare:
+                actual, present := lookup(element, e.Attr)
+                if e.Op == "pr" {
+                        return present && actual != nil && actual != ""
+                }
+                return matchValue(actual, e.Op, e.Value)
+        }
+        return false
+}
+
+func matchValue(actual interface{}, op string, want interface{}) bool {
+        switch want := want.(type) {
+        case nil:
+                return (op == "eq") == (actual == nil)
+        case bool:
+                got, ok := actual.(bool)
+                return ok && (op == "eq") == (got == want)
+        case float64:
+                got, ok := actual.(float64)
+                if !ok {
+                        s, isString := actual.(string)
+                        parsed, err := strconv.ParseFloat(s, 64)
+                        if !isString || err != nil {
+                                return op == "ne"
+                        }
+                        got = parsed
+                }
+                switch op {
+                case "eq":
+                        return got == want
+                case "ne":
+                        return got != want
+                case "gt":
+                        return got > want
+                case "ge":
+                        return got >= want
+                case "lt":
+                        return got < want
+                case "le":
+                        return got <= want
+                }
+        case string:
+                got := strings.ToLower(fmt.Sprint(actual))
+                if actual == nil {
+                        got = ""
+                }
+                w := strings.ToLower(want)
+                switch op {
+                case "eq":
+                        return got == w
+                case "ne":
+                        return got != w
+                case "co":
+                        return strings.Contains(got, w)
+                case "sw":
+                        return strings.HasPrefix(got, w)
+                case "ew":
+                        return strings.HasSuffix(got, w)
+                case "gt":
+                        return got > w
+                case "ge":
+                        return got >= w
+                case "lt":
+                        return got < w
+                case "le":
+                        return got <= w
+                }
+        }
+        return false
+}
+
+// lookup finds a key case-insensitively, as SCIM attribute names are.
+func lookup(m map[string]interface{}, key string) (interface{}, bool) {
+        if v, ok := m[key]; ok {
+                return v, true
+        }
+        for k, v := range m {
+                if strings.EqualFold(k, key) {
+                        return v, true
+                }
+        }
+        return nil, false
+}
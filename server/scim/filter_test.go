@@ -0,0 +1,211 @@
+package scim
+
+import (
+        "reflect"
+        "testing"
+        "time"
+)
+
+func TestParseFilter(t *testing.T) {
+        tests := []struct {
+                filter string
+                want   Expr
+        }{
+                {`userName eq "bjensen"`, Compare{"userName", "eq", "bjensen"}},
+                {`title co "Eng"`, Compare{"title", "co", "Eng"}},
+                {`userName sw "J"`, Compare{"userName", "sw", "J"}},
+                {`title pr`, Compare{Attr: "title", Op: "pr"}},
+                {`USERNAME EQ "x"`, Compare{"USERNAME", "eq", "x"}},
+                {`id eq 7`, Compare{"id", "eq", float64(7)}},
+                {`active eq False`, Compare{"active", "eq", false}},
+                {`manager eq null`, Compare{Attr: "manager", Op: "eq"}},
+                {`userName eq "say \"hi\""`, Compare{"userName", "eq", `say "hi"`}},
+                {`a eq "x" and b pr`, Logical{"and", Compare{"a", "eq", "x"}, Compare{Attr: "b", Op: "pr"}}},
+                // and binds tighter than or
+                {`a eq "x" or b eq "y" AND c pr`, Logical{"or",
+                        Compare{"a", "eq", "x"},
+                        Logical{"and", Compare{"b", "eq", "y"}, Compare{Attr: "c", Op: "pr"}}}},
+                {`(a eq "x" or b pr) and c eq true`, Logical{"and",
+                        Logical{"or", Compare{"a", "eq", "x"}, Compare{Attr: "b", Op: "pr"}},
+                        Compare{"c", "eq", true}}},
+                {`not (active eq false)`, Not{Compare{"active", "eq", false}}},
+                {`emails[type eq "work" and value co "@example.com"]`, ValuePath{"emails",
+                        Logical{"and", Compare{"type", "eq", "work"}, Compare{"value", "co", "@example.com"}}}},
+                {`userName eq "a" or emails[type eq "work"]`, Logical{"or",
+                        Compare{"userName", "eq", "a"},
+                        ValuePath{"emails", Compare{"type", "eq", "work"}}}},
+        }
+        for _, tt := range tests {
+                got, err := ParseFilter(tt.filter)
+                if err != nil {
+                        t.Errorf("ParseFilter(%s): %v", tt.filter, err)
+                        continue
+                }
+                if !reflect.DeepEqual(got, tt.want) {
+                        t.Errorf("ParseFilter(%s) = %#v\nwant %#v", tt.filter, got, tt.want)
+                }
+        }
+}
+
+func TestParseFilterMalformed(t *testing.T) {
+        // Handlers answer any of these with 400 invalidFilter, so they must
+        // come back as errors rather than panics.
+        filters := []string{
+                ``,
+                `   `,
+                `userName`,
+                `userName eq`,
+                `userName xx "a"`,
+                `userName "eq" "a"`,
+                `userName eq "unterminated`,
+                `userName eq "abc\`,
+                `userName eq "\q"`,
+                `userName eq bare`,
+                `"userName" eq "a"`,
+                `userName eq "a" "b"`,
+                `userName eq "a" and`,
+                `or userName pr`,
+                `(userName pr`,
+                `userName pr)`,
+                `()`,
+                `not userName pr`,
+                `not (userName pr`,
+                `emails[type eq "work"`,
+                `emails[]`,
+                `emails[type eq "work"]]`,
+                `]`,
+                `[`,
+                `userName eq "a" ) or (`,
+        }
+        for _, filter := range filters {
+                if expr, err := ParseFilter(filter); err == nil {
+                        t.Errorf("ParseFilter(%s) = %#v, want an error", filter, expr)
+                }
+        }
+}
+
+var testColumns = Columns{
+        "username":     {Expr: "username"},
+        "externalid":   {Expr: "external_id", Type: ExactString},
+        "emails.value": {Expr: "email"},
+        "emails.type":  {Expr: "email_type"},
+        "id":           {Expr: "id", Type: Number},
+        "active":       {Expr: "active", Type: Boolean},
+        "meta.created": {Expr: "created_at", Type: DateTime},
+}
+
+func TestWhere(t *testing.T) {
+        created := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+        tests := []struct {
+                filter string
+                sql    string
+                args   []interface{}
+        }{
+                {`userName eq "BJensen"`, "LOWER(username) = ?", []interface{}{"bjensen"}},
+                {`UserName co "je%"`, `LOWER(username) LIKE ? ESCAPE '\'`, []interface{}{`%je\%%`}},
+                {`externalId sw "AB_"`, `external_id LIKE ? ESCAPE '\'`, []interface{}{`AB\_%`}},
+                {`externalId ew "9"`, `external_id LIKE ? ESCAPE '\'`, []interface{}{`%9`}},
+                {`userName pr`, "(username IS NOT NULL AND username <> '')", nil},
+                {`id pr`, "id IS NOT NULL", nil},
+                {`userName eq null`, "username IS NULL", nil},
+                {`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "x"`, "LOWER(username) = ?", []interface{}{"x"}},
+                {`emails eq "A@example.com"`, "LOWER(email) = ?", []interface{}{"a@example.com"}},
+                {`emails.VALUE eq "a@example.com"`, "LOWER(email) = ?", []interface{}{"a@example.com"}},
+                {`emails[TYPE eq "work" and value ew "@example.com"]`,
+                        `(LOWER(email_type) = ? AND LOWER(email) LIKE ? ESCAPE '\')`, []interface{}{"work", "%@example.com"}},
+                {`userName eq "a" or not (active eq true)`, "(LOWER(username) = ? OR NOT (active = ?))", []interface{}{"a", true}},
+                {`id ge 3`, "id >= ?", []interface{}{float64(3)}},
+                {`id eq "12"`, "id = ?", []interface{}{float64(12)}},
+                {`id eq "abc"`, "1 = 0", nil},
+                {`id ne "abc"`, "1 = 1", nil},
+                {`meta.created gt "2026-03-02T00:00:00Z"`, "created_at > ?", []interface{}{created}},
+        }
+        for _, tt := range tests {
+                expr, err := ParseFilter(tt.filter)
+                if err != nil {
+                        t.Fatalf("ParseFilter(%s): %v", tt.filter, err)
+                }
+                sql, args, err := testColumns.Where(expr)
+                if err != nil {
+                        t.Errorf("Where(%s): %v", tt.filter, err)
+                        continue
+                }
+                if sql != tt.sql || !reflect.DeepEqual(args, tt.args) {
+                        t.Errorf("Where(%s) = %s %v, want %s %v", tt.filter, sql, args, tt.sql, tt.args)
+                }
+        }
+}
+
+func TestWhereRejects(t *testing.T) {
+        filters := []string{
+                `title eq "x"`,
+                `emails[display eq "x"]`,
+                `userName eq 1`,
+                `userName gt null`,
+                `active eq "yes"`,
+                `active gt true`,
+                `id co 1`,
+                `id eq true`,
+                `meta.created gt "yesterday"`,
+                `meta.created sw "2026"`,
+                `userName pr and title pr`,
+        }
+        for _, filter := range filters {
+                expr, err := ParseFilter(filter)
+                if err != nil {
+                        t.Fatalf("ParseFilter(%s): %v", filter, err)
+                }
+                if sql, _, err := testColumns.Where(expr); err == nil {
+                        t.Errorf("Where(%s) = %s, want an error", filter, sql)
+                }
+        }
+}
+
+func TestMatch(t *testing.T) {
+        element := map[string]interface{}{"type": "Work", "value": "a@example.com", "primary": true, "order": float64(2)}
+        tests := []struct {
+                filter string
+                want   bool
+        }{
+                {`type eq "work"`, true},
+                {`TYPE eq "WORK"`, true},
+                {`type ne "work"`, false},
+                {`value co "EXAMPLE"`, true},
+                {`value sw "a@"`, true},
+                {`value sw "b"`, false},
+                {`value ew ".com"`, true},
+                {`primary eq true`, true},
+                {`primary eq false`, false},
+                {`order gt 1`, true},
+                {`order le 1`, false},
+                {`value pr`, true},
+                {`display pr`, false},
+                {`display eq null`, true},
+                {`type eq "home" or primary eq true`, true},
+                {`type eq "home" and primary eq true`, false},
+                {`type eq "work" and not (value ew ".org")`, true},
+        }
+        for _, tt := range tests {
+                expr, err := ParseFilter(tt.filter)
+                if err != nil {
+                        t.Fatalf("ParseFilter(%s): %v", tt.filter, err)
+                }
+                if got := Match(expr, element); got != tt.want {
+                        t.Errorf("Match(%s) = %v, want %v", tt.filter, got, tt.want)
+                }
+        }
+}
+
+func TestAttrName(t *testing.T) {
+        tests := map[string]string{
+                "userName":  "username",
+                "urn:ietf:params:scim:schemas:core:2.0:User:name.givenName": "name.givenname",
+                "urn:ietf:params:scim:schemas:core:2.0:Group:displayName":   "displayname",
+                EnterpriseUserSchema + ":department":                        "urn:ietf:params:scim:schemas:extension:enterprise:2.0:user:department",
+        }
+        for path, want := range tests {
+                if got := AttrName(path); got != want {
+                        t.Errorf("AttrName(%s) = %s, want %s", path, got, want)
+                }
+        }
+}
@@ -0,0 +1,334 @@
+package scim
+
+import (
+        "encoding/json"
+        "fmt"
+        "reflect"
+        "strings"
+)
+
+// PatchRequest is the body of a PATCH request (RFC 7644 section 3.5.2).
+type PatchRequest struct {
+        Schemas    []string    `json:"schemas"`
+        Operations []Operation `json:"Operations"`
+}
+
+type Operation struct {
+        Op    string          `json:"op"`
+        Path  string          `json:"path"`
+        Value json.RawMessage `json:"value"`
+}
+
+// PatchError is a PATCH that can't be applied, with the SCIM error type to
+// report.
+type PatchError struct {
+        ScimType string
+        Detail   string
+}
+
+func (e *PatchError) Error() string {
+        return e.Detail
+}
+
+func patchError(scimType, format string, args ...interface{}) *PatchError {
+        return &PatchError{ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Apply runs the operations against resource, the JSON object form of the
+// resource being patched. Handlers render the resource, apply the patch and
+// then save the result the same way as a PUT.
+//
+// Besides the RFC, it accepts what common identity providers send: operation
+// names in any case, paths as keys of a path-less value, and remove with a
+// value listing the elements to drop.
+func (r PatchRequest) Apply(resource map[string]interface{}) error {
+        if len(r.Operations) == 0 {
+                return patchError(ErrInvalidSyntax, "no Operations given")
+        }
+        for _, op := range r.Operations {
+                var value interface{}
+                if len(op.Value) > 0 {
+                        if err := json.Unmarshal(op.Value, &value); err != nil {
+                                return patchError(ErrInvalidSyntax, "invalid value: %v", err)
+                        }
+                }
+                if err := apply(resource, strings.ToLower(op.Op), op.Path, value); err != nil {
+                        return err
+                }
+        }
+        return nil
+}
+
+func apply(resource map[string]interface{}, op, rawPath string, value interface{}) error {
+        if op != "add" && op != "replace" && op != "remove" {
+                return patchError(ErrInvalidSyntax, "unknown op %q", op)
+        }
+
+        if rawPath == "" {
+                if op == "remove" {
+                        return patchError(ErrNoTarget, "remove needs a path")
+                }
+                attrs, ok := value.(map[string]interface{})
+                if !ok {
+                        return patchError(ErrInvalidValue, "%s without a path needs an object value", op)
+                }
+                // Each key is a path in its own right, and an extension's
+                // attributes may be nested under its URN.
+                for key, v := range attrs {
+                        if nested, ok := v.(map[string]interface{}); ok && isSchemaURN(key) {
+                                for subKey, subValue := range nested {
+                                        if err := apply(resource, op, key+":"+subKey, subValue); err != nil {
+                                                return err
+                                        }
+                                }
+                                continue
+                        }
+                        if err := apply(resource, op, key, v); err != nil {
+                                return err
+                        }
+                }
+                return nil
+        }
+
+        p, err := parsePath(rawPath)
+        if err != nil {
+                return err
+        }
+
+        container := resource
+        if p.schema != "" {
+                key := findKey(resource, p.schema)
+                ext, ok := resource[key].(map[string]interface{})
+                if !ok {
+                        if op == "remove" {
+                                return nil
+                        }
+                        ext = map[string]interface{}{}
+                        resource[key] = ext
+                }
+                container = ext
+        }
+        attr := findKey(container, p.attr)
+
+        if p.filter != nil {
+                return applyFiltered(container, attr, op, p, value)
+        }
+
+        if p.sub != "" {
+                parent, ok := container[attr].(map[string]interface{})
+                if !ok {
+                        if op == "remove" {
+                                return nil
+                        }
+                        parent = map[string]interface{}{}
+                        container[attr] = parent
+                }
+                sub := findKey(parent, p.sub)
+                switch op {
+                case "add":
+                        addValue(parent, sub, value)
+                case "replace":
+                        parent[sub] = value
+                case "remove":
+                        delete(parent, sub)
+                }
+                return nil
+        }
+
+        switch op {
+        case "add":
+                addValue(container, attr, value)
+        case "replace":
+                container[attr] = value
+        case "remove":
+                existing, isList := container[attr].([]interface{})
+                if drop, ok := value.([]interface{}); ok && isList {
+                        container[attr] = without(existing, drop)
+                } else {
+                        delete(container, attr)
+                }
+        }
+        return nil
+}
+
+// applyFiltered handles paths such as members[value eq "7"] or
+// emails[type eq "work"].value.
+func applyFiltered(container map[string]interface{}, attr, op string, p path, value interface{}) error {
+        elements, _ := container[attr].([]interface{})
+
+        matched := false
+        kept := elements[:0:0]
+        for _, element := range elements {
+                fields, ok := element.(map[string]interface{})
+                if !ok || !Match(p.filter, fields) {
+                        kept = append(kept, element)
+                        continue
+                }
+                matched = true
+
+                switch {
+                case op == "remove" && p.sub == "":
+                        continue
+                case op == "remove":
+                        delete(fields, findKey(fields, p.sub))
+                case p.sub != "":
+                        fields[findKey(fields, p.sub)] = value
+                default:
+                        replacement, ok := value.(map[string]interface{})
+                        if !ok {
+                                return patchError(ErrInvalidValue, "%s needs an object value", attr)
+                        }
+                        element = replacement
+                }
+                kept = append(kept, element)
+        }
+
+        if !matched {
+                if op == "remove" {
+                        return nil
+                }
+                return patchError(ErrNoTarget, "no %s matched the filter", attr)
+        }
+        container[attr] = kept
+        return nil
+}
+
+// addValue appends to multi-valued attributes, skipping values already
+// present, merges into complex ones and sets anything else.
+func addValue(m map[string]interface{}, key string, value interface{}) {
+        switch existing := m[key].(type) {
+        case []interface{}:
+                additions, ok := value.([]interface{})
+                if !ok {
+                        additions = []interface{}{value}
+                }
+                for _, addition := range additions {
+                        if !contains(existing, addition) {
+                                existing = append(existing, addition)
+                        }
+                }
+                m[key] = existing
+        case map[string]interface{}:
+                if fields, ok := value.(map[string]interface{}); ok {
+                        for k, v := range fields {
+                                existing[findKey(existing, k)] = v
+                        }
+                        return
+                }
+                m[key] = value
+        default:
+                m[key] = value
+        }
+}
+
+func contains(list []interface{}, value interface{}) bool {
+        for _, element := range list {
+                if sameElement(element, value) {
+                        return true
+                }
+        }
+        return false
+}
+
+// sameElement compares multi-valued elements by their "value" field when
+// both have one, as members and emails do.
+func sameElement(a, b interface{}) bool {
+        am, aok := a.(map[string]interface{})
+        bm, bok := b.(map[string]interface{})
+        if aok && bok {
+                av, aHas := lookup(am, "value")
+                bv, bHas := lookup(bm, "value")
+                if aHas && bHas {
+                        return fmt.Sprint(av) == fmt.Sprint(bv)
+                }
+        }
+        return reflect.DeepEqual(a, b)
+}
+
+func without(list, drop []interface{}) []interface{} {
+        kept := list[:0:0]
+        for _, element := range list {
+                if !contains(drop, element) {
+                        kept = append(kept, element)
+                }
+        }
+        return kept
+}
+
+func findKey(m map[string]interface{}, key string) string {
+        if _, ok := m[key]; ok {
+                return key
+        }
+        for k := range m {
+                if strings.EqualFold(k, key) {
+                        return k
+                }
+        }
+        return key
+}
+
+func isSchemaURN(s string) bool {
+        lower := strings.ToLower(s)
+        for _, urn := range []string{UserSchema, GroupSchema, EnterpriseUserSchema} {
+                if lower == strings.ToLower(urn) {
+                        return true
+                }
+        }
+        return false
+}
+
+// path is a parsed PATCH path: [schema:]attr[filter][.sub].
+type path struct {
+        schema string
+        attr   string
+        filter Expr
+        sub    string
+}
+
+func parsePath(raw string) (path, error) {
+        var p path
+
+        head, rest, hasFilter := strings.Cut(raw, "[")
+
+        // A URN prefix ends at the last colon; its version number contains a
+        // dot, so this has to happen before looking for sub-attributes.
+        if strings.HasPrefix(strings.ToLower(head), "urn:") {
+                i := strings.LastIndex(head, ":")
+                schema := head[:i]
+                head = head[i+1:]
+                if !strings.EqualFold(schema, UserSchema) && !strings.EqualFold(schema, GroupSchema) {
+                        p.schema = schema
+                }
+        }
+
+        p.attr, p.sub, _ = strings.Cut(head, ".")
+
+        if hasFilter {
+                end := strings.LastIndex(rest, "]")
+                if end < 0 {
+                        return p, patchError(ErrInvalidPath, "unterminated filter in path %q", raw)
+                }
+                if p.sub != "" {
+                        return p, patchError(ErrInvalidPath, "invalid path %q", raw)
+                }
+                filter, err := ParseFilter(rest[:end])
+                if err != nil {
+                        return p, patchError(ErrInvalidPath, "invalid filter in path %q: %v", raw, err)
+                }
+                p.filter = filter
+
+                after := rest[end+1:]
+                if after != "" {
+                        sub, ok := strings.CutPrefix(after, ".")
+                        if !ok || sub == "" {
+                                return p, patchError(ErrInvalidPath, "invalid path %q", raw)
+                        }
+                        p.sub = sub
+                }
+        }
+
+        if p.attr == "" {
+                return p, patchError(ErrInvalidPath, "invalid path %q", raw)
+        }
+        return p, nil
+}
@@ -0,0 +1,165 @@
+package scim
+
+import (
+        "encoding/json"
+        "net/http"
+        "net/http/httptest"
+        "reflect"
+        "testing"
+
+        "github.com/gin-gonic/gin"
+)
+
+const enterprise = EnterpriseUserSchema
+
+// testUser is a rendered SCIM User for patches to run against.
+func testUser() map[string]interface{} {
+        var user map[string]interface{}
+        json.Unmarshal([]byte(`{
+                "userName": "bjensen",
+                "name": {"givenName": "Barbara", "familyName": "Jensen"},
+                "emails": [{"type": "work", "value": "b@example.com", "primary": true}],
+                "`+enterprise+`": {"department": "Sales"}
+        }`), &user)
+        return user
+}
+
+func parsePatch(t *testing.T, operations string) PatchRequest {
+        t.Helper()
+        var patch PatchRequest
+        if err := json.Unmarshal([]byte(`{"Operations": `+operations+`}`), &patch); err != nil {
+                t.Fatalf("invalid test patch %s: %v", operations, err)
+        }
+        return patch
+}
+
+func TestApply(t *testing.T) {
+        tests := []struct {
+                name       string
+                operations string
+                change     func(user map[string]interface{})
+        }{
+                {"add an attribute",
+                        `[{"op": "add", "path": "title", "value": "Manager"}]`,
+                        func(u map[string]interface{}) { u["title"] = "Manager" }},
+                {"add to a multi-valued attribute",
+                        `[{"op": "Add", "path": "emails", "value": [{"type": "home", "value": "h@example.com"}]}]`,
+                        func(u map[string]interface{}) {
+                                u["emails"] = append(u["emails"].([]interface{}), map[string]interface{}{"type": "home", "value": "h@example.com"})
+                        }},
+                {"add an element already present",
+                        `[{"op": "add", "path": "emails", "value": [{"type": "home", "value": "b@example.com"}]}]`,
+                        func(u map[string]interface{}) {}},
+                {"add a sub-attribute",
+                        `[{"op": "add", "path": "name.middleName", "value": "J"}]`,
+                        func(u map[string]interface{}) { u["name"].(map[string]interface{})["middleName"] = "J" }},
+                {"add without a path merges",
+                        `[{"op": "add", "value": {"name": {"middleName": "J"}, "nickName": "Babs"}}]`,
+                        func(u map[string]interface{}) {
+                                u["name"].(map[string]interface{})["middleName"] = "J"
+                                u["nickName"] = "Babs"
+                        }},
+                {"add an extension attribute",
+                        `[{"op": "add", "path": "` + enterprise + `:employeeNumber", "value": "42"}]`,
+                        func(u map[string]interface{}) { u[enterprise].(map[string]interface{})["employeeNumber"] = "42" }},
+                {"replace a core attribute by its URN path",
+                        `[{"op": "replace", "path": "` + UserSchema + `:userName", "value": "bj"}]`,
+                        func(u map[string]interface{}) { u["userName"] = "bj" }},
+                {"replace with the attribute name in another case",
+                        `[{"op": "REPLACE", "path": "USERNAME", "value": "bj"}]`,
+                        func(u map[string]interface{}) { u["userName"] = "bj" }},
+                {"replace a sub-attribute",
+                        `[{"op": "replace", "path": "name.GivenName", "value": "Babs"}]`,
+                        func(u map[string]interface{}) { u["name"].(map[string]interface{})["givenName"] = "Babs" }},
+                {"replace through a value filter",
+                        `[{"op": "replace", "path": "emails[type eq \"WORK\"].value", "value": "new@example.com"}]`,
+                        func(u map[string]interface{}) {
+                                u["emails"].([]interface{})[0].(map[string]interface{})["value"] = "new@example.com"
+                        }},
+                {"replace a whole filtered element",
+                        `[{"op": "replace", "path": "emails[primary eq true]", "value": {"type": "home", "value": "h@example.com"}}]`,
+                        func(u map[string]interface{}) {
+                                u["emails"] = []interface{}{map[string]interface{}{"type": "home", "value": "h@example.com"}}
+                        }},
+                {"replace without a path",
+                        `[{"op": "replace", "value": {"active": false, "` + enterprise + `": {"department": "Eng"}}}]`,
+                        func(u map[string]interface{}) {
+                                u["active"] = false
+                                u[enterprise].(map[string]interface{})["department"] = "Eng"
+                        }},
+                {"remove an attribute",
+                        `[{"op": "remove", "path": "userName"}]`,
+                        func(u map[string]interface{}) { delete(u, "userName") }},
+                {"remove a sub-attribute",
+                        `[{"op": "remove", "path": "name.familyName"}]`,
+                        func(u map[string]interface{}) { delete(u["name"].(map[string]interface{}), "familyName") }},
+                {"remove a filtered element",
+                        `[{"op": "remove", "path": "emails[value eq \"b@example.com\"]"}]`,
+                        func(u map[string]interface{}) { u["emails"] = []interface{}{} }},
+                {"remove a sub-attribute of a filtered element",
+                        `[{"op": "remove", "path": "emails[type eq \"work\"].primary"}]`,
+                        func(u map[string]interface{}) { delete(u["emails"].([]interface{})[0].(map[string]interface{}), "primary") }},
+                {"remove the elements listed in the value",
+                        `[{"op": "remove", "path": "emails", "value": [{"value": "b@example.com"}]}]`,
+                        func(u map[string]interface{}) { u["emails"] = []interface{}{} }},
+                {"remove an extension attribute",
+                        `[{"op": "remove", "path": "` + enterprise + `:department"}]`,
+                        func(u map[string]interface{}) { delete(u[enterprise].(map[string]interface{}), "department") }},
+                {"remove what isn't there",
+                        `[{"op": "remove", "path": "title"}, {"op": "remove", "path": "emails[type eq \"home\"]"}]`,
+                        func(u map[string]interface{}) {}},
+                {"operations run in order",
+                        `[{"op": "add", "path": "title", "value": "Manager"}, {"op": "replace", "path": "title", "value": "Director"}]`,
+                        func(u map[string]interface{}) { u["title"] = "Director" }},
+        }
+        for _, tt := range tests {
+                got, want := testUser(), testUser()
+                tt.change(want)
+                if err := parsePatch(t, tt.operations).Apply(got); err != nil {
+                        t.Errorf("%s: Apply: %v", tt.name, err)
+                        continue
+                }
+                if !reflect.DeepEqual(got, want) {
+                        t.Errorf("%s: got %v\nwant %v", tt.name, got, want)
+                }
+        }
+}
+
+func TestApplyRejects(t *testing.T) {
+        tests := []struct {
+                name       string
+                operations string
+                scimType   string
+        }{
+                {"no operations", `[]`, ErrInvalidSyntax},
+                {"unknown op", `[{"op": "move", "path": "title", "value": "x"}]`, ErrInvalidSyntax},
+                {"remove without a path", `[{"op": "remove"}]`, ErrNoTarget},
+                {"add without a path or object", `[{"op": "add", "value": "x"}]`, ErrInvalidValue},
+                {"unterminated filter", `[{"op": "replace", "path": "emails[type eq \"work\"", "value": "x"}]`, ErrInvalidPath},
+                {"invalid filter", `[{"op": "replace", "path": "emails[type zz \"work\"]", "value": "x"}]`, ErrInvalidPath},
+                {"filter after a sub-attribute", `[{"op": "replace", "path": "emails.value[type eq \"work\"]", "value": "x"}]`, ErrInvalidPath},
+                {"text after a filter", `[{"op": "replace", "path": "emails[type eq \"work\"]value", "value": "x"}]`, ErrInvalidPath},
+                {"empty sub-attribute", `[{"op": "replace", "path": "emails[type eq \"work\"].", "value": "x"}]`, ErrInvalidPath},
+                {"no attribute", `[{"op": "replace", "path": ".value", "value": "x"}]`, ErrInvalidPath},
+                {"filter matching nothing", `[{"op": "replace", "path": "emails[type eq \"home\"].value", "value": "x"}]`, ErrNoTarget},
+                {"element replaced by a string", `[{"op": "replace", "path": "emails[type eq \"work\"]", "value": "x"}]`, ErrInvalidValue},
+        }
+        gin.SetMode(gin.TestMode)
+        for _, tt := range tests {
+                err := parsePatch(t, tt.operations).Apply(testUser())
+                patchErr, ok := err.(*PatchError)
+                if !ok || patchErr.ScimType != tt.scimType {
+                        t.Errorf("%s: Apply = %v, want a %s error", tt.name, err, tt.scimType)
+                        continue
+                }
+
+                w := httptest.NewRecorder()
+                c, _ := gin.CreateTestContext(w)
+                Fail(c, err)
+                var body map[string]interface{}
+                json.Unmarshal(w.Body.Bytes(), &body)
+                if w.Code != http.StatusBadRequest || body["scimType"] != tt.scimType || body["status"] != "400" {
+                        t.Errorf("%s: response %d %s, want 400 %s", tt.name, w.Code, w.Body, tt.scimType)
+                }
+        }
+}
@@ -0,0 +1,221 @@
+// Package scim implements the protocol side of SCIM 2.0 (RFC 7643, RFC
+// 7644): filters, PATCH operations and the response envelopes. Mapping SCIM
+// resources onto our models is left to the handlers.
+package scim
+
+import (
+        "encoding/json"
+        "errors"
+        "fmt"
+        "log"
+        "net/http"
+        "strconv"
+        "strings"
+        "time"
+
+        "github.com/gin-gonic/gin"
+)
+
+const (
+        UserSchema           = "urn:ietf:params:scim:schemas:core:2.0:User"
+        GroupSchema          = "urn:ietf:params:scim:schemas:core:2.0:Group"
+        EnterpriseUserSchema = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+
+        listResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+        errorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+        serviceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+        resourceTypeSchema          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+
+        contentType = "application/scim+json"
+
+        // MaxPageSize caps count on list requests.
+        MaxPageSize = 200
+)
+
+// Error types from RFC 7644 section 3.12.
+const (
+        ErrInvalidFilter = "invalidFilter"
+        ErrInvalidSyntax = "invalidSyntax"
+        ErrInvalidPath   = "invalidPath"
+        ErrInvalidValue  = "invalidValue"
+        ErrUniqueness    = "uniqueness"
+        ErrMutability    = "mutability"
+        ErrNoTarget      = "noTarget"
+)
+
+type Meta struct {
+        ResourceType string    `json:"resourceType"`
+        Created      time.Time `json:"created"`
+        LastModified time.Time `json:"lastModified"`
+        Location     string    `json:"location"`
+}
+
+// JSON writes body with the SCIM media type.
+func JSON(c *gin.Context, status int, body interface{}) {
+        c.Header("Content-Type", contentType)
+        c.JSON(status, body)
+}
+
+// Error writes a SCIM error response. scimType may be empty.
+func Error(c *gin.Context, status int, scimType, detail string) {
+        body := gin.H{
+                "schemas": []string{errorSchema},
+                "status":  strconv.Itoa(status),
+                "detail":  detail,
+        }
+        if scimType != "" {
+                body["scimType"] = scimType
+        }
+        JSON(c, status, body)
+}
+
+// HTTPError is a request that can't be served, as the SCIM error to report.
+type HTTPError struct {
+        Status   int
+        ScimType string
+        Detail   string
+}
+
+func (e *HTTPError) Error() string {
+        return e.Detail
+}
+
+func NewError(status int, scimType, format string, args ...interface{}) *HTTPError {
+        return &HTTPError{Status: status, ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Fail writes err as a SCIM error. Errors other than *HTTPError and
+// *PatchError are logged and reported as a 500 without detail.
+func Fail(c *gin.Context, err error) {
+        var httpErr *HTTPError
+        var patchErr *PatchError
+        switch {
+        case errors.As(err, &httpErr):
+                Error(c, httpErr.Status, httpErr.ScimType, httpErr.Detail)
+        case errors.As(err, &patchErr):
+                Error(c, http.StatusBadRequest, patchErr.ScimType, patchErr.Detail)
+        default:
+                log.Println("SCIM request failed:", err)
+                Error(c, http.StatusInternalServerError, "", "Internal error")
+        }
+}
+
+// Bool is a boolean that also accepts "true" and "false" strings, which some
+// identity providers send for active.
+type Bool bool
+
+func (b *Bool) UnmarshalJSON(data []byte) error {
+        var s string
+        if err := json.Unmarshal(data, &s); err == nil {
+                parsed, err := strconv.ParseBool(strings.ToLower(s))
+                if err != nil {
+                        return fmt.Errorf("invalid boolean %q", s)
+                }
+                *b = Bool(parsed)
+                return nil
+        }
+        var v bool
+        if err := json.Unmarshal(data, &v); err != nil {
+                return err
+        }
+        *b = Bool(v)
+        return nil
+}
+
+// Location returns the absolute URL of path on this server, for meta.location.
+func Location(c *gin.Context, path string) string {
+        scheme := "http"
+        if c.Request.TLS != nil {
+                scheme = "https"
+        }
+        if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+                scheme = proto
+        }
+        return scheme + "://" + c.Request.Host + path
+}
+
+// Page is the 1-based startIndex and count of a list request.
+type Page struct {
+        StartIndex int
+        Count      int
+}
+
+// Offset is the number of rows to skip for the page.
+func (p Page) Offset() int {
+        return p.StartIndex - 1
+}
+
+// ParsePage reads startIndex and count, clamping them as RFC 7644 section
+// 3.4.2.4 asks instead of rejecting out-of-range values.
+func ParsePage(c *gin.Context) Page {
+        page := Page{StartIndex: 1, Count: 100}
+        if v, err := strconv.Atoi(c.Query("startIndex")); err == nil && v > 1 {
+                page.StartIndex = v
+        }
+        if v, err := strconv.Atoi(c.Query("count")); err == nil {
+                page.Count = v
+        }
+        if page.Count < 0 {
+                page.Count = 0
+        }
+        if page.Count > MaxPageSize {
+                page.Count = MaxPageSize
+        }
+        return page
+}
+
+// List writes a ListResponse.
+func List(c *gin.Context, page Page, total int64, resources interface{}) {
+        JSON(c, http.StatusOK, gin.H{
+                "schemas":      []string{listResponseSchema},
+                "totalResults": total,
+                "startIndex":   page.StartIndex,
+                "itemsPerPage": page.Count,
+                "Resources":    resources,
+        })
+}
+
+// ServiceProviderConfig describes what this implementation supports.
+func ServiceProviderConfig(c *gin.Context) {
+        JSON(c, http.StatusOK, gin.H{
+                "schemas":        []string{serviceProviderConfigSchema},
+                "patch":          gin.H{"supported": true},
+                "bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+                "filter":         gin.H{"supported": true, "maxResults": MaxPageSize},
+                "changePassword": gin.H{"supported": false},
+                "sort":           gin.H{"supported": false},
+                "etag":           gin.H{"supported": false},
+                "authenticationSchemes": []gin.H{{
+                        "type":        "oauthbearertoken",
+                        "name":        "API key",
+                        "description": "An API key with the scim:provision scope, sent as a bearer token",
+                        "primary":     true,
+                }},
+                "meta": gin.H{"resourceType": "ServiceProviderConfig", "location": Location(c, c.Request.URL.Path)},
+        })
+}
+
+// ResourceTypes lists the User and Group endpoints.
+func ResourceTypes(c *gin.Context) {
+        base := Location(c, "/scim/v2/ResourceTypes/")
+        resources := []gin.H{
+                {
+                        "schemas":          []string{resourceTypeSchema},
+                        "id":               "User",
+                        "name":             "User",
+                        "endpoint":         "/Users",
+                        "schema":           UserSchema,
+                        "schemaExtensions": []gin.H{{"schema": EnterpriseUserSchema, "required": false}},
+                        "meta":             gin.H{"resourceType": "ResourceType", "location": base + "User"},
+                },
+                {
+                        "schemas":  []string{resourceTypeSchema},
+                        "id":       "Group",
+                        "name":     "Group",
+                        "endpoint": "/Groups",
+                        "schema":   GroupSchema,
+                        "meta":     gin.H{"resourceType": "ResourceType", "location": base + "Group"},
+                },
+        }
+        List(c, Page{StartIndex: 1, Count: len(resources)}, int64(len(resources)), resources)
+}
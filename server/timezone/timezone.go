@@ -0,0 +1,75 @@
+// Package timezone loads the IANA time zones attendance is recorded in, and
+// the default zone for employees that have none.
+package timezone
+
+import (
+        "fmt"
+        "os"
+        "sync"
+        "time"
+
+        // Embedded so zones load on hosts without a zoneinfo database
+        _ "time/tzdata"
+)
+
+var (
+        defaultLocation = time.UTC
+        locations       sync.Map
+)
+
+// Init sets the default zone from DEFAULT_TIMEZONE, or UTC if it is unset.
+func Init() error {
+        name := os.Getenv("DEFAULT_TIMEZONE")
+        if name == "" {
+                return nil
+        }
+        loc, err := Load(name)
+        if err != nil {
+                return err
+        }
+        defaultLocation = loc
+        return nil
+}
+
+// Default is the zone of employees with no zone of their own.
+func Default() *time.Location {
+        return defaultLocation
+}
+
+// Load returns the named IANA zone, such as Europe/Amsterdam. Zones are
+// cached, as they are looked up for every attendance record read.
+func Load(name string) (*time.Location, error) {
+        if loc, ok := locations.Load(name); ok {
+                return loc.(*time.Location), nil
+        }
+        // "Local" would be whatever zone the server happens to run in
+        if name == "" || name == "Local" {
+                return nil, fmt.Errorf("%q is not an IANA time zone", name)
+        }
+        loc, err := time.LoadLocation(name)
+        if err != nil {
+                return nil, fmt.Errorf("%q is not an IANA time zone", name)
+        }
+        locations.Store(name, loc)
+        return loc, nil
+}
+
+// Resolve returns the first of names that is a valid zone, or the default.
+func Resolve(names ...string) *time.Location {
+        for _, name := range names {
+                if name == "" {
+                        continue
+                }
+                if loc, err := Load(name); err == nil {
+                        return loc
+                }
+        }
+        return defaultLocation
+}
+
+// Day returns the calendar day t falls on in loc, as midnight UTC, the form
+// attendance dates are stored in.
+func Day(t time.Time, loc *time.Location) time.Time {
+        local := t.In(loc)
+        return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+}
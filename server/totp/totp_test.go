@@ -0,0 +1,87 @@
+package totp
+
+import (
+        "strings"
+        "testing"
+        "time"
+)
+
+// The RFC 6238 Appendix B test vectors for HMAC-SHA1.
+var rfcSecret = []byte("12345678901234567890")
+
+var rfcVectors = []struct {
+        unix int64
+        code string
+}{
+        {59, "94287082"},
+        {1111111109, "07081804"},
+        {1111111111, "14050471"},
+        {1234567890, "89005924"},
+        {2000000000, "69279037"},
+        {20000000000, "65353130"},
+}
+
+func TestRFC6238Vectors(t *testing.T) {
+        for _, tt := range rfcVectors {
+                step := Step(time.Unix(tt.unix, 0))
+                if got := hotp(rfcSecret, step, 8); got != tt.code {
+                        t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.code)
+                }
+        }
+}
+
+func TestCode(t *testing.T) {
+        // Six digit codes are the last six digits of the eight digit ones.
+        secret := encoding.EncodeToString(rfcSecret)
+        for _, tt := range rfcVectors {
+                got, err := Code(strings.ToLower(secret), Step(time.Unix(tt.unix, 0)))
+                if err != nil {
+                        t.Fatalf("Code: %v", err)
+                }
+                if want := tt.code[2:]; got != want {
+                        t.Errorf("code at %d = %s, want %s", tt.unix, got, want)
+                }
+        }
+
+        if _, err := Code("not base32!", 1); err == nil {
+                t.Error("Code with an invalid secret: want an error")
+        }
+}
+
+func TestValidate(t *testing.T) {
+        secret := encoding.EncodeToString(rfcSecret)
+        now := time.Unix(1111111111, 0)
+        step := Step(now)
+
+        tests := []struct {
+                name   string
+                offset int64
+                ok     bool
+        }{
+                {"current step", 0, true},
+                {"one step behind", -1, true},
+                {"one step ahead", 1, true},
+                {"two steps behind", -2, false},
+                {"two steps ahead", 2, false},
+        }
+        for _, tt := range tests {
+                code, _ := Code(secret, step+tt.offset)
+                got, ok := Validate(secret, code, now)
+                if ok != tt.ok {
+                        t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.ok)
+                }
+                if ok && got != step+tt.offset {
+                        t.Errorf("%s: step = %d, want %d", tt.name, got, step+tt.offset)
+                }
+        }
+
+        // Spacing is ignored, but codes must have exactly six digits
+        if _, ok := Validate(secret, " 050 471 ", now); !ok {
+                t.Error("code with spaces: want it accepted")
+        }
+        for _, code := range []string{"", "05047", "0050471", "14050471", "abcdef"} {
+                if _, ok := Validate(secret, code, now); ok {
+                        t.Errorf("code %q: want it rejected", code)
+                }
+        }
+}
@@ -0,0 +1,296 @@
+// Package views shapes records for a particular caller, dropping or masking
+// the fields they are not allowed to see.
+package views
+
+import (
+        "database/sql"
+        "encoding/json"
+        "strings"
+        "sync"
+
+        "hcm-backend/database"
+        "hcm-backend/models"
+
+        "gorm.io/gorm"
+)
+
+// Sensitive employee fields, by JSON name. Everything else on an employee is
+// visible to anyone who can read the directory.
+var (
+        // Shown to the employee and HR.
+        personalFields = []string{"date_of_birth", "marital_status", "termination_reason"}
+
+        // Shown to HR and payroll. The employee sees them masked.
+        identifierFields = []string{"national_id", "tax_id", "bank_account"}
+
+        // Shown to the employee, HR and payroll.
+        compensationFields = []string{"base_salary", "pay_frequency", "currency", "benefit_eligibility"}
+
+        // Shown to the employee, anyone above them in the manager chain and HR.
+        performanceFields = []string{"performance_rating", "probation_end_date", "career_notes", "training_completed"}
+)
+
+// PublicEmployee is a Preload scope that loads only directory fields, for
+// employees embedded in other records such as leave requests:
+//
+//	database.DB.Preload("Employee", views.PublicEmployee)
+func PublicEmployee(db *gorm.DB) *gorm.DB {
+        return db.Select("id", "name", "email", "job_title", "department_id", "manager_id", "user_id", "work_location")
+}
+
+// Viewer is the caller records are being shaped for.
+type Viewer struct {
+        // can reports whether the caller holds a permission.
+        can func(permission string) bool
+
+        // EmployeeID is the caller's own employee record, or 0 if their
+        // account isn't linked to one.
+        EmployeeID uint
+
+        // lazy holds what is only loaded once a check needs it, shared by
+        // copies of the viewer.
+        lazy *viewerData
+}
+
+type viewerData struct {
+        reportsOnce sync.Once
+        // reports holds everyone below the caller in the manager chain.
+        reports map[uint]bool
+
+        customFieldsOnce sync.Once
+        // customFields holds the visibility of each custom field by key.
+        customFields map[string]string
+}
+
+// NewViewer loads the caller's employee record. userID is 0 for callers
+// without an account, such as API keys.
+func NewViewer(userID uint, can func(permission string) bool) Viewer {
+        viewer := Viewer{can: can, lazy: &viewerData{}}
+        if userID == 0 {
+                return viewer
+        }
+
+        var self models.Employee
+        if err := database.DB.Select("id").Where("user_id = ?", userID).First(&self).Error; err != nil {
+                return viewer
+        }
+        viewer.EmployeeID = self.ID
+        return viewer
+}
+
+// reportsSQL selects everyone below an employee in the manager chain. UNION
+// rather than UNION ALL also stops a bad manager_id loop from recursing
+// forever.
+const reportsSQL = `WITH RECURSIVE reports AS (
+        SELECT id FROM employees WHERE manager_id = @manager AND deleted_at IS NULL
+        UNION
+        SELECT employees.id FROM employees JOIN reports ON employees.manager_id = reports.id WHERE employees.deleted_at IS NULL
+) SELECT id FROM reports WHERE id <> @manager`
+
+// Reports is a subquery of the IDs of everyone below managerID in the
+// manager chain, for scoping lists to a manager's team:
+//
+//	query.Where("employee_id IN (?)", views.Reports(database.DB, managerID))
+func Reports(db *gorm.DB, managerID uint) *gorm.DB {
+        return db.Raw(reportsSQL, sql.Named("manager", managerID))
+}
+
+func (v Viewer) reports() map[uint]bool {
+        if v.lazy == nil {
+                return nil
+        }
+        v.lazy.reportsOnce.Do(func() {
+                v.lazy.reports = map[uint]bool{}
+                if v.EmployeeID == 0 {
+                        return
+                }
+                var ids []uint
+                Reports(database.DB, v.EmployeeID).Scan(&ids)
+                for _, id := range ids {
+                        v.lazy.reports[id] = true
+                }
+        })
+        return v.lazy.reports
+}
+
+func (v Viewer) customFields() map[string]string {
+        if v.lazy == nil {
+                return nil
+        }
+        v.lazy.customFieldsOnce.Do(func() {
+                v.lazy.customFields = map[string]string{}
+                var fields []models.CustomField
+                database.DB.Select("key", "visibility").Find(&fields)
+                for _, field := range fields {
+                        v.lazy.customFields[field.Key] = field.Visibility
+                }
+        })
+        return v.lazy.customFields
+}
+
+func (v Viewer) isSelf(e models.Employee) bool {
+        return v.EmployeeID != 0 && v.EmployeeID == e.ID
+}
+
+func (v Viewer) isHR() bool {
+        return v.can(models.PermEmployeesSensitive)
+}
+
+func (v Viewer) isPayroll() bool {
+        return v.can(models.PermPayrollRead)
+}
+
+// ManagesEmployee reports whether e is somewhere below the caller in the
+// manager chain.
+func (v Viewer) ManagesEmployee(e models.Employee) bool {
+        if v.EmployeeID == 0 {
+                return false
+        }
+        return v.reports()[e.ID]
+}
+
+func (v Viewer) CanSeePersonal(e models.Employee) bool {
+        return v.isSelf(e) || v.isHR()
+}
+
+func (v Viewer) CanSeeIdentifiers(e models.Employee) bool {
+        return v.isHR() || v.isPayroll()
+}
+
+func (v Viewer) CanSeeCompensation(e models.Employee) bool {
+        return v.isSelf(e) || v.isHR() || v.isPayroll()
+}
+
+func (v Viewer) CanSeePerformance(e models.Employee) bool {
+        return v.isSelf(e) || v.ManagesEmployee(e) || v.isHR()
+}
+
+// CanSeeDocuments reports whether the caller may list and download e's
+// documents, which often hold personal details.
+func (v Viewer) CanSeeDocuments(e models.Employee) bool {
+        return v.isSelf(e) || v.isHR()
+}
+
+// CanSeeCustomField reports whether the caller may see e's values of custom
+// fields with the given visibility. Asked about the zero employee, it reports
+// whether they may see everyone's, which is what filtering on a field needs.
+func (v Viewer) CanSeeCustomField(e models.Employee, visibility string) bool {
+        switch visibility {
+        case models.CustomFieldDirectory:
+                return true
+        case models.CustomFieldPersonal:
+                return v.CanSeePersonal(e)
+        case models.CustomFieldCompensation:
+                return v.CanSeeCompensation(e)
+        case models.CustomFieldPerformance:
+                return v.CanSeePerformance(e)
+        }
+        return v.isHR()
+}
+
+// Employee returns e as a JSON object with the fields the caller may not see
+// removed. Embedded managers and reports are shaped the same way.
+func (v Viewer) Employee(e models.Employee) map[string]interface{} {
+        var out map[string]interface{}
+        data, _ := json.Marshal(e)
+        json.Unmarshal(data, &out)
+
+        if !v.CanSeePersonal(e) {
+                drop(out, personalFields)
+        }
+        if !v.CanSeeIdentifiers(e) {
+                if v.isSelf(e) {
+                        for _, field := range identifierFields {
+                                if value, ok := out[field].(string); ok && value != "" {
+                                        out[field] = Mask(value)
+                                }
+                        }
+                } else {
+                        drop(out, identifierFields)
+                }
+        }
+        if !v.CanSeeCompensation(e) {
+                drop(out, compensationFields)
+        }
+        if !v.CanSeePerformance(e) {
+                drop(out, performanceFields)
+        }
+        // Values of fields that have since been deleted are dropped too
+        custom := map[string]interface{}{}
+        for key, value := range e.CustomFields {
+                if visibility, ok := v.customFields()[key]; ok && v.CanSeeCustomField(e, visibility) {
+                        custom[key] = value
+                }
+        }
+        out["custom_fields"] = custom
+
+        if e.Manager != nil {
+                out["manager"] = v.Employee(*e.Manager)
+        }
+        if len(e.Reports) > 0 {
+                out["reports"] = v.Employees(e.Reports)
+        }
+        return out
+}
+
+func (v Viewer) Employees(employees []models.Employee) []map[string]interface{} {
+        out := make([]map[string]interface{}, 0, len(employees))
+        for _, e := range employees {
+                out = append(out, v.Employee(e))
+        }
+        return out
+}
+
+// JobRecords returns e's job history as JSON objects, shaped as JobRecord
+// does. Records that only changed pay are left out for callers who may not
+// see it, so a raise can't be read from the dates.
+func (v Viewer) JobRecords(e models.Employee, records []models.JobRecord) []map[string]interface{} {
+        out := make([]map[string]interface{}, 0, len(records))
+        for _, record := range records {
+                shaped := v.JobRecord(e, record)
+                if changed, ok := shaped["changed_fields"].([]string); ok && len(changed) == 0 {
+                        continue
+                }
+                out = append(out, shaped)
+        }
+        return out
+}
+
+// JobRecord returns a job record of e as a JSON object. Callers who may not
+// see e's compensation get no base_salary, and no mention of it among the
+// changed fields.
+func (v Viewer) JobRecord(e models.Employee, record models.JobRecord) map[string]interface{} {
+        var out map[string]interface{}
+        data, _ := json.Marshal(record)
+        json.Unmarshal(data, &out)
+        if v.CanSeeCompensation(e) {
+                return out
+        }
+
+        delete(out, "base_salary")
+        if record.ChangedFields != nil {
+                changed := []string{}
+                for _, field := range record.ChangedFields {
+                        if field != "base_salary" {
+                                changed = append(changed, field)
+                        }
+                }
+                out["changed_fields"] = changed
+        }
+        return out
+}
+
+func drop(out map[string]interface{}, fields []string) {
+        for _, field := range fields {
+                delete(out, field)
+        }
+}
+
+// Mask hides all but the last four characters of value.
+func Mask(value string) string {
+        runes := []rune(value)
+        if len(runes) <= 4 {
+                return strings.Repeat("*", len(runes))
+        }
+        return strings.Repeat("*", len(runes)-4) + string(runes[len(runes)-4:])
+}
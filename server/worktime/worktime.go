@@ -0,0 +1,157 @@
+// Package worktime totals worked time by day and week and splits it into
+// regular time and overtime under an overtime policy.
+package worktime
+
+import (
+        "math"
+        "time"
+
+        "hcm-backend/models"
+)
+
+// Day is the time worked on one day. PaidMinutes weighs each minute by the
+// multiplier it is paid at, so payroll only needs the base rate.
+type Day struct {
+        Date               string  `json:"date"`
+        WorkedMinutes      int     `json:"worked_minutes"`
+        RegularMinutes     int     `json:"regular_minutes"`
+        OvertimeMinutes    int     `json:"overtime_minutes"`
+        Weekend            bool    `json:"weekend"`
+        Holiday            string  `json:"holiday,omitempty"`
+        Multiplier         float64 `json:"multiplier"`
+        OvertimeMultiplier float64 `json:"overtime_multiplier"`
+        PaidMinutes        float64 `json:"paid_minutes"`
+}
+
+// Week totals a whole week, including days outside the summarized range.
+type Week struct {
+        Start           string `json:"start"`
+        WorkedMinutes   int    `json:"worked_minutes"`
+        RegularMinutes  int    `json:"regular_minutes"`
+        OvertimeMinutes int    `json:"overtime_minutes"`
+}
+
+// Totals adds up the days of the summarized range.
+type Totals struct {
+        WorkedMinutes   int     `json:"worked_minutes"`
+        RegularMinutes  int     `json:"regular_minutes"`
+        OvertimeMinutes int     `json:"overtime_minutes"`
+        WeekendMinutes  int     `json:"weekend_minutes"`
+        HolidayMinutes  int     `json:"holiday_minutes"`
+        PaidMinutes     float64 `json:"paid_minutes"`
+        WorkedHours     float64 `json:"worked_hours"`
+        RegularHours    float64 `json:"regular_hours"`
+        OvertimeHours   float64 `json:"overtime_hours"`
+        PaidHours       float64 `json:"paid_hours"`
+}
+
+// Summary is the worked time of a range of days.
+type Summary struct {
+        Days   []Day  `json:"days"`
+        Weeks  []Week `json:"weeks"`
+        Totals Totals `json:"totals"`
+}
+
+// Weeks returns the start of the week from falls in and the end of the week
+// to-1 falls in, the days Summarize needs worked time for.
+func Weeks(policy models.OvertimePolicy, from, to time.Time) (time.Time, time.Time) {
+        return weekStart(policy, from), weekStart(policy, to.AddDate(0, 0, -1)).AddDate(0, 0, 7)
+}
+
+func weekStart(policy models.OvertimePolicy, day time.Time) time.Time {
+        back := (int(day.Weekday()) - policy.WeekStartDay + 7) % 7
+        return day.AddDate(0, 0, -back)
+}
+
+// Round rounds minutes to a multiple of step by mode.
+func Round(minutes, step int, mode string) int {
+        if step <= 0 {
+                return minutes
+        }
+        switch mode {
+        case models.RoundUp:
+                return (minutes + step - 1) / step * step
+        case models.RoundDown:
+                return minutes / step * step
+        }
+        return (minutes + step/2) / step * step
+}
+
+// Summarize splits worked time, in minutes by day as midnight UTC, into
+// regular time and overtime, and totals the days from from up to to. Time
+// beyond the daily threshold is overtime, and so is regular time once the
+// week's regular time passes the weekly threshold. Whole weeks are worked
+// through, so days of the week before from count towards it. holidays holds
+// holiday names by day.
+func Summarize(policy models.OvertimePolicy, worked map[time.Time]int, holidays map[time.Time]string, from, to time.Time) Summary {
+        summary := Summary{Days: []Day{}, Weeks: []Week{}}
+        start, end := Weeks(policy, from, to)
+        weekendDays := map[time.Weekday]bool{}
+        for _, day := range policy.WeekendDays {
+                weekendDays[time.Weekday(day)] = true
+        }
+
+        var week Week
+        for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+                if int(day.Weekday()) == policy.WeekStartDay {
+                        week = Week{Start: day.Format("2006-01-02")}
+                }
+
+                minutes := Round(worked[day], policy.RoundingMinutes, policy.RoundingMode)
+                overtime := 0
+                if policy.DailyThresholdMinutes > 0 && minutes > policy.DailyThresholdMinutes {
+                        overtime = minutes - policy.DailyThresholdMinutes
+                }
+                regular := minutes - overtime
+                if policy.WeeklyThresholdMinutes > 0 && week.RegularMinutes+regular > policy.WeeklyThresholdMinutes {
+                        extra := min(regular, week.RegularMinutes+regular-policy.WeeklyThresholdMinutes)
+                        regular -= extra
+                        overtime += extra
+                }
+                week.WorkedMinutes += minutes
+                week.RegularMinutes += regular
+                week.OvertimeMinutes += overtime
+
+                if minutes > 0 && !day.Before(from) && day.Before(to) {
+                        d := Day{
+                                Date:            day.Format("2006-01-02"),
+                                WorkedMinutes:   minutes,
+                                RegularMinutes:  regular,
+                                OvertimeMinutes: overtime,
+                                Weekend:         weekendDays[day.Weekday()],
+                                Holiday:         holidays[day],
+                                Multiplier:      1,
+                        }
+                        if d.Holiday != "" {
+                                d.Multiplier = max(policy.HolidayMultiplier, 1)
+                                summary.Totals.HolidayMinutes += minutes
+                        } else if d.Weekend {
+                                d.Multiplier = max(policy.WeekendMultiplier, 1)
+                                summary.Totals.WeekendMinutes += minutes
+                        }
+                        d.OvertimeMultiplier = max(policy.OvertimeMultiplier, d.Multiplier)
+                        d.PaidMinutes = float64(regular)*d.Multiplier + float64(overtime)*d.OvertimeMultiplier
+                        summary.Days = append(summary.Days, d)
+
+                        summary.Totals.WorkedMinutes += minutes
+                        summary.Totals.RegularMinutes += regular
+                        summary.Totals.OvertimeMinutes += overtime
+                        summary.Totals.PaidMinutes += d.PaidMinutes
+                }
+
+                if next := day.AddDate(0, 0, 1); int(next.Weekday()) == policy.WeekStartDay && week.WorkedMinutes > 0 {
+                        summary.Weeks = append(summary.Weeks, week)
+                }
+        }
+
+        summary.Totals.WorkedHours = hours(float64(summary.Totals.WorkedMinutes))
+        summary.Totals.RegularHours = hours(float64(summary.Totals.RegularMinutes))
+        summary.Totals.OvertimeHours = hours(float64(summary.Totals.OvertimeMinutes))
+        summary.Totals.PaidHours = hours(summary.Totals.PaidMinutes)
+        return summary
+}
+
+// hours converts minutes to hours, to the hundredth.
+func hours(minutes float64) float64 {
+        return math.Round(minutes/60*100) / 100
+}
@@ -0,0 +1,212 @@
+package worktime
+
+import (
+        "reflect"
+        "testing"
+        "time"
+
+        "hcm-backend/models"
+)
+
+// 2 March 2026 is a Monday.
+var monday = time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+
+func day(days int) time.Time {
+        return monday.AddDate(0, 0, days)
+}
+
+func TestRound(t *testing.T) {
+        tests := []struct {
+                minutes, step int
+                mode          string
+                want          int
+        }{
+                {7, 15, models.RoundNearest, 0},
+                {8, 15, models.RoundNearest, 15},
+                {487, 15, models.RoundNearest, 480},
+                {488, 15, models.RoundNearest, 495},
+                {488, 15, "", 495},
+                {1, 15, models.RoundUp, 15},
+                {480, 15, models.RoundUp, 480},
+                {0, 15, models.RoundUp, 0},
+                {494, 15, models.RoundDown, 480},
+                {495, 15, models.RoundDown, 495},
+                {487, 0, models.RoundNearest, 487},
+                {487, -5, models.RoundUp, 487},
+        }
+        for _, tt := range tests {
+                if got := Round(tt.minutes, tt.step, tt.mode); got != tt.want {
+                        t.Errorf("Round(%d, %d, %q) = %d, want %d", tt.minutes, tt.step, tt.mode, got, tt.want)
+                }
+        }
+}
+
+func TestWeeks(t *testing.T) {
+        policy := models.DefaultOvertimePolicy()
+        start, end := Weeks(policy, day(0), day(7))
+        if !start.Equal(day(0)) || !end.Equal(day(7)) {
+                t.Errorf("Weeks of a whole Monday week = %v to %v", start, end)
+        }
+
+        policy.WeekStartDay = int(time.Sunday)
+        start, end = Weeks(policy, day(2), day(9))
+        if !start.Equal(day(-1)) || !end.Equal(day(13)) {
+                t.Errorf("Weeks from Sunday of Wednesday to Wednesday = %v to %v, want %v to %v", start, end, day(-1), day(13))
+        }
+}
+
+func TestSummarizeDailyThreshold(t *testing.T) {
+        worked := map[time.Time]int{day(0): 540, day(1): 480, day(2): 0}
+        summary := Summarize(models.DefaultOvertimePolicy(), worked, nil, day(0), day(3))
+
+        wantDays := []Day{
+                {Date: "2026-03-02", WorkedMinutes: 540, RegularMinutes: 480, OvertimeMinutes: 60, Multiplier: 1, OvertimeMultiplier: 1.5, PaidMinutes: 570},
+                {Date: "2026-03-03", WorkedMinutes: 480, RegularMinutes: 480, Multiplier: 1, OvertimeMultiplier: 1.5, PaidMinutes: 480},
+        }
+        if !reflect.DeepEqual(summary.Days, wantDays) {
+                t.Errorf("Days = %+v\nwant %+v", summary.Days, wantDays)
+        }
+        wantWeeks := []Week{{Start: "2026-03-02", WorkedMinutes: 1020, RegularMinutes: 960, OvertimeMinutes: 60}}
+        if !reflect.DeepEqual(summary.Weeks, wantWeeks) {
+                t.Errorf("Weeks = %+v, want %+v", summary.Weeks, wantWeeks)
+        }
+        wantTotals := Totals{
+                WorkedMinutes:   1020,
+                RegularMinutes:  960,
+                OvertimeMinutes: 60,
+                PaidMinutes:     1050,
+                WorkedHours:     17,
+                RegularHours:    16,
+                OvertimeHours:   1,
+                PaidHours:       17.5,
+        }
+        if summary.Totals != wantTotals {
+                t.Errorf("Totals = %+v\nwant %+v", summary.Totals, wantTotals)
+        }
+}
+
+func TestSummarizeWeeklyThreshold(t *testing.T) {
+        policy := models.DefaultOvertimePolicy()
+        policy.DailyThresholdMinutes = 0
+        worked := map[time.Time]int{day(0): 540, day(1): 540, day(2): 540, day(3): 540, day(4): 540, day(5): 60}
+
+        // Monday to Thursday are before from but still count towards the week.
+        summary := Summarize(policy, worked, nil, day(4), day(7))
+
+        wantDays := []Day{
+                {Date: "2026-03-06", WorkedMinutes: 540, RegularMinutes: 240, OvertimeMinutes: 300, Multiplier: 1, OvertimeMultiplier: 1.5, PaidMinutes: 690},
+                {Date: "2026-03-07", WorkedMinutes: 60, OvertimeMinutes: 60, Weekend: true, Multiplier: 1.5, OvertimeMultiplier: 1.5, PaidMinutes: 90},
+        }
+        if !reflect.DeepEqual(summary.Days, wantDays) {
+                t.Errorf("Days = %+v\nwant %+v", summary.Days, wantDays)
+        }
+        wantWeeks := []Week{{Start: "2026-03-02", WorkedMinutes: 2760, RegularMinutes: 2400, OvertimeMinutes: 360}}
+        if !reflect.DeepEqual(summary.Weeks, wantWeeks) {
+                t.Errorf("Weeks = %+v, want %+v", summary.Weeks, wantWeeks)
+        }
+        if got := summary.Totals; got.WorkedMinutes != 600 || got.RegularMinutes != 240 || got.OvertimeMinutes != 360 || got.WeekendMinutes != 60 || got.PaidMinutes != 780 {
+                t.Errorf("Totals = %+v, want 600 worked, 240 regular, 360 overtime, 60 weekend and 780 paid", got)
+        }
+
+        // The threshold starts again with the next week.
+        worked[day(7)] = 540
+        summary = Summarize(policy, worked, nil, day(7), day(8))
+        if len(summary.Days) != 1 || summary.Days[0].OvertimeMinutes != 0 {
+                t.Errorf("Days of the next week = %+v, want no overtime", summary.Days)
+        }
+}
+
+func TestSummarizeDailyAndWeekly(t *testing.T) {
+        // Daily overtime doesn't count towards the weekly threshold.
+        worked := map[time.Time]int{day(0): 600, day(1): 600, day(2): 600, day(3): 600, day(4): 600}
+        summary := Summarize(models.DefaultOvertimePolicy(), worked, nil, day(0), day(7))
+
+        wantWeeks := []Week{{Start: "2026-03-02", WorkedMinutes: 3000, RegularMinutes: 2400, OvertimeMinutes: 600}}
+        if !reflect.DeepEqual(summary.Weeks, wantWeeks) {
+                t.Errorf("Weeks = %+v, want %+v", summary.Weeks, wantWeeks)
+        }
+        if friday := summary.Days[4]; friday.RegularMinutes != 480 || friday.OvertimeMinutes != 120 {
+                t.Errorf("Friday = %+v, want 480 regular and 120 overtime", friday)
+        }
+}
+
+func TestSummarizeHolidays(t *testing.T) {
+        worked := map[time.Time]int{day(2): 480, day(5): 600, day(6): 120}
+        holidays := map[time.Time]string{day(2): "Spring Holiday", day(5): "Founders' Day"}
+        summary := Summarize(models.DefaultOvertimePolicy(), worked, holidays, day(0), day(7))
+
+        wantDays := []Day{
+                {Date: "2026-03-04", WorkedMinutes: 480, RegularMinutes: 480, Holiday: "Spring Holiday", Multiplier: 2, OvertimeMultiplier: 2, PaidMinutes: 960},
+                // A holiday on a weekend is paid at the holiday multiplier.
+                {Date: "2026-03-07", WorkedMinutes: 600, RegularMinutes: 480, OvertimeMinutes: 120, Weekend: true, Holiday: "Founders' Day", Multiplier: 2, OvertimeMultiplier: 2, PaidMinutes: 1200},
+                {Date: "2026-03-08", WorkedMinutes: 120, RegularMinutes: 120, Weekend: true, Multiplier: 1.5, OvertimeMultiplier: 1.5, PaidMinutes: 180},
+        }
+        if !reflect.DeepEqual(summary.Days, wantDays) {
+                t.Errorf("Days = %+v\nwant %+v", summary.Days, wantDays)
+        }
+        if got := summary.Totals; got.HolidayMinutes != 1080 || got.WeekendMinutes != 120 || got.PaidMinutes != 2340 || got.PaidHours != 39 {
+                t.Errorf("Totals = %+v, want 1080 holiday, 120 weekend and 2340 paid minutes", got)
+        }
+
+        // Multipliers below 1 are paid at the base rate, and overtime at no
+        // less than the day's rate.
+        policy := models.DefaultOvertimePolicy()
+        policy.HolidayMultiplier = 0
+        policy.OvertimeMultiplier = 1.25
+        summary = Summarize(policy, worked, holidays, day(0), day(7))
+        if saturday := summary.Days[1]; saturday.Multiplier != 1 || saturday.OvertimeMultiplier != 1.25 || saturday.PaidMinutes != 630 {
+                t.Errorf("Saturday = %+v, want a multiplier of 1 and overtime at 1.25", saturday)
+        }
+        if sunday := summary.Days[2]; sunday.OvertimeMultiplier != 1.5 {
+                t.Errorf("Sunday = %+v, want overtime at the weekend multiplier", sunday)
+        }
+}
+
+func TestSummarizeRounding(t *testing.T) {
+        policy := models.DefaultOvertimePolicy()
+        policy.RoundingMinutes = 15
+        policy.RoundingMode = models.RoundUp
+        worked := map[time.Time]int{day(0): 481, day(1): 1}
+        summary := Summarize(policy, worked, nil, day(0), day(7))
+
+        if monday := summary.Days[0]; monday.WorkedMinutes != 495 || monday.RegularMinutes != 480 || monday.OvertimeMinutes != 15 {
+                t.Errorf("Monday = %+v, want 495 worked with 15 overtime", monday)
+        }
+        if tuesday := summary.Days[1]; tuesday.WorkedMinutes != 15 {
+                t.Errorf("Tuesday = %+v, want 15 worked", tuesday)
+        }
+
+        policy.RoundingMode = models.RoundDown
+        summary = Summarize(policy, worked, nil, day(0), day(7))
+        if len(summary.Days) != 1 || summary.Days[0].WorkedMinutes != 480 || summary.Days[0].OvertimeMinutes != 0 {
+                t.Errorf("Days rounded down = %+v, want only Monday with 480 worked", summary.Days)
+        }
+}
+
+func TestSummarizeWeekStartDay(t *testing.T) {
+        policy := models.DefaultOvertimePolicy()
+        policy.WeekStartDay = int(time.Sunday)
+        worked := map[time.Time]int{day(5): 60, day(6): 90, day(20): 30}
+        summary := Summarize(policy, worked, nil, day(-1), day(27))
+
+        // Saturday ends the week from Sunday 1 March, and the week from the
+        // 15th, with no time worked, is left out.
+        wantWeeks := []Week{
+                {Start: "2026-03-01", WorkedMinutes: 60, RegularMinutes: 60},
+                {Start: "2026-03-08", WorkedMinutes: 90, RegularMinutes: 90},
+                {Start: "2026-03-22", WorkedMinutes: 30, RegularMinutes: 30},
+        }
+        if !reflect.DeepEqual(summary.Weeks, wantWeeks) {
+                t.Errorf("Weeks = %+v\nwant %+v", summary.Weeks, wantWeeks)
+        }
+        if len(summary.Days) != 3 {
+                t.Errorf("Days = %+v, want the 3 days worked", summary.Days)
+        }
+
+        // Ending the range before Sunday leaves out the week that starts then.
+        summary = Summarize(policy, worked, nil, day(-1), day(6))
+        wantWeeks = []Week{{Start: "2026-03-01", WorkedMinutes: 60, RegularMinutes: 60}}
+        if len(summary.Days) != 1 || !reflect.DeepEqual(summary.Weeks, wantWeeks) {
+                t.Errorf("Summarize up to Sunday = %+v, want only Saturday", summary)
+        }
+}
@@ -0,0 +1,332 @@
+// Package xlsx reads and writes the parts of Office Open XML spreadsheets
+// that employee imports and exports need: the rows of a single sheet, as
+// text.
+package xlsx
+
+import (
+        "archive/zip"
+        "bytes"
+        "encoding/xml"
+        "errors"
+        "fmt"
+        "io"
+        "math"
+        "path"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// maxPartSize caps how much of any one file inside the archive is read, so a
+// small upload can't unpack into gigabytes.
+const maxPartSize = 64 << 20
+
+// ErrInvalid is returned for files that aren't spreadsheets this package can
+// read.
+var ErrInvalid = errors.New("not a valid XLSX file")
+
+// ReadRows returns the cells of the workbook's first sheet, row by row.
+// Numbers are returned as written, and numbers formatted as dates as
+// YYYY-MM-DD. Missing cells are returned as empty strings.
+func ReadRows(r io.ReaderAt, size int64) ([][]string, error) {
+        archive, err := zip.NewReader(r, size)
+        if err != nil {
+                return nil, ErrInvalid
+        }
+        files := map[string]*zip.File{}
+        for _, f := range archive.File {
+                files[f.Name] = f
+        }
+
+        sheetPath, date1904, err := firstSheet(files)
+        if err != nil {
+                return nil, err
+        }
+        var shared []string
+        if f, ok := files["xl/sharedStrings.xml"]; ok {
+                if shared, err = readSharedStrings(f); err != nil {
+                        return nil, err
+                }
+        }
+        var dateStyles map[int]bool
+        if f, ok := files["xl/styles.xml"]; ok {
+                if dateStyles, err = readDateStyles(f); err != nil {
+                        return nil, err
+                }
+        }
+        sheet, ok := files[sheetPath]
+        if !ok {
+                return nil, ErrInvalid
+        }
+        return readSheet(sheet, shared, dateStyles, date1904)
+}
+
+func readPart(f *zip.File) ([]byte, error) {
+        rc, err := f.Open()
+        if err != nil {
+                return nil, ErrInvalid
+        }
+        defer rc.Close()
+        data, err := io.ReadAll(io.LimitReader(rc, maxPartSize+1))
+        if err != nil {
+                return nil, ErrInvalid
+        }
+        if len(data) > maxPartSize {
+                return nil, fmt.Errorf("%s is too large", f.Name)
+        }
+        return data, nil
+}
+
+func decodePart(f *zip.File, v interface{}) error {
+        data, err := readPart(f)
+        if err != nil {
+                return err
+        }
+        if err := xml.Unmarshal(data, v); err != nil {
+                return ErrInvalid
+        }
+        return nil
+}
+
+// firstSheet finds the path of the first sheet listed in the workbook and
+// whether its dates count from 1904.
+func firstSheet(files map[string]*zip.File) (string, bool, error) {
+        workbookFile, ok := files["xl/workbook.xml"]
+        if !ok {
+                return "", false, ErrInvalid
+        }
+        var workbook struct {
+                Properties struct {
+                        Date1904 bool `xml:"date1904,attr"`
+                } `xml:"workbookPr"`
+                Sheets []struct {
+                        RelationID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+                } `xml:"sheets>sheet"`
+        }
+        if err := decodePart(workbookFile, &workbook); err != nil {
+                return "", false, err
+        }
+        if len(workbook.Sheets) == 0 {
+                return "", false, errors.New("the workbook has no sheets")
+        }
+
+        relsFile, ok := files["xl/_rels/workbook.xml.rels"]
+        if !ok {
+                return "xl/worksheets/sheet1.xml", workbook.Properties.Date1904, nil
+        }
+        var rels struct {
+                Relationships []struct {
+                        ID     string `xml:"Id,attr"`
+                        Target string `xml:"Target,attr"`
+                } `xml:"Relationship"`
+        }
+        if err := decodePart(relsFile, &rels); err != nil {
+                return "", false, err
+        }
+        for _, rel := range rels.Relationships {
+                if rel.ID != workbook.Sheets[0].RelationID {
+                        continue
+                }
+                // Targets are usually relative to xl/, occasionally absolute
+                if strings.HasPrefix(rel.Target, "/") {
+                        return strings.TrimPrefix(rel.Target, "/"), workbook.Properties.Date1904, nil
+                }
+                return path.Join("xl", rel.Target), workbook.Properties.Date1904, nil
+        }
+        return "", false, ErrInvalid
+}
+
+// richText is the text of a shared or inline string, either plain or split
+// into formatted runs.
+type richText struct {
+        Text string `xml:"t"`
+        Runs []struct {
+                Text string `xml:"t"`
+        } `xml:"r"`
+}
+
+func (t richText) String() string {
+        if len(t.Runs) == 0 {
+                return t.Text
+        }
+        var b strings.Builder
+        for _, run := range t.Runs {
+                b.WriteString(run.Text)
+        }
+        return b.String()
+}
+
+func readSharedStrings(f *zip.File) ([]string, error) {
+        var table struct {
+                Items []richText `xml:"si"`
+        }
+        if err := decodePart(f, &table); err != nil {
+                return nil, err
+        }
+        shared := make([]string, len(table.Items))
+        for i, item := range table.Items {
+                shared[i] = item.String()
+        }
+        return shared, nil
+}
+
+// readDateStyles returns the cell styles, by index, whose number format
+// shows a date.
+func readDateStyles(f *zip.File) (map[int]bool, error) {
+        var styles struct {
+                NumFmts []struct {
+                        ID   int    `xml:"numFmtId,attr"`
+                        Code string `xml:"formatCode,attr"`
+                } `xml:"numFmts>numFmt"`
+                CellXfs []struct {
+                        NumFmtID int `xml:"numFmtId,attr"`
+                } `xml:"cellXfs>xf"`
+        }
+        if err := decodePart(f, &styles); err != nil {
+                return nil, err
+        }
+
+        custom := map[int]string{}
+        for _, format := range styles.NumFmts {
+                custom[format.ID] = format.Code
+        }
+        dates := map[int]bool{}
+        for i, xf := range styles.CellXfs {
+                if code, ok := custom[xf.NumFmtID]; ok {
+                        dates[i] = isDateFormat(code)
+                } else {
+                        dates[i] = isBuiltInDateFormat(xf.NumFmtID)
+                }
+        }
+        return dates, nil
+}
+
+// isBuiltInDateFormat reports whether one of the predefined number formats
+// shows a date.
+func isBuiltInDateFormat(id int) bool {
+        return (id >= 14 && id <= 22) || (id >= 45 && id <= 47)
+}
+
+// isDateFormat reports whether a custom format code shows a date: whether it
+// has day, month or year parts outside quoted text and brackets.
+func isDateFormat(code string) bool {
+        quoted, bracketed := false, false
+        for _, r := range strings.ToLower(code) {
+                switch {
+                case r == '"':
+                        quoted = !quoted
+                case quoted:
+                case r == '[':
+                        bracketed = true
+                case r == ']':
+                        bracketed = false
+                case bracketed:
+                case r == 'd' || r == 'm' || r == 'y':
+                        return true
+                }
+        }
+        return false
+}
+
+func readSheet(f *zip.File, shared []string, dateStyles map[int]bool, date1904 bool) ([][]string, error) {
+        data, err := readPart(f)
+        if err != nil {
+                return nil, err
+        }
+
+        var sheet struct {
+                Rows []struct {
+                        Index int `xml:"r,attr"`
+                        Cells []struct {
+                                Ref    string   `xml:"r,attr"`
+                                Type   string   `xml:"t,attr"`
+                                Style  int      `xml:"s,attr"`
+                                Value  string   `xml:"v"`
+                                Inline richText `xml:"is"`
+                        } `xml:"c"`
+                } `xml:"sheetData>row"`
+        }
+        if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&sheet); err != nil {
+                return nil, ErrInvalid
+        }
+
+        var rows [][]string
+        for _, row := range sheet.Rows {
+                // Rows and cells may be left out when empty; their references
+                // say where the next one goes.
+                index := len(rows) + 1
+                if row.Index > 0 {
+                        index = row.Index
+                }
+                for len(rows) < index-1 {
+                        rows = append(rows, nil)
+                }
+
+                var cells []string
+                for _, cell := range row.Cells {
+                        column := len(cells)
+                        if cell.Ref != "" {
+                                if column, err = columnIndex(cell.Ref); err != nil {
+                                        return nil, err
+                                }
+                        }
+                        for len(cells) < column {
+                                cells = append(cells, "")
+                        }
+
+                        value := cell.Value
+                        switch cell.Type {
+                        case "s":
+                                i, err := strconv.Atoi(value)
+                                if err != nil || i < 0 || i >= len(shared) {
+                                        return nil, ErrInvalid
+                                }
+                                value = shared[i]
+                        case "inlineStr":
+                                value = cell.Inline.String()
+                        case "b":
+                                value = map[string]string{"1": "true", "0": "false"}[value]
+                        case "", "n":
+                                if dateStyles[cell.Style] && value != "" {
+                                        if serial, err := strconv.ParseFloat(value, 64); err == nil {
+                                                value = dateFromSerial(serial, date1904).Format("2006-01-02")
+                                        }
+                                }
+                        }
+                        cells = append(cells, value)
+                }
+                rows = append(rows, cells)
+        }
+        return rows, nil
+}
+
+// columnIndex returns the zero-based column of a cell reference such as
+// "C12".
+func columnIndex(ref string) (int, error) {
+        column := 0
+        for _, r := range ref {
+                if r >= 'A' && r <= 'Z' {
+                        column = column*26 + int(r-'A'+1)
+                        continue
+                }
+                break
+        }
+        if column == 0 {
+                return 0, ErrInvalid
+        }
+        return column - 1, nil
+}
+
+// dateFromSerial converts a spreadsheet date serial to a time. Serials count
+// days from 1900, or 1904 for old Mac workbooks.
+func dateFromSerial(serial float64, date1904 bool) time.Time {
+        // 30 December 1899 absorbs the non-existent 29 February 1900
+        // spreadsheets count for compatibility with Lotus 1-2-3.
+        epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+        if date1904 {
+                epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+        }
+        days := math.Floor(serial)
+        seconds := math.Round((serial - days) * 86400)
+        return epoch.AddDate(0, 0, int(days)).Add(time.Duration(seconds) * time.Second)
+}
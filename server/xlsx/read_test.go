@@ -0,0 +1,191 @@
+package xlsx
+
+import (
+        "archive/zip"
+        "bytes"
+        "reflect"
+        "testing"
+        "time"
+)
+
+const (
+        mainNS = `xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"`
+        relsNS = `xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"`
+
+        testWorkbook = `<workbook ` + mainNS + ` ` + relsNS + `><sheets><sheet name="Staff" sheetId="1" r:id="rId1"/></sheets></workbook>`
+        testRels     = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+                `<Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`
+)
+
+// zipFile builds an archive holding the given parts.
+func zipFile(t *testing.T, parts map[string]string) []byte {
+        t.Helper()
+        var buf bytes.Buffer
+        archive := zip.NewWriter(&buf)
+        for name, content := range parts {
+                f, err := archive.Create(name)
+                if err != nil {
+                        t.Fatal(err)
+                }
+                f.Write([]byte(content))
+        }
+        if err := archive.Close(); err != nil {
+                t.Fatal(err)
+        }
+        return buf.Bytes()
+}
+
+// workbook builds a workbook whose first sheet has the given sheetData. Extra
+// parts are added or replace the defaults, and empty ones are left out.
+func workbook(t *testing.T, sheetData string, extra map[string]string) []byte {
+        parts := map[string]string{
+                "xl/workbook.xml":            testWorkbook,
+                "xl/_rels/workbook.xml.rels": testRels,
+                "xl/worksheets/sheet1.xml":   `<worksheet ` + mainNS + `><sheetData>` + sheetData + `</sheetData></worksheet>`,
+        }
+        for name, content := range extra {
+                if content == "" {
+                        delete(parts, name)
+                } else {
+                        parts[name] = content
+                }
+        }
+        return zipFile(t, parts)
+}
+
+func readRows(t *testing.T, data []byte) ([][]string, error) {
+        t.Helper()
+        return ReadRows(bytes.NewReader(data), int64(len(data)))
+}
+
+func TestReadRows(t *testing.T) {
+        sharedStrings := `<sst ` + mainNS + `>` +
+                `<si><t>name</t></si>` +
+                `<si><t>email</t></si>` +
+                `<si><r><t>Ada </t></r><r><rPr><b/></rPr><t>Lovelace</t></r></si>` +
+                `</sst>`
+        // Style 1 is a built-in date format, 2 a custom one, 3 a number and
+        // 4 a number whose format has a "d" only in quoted text.
+        styles := `<styleSheet ` + mainNS + `>` +
+                `<numFmts><numFmt numFmtId="164" formatCode="dd/mm/yyyy"/><numFmt numFmtId="165" formatCode="0.00 &quot;days&quot;"/></numFmts>` +
+                `<cellXfs><xf numFmtId="0"/><xf numFmtId="14"/><xf numFmtId="164"/><xf numFmtId="2"/><xf numFmtId="165"/></cellXfs>` +
+                `</styleSheet>`
+
+        tests := []struct {
+                name      string
+                sheetData string
+                extra     map[string]string
+                want      [][]string
+        }{
+                {"shared strings",
+                        `<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>` +
+                                `<row r="2"><c r="A2" t="s"><v>2</v></c><c r="B2" t="s"><v>1</v></c></row>`,
+                        map[string]string{"xl/sharedStrings.xml": sharedStrings},
+                        [][]string{{"name", "email"}, {"Ada Lovelace", "email"}}},
+                {"inline strings",
+                        `<row r="1"><c r="A1" t="inlineStr"><is><t>Grace</t></is></c>` +
+                                `<c r="B1" t="inlineStr"><is><r><t>grace@</t></r><r><t>example.com</t></r></is></c></row>`,
+                        nil,
+                        [][]string{{"Grace", "grace@example.com"}}},
+                {"numeric dates",
+                        `<row r="1"><c r="A1" s="1"><v>46083</v></c><c r="B1" s="2"><v>33010.75</v></c>` +
+                                `<c r="C1" s="3"><v>46083</v></c><c r="D1" s="4"><v>2.5</v></c><c r="E1"><v>1234.5</v></c></row>`,
+                        map[string]string{"xl/styles.xml": styles},
+                        [][]string{{"2026-03-02", "1990-05-17", "46083", "2.5", "1234.5"}}},
+                {"dates counted from 1904",
+                        `<row r="1"><c r="A1" s="1"><v>44621</v></c></row>`,
+                        map[string]string{
+                                "xl/styles.xml":   styles,
+                                "xl/workbook.xml": `<workbook ` + mainNS + ` ` + relsNS + `><workbookPr date1904="1"/><sheets><sheet name="Staff" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+                        },
+                        [][]string{{"2026-03-02"}}},
+                {"empty cells and rows",
+                        `<row r="1"><c r="A1" t="inlineStr"><is><t>a</t></is></c><c r="C1" t="inlineStr"><is><t>c</t></is></c></row>` +
+                                `<row r="3"><c r="B3"><v>2</v></c><c r="C3" s="1"/></row>`,
+                        map[string]string{"xl/styles.xml": styles},
+                        [][]string{{"a", "", "c"}, nil, {"", "2", ""}}},
+                {"cells and rows without references",
+                        `<row><c t="inlineStr"><is><t>a</t></is></c><c><v>1</v></c></row><row><c t="b"><v>1</v></c><c t="b"><v>0</v></c></row>`,
+                        nil,
+                        [][]string{{"a", "1"}, {"true", "false"}}},
+                {"columns past Z",
+                        `<row r="1"><c r="AB1"><v>1</v></c></row>`,
+                        nil,
+                        [][]string{append(make([]string, 27), "1")}},
+                {"no workbook relationships",
+                        `<row r="1"><c r="A1"><v>1</v></c></row>`,
+                        map[string]string{"xl/_rels/workbook.xml.rels": ""},
+                        [][]string{{"1"}}},
+                {"absolute relationship target",
+                        ``,
+                        map[string]string{
+                                "xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+                                        `<Relationship Id="rId1" Target="/xl/worksheets/other.xml"/></Relationships>`,
+                                "xl/worksheets/other.xml": `<worksheet ` + mainNS + `><sheetData><row r="1"><c r="A1"><v>7</v></c></row></sheetData></worksheet>`,
+                        },
+                        [][]string{{"7"}}},
+        }
+        for _, tt := range tests {
+                got, err := readRows(t, workbook(t, tt.sheetData, tt.extra))
+                if err != nil {
+                        t.Errorf("%s: ReadRows: %v", tt.name, err)
+                        continue
+                }
+                if !reflect.DeepEqual(got, tt.want) {
+                        t.Errorf("%s: ReadRows = %q\nwant %q", tt.name, got, tt.want)
+                }
+        }
+}
+
+func TestReadRowsInvalid(t *testing.T) {
+        tests := map[string][]byte{
+                "not a zip":      []byte("name,email\nAda,ada@example.com\n"),
+                "no workbook":    zipFile(t, map[string]string{"xl/worksheets/sheet1.xml": "<worksheet/>"}),
+                "no sheets":      workbook(t, "", map[string]string{"xl/workbook.xml": `<workbook ` + mainNS + `><sheets/></workbook>`}),
+                "missing sheet":  workbook(t, "", map[string]string{"xl/worksheets/sheet1.xml": ""}),
+                "unknown rel":    workbook(t, "", map[string]string{"xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId9" Target="x.xml"/></Relationships>`}),
+                "broken XML":     workbook(t, `<row><c><v>1</c></row>`, nil),
+                "shared string":  workbook(t, `<row><c t="s"><v>3</v></c></row>`, map[string]string{"xl/sharedStrings.xml": `<sst><si><t>a</t></si></sst>`}),
+                "no shared list": workbook(t, `<row><c t="s"><v>0</v></c></row>`, nil),
+                "bad reference":  workbook(t, `<row><c r="12"><v>1</v></c></row>`, nil),
+        }
+        for name, data := range tests {
+                if rows, err := readRows(t, data); err == nil {
+                        t.Errorf("%s: ReadRows = %q, want an error", name, rows)
+                }
+        }
+}
+
+func TestWriteThenRead(t *testing.T) {
+        var buf bytes.Buffer
+        w, err := NewWriter(&buf, "Employees", []string{"name", "hire_date", "salary", "active"})
+        if err != nil {
+                t.Fatal(err)
+        }
+        hired := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+        rows := [][]interface{}{
+                {"Ada <& Lovelace>", hired, 1234.5, true},
+                {"Grace", nil, 42, false},
+        }
+        for _, row := range rows {
+                if err := w.WriteRow(row); err != nil {
+                        t.Fatal(err)
+                }
+        }
+        if err := w.Close(); err != nil {
+                t.Fatal(err)
+        }
+
+        got, err := readRows(t, buf.Bytes())
+        if err != nil {
+                t.Fatalf("ReadRows: %v", err)
+        }
+        want := [][]string{
+                {"name", "hire_date", "salary", "active"},
+                {"Ada <& Lovelace>", "2026-03-02", "1234.5", "true"},
+                {"Grace", "", "42", "false"},
+        }
+        if !reflect.DeepEqual(got, want) {
+                t.Errorf("ReadRows = %q\nwant %q", got, want)
+        }
+}
@@ -0,0 +1,212 @@
+package xlsx
+
+import (
+        "archive/zip"
+        "bufio"
+        "encoding/xml"
+        "errors"
+        "fmt"
+        "io"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// MaxRows is the most rows a sheet can hold.
+const MaxRows = 1 << 20
+
+// ErrTooManyRows is returned when a sheet is already full.
+var ErrTooManyRows = errors.New("a sheet holds at most 1048576 rows")
+
+// Cell styles, as indexes into cellXfs in stylesXML.
+const (
+        styleDefault = iota
+        styleDate
+        styleDateTime
+        styleHeader
+)
+
+// Writer writes a workbook with a single sheet a row at a time, so large
+// exports aren't held in memory. The sheet is written first; the rest of the
+// workbook follows on Close.
+type Writer struct {
+        archive *zip.Writer
+        sheet   *bufio.Writer
+        name    string
+        rows    int
+}
+
+// NewWriter starts a workbook on w. sheetName must be a valid sheet name: at
+// most 31 characters, none of them []:*?/\. If header is given it becomes
+// the first row, in bold and frozen in place when scrolling.
+func NewWriter(w io.Writer, sheetName string, header []string) (*Writer, error) {
+        archive := zip.NewWriter(w)
+        part, err := archive.Create("xl/worksheets/sheet1.xml")
+        if err != nil {
+                return nil, err
+        }
+        writer := &Writer{archive: archive, sheet: bufio.NewWriter(part), name: sheetName}
+
+        writer.sheet.WriteString(xml.Header)
+        writer.sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+        if header != nil {
+                writer.sheet.WriteString(`<sheetViews><sheetView workbookViewId="0">` +
+                        `<pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/>` +
+                        `</sheetView></sheetViews>`)
+        }
+        writer.sheet.WriteString(`<sheetData>`)
+        if header != nil {
+                cells := make([]interface{}, len(header))
+                for i, name := range header {
+                        cells[i] = name
+                }
+                if err := writer.writeRow(cells, styleHeader); err != nil {
+                        return nil, err
+                }
+        }
+        return writer, nil
+}
+
+// WriteRow appends a row. Cells may be strings, numbers, bools, times or nil
+// for an empty cell. Times at midnight are shown as dates, others as dates
+// and times, both in the time's own zone.
+func (w *Writer) WriteRow(cells []interface{}) error {
+        return w.writeRow(cells, styleDefault)
+}
+
+func (w *Writer) writeRow(cells []interface{}, style int) error {
+        if w.rows >= MaxRows {
+                return ErrTooManyRows
+        }
+        w.rows++
+        fmt.Fprintf(w.sheet, `<row r="%d">`, w.rows)
+        for i, cell := range cells {
+                if cell == nil {
+                        continue
+                }
+                ref := columnName(i) + strconv.Itoa(w.rows)
+                cellStyle := ""
+                if style != styleDefault {
+                        cellStyle = fmt.Sprintf(` s="%d"`, style)
+                }
+
+                switch v := cell.(type) {
+                case string:
+                        fmt.Fprintf(w.sheet, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">`, ref, cellStyle)
+                        xml.EscapeText(w.sheet, []byte(v))
+                        w.sheet.WriteString(`</t></is></c>`)
+                case bool:
+                        value := 0
+                        if v {
+                                value = 1
+                        }
+                        fmt.Fprintf(w.sheet, `<c r="%s"%s t="b"><v>%d</v></c>`, ref, cellStyle, value)
+                case int:
+                        fmt.Fprintf(w.sheet, `<c r="%s"%s><v>%d</v></c>`, ref, cellStyle, v)
+                case int64:
+                        fmt.Fprintf(w.sheet, `<c r="%s"%s><v>%d</v></c>`, ref, cellStyle, v)
+                case uint:
+                        fmt.Fprintf(w.sheet, `<c r="%s"%s><v>%d</v></c>`, ref, cellStyle, v)
+                case float64:
+                        fmt.Fprintf(w.sheet, `<c r="%s"%s><v>%s</v></c>`, ref, cellStyle, strconv.FormatFloat(v, 'f', -1, 64))
+                case time.Time:
+                        timeStyle := styleDateTime
+                        if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 {
+                                timeStyle = styleDate
+                        }
+                        fmt.Fprintf(w.sheet, `<c r="%s" s="%d"><v>%s</v></c>`, ref, timeStyle, strconv.FormatFloat(serialFromDate(v), 'f', -1, 64))
+                default:
+                        return fmt.Errorf("xlsx: cannot write a %T", cell)
+                }
+        }
+        w.sheet.WriteString(`</row>`)
+        return nil
+}
+
+// Close finishes the sheet and writes the rest of the workbook. It does not
+// close the underlying writer.
+func (w *Writer) Close() error {
+        w.sheet.WriteString(`</sheetData></worksheet>`)
+        if err := w.sheet.Flush(); err != nil {
+                return err
+        }
+
+        var workbook strings.Builder
+        workbook.WriteString(xml.Header)
+        workbook.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+                `xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="`)
+        xml.EscapeText(&workbook, []byte(w.name))
+        workbook.WriteString(`" sheetId="1" r:id="rId1"/></sheets></workbook>`)
+
+        parts := []struct{ name, content string }{
+                {"xl/workbook.xml", workbook.String()},
+                {"xl/_rels/workbook.xml.rels", workbookRelsXML},
+                {"xl/styles.xml", stylesXML},
+                {"_rels/.rels", rootRelsXML},
+                {"[Content_Types].xml", contentTypesXML},
+        }
+        for _, part := range parts {
+                f, err := w.archive.Create(part.name)
+                if err != nil {
+                        return err
+                }
+                if _, err := io.WriteString(f, part.content); err != nil {
+                        return err
+                }
+        }
+        return w.archive.Close()
+}
+
+// columnName returns the letters of a zero-based column, such as "AB" for 27.
+func columnName(column int) string {
+        name := ""
+        for column++; column > 0; column = (column - 1) / 26 {
+                name = string(rune('A'+(column-1)%26)) + name
+        }
+        return name
+}
+
+// serialFromDate converts a time to a spreadsheet date serial, the inverse
+// of dateFromSerial.
+func serialFromDate(t time.Time) float64 {
+        local := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+        epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+        return local.Sub(epoch).Hours() / 24
+}
+
+const contentTypesXML = xml.Header +
+        `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+        `<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+        `<Default Extension="xml" ContentType="application/xml"/>` +
+        `<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+        `<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+        `<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+        `</Types>`
+
+const rootRelsXML = xml.Header +
+        `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+        `<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+        `</Relationships>`
+
+const workbookRelsXML = xml.Header +
+        `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+        `<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+        `<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+        `</Relationships>`
+
+// stylesXML defines the cell styles in the order of the style constants.
+const stylesXML = xml.Header +
+        `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+        `<numFmts count="2"><numFmt numFmtId="164" formatCode="yyyy-mm-dd"/><numFmt numFmtId="165" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>` +
+        `<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+        `<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+        `<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+        `<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+        `<cellXfs count="4">` +
+        `<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+        `<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+        `<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+        `<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+        `</cellXfs>` +
+        `<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+        `</styleSheet>`